@@ -1,30 +1,36 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
 
+	"github.com/hphilipps/stagerunner/domain"
 	myhttp "github.com/hphilipps/stagerunner/http"
 	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
 )
 
 // clientCommand is the cli command for running client requests against the API
 var clientCommand = &cli.Command{
 	Name:  "client",
 	Usage: "Run client commands against the API",
-	Flags: []cli.Flag{
-		&cli.StringFlag{
-			Name:    "url",
-			Value:   defaultAPIURL,
-			Usage:   "API server URL",
-			EnvVars: []string{"STAGERUNNER_API_URL"},
-		},
-		&cli.StringFlag{
-			Name:    "token",
-			Usage:   "Authorization token",
-			EnvVars: []string{"STAGERUNNER_TOKEN"},
-		},
+	Flags: clientFlags(),
+	// validate the output format before sending requests
+	Before: func(c *cli.Context) error {
+		_, err := parseOutput(c.String("output"))
+		return err
 	},
 	Subcommands: []*cli.Command{
 		{
@@ -41,14 +47,62 @@ var clientCommand = &cli.Command{
 		{
 			Name:      "create",
 			Usage:     "Create a new pipeline",
-			ArgsUsage: "<stages-json>",
-			Action:    createPipeline,
+			ArgsUsage: "[flags] [<pipeline-json>]",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "file",
+					Aliases: []string{"f"},
+					Usage:   "Read the pipeline JSON or YAML from this file, - reads from stdin",
+				},
+			},
+			Action: createPipeline,
+		},
+		{
+			Name:      "update",
+			Usage:     "Replace the definition of a pipeline",
+			ArgsUsage: "[flags] <pipeline-id> [<pipeline-json>]",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "file",
+					Aliases: []string{"f"},
+					Usage:   "Read the pipeline JSON or YAML from this file, - reads from stdin",
+				},
+			},
+			Action: updatePipeline,
+		},
+		{
+			Name:      "lint",
+			Usage:     "Validate a pipeline without creating it and show its execution plan, exits with an error if it is invalid",
+			ArgsUsage: "[flags] [<pipeline-json>]",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "file",
+					Aliases: []string{"f"},
+					Usage:   "Read the pipeline JSON or YAML from this file, - reads from stdin",
+				},
+				&cli.BoolFlag{Name: "strict", Usage: "Exit with an error on warnings too"},
+			},
+			Action: lintPipeline,
+		},
+		{
+			Name:      "delete",
+			Usage:     "Delete a pipeline",
+			ArgsUsage: "[flags] <pipeline-id>",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{Name: "cascade", Usage: "Delete the runs of the pipeline as well"},
+				&cli.BoolFlag{Name: "force", Usage: "Delete without asking for confirmation"},
+			},
+			Action: deletePipeline,
 		},
 		{
 			Name:      "trigger",
 			Usage:     "Trigger a pipeline run",
-			ArgsUsage: "<pipeline-id> <git-ref>",
-			Action:    triggerPipeline,
+			ArgsUsage: "[flags] <pipeline-id> <git-ref>",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{Name: "wait", Usage: "Wait for the run to finish, exits with an error if the run failed"},
+				&cli.DurationFlag{Name: "wait-timeout", Usage: "Maximum time to wait for the run, e.g. 10m (0 waits forever)"},
+			},
+			Action: triggerPipeline,
 		},
 		{
 			Name:   "list-runs",
@@ -58,23 +112,233 @@ var clientCommand = &cli.Command{
 		{
 			Name:      "get-run",
 			Usage:     "Get details of a specific run",
+			ArgsUsage: "[flags] <run-id>",
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "stage", Usage: "Only show the status and log of this stage (run, build or deploy)"},
+			},
+			Action: getRun,
+		},
+		{
+			Name:      "delete-run",
+			Usage:     "Delete a finished run",
+			ArgsUsage: "[flags] <run-id>",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{Name: "force", Usage: "Delete without asking for confirmation"},
+			},
+			Action: deleteRun,
+		},
+		{
+			Name:      "cancel",
+			Usage:     "Cancel a queued or running run",
+			ArgsUsage: "<run-id>",
+			Action:    cancelRun,
+		},
+		{
+			Name:      "watch",
+			Usage:     "Show the status of a run and its stages live until it is finished, exits with an error if the run failed",
 			ArgsUsage: "<run-id>",
-			Action:    getRun,
+			Action:    watchRun,
 		},
+		{
+			Name:  "audit",
+			Usage: "Show the audit log of mutating API calls",
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "actor", Usage: "Only show calls of this actor"},
+				&cli.StringFlag{Name: "action", Usage: "Only show this action, e.g. pipeline.delete"},
+				&cli.StringFlag{Name: "target-type", Usage: "Only show calls on this target type, e.g. pipeline"},
+				&cli.StringFlag{Name: "target-id", Usage: "Only show calls on this target"},
+				&cli.StringFlag{Name: "since", Usage: "Only show calls since this RFC3339 time or duration ago, e.g. 24h"},
+				&cli.BoolFlag{Name: "changes", Usage: "Show the changed fields of every call in the table output"},
+			},
+			Action: listAuditEntries,
+		},
+		{
+			Name:  "export",
+			Usage: "Export all pipelines and runs as a JSON lines archive",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "file",
+					Aliases: []string{"f"},
+					Usage:   "Write the archive to this file instead of stdout",
+				},
+			},
+			Action: exportState,
+		},
+		{
+			Name:  "import",
+			Usage: "Import pipelines and runs from a JSON lines archive",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "file",
+					Aliases: []string{"f"},
+					Usage:   "Read the archive from this file instead of stdin",
+				},
+				&cli.StringFlag{
+					Name:  "on-conflict",
+					Value: "skip",
+					Usage: "How to handle existing pipelines and runs: skip, overwrite or rename",
+				},
+			},
+			Action: importState,
+		},
+		contextCommand,
 	},
 }
 
+// clientFlags returns the flags of the client command
+func clientFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "client-config",
+			Value:   defaultClientConfigFile(),
+			Usage:   "Client config file with the contexts of the API servers",
+			EnvVars: []string{"STAGERUNNER_CLIENT_CONFIG"},
+		},
+		&cli.StringFlag{
+			Name:    "context",
+			Usage:   "Context of the client config to use instead of the current context",
+			EnvVars: []string{"STAGERUNNER_CONTEXT"},
+		},
+		&cli.StringFlag{
+			Name:    "url",
+			Value:   defaultAPIURL,
+			Usage:   "API server URL",
+			EnvVars: []string{"STAGERUNNER_API_URL"},
+		},
+		&cli.StringFlag{
+			Name:    "token",
+			Usage:   "Authorization token",
+			EnvVars: []string{"STAGERUNNER_TOKEN"},
+		},
+		&cli.StringFlag{
+			Name:    "ca-cert",
+			Usage:   "CA certificate file to verify the server certificate with",
+			EnvVars: []string{"STAGERUNNER_CA_CERT"},
+		},
+		&cli.StringFlag{
+			Name:    "client-cert",
+			Usage:   "Client certificate file to authenticate with (requires --client-key)",
+			EnvVars: []string{"STAGERUNNER_CLIENT_CERT"},
+		},
+		&cli.StringFlag{
+			Name:    "client-key",
+			Usage:   "Private key file of the client certificate",
+			EnvVars: []string{"STAGERUNNER_CLIENT_KEY"},
+		},
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Value:   outputTable,
+			Usage:   "Output format: table, json, yaml, template=<go-template> or jsonpath=<expression>, e.g. jsonpath={.id}",
+			EnvVars: []string{"STAGERUNNER_OUTPUT"},
+		},
+	}
+}
+
+// pipelineColumns are the columns of the table output of pipelines
+var pipelineColumns = []column[myhttp.PipelineResponse]{
+	{"ID", func(p myhttp.PipelineResponse) string { return p.ID }},
+	{"NAME", func(p myhttp.PipelineResponse) string { return p.Name }},
+	{"REPOSITORY", func(p myhttp.PipelineResponse) string { return p.Repository }},
+}
+
+// runColumns are the columns of the table output of pipeline runs
+var runColumns = []column[myhttp.PipelineRunResponse]{
+	{"ID", func(r myhttp.PipelineRunResponse) string { return r.ID }},
+	{"PIPELINE ID", func(r myhttp.PipelineRunResponse) string { return r.PipelineID }},
+	{"GIT REF", func(r myhttp.PipelineRunResponse) string { return r.GitRef }},
+	{"STATUS", func(r myhttp.PipelineRunResponse) string { return r.Status }},
+	{"RUN", func(r myhttp.PipelineRunResponse) string { return r.RunStatus }},
+	{"BUILD", func(r myhttp.PipelineRunResponse) string { return r.BuildStatus }},
+	{"DEPLOY", func(r myhttp.PipelineRunResponse) string { return r.DeployStatus }},
+	{"CREATED", func(r myhttp.PipelineRunResponse) string { return r.CreatedAt.Format(time.RFC3339) }},
+	{"UPDATED", func(r myhttp.PipelineRunResponse) string { return r.UpdatedAt.Format(time.RFC3339) }},
+}
+
+// triggerColumns are the columns of the table output of triggered runs
+var triggerColumns = []column[myhttp.TriggerPipelineResponse]{
+	{"RUN ID", func(r myhttp.TriggerPipelineResponse) string { return r.ID }},
+}
+
+// stageColumns are the columns of the table output of a stage of a run
+var stageColumns = []column[stageResponse]{
+	{"RUN ID", func(s stageResponse) string { return s.RunID }},
+	{"STAGE", func(s stageResponse) string { return s.Stage }},
+	{"STATUS", func(s stageResponse) string { return s.Status }},
+}
+
+// auditColumns are the columns of the table output of audit entries
+var auditColumns = []column[myhttp.AuditEntryResponse]{
+	{"TIME", func(e myhttp.AuditEntryResponse) string { return e.Time.Format(time.RFC3339) }},
+	{"ACTOR", func(e myhttp.AuditEntryResponse) string { return e.Actor }},
+	{"ACTION", func(e myhttp.AuditEntryResponse) string { return e.Action }},
+	{"TARGET", func(e myhttp.AuditEntryResponse) string { return strings.TrimSpace(e.TargetType + " " + e.TargetID) }},
+	{"STATUS", func(e myhttp.AuditEntryResponse) string { return strconv.Itoa(e.Status) }},
+	{"REQUEST ID", func(e myhttp.AuditEntryResponse) string { return e.RequestID }},
+}
+
+// auditChangesColumn is the column with the changed fields of audit entries
+var auditChangesColumn = column[myhttp.AuditEntryResponse]{"CHANGES", func(e myhttp.AuditEntryResponse) string {
+	changes := make([]string, 0, len(e.Changes))
+	for _, change := range e.Changes {
+		changes = append(changes, fmt.Sprintf("%s: %v -> %v", change.Field, change.Before, change.After))
+	}
+	return strings.Join(changes, "; ")
+}}
+
+// newClient creates a client with the connection and authentication settings of the client command
+// and the selected context of the client config
+func newClient(c *cli.Context, opts ...myhttp.ClientOption) (*myhttp.Client, error) {
+	conn, err := clientConnection(c)
+	if err != nil {
+		return nil, err
+	}
+	opts = append([]myhttp.ClientOption{myhttp.WithToken(conn.Token)}, opts...)
+
+	if conn.CACert != "" {
+		pool, err := loadCertPool(conn.CACert)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, myhttp.WithCACert(pool))
+	}
+	if conn.ClientCert != "" || conn.ClientKey != "" {
+		if conn.ClientCert == "" || conn.ClientKey == "" {
+			return nil, fmt.Errorf("client-cert and client-key must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(conn.ClientCert, conn.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		opts = append(opts, myhttp.WithClientCert(cert))
+	}
+	return myhttp.NewClient(conn.URL, opts...), nil
+}
+
+// loadCertPool reads a PEM file with CA certificates
+func loadCertPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", file)
+	}
+	return pool, nil
+}
+
 func listPipelines(c *cli.Context) error {
-	client := myhttp.NewClient(c.String("url"), myhttp.WithToken(c.String("token")))
+	client, err := newClient(c)
+	if err != nil {
+		return err
+	}
 	pipelines, err := client.ListPipelines(context.Background())
 	if err != nil {
 		return fmt.Errorf("error listing pipelines: %w", err)
 	}
 
-	for _, p := range pipelines {
-		fmt.Printf("ID: %s, Name: %s, Repository: %s\n", p.ID, p.Name, p.Repository)
-	}
-	return nil
+	return printList(c, pipelines, pipelineColumns)
 }
 
 func getPipeline(c *cli.Context) error {
@@ -82,79 +346,500 @@ func getPipeline(c *cli.Context) error {
 		return fmt.Errorf("pipeline ID required")
 	}
 
-	client := myhttp.NewClient(c.String("url"), myhttp.WithToken(c.String("token")))
+	client, err := newClient(c)
+	if err != nil {
+		return err
+	}
 	pipeline, err := client.GetPipeline(context.Background(), c.Args().Get(0))
 	if err != nil {
 		return fmt.Errorf("error getting pipeline: %w", err)
 	}
 
-	fmt.Printf("Pipeline: %+v\n", pipeline)
-	return nil
+	return printItem(c, *pipeline, pipelineColumns)
 }
 
 func createPipeline(c *cli.Context) error {
+	pipeline, err := readPipelineRequest(c, c.Args().Get(0))
+	if err != nil {
+		return err
+	}
+
+	client, err := newClient(c)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.CreatePipeline(context.Background(), pipeline)
+	if err != nil {
+		return fmt.Errorf("error creating pipeline: %w", err)
+	}
+
+	return printItem(c, *resp, pipelineColumns)
+}
+
+func updatePipeline(c *cli.Context) error {
 	if c.NArg() < 1 {
-		return fmt.Errorf("pipeline JSON definition required")
+		return fmt.Errorf("pipeline ID required")
+	}
+	pipeline, err := readPipelineRequest(c, c.Args().Get(1))
+	if err != nil {
+		return err
+	}
+
+	client, err := newClient(c)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.UpdatePipeline(context.Background(), c.Args().Get(0), pipeline)
+	if err != nil {
+		return fmt.Errorf("error updating pipeline: %w", err)
+	}
+
+	return printItem(c, *resp, pipelineColumns)
+}
+
+// readPipelineRequest decodes the pipeline JSON or YAML of the argument or the file of the --file flag
+func readPipelineRequest(c *cli.Context, arg string) (myhttp.PipelineRequest, error) {
+	data, err := readPipeline(c, arg)
+	if err != nil {
+		return myhttp.PipelineRequest{}, err
 	}
+	return decodePipelineRequest(data)
+}
 
-	pipelineJSON := c.Args().Get(0)
+// readPipeline reads the pipeline definition of the argument or the --file flag
+func readPipeline(c *cli.Context, arg string) ([]byte, error) {
+	file := c.String("file")
+	switch {
+	case file != "" && arg != "":
+		return nil, fmt.Errorf("pipeline JSON argument and --file are mutually exclusive")
+	case file == "-":
+		data, err := io.ReadAll(c.App.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("error reading pipeline from stdin: %w", err)
+		}
+		return data, nil
+	case file != "":
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading pipeline file: %w", err)
+		}
+		return data, nil
+	case arg != "":
+		return []byte(arg), nil
+	default:
+		return nil, fmt.Errorf("pipeline JSON definition required, as argument or with --file")
+	}
+}
 
-	// json unmarshal
+// decodePipelineRequest decodes a pipeline in JSON or YAML. YAML is converted to JSON first,
+// so both use the JSON field names of the API.
+func decodePipelineRequest(data []byte) (myhttp.PipelineRequest, error) {
 	var pipeline myhttp.PipelineRequest
-	if err := json.Unmarshal([]byte(pipelineJSON), &pipeline); err != nil {
-		return fmt.Errorf("error unmarshalling pipeline: %w", err)
+	data, err := pipelineJSON(data)
+	if err != nil {
+		return pipeline, err
 	}
+	if err := json.Unmarshal(data, &pipeline); err != nil {
+		return pipeline, fmt.Errorf("error unmarshalling pipeline: %w", err)
+	}
+	return pipeline, nil
+}
 
-	client := myhttp.NewClient(c.String("url"), myhttp.WithToken(c.String("token")))
+// pipelineJSON converts a pipeline in JSON or YAML to JSON, keeping all of its fields
+func pipelineJSON(data []byte) (json.RawMessage, error) {
+	var value interface{}
+	if err := yaml.Unmarshal(data, &value); err != nil {
+		return nil, fmt.Errorf("error unmarshalling pipeline: %w", err)
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling pipeline: %w", err)
+	}
+	return data, nil
+}
 
-	resp, err := client.CreatePipeline(context.Background(), pipeline)
+func lintPipeline(c *cli.Context) error {
+	data, err := readPipeline(c, c.Args().Get(0))
 	if err != nil {
-		return fmt.Errorf("error creating pipeline: %w", err)
+		return err
+	}
+	// the JSON is sent as it is, so the server reports unknown fields
+	pipeline, err := pipelineJSON(data)
+	if err != nil {
+		return err
 	}
 
-	fmt.Printf("Pipeline created. ID: %s\n", resp.ID)
+	client, err := newClient(c)
+	if err != nil {
+		return err
+	}
+	resp, err := client.ValidatePipeline(context.Background(), pipeline)
+	if err != nil {
+		return fmt.Errorf("error validating pipeline: %w", err)
+	}
+
+	format, err := parseOutput(c.String("output"))
+	if err != nil {
+		return err
+	}
+	if format.kind == outputTable {
+		printLintResult(c.App.Writer, resp)
+	} else if err := printItem(c, *resp, nil); err != nil {
+		return err
+	}
+
+	switch {
+	case !resp.Valid:
+		return cli.Exit("pipeline is invalid", 1)
+	case c.Bool("strict") && len(resp.Warnings) > 0:
+		return cli.Exit("pipeline has warnings, which fail with --strict", 1)
+	}
+	return nil
+}
+
+// printLintResult prints the problems and the execution plan of a validated pipeline
+func printLintResult(w io.Writer, resp *myhttp.ValidatePipelineResponse) {
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	if len(resp.Errors)+len(resp.Warnings) == 0 {
+		fmt.Fprintln(w, "No problems found")
+	} else {
+		fmt.Fprintln(tw, "SEVERITY\tPATH\tMESSAGE")
+		for _, problem := range resp.Errors {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", domain.SeverityError, problem.Path, problem.Message)
+		}
+		for _, problem := range resp.Warnings {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", domain.SeverityWarning, problem.Path, problem.Message)
+		}
+		tw.Flush()
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(tw, "STEP\tSTAGE\tON FAILURE\tDESCRIPTION")
+	for i, step := range resp.Plan {
+		onFailure := "stop"
+		if step.ContinueOnError {
+			onFailure = "continue"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", i+1, step.Stage, onFailure, step.Description)
+	}
+	tw.Flush()
+}
+
+func deletePipeline(c *cli.Context) error {
+	if c.NArg() < 1 {
+		return fmt.Errorf("pipeline ID required")
+	}
+	id := c.Args().Get(0)
+
+	prompt := fmt.Sprintf("Delete pipeline %s?", id)
+	if c.Bool("cascade") {
+		prompt = fmt.Sprintf("Delete pipeline %s and all of its runs?", id)
+	}
+	if err := confirm(c, prompt); err != nil {
+		return err
+	}
+
+	client, err := newClient(c)
+	if err != nil {
+		return err
+	}
+	if c.Bool("cascade") {
+		err = client.DeletePipelineWithRuns(context.Background(), id)
+	} else {
+		err = client.DeletePipeline(context.Background(), id)
+	}
+	if err != nil {
+		return fmt.Errorf("error deleting pipeline: %w", err)
+	}
+
+	fmt.Fprintf(c.App.Writer, "Pipeline %s deleted\n", id)
 	return nil
 }
 
+// confirm asks the user for confirmation on stdin, unless the --force flag is set
+func confirm(c *cli.Context, prompt string) error {
+	if c.Bool("force") {
+		return nil
+	}
+
+	fmt.Fprintf(c.App.ErrWriter, "%s [y/N] ", prompt)
+	answer, err := bufio.NewReader(c.App.Reader).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("error reading confirmation: %w", err)
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	default:
+		return fmt.Errorf("aborted, use --force to skip the confirmation")
+	}
+}
+
 func triggerPipeline(c *cli.Context) error {
 	if c.NArg() < 2 {
 		return fmt.Errorf("pipeline ID and Git ref required")
 	}
 
-	client := myhttp.NewClient(c.String("url"), myhttp.WithToken(c.String("token")))
+	client, err := newClient(c)
+	if err != nil {
+		return err
+	}
 	resp, err := client.TriggerPipeline(context.Background(), c.Args().Get(0), c.Args().Get(1))
 	if err != nil {
 		return fmt.Errorf("error triggering pipeline: %w", err)
 	}
+	if !c.Bool("wait") {
+		return printItem(c, *resp, triggerColumns)
+	}
 
-	fmt.Printf("Pipeline triggered. Run ID: %s\n", resp.ID)
+	ctx := context.Background()
+	if timeout := c.Duration("wait-timeout"); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	run, err := client.WatchRun(ctx, resp.ID, func(*myhttp.PipelineRunResponse) error { return nil })
+	if err != nil {
+		return fmt.Errorf("error waiting for run %s: %w", resp.ID, err)
+	}
+	if err := printItem(c, *run, runColumns); err != nil {
+		return err
+	}
+	return runResult(run)
+}
+
+// runResult returns an error with a non-zero exit code if the run failed
+func runResult(run *myhttp.PipelineRunResponse) error {
+	if run.Status != domain.StatusSuccess {
+		return cli.Exit(fmt.Sprintf("run %s %s", run.ID, run.Status), 1)
+	}
 	return nil
 }
 
 func listRuns(c *cli.Context) error {
-	client := myhttp.NewClient(c.String("url"), myhttp.WithToken(c.String("token")))
+	client, err := newClient(c)
+	if err != nil {
+		return err
+	}
 	runs, err := client.ListRuns(context.Background())
 	if err != nil {
 		return fmt.Errorf("error listing runs: %w", err)
 	}
 
-	for _, r := range runs {
-		fmt.Printf("Run ID: %s, Status: %s\n", r.ID, r.Status)
-	}
-	return nil
+	return printList(c, runs, runColumns)
 }
 
 func getRun(c *cli.Context) error {
 	if c.NArg() < 1 {
 		return fmt.Errorf("run ID required")
 	}
+	stage := c.String("stage")
 
-	client := myhttp.NewClient(c.String("url"), myhttp.WithToken(c.String("token")))
+	client, err := newClient(c)
+	if err != nil {
+		return err
+	}
 	run, err := client.GetRun(context.Background(), c.Args().Get(0))
 	if err != nil {
 		return fmt.Errorf("error getting run: %w", err)
 	}
+	if stage == "" {
+		return printItem(c, *run, runColumns)
+	}
+	if _, ok := stageStatuses(run)[stage]; !ok {
+		return fmt.Errorf("invalid stage %q, must be one of %s", stage, strings.Join(runStages(run), ", "))
+	}
 
-	fmt.Printf("Run: %+v\n", run)
+	result := stageResult(run, stage)
+	if err := printItem(c, result, stageColumns); err != nil {
+		return err
+	}
+	// the log has multiple lines, so it is printed below the table
+	if c.String("output") == outputTable && result.Log != "" {
+		fmt.Fprintf(c.App.Writer, "\n%s", result.Log)
+	}
 	return nil
 }
+
+// stageResponse is the status and log of a stage of a run
+type stageResponse struct {
+	RunID  string `json:"run_id"`
+	Stage  string `json:"stage"`
+	Status string `json:"status"`
+	Log    string `json:"log"`
+}
+
+// stageResult returns the status and log of the stage of the run
+func stageResult(run *myhttp.PipelineRunResponse, stage string) stageResponse {
+	return stageResponse{RunID: run.ID, Stage: stage, Status: stageStatuses(run)[stage], Log: run.Logs[stage]}
+}
+
+// stageStatuses returns the statuses of the stages of a run. Servers without stage types only
+// return the statuses of the built-in stages.
+func stageStatuses(run *myhttp.PipelineRunResponse) map[string]string {
+	if len(run.StageStatuses) > 0 {
+		return run.StageStatuses
+	}
+	return map[string]string{
+		domain.StageRun:    run.RunStatus,
+		domain.StageBuild:  run.BuildStatus,
+		domain.StageDeploy: run.DeployStatus,
+	}
+}
+
+// runStages returns the names of the stages of a run in the order of their stage types.
+// Stages of types which are not registered in the client follow in alphabetical order.
+func runStages(run *myhttp.PipelineRunResponse) []string {
+	statuses := stageStatuses(run)
+	stages := make([]string, 0, len(statuses))
+	for _, t := range domain.StageTypes() {
+		if _, ok := statuses[t.Name]; ok {
+			stages = append(stages, t.Name)
+		}
+	}
+	var unknown []string
+	for stage := range statuses {
+		if _, ok := domain.LookupStageType(stage); !ok {
+			unknown = append(unknown, stage)
+		}
+	}
+	sort.Strings(unknown)
+	return append(stages, unknown...)
+}
+
+// formatStageStatuses returns the statuses of the stages of a run in a single line, e.g. run=success build=running
+func formatStageStatuses(run *myhttp.PipelineRunResponse) string {
+	statuses := stageStatuses(run)
+	parts := make([]string, 0, len(statuses))
+	for _, stage := range runStages(run) {
+		parts = append(parts, stage+"="+statuses[stage])
+	}
+	return strings.Join(parts, " ")
+}
+
+func cancelRun(c *cli.Context) error {
+	if c.NArg() < 1 {
+		return fmt.Errorf("run ID required")
+	}
+	id := c.Args().Get(0)
+
+	client, err := newClient(c)
+	if err != nil {
+		return err
+	}
+	if err := client.CancelRun(context.Background(), id); err != nil {
+		return fmt.Errorf("error cancelling run: %w", err)
+	}
+
+	fmt.Fprintf(c.App.Writer, "Run %s cancelled\n", id)
+	return nil
+}
+
+func deleteRun(c *cli.Context) error {
+	if c.NArg() < 1 {
+		return fmt.Errorf("run ID required")
+	}
+	id := c.Args().Get(0)
+	if err := confirm(c, fmt.Sprintf("Delete run %s?", id)); err != nil {
+		return err
+	}
+
+	client, err := newClient(c)
+	if err != nil {
+		return err
+	}
+	if err := client.DeleteRun(context.Background(), id); err != nil {
+		return fmt.Errorf("error deleting run: %w", err)
+	}
+
+	fmt.Fprintf(c.App.Writer, "Run %s deleted\n", id)
+	return nil
+}
+
+func exportState(c *cli.Context) error {
+	var w io.Writer = os.Stdout
+	if path := c.String("file"); path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("error creating export file: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	client, err := newClient(c, myhttp.WithTimeout(0))
+	if err != nil {
+		return err
+	}
+	if err := client.Export(context.Background(), w); err != nil {
+		return fmt.Errorf("error exporting: %w", err)
+	}
+	return nil
+}
+
+func importState(c *cli.Context) error {
+	format, err := parseOutput(c.String("output"))
+	if err != nil {
+		return err
+	}
+	r := c.App.Reader
+	if path := c.String("file"); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("error opening import file: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	client, err := newClient(c, myhttp.WithTimeout(0))
+	if err != nil {
+		return err
+	}
+	resp, err := client.Import(context.Background(), r, c.String("on-conflict"))
+	if err != nil {
+		return fmt.Errorf("error importing: %w", err)
+	}
+
+	if format.kind != outputTable {
+		return printItem(c, *resp, nil)
+	}
+	fmt.Fprintf(c.App.Writer, "Imported %d pipelines and %d runs (skipped: %d, overwritten: %d, renamed: %d)\n",
+		resp.Pipelines, resp.Runs, resp.Skipped, resp.Overwritten, resp.Renamed)
+	return nil
+}
+
+func listAuditEntries(c *cli.Context) error {
+	query := myhttp.AuditQuery{
+		Actor:      c.String("actor"),
+		Action:     c.String("action"),
+		TargetType: c.String("target-type"),
+		TargetID:   c.String("target-id"),
+	}
+	if since := c.String("since"); since != "" {
+		if d, err := time.ParseDuration(since); err == nil {
+			query.Since = time.Now().Add(-d)
+		} else if t, err := time.Parse(time.RFC3339, since); err == nil {
+			query.Since = t
+		} else {
+			return fmt.Errorf("invalid since %q - must be an RFC3339 time or a duration", since)
+		}
+	}
+
+	client, err := newClient(c)
+	if err != nil {
+		return err
+	}
+	entries, err := client.ListAuditEntries(context.Background(), query)
+	if err != nil {
+		return fmt.Errorf("error listing audit entries: %w", err)
+	}
+
+	columns := auditColumns
+	if c.Bool("changes") {
+		columns = append(columns[:len(columns):len(columns)], auditChangesColumn)
+	}
+	return printList(c, entries, columns)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hphilipps/stagerunner/domain"
+	myhttp "github.com/hphilipps/stagerunner/http"
+	"github.com/hphilipps/stagerunner/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestReadPipelineRequest(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "pipeline.json")
+	require.NoError(t, os.WriteFile(file, []byte(`{"name": "from-file", "repository": "repo"}`), 0o600))
+
+	tests := []struct {
+		name    string
+		args    []string
+		stdin   string
+		want    string
+		wantErr string
+	}{
+		{name: "argument", args: []string{`{"name": "from-arg"}`}, want: "from-arg"},
+		{name: "file", args: []string{"--file", file}, want: "from-file"},
+		{name: "stdin", args: []string{"-f", "-"}, stdin: `{"name": "from-stdin"}`, want: "from-stdin"},
+		{name: "YAML", args: []string{"-f", "-"}, stdin: "name: from-yaml\nstages:\n  run_stage:\n    command: make\n", want: "from-yaml"},
+		{name: "missing", wantErr: "pipeline JSON definition required"},
+		{name: "both", args: []string{"-f", file, `{"name": "from-arg"}`}, wantErr: "mutually exclusive"},
+		{name: "invalid", args: []string{"-f", "-"}, stdin: "{", wantErr: "error unmarshalling pipeline"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got myhttp.PipelineRequest
+			app := &cli.App{
+				Reader: strings.NewReader(tt.stdin),
+				Flags:  []cli.Flag{&cli.StringFlag{Name: "file", Aliases: []string{"f"}}},
+				Action: func(c *cli.Context) error {
+					var err error
+					got, err = readPipelineRequest(c, c.Args().Get(0))
+					return err
+				},
+			}
+			err := app.Run(append([]string{"create"}, tt.args...))
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got.Name)
+		})
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		stdin   string
+		wantErr bool
+	}{
+		{name: "yes", stdin: "yes\n"},
+		{name: "y without newline", stdin: "Y"},
+		{name: "no", stdin: "n\n", wantErr: true},
+		{name: "no input", wantErr: true},
+		{name: "force", args: []string{"--force"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var prompt bytes.Buffer
+			app := &cli.App{
+				Reader:    strings.NewReader(tt.stdin),
+				ErrWriter: &prompt,
+				Flags:     []cli.Flag{&cli.BoolFlag{Name: "force"}},
+				Action: func(c *cli.Context) error {
+					return confirm(c, "Delete pipeline p-1?")
+				},
+			}
+			err := app.Run(append([]string{"delete"}, tt.args...))
+			if tt.wantErr {
+				assert.ErrorContains(t, err, "use --force to skip the confirmation")
+			} else {
+				assert.NoError(t, err)
+			}
+			if len(tt.args) == 0 {
+				assert.Equal(t, "Delete pipeline p-1? [y/N] ", prompt.String())
+			} else {
+				assert.Empty(t, prompt.String())
+			}
+		})
+	}
+}
+
+func TestStageResult(t *testing.T) {
+	run := &myhttp.PipelineRunResponse{
+		ID: "run-1", RunStatus: "success", BuildStatus: "failed", DeployStatus: "pending",
+		Logs: map[string]string{"build": "building\nfailed\n"},
+	}
+	assert.Equal(t, stageResponse{RunID: "run-1", Stage: "build", Status: "failed", Log: "building\nfailed\n"},
+		stageResult(run, "build"))
+	assert.Equal(t, stageResponse{RunID: "run-1", Stage: "deploy", Status: "pending"}, stageResult(run, "deploy"))
+}
+
+func TestPipelineJSON(t *testing.T) {
+	data, err := pipelineJSON([]byte("name: test\nstages:\n  run_stage:\n    command: make\n    retries: 2\n"))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"name": "test", "stages": {"run_stage": {"command": "make", "retries": 2}}}`, string(data))
+
+	_, err = pipelineJSON([]byte("{"))
+	assert.ErrorContains(t, err, "error unmarshalling pipeline")
+}
+
+func TestPrintLintResult(t *testing.T) {
+	var buf bytes.Buffer
+	printLintResult(&buf, &myhttp.ValidatePipelineResponse{
+		Errors:   []myhttp.ValidationProblem{{Path: "stages.run_stage.command", Message: "command is required for run stage"}},
+		Warnings: []myhttp.ValidationProblem{{Path: "name", Message: "pipeline has no name"}},
+		Plan: []myhttp.PlanStepResponse{
+			{Stage: "run", Description: `run ""`, ContinueOnError: true},
+			{Stage: "build", Description: "build the image of Dockerfile"},
+		},
+	})
+	assert.Equal(t, `SEVERITY   PATH                       MESSAGE
+error      stages.run_stage.command   command is required for run stage
+warning    name                       pipeline has no name
+
+STEP   STAGE   ON FAILURE   DESCRIPTION
+1      run     continue     run ""
+2      build   stop         build the image of Dockerfile
+`, buf.String())
+
+	buf.Reset()
+	printLintResult(&buf, &myhttp.ValidatePipelineResponse{Valid: true})
+	assert.True(t, strings.HasPrefix(buf.String(), "No problems found\n"))
+}
+
+func TestImportState(t *testing.T) {
+	t.Setenv("STAGERUNNER_CLIENT_CONFIG", filepath.Join(t.TempDir(), "config.yaml"))
+	s := store.NewMemoryStore()
+	server := httptest.NewServer(myhttp.NewAPI(s, domain.NewExecutor(s, 1, 1, 1, 0.0, time.Millisecond)).SetupRouter())
+	defer server.Close()
+	archive := `{"kind":"header","version":2}` + "\n" + `{"kind":"pipeline","pipeline":{"id":"p-1","name":"imported"}}` + "\n"
+
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{name: "table", output: "table", want: "Imported 1 pipelines and 0 runs (skipped: 0, overwritten: 0, renamed: 0)\n"},
+		{name: "jsonpath", output: "jsonpath={.skipped}", want: "1\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			app := &cli.App{
+				Reader:   strings.NewReader(archive),
+				Writer:   &out,
+				Flags:    clientFlags(),
+				Commands: clientCommand.Subcommands,
+			}
+			err := app.Run([]string{"client", "--url", server.URL, "--token", "test-token", "--output", tt.output, "import"})
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, out.String())
+		})
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/hphilipps/stagerunner/tracing"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// redacted is replacing secrets in the printed config
+const redacted = "<redacted>"
+
+// serverConfig is the configuration of the server. The settings are taken from the flags, then their
+// environment variables, then the --config file and finally the defaults of the flags.
+type serverConfig struct {
+	Addr            string          `yaml:"addr" toml:"addr"`
+	DrainDelay      time.Duration   `yaml:"drain_delay" toml:"drain_delay"`
+	ShutdownTimeout time.Duration   `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+	IdempotencyTTL  time.Duration   `yaml:"idempotency_ttl" toml:"idempotency_ttl"`
+	UI              bool            `yaml:"ui" toml:"ui"`
+	Executor        executorConfig  `yaml:"executor" toml:"executor"`
+	Plugins         pluginsConfig   `yaml:"plugins" toml:"plugins"`
+	Retention       retentionConfig `yaml:"retention" toml:"retention"`
+	Log             logConfig       `yaml:"log" toml:"log"`
+	Tracing         tracingConfig   `yaml:"tracing" toml:"tracing"`
+	TLS             tlsConfig       `yaml:"tls" toml:"tls"`
+}
+
+type executorConfig struct {
+	Workers          int           `yaml:"workers" toml:"workers"`
+	QueueSize        int           `yaml:"queue_size" toml:"queue_size"`
+	PerPipelineQueue int           `yaml:"per_pipeline_queue" toml:"per_pipeline_queue"`
+	Delay            time.Duration `yaml:"delay" toml:"delay"`
+	FailProbability  float64       `yaml:"fail_probability" toml:"fail_probability"`
+}
+
+type pluginsConfig struct {
+	// Dir is the directory the stage plugins are discovered in, no plugins are loaded if it is empty
+	Dir     string        `yaml:"dir" toml:"dir"`
+	Timeout time.Duration `yaml:"timeout" toml:"timeout"`
+}
+
+type retentionConfig struct {
+	KeepRuns           int           `yaml:"keep_runs" toml:"keep_runs"`
+	MaxRunAge          time.Duration `yaml:"max_run_age" toml:"max_run_age"`
+	KeepLastSuccessful bool          `yaml:"keep_last_successful" toml:"keep_last_successful"`
+	JanitorInterval    time.Duration `yaml:"janitor_interval" toml:"janitor_interval"`
+}
+
+type logConfig struct {
+	Format string `yaml:"format" toml:"format"`
+	Level  string `yaml:"level" toml:"level"`
+}
+
+type tracingConfig struct {
+	Exporter     string `yaml:"exporter" toml:"exporter"`
+	OTLPEndpoint string `yaml:"otlp_endpoint" toml:"otlp_endpoint"`
+	OTLPInsecure bool   `yaml:"otlp_insecure" toml:"otlp_insecure"`
+	// OTLPHeaders are sent to the collector, e.g. API keys. The values are secrets.
+	OTLPHeaders map[string]string `yaml:"otlp_headers" toml:"otlp_headers"`
+}
+
+type tlsConfig struct {
+	Cert     string `yaml:"cert" toml:"cert"`
+	Key      string `yaml:"key" toml:"key"`
+	ClientCA string `yaml:"client_ca" toml:"client_ca"`
+	// ClientIdentities is mapping identities to the subject or common name of client certificates
+	ClientIdentities map[string]string `yaml:"client_identities" toml:"client_identities"`
+}
+
+// loadServerConfig returns the effective config of the server command
+func loadServerConfig(c *cli.Context) (*serverConfig, error) {
+	cfg := &serverConfig{}
+	// start with the defaults of the flags
+	if err := cfg.applyFlags(c, false); err != nil {
+		return nil, err
+	}
+	if file := c.String("config"); file != "" {
+		if err := cfg.readFile(file); err != nil {
+			return nil, err
+		}
+	}
+	// flags and environment variables take precedence over the config file
+	if err := cfg.applyFlags(c, true); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return cfg, nil
+}
+
+// readFile is overriding the settings of the config with the settings of a YAML or TOML file.
+// Unknown settings are rejected, so typos don't go unnoticed.
+func (cfg *serverConfig) readFile(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(file)); ext {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("invalid config file %s: %w", file, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(data), cfg)
+		if err != nil {
+			return fmt.Errorf("invalid config file %s: %w", file, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			keys := make([]string, 0, len(undecoded))
+			for _, key := range undecoded {
+				keys = append(keys, key.String())
+			}
+			return fmt.Errorf("invalid config file %s: unknown settings %s", file, strings.Join(keys, ", "))
+		}
+	default:
+		return fmt.Errorf("unsupported config file extension %q, must be .yaml, .yml or .toml", ext)
+	}
+	return nil
+}
+
+// applyFlags is setting the config from the flags of the command. With onlySet, only the flags
+// which are set on the command line or by their environment variable are applied.
+func (cfg *serverConfig) applyFlags(c *cli.Context, onlySet bool) error {
+	bindFlag(c, onlySet, "addr", &cfg.Addr, c.String)
+	bindFlag(c, onlySet, "drain-delay", &cfg.DrainDelay, c.Duration)
+	bindFlag(c, onlySet, "shutdown-timeout", &cfg.ShutdownTimeout, c.Duration)
+	bindFlag(c, onlySet, "idempotency-ttl", &cfg.IdempotencyTTL, c.Duration)
+	bindFlag(c, onlySet, "ui", &cfg.UI, c.Bool)
+	bindFlag(c, onlySet, "workers", &cfg.Executor.Workers, c.Int)
+	bindFlag(c, onlySet, "queue-size", &cfg.Executor.QueueSize, c.Int)
+	bindFlag(c, onlySet, "per-pipeline-queue", &cfg.Executor.PerPipelineQueue, c.Int)
+	bindFlag(c, onlySet, "executor-delay", &cfg.Executor.Delay, func(name string) time.Duration {
+		return time.Duration(c.Int(name)) * time.Second
+	})
+	bindFlag(c, onlySet, "fail-probability", &cfg.Executor.FailProbability, c.Float64)
+	bindFlag(c, onlySet, "plugin-dir", &cfg.Plugins.Dir, c.String)
+	bindFlag(c, onlySet, "plugin-timeout", &cfg.Plugins.Timeout, c.Duration)
+	bindFlag(c, onlySet, "keep-runs", &cfg.Retention.KeepRuns, c.Int)
+	bindFlag(c, onlySet, "max-run-age", &cfg.Retention.MaxRunAge, c.Duration)
+	bindFlag(c, onlySet, "keep-last-successful", &cfg.Retention.KeepLastSuccessful, c.Bool)
+	bindFlag(c, onlySet, "janitor-interval", &cfg.Retention.JanitorInterval, c.Duration)
+	bindFlag(c, onlySet, "log-format", &cfg.Log.Format, c.String)
+	bindFlag(c, onlySet, "log-level", &cfg.Log.Level, c.String)
+	bindFlag(c, onlySet, "trace-exporter", &cfg.Tracing.Exporter, c.String)
+	bindFlag(c, onlySet, "otlp-endpoint", &cfg.Tracing.OTLPEndpoint, c.String)
+	bindFlag(c, onlySet, "otlp-insecure", &cfg.Tracing.OTLPInsecure, c.Bool)
+	bindFlag(c, onlySet, "tls-cert", &cfg.TLS.Cert, c.String)
+	bindFlag(c, onlySet, "tls-key", &cfg.TLS.Key, c.String)
+	bindFlag(c, onlySet, "client-ca", &cfg.TLS.ClientCA, c.String)
+
+	if !onlySet || c.IsSet("otlp-header") {
+		headers, err := parsePairs("otlp-header", "key=value", c.StringSlice("otlp-header"))
+		if err != nil {
+			return err
+		}
+		cfg.Tracing.OTLPHeaders = headers
+	}
+	if !onlySet || c.IsSet("client-identity") {
+		identities, err := parsePairs("client-identity", "identity=subject", c.StringSlice("client-identity"))
+		if err != nil {
+			return err
+		}
+		cfg.TLS.ClientIdentities = identities
+	}
+	return nil
+}
+
+// bindFlag is setting dst to the value of the named flag, if the flag is set or onlySet is false
+func bindFlag[T any](c *cli.Context, onlySet bool, name string, dst *T, get func(string) T) {
+	if !onlySet || c.IsSet(name) {
+		*dst = get(name)
+	}
+}
+
+// parsePairs parses the key=value pairs of a slice flag
+func parsePairs(name, format string, pairs []string) (map[string]string, error) {
+	m := make(map[string]string, len(pairs))
+	for _, p := range pairs {
+		key, value, ok := strings.Cut(p, "=")
+		if !ok || key == "" || value == "" {
+			return nil, fmt.Errorf("invalid %s %q, must be %s", name, p, format)
+		}
+		m[key] = value
+	}
+	return m, nil
+}
+
+// validate returns all invalid settings of the config
+func (cfg *serverConfig) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(cfg.Addr != "", "addr must be set")
+	check(cfg.DrainDelay >= 0, "drain_delay must not be negative")
+	check(cfg.ShutdownTimeout > 0, "shutdown_timeout must be positive")
+	check(cfg.IdempotencyTTL > 0, "idempotency_ttl must be positive")
+
+	check(cfg.Executor.Workers > 0, "executor.workers must be positive")
+	check(cfg.Executor.QueueSize > 0, "executor.queue_size must be positive")
+	check(cfg.Executor.PerPipelineQueue > 0, "executor.per_pipeline_queue must be positive")
+	check(cfg.Executor.Delay >= 0, "executor.delay must not be negative")
+	check(cfg.Executor.FailProbability >= 0 && cfg.Executor.FailProbability <= 1,
+		"executor.fail_probability must be between 0 and 1, got %v", cfg.Executor.FailProbability)
+
+	check(cfg.Plugins.Timeout >= 0, "plugins.timeout must not be negative")
+
+	check(cfg.Retention.KeepRuns >= 0, "retention.keep_runs must not be negative")
+	check(cfg.Retention.MaxRunAge >= 0, "retention.max_run_age must not be negative")
+	check(cfg.Retention.JanitorInterval > 0, "retention.janitor_interval must be positive")
+
+	check(cfg.Log.Format == "json" || cfg.Log.Format == "text", "log.format must be json or text, got %q", cfg.Log.Format)
+	var level slog.Level
+	check(level.UnmarshalText([]byte(cfg.Log.Level)) == nil, "log.level must be debug, info, warn or error, got %q", cfg.Log.Level)
+
+	switch cfg.Tracing.Exporter {
+	case tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterOTLP:
+	default:
+		check(false, "tracing.exporter must be %s, %s or %s, got %q",
+			tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterOTLP, cfg.Tracing.Exporter)
+	}
+
+	check((cfg.TLS.Cert == "") == (cfg.TLS.Key == ""), "tls.cert and tls.key must be set together")
+	check(cfg.TLS.ClientCA == "" || cfg.TLS.Cert != "", "tls.client_ca requires tls.cert and tls.key")
+	check(len(cfg.TLS.ClientIdentities) == 0 || cfg.TLS.ClientCA != "", "tls.client_identities requires tls.client_ca")
+	subjects := make(map[string]string, len(cfg.TLS.ClientIdentities))
+	for _, identity := range sortedKeys(cfg.TLS.ClientIdentities) {
+		subject := cfg.TLS.ClientIdentities[identity]
+		if other, ok := subjects[subject]; ok {
+			check(false, "tls.client_identities %q and %q have the same subject %q", other, identity, subject)
+		}
+		subjects[subject] = identity
+	}
+
+	return errors.Join(errs...)
+}
+
+// clientIdentities returns the identities of client certificates by their subject or common name
+func (cfg *serverConfig) clientIdentities() map[string]string {
+	identities := make(map[string]string, len(cfg.TLS.ClientIdentities))
+	for identity, subject := range cfg.TLS.ClientIdentities {
+		identities[subject] = identity
+	}
+	return identities
+}
+
+// redacted returns a copy of the config with the secrets replaced
+func (cfg *serverConfig) redacted() *serverConfig {
+	r := *cfg
+	if cfg.Tracing.OTLPHeaders != nil {
+		r.Tracing.OTLPHeaders = make(map[string]string, len(cfg.Tracing.OTLPHeaders))
+		for key := range cfg.Tracing.OTLPHeaders {
+			r.Tracing.OTLPHeaders[key] = redacted
+		}
+	}
+	return &r
+}
+
+// print is writing the config with redacted secrets as YAML, which can be used as config file
+func (cfg *serverConfig) print(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(cfg.redacted()); err != nil {
+		return fmt.Errorf("failed to print config: %w", err)
+	}
+	return enc.Close()
+}
+
+// sortedKeys returns the keys of the map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+// loadConfig returns the config of the server command with the given arguments
+func loadConfig(t *testing.T, args ...string) (*serverConfig, error) {
+	t.Helper()
+	var cfg *serverConfig
+	app := &cli.App{
+		Flags:                     serverFlags(),
+		DisableSliceFlagSeparator: true,
+		Action: func(c *cli.Context) error {
+			var err error
+			cfg, err = loadServerConfig(c)
+			return err
+		},
+	}
+	err := app.Run(append([]string{"server"}, args...))
+	return cfg, err
+}
+
+// writeConfig writes a config file with the given name to a temporary directory
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(file, []byte(content), 0o600))
+	return file
+}
+
+func TestServerConfig_Defaults(t *testing.T) {
+	cfg, err := loadConfig(t)
+	require.NoError(t, err)
+	assert.Equal(t, defaultServerAddr, cfg.Addr)
+	assert.Equal(t, 2, cfg.Executor.Workers)
+	assert.Equal(t, 5*time.Second, cfg.Executor.Delay)
+	assert.True(t, cfg.Retention.KeepLastSuccessful)
+	assert.Equal(t, time.Minute, cfg.Retention.JanitorInterval)
+	assert.Equal(t, "json", cfg.Log.Format)
+	assert.Equal(t, "none", cfg.Tracing.Exporter)
+	assert.True(t, cfg.UI)
+}
+
+func TestServerConfig_Files(t *testing.T) {
+	yamlFile := writeConfig(t, "stagerunner.yaml", `
+addr: ":9090"
+shutdown_timeout: 1m
+executor:
+  workers: 8
+  delay: 1500ms
+plugins:
+  dir: /usr/lib/stagerunner/plugins
+retention:
+  keep_runs: 20
+  keep_last_successful: false
+tls:
+  cert: server.crt
+  key: server.key
+  client_ca: ca.crt
+  client_identities:
+    deployer: CN=ci,O=acme
+`)
+	tomlFile := writeConfig(t, "stagerunner.toml", `
+addr = ":9090"
+shutdown_timeout = "1m"
+
+[executor]
+workers = 8
+delay = "1500ms"
+
+[plugins]
+dir = "/usr/lib/stagerunner/plugins"
+
+[retention]
+keep_runs = 20
+keep_last_successful = false
+
+[tls]
+cert = "server.crt"
+key = "server.key"
+client_ca = "ca.crt"
+
+[tls.client_identities]
+deployer = "CN=ci,O=acme"
+`)
+
+	for _, file := range []string{yamlFile, tomlFile} {
+		t.Run(filepath.Ext(file), func(t *testing.T) {
+			cfg, err := loadConfig(t, "--config", file)
+			require.NoError(t, err)
+			assert.Equal(t, ":9090", cfg.Addr)
+			assert.Equal(t, time.Minute, cfg.ShutdownTimeout)
+			assert.Equal(t, 8, cfg.Executor.Workers)
+			assert.Equal(t, 1500*time.Millisecond, cfg.Executor.Delay)
+			assert.Equal(t, "/usr/lib/stagerunner/plugins", cfg.Plugins.Dir)
+			assert.Equal(t, 20, cfg.Retention.KeepRuns)
+			assert.False(t, cfg.Retention.KeepLastSuccessful)
+			assert.Equal(t, map[string]string{"CN=ci,O=acme": "deployer"}, cfg.clientIdentities())
+			// settings missing in the file have their defaults
+			assert.Equal(t, 10, cfg.Executor.QueueSize)
+			assert.Equal(t, "info", cfg.Log.Level)
+			assert.Equal(t, 30*time.Minute, cfg.Plugins.Timeout)
+		})
+	}
+}
+
+func TestServerConfig_Precedence(t *testing.T) {
+	file := writeConfig(t, "stagerunner.yaml", `
+addr: ":9090"
+executor:
+  workers: 8
+  queue_size: 20
+log:
+  level: debug
+`)
+	t.Setenv("STAGERUNNER_WORKERS", "4")
+	t.Setenv("STAGERUNNER_QUEUE_SIZE", "30")
+
+	cfg, err := loadConfig(t, "--config", file, "--workers", "6")
+	require.NoError(t, err)
+	// flags take precedence over environment variables
+	assert.Equal(t, 6, cfg.Executor.Workers)
+	// environment variables take precedence over the config file
+	assert.Equal(t, 30, cfg.Executor.QueueSize)
+	// the config file takes precedence over the defaults
+	assert.Equal(t, ":9090", cfg.Addr)
+	assert.Equal(t, "debug", cfg.Log.Level)
+}
+
+func TestServerConfig_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		args    []string
+		wantErr []string
+	}{
+		{
+			name:    "unknown yaml setting",
+			file:    "stagerunner.yaml",
+			content: "executor:\n  worker: 3\n",
+			wantErr: []string{"line 2: field worker not found"},
+		},
+		{
+			name:    "unknown toml setting",
+			file:    "stagerunner.toml",
+			content: "[executor]\nworker = 3\n",
+			wantErr: []string{"unknown settings executor.worker"},
+		},
+		{
+			name:    "wrong type",
+			file:    "stagerunner.yaml",
+			content: "executor:\n  workers: many\n",
+			wantErr: []string{"cannot unmarshal !!str `many` into int"},
+		},
+		{
+			name:    "unsupported extension",
+			file:    "stagerunner.json",
+			content: "{}",
+			wantErr: []string{`unsupported config file extension ".json"`},
+		},
+		{
+			name:    "all invalid settings are reported",
+			file:    "stagerunner.yaml",
+			content: "executor:\n  workers: 0\n  fail_probability: 2\nlog:\n  format: xml\ntls:\n  cert: server.crt\n",
+			wantErr: []string{
+				"executor.workers must be positive",
+				"executor.fail_probability must be between 0 and 1, got 2",
+				`log.format must be json or text, got "xml"`,
+				"tls.cert and tls.key must be set together",
+			},
+		},
+		{
+			name:    "invalid flag",
+			file:    "stagerunner.yaml",
+			args:    []string{"--client-identity", "deployer"},
+			wantErr: []string{`invalid client-identity "deployer", must be identity=subject`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"--config", writeConfig(t, tt.file, tt.content)}, tt.args...)
+			_, err := loadConfig(t, args...)
+			require.Error(t, err)
+			for _, want := range tt.wantErr {
+				assert.ErrorContains(t, err, want)
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := loadConfig(t, "--config", filepath.Join(t.TempDir(), "missing.yaml"))
+		assert.ErrorContains(t, err, "failed to read config file")
+	})
+}
+
+func TestServerConfig_Print(t *testing.T) {
+	cfg, err := loadConfig(t, "--otlp-header", "api-key=secret", "--trace-exporter", "otlp")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"api-key": "secret"}, cfg.Tracing.OTLPHeaders)
+
+	var buf bytes.Buffer
+	require.NoError(t, cfg.print(&buf))
+	assert.Contains(t, buf.String(), "api-key: <redacted>")
+	assert.NotContains(t, buf.String(), "secret")
+	assert.Contains(t, buf.String(), "janitor_interval: 1m0s")
+	// the config itself is not changed
+	assert.Equal(t, "secret", cfg.Tracing.OTLPHeaders["api-key"])
+
+	// the printed config can be used as config file
+	printed, err := loadConfig(t, "--config", writeConfig(t, "printed.yaml", buf.String()))
+	require.NoError(t, err)
+	assert.Equal(t, cfg.Executor, printed.Executor)
+	assert.Equal(t, cfg.Retention, printed.Retention)
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// tokenCommandTimeout is the maximum time the token command of a context may take
+const tokenCommandTimeout = 30 * time.Second
+
+// clientConfig is the config file of the client with the named contexts of the API servers
+type clientConfig struct {
+	CurrentContext string                    `yaml:"current_context,omitempty"`
+	Contexts       map[string]*clientContext `yaml:"contexts,omitempty"`
+}
+
+// clientContext is the connection to an API server
+type clientContext struct {
+	URL   string `yaml:"url"`
+	Token string `yaml:"token,omitempty"`
+	// TokenCommand is a shell command printing the token, e.g. to read it from a password manager
+	TokenCommand string `yaml:"token_command,omitempty"`
+	CACert       string `yaml:"ca_cert,omitempty"`
+	ClientCert   string `yaml:"client_cert,omitempty"`
+	ClientKey    string `yaml:"client_key,omitempty"`
+}
+
+// connection is the effective connection settings of a client command
+type connection struct {
+	URL        string
+	Token      string
+	CACert     string
+	ClientCert string
+	ClientKey  string
+}
+
+// contextCommand is the cli command for managing the contexts of the client config
+var contextCommand = &cli.Command{
+	Name:  "context",
+	Usage: "Manage the API servers of the client config",
+	Subcommands: []*cli.Command{
+		{
+			Name:   "list",
+			Usage:  "List the contexts, the current context is marked with *",
+			Action: listContexts,
+		},
+		{
+			Name:      "use",
+			Usage:     "Set the current context",
+			ArgsUsage: "<name>",
+			Action:    useContext,
+		},
+		{
+			Name:      "add",
+			Usage:     "Add a context or replace an existing context with the same name",
+			ArgsUsage: "[flags] <name>",
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "url", Usage: "API server URL", Required: true},
+				&cli.StringFlag{Name: "token", Usage: "Authorization token, stored in the client config"},
+				&cli.StringFlag{Name: "token-command", Usage: "Shell command printing the authorization token, e.g. \"pass show stagerunner\""},
+				&cli.StringFlag{Name: "ca-cert", Usage: "CA certificate file to verify the server certificate with"},
+				&cli.StringFlag{Name: "client-cert", Usage: "Client certificate file to authenticate with"},
+				&cli.StringFlag{Name: "client-key", Usage: "Private key file of the client certificate"},
+				&cli.BoolFlag{Name: "use", Usage: "Make it the current context"},
+			},
+			Action: addContext,
+		},
+	},
+}
+
+// defaultClientConfigFile returns the path of the client config in the user's config directory
+func defaultClientConfigFile() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "stagerunner", "config.yaml")
+}
+
+// loadClientConfig reads the client config, a missing file is an empty config. Config files with
+// tokens are rejected if they are accessible by other users.
+func loadClientConfig(file string) (*clientConfig, error) {
+	cfg := &clientConfig{Contexts: map[string]*clientContext{}}
+	if file == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client config: %w", err)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && len(bytes.TrimSpace(data)) > 0 {
+		return nil, fmt.Errorf("invalid client config %s: %w", file, err)
+	}
+	if cfg.Contexts == nil {
+		cfg.Contexts = map[string]*clientContext{}
+	}
+
+	if cfg.hasTokens() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client config: %w", err)
+		}
+		if info.Mode().Perm()&0o077 != 0 {
+			return nil, fmt.Errorf("client config %s contains tokens and is accessible by other users, run: chmod 600 %s", file, file)
+		}
+	}
+	return cfg, nil
+}
+
+// save writes the client config, only accessible by the current user
+func (cfg *clientConfig) save(file string) error {
+	if file == "" {
+		return fmt.Errorf("no client config file, set --client-config")
+	}
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to encode client config: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return fmt.Errorf("failed to create client config directory: %w", err)
+	}
+
+	// write to a temporary file first, so the config is not lost if writing fails
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".config-*.yaml")
+	if err != nil {
+		return fmt.Errorf("failed to write client config: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write client config: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write client config: %w", err)
+	}
+	// CreateTemp is creating the file with mode 0600
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return fmt.Errorf("failed to write client config: %w", err)
+	}
+	return nil
+}
+
+// hasTokens returns true if a context of the config contains a token
+func (cfg *clientConfig) hasTokens() bool {
+	for _, ctx := range cfg.Contexts {
+		if ctx.Token != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// context returns the named context, or the current context if name is empty.
+// It returns nil if no context is selected.
+func (cfg *clientConfig) context(name string) (*clientContext, error) {
+	if name == "" {
+		name = cfg.CurrentContext
+	}
+	if name == "" {
+		return nil, nil
+	}
+	ctx, ok := cfg.Contexts[name]
+	if !ok {
+		return nil, fmt.Errorf("context %q not found in client config", name)
+	}
+	return ctx, nil
+}
+
+// clientConnection returns the connection settings of a client command. The flags and their
+// environment variables take precedence over the selected context of the client config.
+func clientConnection(c *cli.Context) (connection, error) {
+	conn := connection{
+		URL:        c.String("url"),
+		Token:      c.String("token"),
+		CACert:     c.String("ca-cert"),
+		ClientCert: c.String("client-cert"),
+		ClientKey:  c.String("client-key"),
+	}
+
+	cfg, err := loadClientConfig(c.String("client-config"))
+	if err != nil {
+		return connection{}, err
+	}
+	ctx, err := cfg.context(c.String("context"))
+	if err != nil || ctx == nil {
+		return conn, err
+	}
+
+	override := func(name string, dst *string, value string) {
+		if !c.IsSet(name) && value != "" {
+			*dst = value
+		}
+	}
+	override("url", &conn.URL, ctx.URL)
+	override("ca-cert", &conn.CACert, ctx.CACert)
+	override("client-cert", &conn.ClientCert, ctx.ClientCert)
+	override("client-key", &conn.ClientKey, ctx.ClientKey)
+	if !c.IsSet("token") {
+		if ctx.TokenCommand != "" {
+			token, err := runTokenCommand(c.Context, ctx.TokenCommand)
+			if err != nil {
+				return connection{}, err
+			}
+			conn.Token = token
+		} else {
+			conn.Token = ctx.Token
+		}
+	}
+	return conn, nil
+}
+
+// runTokenCommand runs the shell command and returns its output without surrounding whitespace
+func runTokenCommand(ctx context.Context, command string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, tokenCommandTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("token command failed: %w", err)
+	}
+	token := strings.TrimSpace(string(out))
+	if token == "" {
+		return "", fmt.Errorf("token command returned no token")
+	}
+	return token, nil
+}
+
+func listContexts(c *cli.Context) error {
+	cfg, err := loadClientConfig(c.String("client-config"))
+	if err != nil {
+		return err
+	}
+
+	for _, name := range sortedKeys(cfg.Contexts) {
+		marker := " "
+		if name == cfg.CurrentContext {
+			marker = "*"
+		}
+		fmt.Fprintf(c.App.Writer, "%s %s, URL: %s\n", marker, name, cfg.Contexts[name].URL)
+	}
+	return nil
+}
+
+func useContext(c *cli.Context) error {
+	if c.NArg() < 1 {
+		return fmt.Errorf("context name required")
+	}
+	name := c.Args().Get(0)
+
+	file := c.String("client-config")
+	cfg, err := loadClientConfig(file)
+	if err != nil {
+		return err
+	}
+	if _, ok := cfg.Contexts[name]; !ok {
+		return fmt.Errorf("context %q not found in client config", name)
+	}
+	cfg.CurrentContext = name
+	if err := cfg.save(file); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.App.Writer, "Switched to context %s\n", name)
+	return nil
+}
+
+func addContext(c *cli.Context) error {
+	if c.NArg() < 1 {
+		return fmt.Errorf("context name required")
+	}
+	name := c.Args().Get(0)
+	if c.String("token") != "" && c.String("token-command") != "" {
+		return fmt.Errorf("token and token-command are mutually exclusive")
+	}
+	if (c.String("client-cert") == "") != (c.String("client-key") == "") {
+		return fmt.Errorf("client-cert and client-key must be set together")
+	}
+
+	file := c.String("client-config")
+	cfg, err := loadClientConfig(file)
+	if err != nil {
+		return err
+	}
+	_, exists := cfg.Contexts[name]
+	cfg.Contexts[name] = &clientContext{
+		URL:          c.String("url"),
+		Token:        c.String("token"),
+		TokenCommand: c.String("token-command"),
+		CACert:       c.String("ca-cert"),
+		ClientCert:   c.String("client-cert"),
+		ClientKey:    c.String("client-key"),
+	}
+	if c.Bool("use") || cfg.CurrentContext == "" {
+		cfg.CurrentContext = name
+	}
+	if err := cfg.save(file); err != nil {
+		return err
+	}
+
+	if exists {
+		fmt.Fprintf(c.App.Writer, "Context %s updated\n", name)
+	} else {
+		fmt.Fprintf(c.App.Writer, "Context %s added\n", name)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+// runClient runs the client command with the given arguments and returns its output. The connection
+// of the client is resolved by the "connection" subcommand.
+func runClient(t *testing.T, args ...string) (string, connection, error) {
+	t.Helper()
+	var out bytes.Buffer
+	var conn connection
+	app := &cli.App{
+		Writer: &out,
+		Flags:  clientFlags(),
+		Commands: []*cli.Command{
+			contextCommand,
+			{
+				Name: "connection",
+				Action: func(c *cli.Context) error {
+					var err error
+					conn, err = clientConnection(c)
+					return err
+				},
+			},
+		},
+	}
+	err := app.Run(append([]string{"client"}, args...))
+	return out.String(), conn, err
+}
+
+func TestClientContexts(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "stagerunner", "config.yaml")
+	t.Setenv("STAGERUNNER_CLIENT_CONFIG", file)
+
+	t.Run("no config", func(t *testing.T) {
+		_, conn, err := runClient(t, "connection")
+		require.NoError(t, err)
+		assert.Equal(t, connection{URL: defaultAPIURL}, conn)
+	})
+
+	out, _, err := runClient(t, "context", "add", "--url", "https://staging:8080", "--token-command", "echo staging-token", "staging")
+	require.NoError(t, err)
+	assert.Equal(t, "Context staging added\n", out)
+	_, _, err = runClient(t, "context", "add", "--url", "https://prod:8080", "--token", "prod-token", "--ca-cert", "ca.crt", "prod")
+	require.NoError(t, err)
+
+	info, err := os.Stat(file)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	// the first context is the current context
+	out, _, err = runClient(t, "context", "list")
+	require.NoError(t, err)
+	assert.Equal(t, "  prod, URL: https://prod:8080\n* staging, URL: https://staging:8080\n", out)
+
+	t.Run("token command", func(t *testing.T) {
+		_, conn, err := runClient(t, "connection")
+		require.NoError(t, err)
+		assert.Equal(t, connection{URL: "https://staging:8080", Token: "staging-token"}, conn)
+	})
+
+	out, _, err = runClient(t, "context", "use", "prod")
+	require.NoError(t, err)
+	assert.Equal(t, "Switched to context prod\n", out)
+
+	tests := []struct {
+		name     string
+		args     []string
+		env      map[string]string
+		wantConn connection
+		wantErr  string
+	}{
+		{
+			name:     "current context",
+			wantConn: connection{URL: "https://prod:8080", Token: "prod-token", CACert: "ca.crt"},
+		},
+		{
+			name:     "context flag",
+			args:     []string{"--context", "staging"},
+			wantConn: connection{URL: "https://staging:8080", Token: "staging-token"},
+		},
+		{
+			name:     "flags take precedence",
+			args:     []string{"--url", "https://other:8080", "--token", "other-token"},
+			wantConn: connection{URL: "https://other:8080", Token: "other-token", CACert: "ca.crt"},
+		},
+		{
+			name:     "environment variables take precedence",
+			env:      map[string]string{"STAGERUNNER_TOKEN": "env-token"},
+			wantConn: connection{URL: "https://prod:8080", Token: "env-token", CACert: "ca.crt"},
+		},
+		{
+			name:    "unknown context",
+			args:    []string{"--context", "dev"},
+			wantErr: `context "dev" not found in client config`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			_, conn, err := runClient(t, append(tt.args, "connection")...)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantConn, conn)
+		})
+	}
+
+	t.Run("use unknown context", func(t *testing.T) {
+		_, _, err := runClient(t, "context", "use", "dev")
+		assert.ErrorContains(t, err, `context "dev" not found in client config`)
+	})
+
+	t.Run("failing token command", func(t *testing.T) {
+		_, _, err := runClient(t, "context", "add", "--url", "https://broken:8080", "--token-command", "exit 1", "broken")
+		require.NoError(t, err)
+		_, _, err = runClient(t, "--context", "broken", "connection")
+		assert.ErrorContains(t, err, "token command failed")
+	})
+
+	t.Run("config with tokens accessible by other users", func(t *testing.T) {
+		require.NoError(t, os.Chmod(file, 0o644))
+		_, _, err := runClient(t, "connection")
+		assert.ErrorContains(t, err, "contains tokens and is accessible by other users")
+	})
+}
@@ -18,7 +18,11 @@ func main() {
 		Commands: []*cli.Command{
 			serverCommand,
 			clientCommand,
+			tuiCommand,
+			runCommand,
 		},
+		// values of slice flags like certificate subjects contain commas
+		DisableSliceFlagSeparator: true,
 	}
 
 	// run cli app
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Output formats of the --output flag
+const (
+	outputTable    = "table"
+	outputJSON     = "json"
+	outputYAML     = "yaml"
+	outputTemplate = "template"
+	outputJSONPath = "jsonpath"
+)
+
+// outputFormat is the parsed --output flag, e.g. template={{.id}}
+type outputFormat struct {
+	kind string
+	// arg is the template or JSONPath expression
+	arg string
+}
+
+// parseOutput parses the value of the --output flag
+func parseOutput(s string) (outputFormat, error) {
+	kind, arg, _ := strings.Cut(s, "=")
+	switch kind {
+	case outputTable, outputJSON, outputYAML:
+		if arg != "" {
+			return outputFormat{}, fmt.Errorf("output %s does not take an argument", kind)
+		}
+	case outputTemplate:
+		if arg == "" {
+			return outputFormat{}, fmt.Errorf("output template requires a Go template, e.g. template={{.id}}")
+		}
+		if _, err := template.New("output").Parse(arg); err != nil {
+			return outputFormat{}, fmt.Errorf("invalid output template: %w", err)
+		}
+	case outputJSONPath:
+		if arg == "" {
+			return outputFormat{}, fmt.Errorf("output jsonpath requires an expression, e.g. jsonpath={.id}")
+		}
+		if _, err := parseJSONPath(arg); err != nil {
+			return outputFormat{}, err
+		}
+	default:
+		return outputFormat{}, fmt.Errorf("invalid output %q, must be table, json, yaml, template=<go-template> or jsonpath=<expression>", s)
+	}
+	return outputFormat{kind: kind, arg: arg}, nil
+}
+
+// column is a column of the table output of items of type T
+type column[T any] struct {
+	header string
+	value  func(T) string
+}
+
+// printList writes the items in the output format of the client command. JSON and YAML are
+// written as a list, templates and JSONPath expressions are evaluated for every item.
+func printList[T any](c *cli.Context, items []T, columns []column[T]) error {
+	if items == nil {
+		items = []T{}
+	}
+	return printOutput(c, items, items, columns)
+}
+
+// printItem writes a single item in the output format of the client command
+func printItem[T any](c *cli.Context, item T, columns []column[T]) error {
+	return printOutput(c, item, []T{item}, columns)
+}
+
+func printOutput[T any](c *cli.Context, data any, items []T, columns []column[T]) error {
+	format, err := parseOutput(c.String("output"))
+	if err != nil {
+		return err
+	}
+	w := c.App.Writer
+
+	switch format.kind {
+	case outputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(data)
+	case outputYAML:
+		// use the JSON field names and formats
+		v, err := toGeneric(data)
+		if err != nil {
+			return err
+		}
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		return enc.Close()
+	case outputTemplate:
+		tmpl, err := template.New("output").Option("missingkey=error").Parse(format.arg)
+		if err != nil {
+			return fmt.Errorf("invalid output template: %w", err)
+		}
+		return printEach(w, items, func(w io.Writer, v any) error {
+			return tmpl.Execute(w, v)
+		})
+	case outputJSONPath:
+		path, err := parseJSONPath(format.arg)
+		if err != nil {
+			return err
+		}
+		return printEach(w, items, func(w io.Writer, v any) error {
+			values, err := path.eval(v)
+			if err != nil {
+				return err
+			}
+			strs := make([]string, 0, len(values))
+			for _, v := range values {
+				strs = append(strs, formatValue(v))
+			}
+			_, err = io.WriteString(w, strings.Join(strs, " "))
+			return err
+		})
+	default:
+		tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+		headers := make([]string, 0, len(columns))
+		for _, col := range columns {
+			headers = append(headers, col.header)
+		}
+		fmt.Fprintln(tw, strings.Join(headers, "\t"))
+		for _, item := range items {
+			values := make([]string, 0, len(columns))
+			for _, col := range columns {
+				values = append(values, col.value(item))
+			}
+			fmt.Fprintln(tw, strings.Join(values, "\t"))
+		}
+		return tw.Flush()
+	}
+}
+
+// printEach writes a line per item with the JSON representation of the item passed to print
+func printEach[T any](w io.Writer, items []T, print func(io.Writer, any) error) error {
+	for _, item := range items {
+		v, err := toGeneric(item)
+		if err != nil {
+			return err
+		}
+		if err := print(w, v); err != nil {
+			return fmt.Errorf("failed to print output: %w", err)
+		}
+		if _, err := fmt.Fprintln(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// toGeneric returns the JSON representation of v as maps, slices and scalars
+func toGeneric(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	// keep integers as they are
+	dec.UseNumber()
+	var generic any
+	if err := dec.Decode(&generic); err != nil {
+		return nil, err
+	}
+	return numbersToScalars(generic), nil
+}
+
+// numbersToScalars replaces the json.Numbers of the generic value with int64 or float64 values
+func numbersToScalars(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		for k, e := range v {
+			v[k] = numbersToScalars(e)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = numbersToScalars(e)
+		}
+	}
+	return v
+}
+
+// formatValue formats a value selected by a JSONPath expression. Objects and lists are written as JSON.
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
+
+// jsonPath is a JSONPath-like expression selecting fields of the JSON representation of an item,
+// e.g. {.id}, {.logs.run_stage}, {.items[0]} or {.items[*].id}
+type jsonPath []pathSegment
+
+// pathSegment is a field name or an index, where an index of -1 is selecting all elements
+type pathSegment struct {
+	field string
+	index int
+	isIdx bool
+}
+
+// parseJSONPath parses an expression with optional braces, starting with a field or index
+func parseJSONPath(expr string) (jsonPath, error) {
+	invalid := func(reason string) (jsonPath, error) {
+		return nil, fmt.Errorf("invalid output jsonpath %q: %s", expr, reason)
+	}
+
+	s := strings.TrimSpace(expr)
+	if strings.HasPrefix(s, "{") {
+		if !strings.HasSuffix(s, "}") {
+			return invalid("missing closing brace")
+		}
+		s = s[1 : len(s)-1]
+	}
+	if s == "" || (s[0] != '.' && s[0] != '[') {
+		return invalid("must start with . or [")
+	}
+
+	var path jsonPath
+	for len(s) > 0 {
+		switch s[0] {
+		case '.':
+			s = s[1:]
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+			if end == 0 {
+				if len(s) == 0 && len(path) == 0 {
+					// "." is selecting the whole item
+					return path, nil
+				}
+				return invalid("empty field name")
+			}
+			path = append(path, pathSegment{field: s[:end]})
+			s = s[end:]
+		case '[':
+			end := strings.IndexByte(s, ']')
+			if end < 0 {
+				return invalid("missing ]")
+			}
+			idx := s[1:end]
+			if idx == "*" {
+				path = append(path, pathSegment{index: -1, isIdx: true})
+			} else {
+				i, err := strconv.Atoi(idx)
+				if err != nil || i < 0 {
+					return invalid(fmt.Sprintf("invalid index %q", idx))
+				}
+				path = append(path, pathSegment{index: i, isIdx: true})
+			}
+			s = s[end+1:]
+		default:
+			return invalid(fmt.Sprintf("unexpected %q", s[0]))
+		}
+	}
+	return path, nil
+}
+
+// eval returns the values selected by the expression
+func (p jsonPath) eval(v any) ([]any, error) {
+	values := []any{v}
+	for _, seg := range p {
+		var next []any
+		for _, v := range values {
+			switch {
+			case !seg.isIdx:
+				m, ok := v.(map[string]any)
+				if !ok {
+					return nil, fmt.Errorf("field %q not found", seg.field)
+				}
+				e, ok := m[seg.field]
+				if !ok {
+					return nil, fmt.Errorf("field %q not found", seg.field)
+				}
+				next = append(next, e)
+			case seg.index < 0:
+				switch v := v.(type) {
+				case nil:
+				case []any:
+					next = append(next, v...)
+				case map[string]any:
+					// select the values of objects in the order of their keys
+					for _, k := range sortedKeys(v) {
+						next = append(next, v[k])
+					}
+				default:
+					return nil, fmt.Errorf("[*] applied to a value which is not a list or object")
+				}
+			default:
+				l, ok := v.([]any)
+				if !ok || seg.index >= len(l) {
+					return nil, fmt.Errorf("index %d out of range", seg.index)
+				}
+				next = append(next, l[seg.index])
+			}
+		}
+		values = next
+	}
+	return values, nil
+}
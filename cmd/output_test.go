@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	myhttp "github.com/hphilipps/stagerunner/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+// printRuns prints the runs with the given output flag
+func printRuns(t *testing.T, output string, runs []myhttp.PipelineRunResponse) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	app := &cli.App{
+		Writer: &out,
+		Flags:  []cli.Flag{&cli.StringFlag{Name: "output", Value: outputTable}},
+		Action: func(c *cli.Context) error {
+			return printList(c, runs, runColumns)
+		},
+	}
+	err := app.Run([]string{"client", "--output", output})
+	return out.String(), err
+}
+
+func TestOutput(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	runs := []myhttp.PipelineRunResponse{
+		{
+			ID: "run-1", PipelineID: "p-1", GitRef: "main", Status: "succeeded",
+			RunStatus: "succeeded", BuildStatus: "succeeded", DeployStatus: "succeeded",
+			CreatedAt: created, UpdatedAt: created.Add(time.Minute),
+			Logs: map[string]string{"run": "ok", "build": "built"},
+		},
+		{
+			ID: "run-2", PipelineID: "p-1", GitRef: "feature-branch", Status: "running",
+			RunStatus: "running", BuildStatus: "pending", DeployStatus: "pending",
+			CreatedAt: created, UpdatedAt: created,
+		},
+	}
+
+	tests := []struct {
+		name   string
+		output string
+		runs   []myhttp.PipelineRunResponse
+		want   string
+	}{
+		{
+			name:   "table",
+			output: "table",
+			runs:   runs,
+			want: `ID      PIPELINE ID   GIT REF          STATUS      RUN         BUILD       DEPLOY      CREATED                UPDATED
+run-1   p-1           main             succeeded   succeeded   succeeded   succeeded   2024-05-01T12:00:00Z   2024-05-01T12:01:00Z
+run-2   p-1           feature-branch   running     running     pending     pending     2024-05-01T12:00:00Z   2024-05-01T12:00:00Z
+`,
+		},
+		{
+			name:   "template",
+			output: "template={{.id}} {{.git_ref}}",
+			runs:   runs,
+			want:   "run-1 main\nrun-2 feature-branch\n",
+		},
+		{
+			name:   "jsonpath",
+			output: "jsonpath={.id}",
+			runs:   runs,
+			want:   "run-1\nrun-2\n",
+		},
+		{
+			name:   "jsonpath without braces",
+			output: "jsonpath=.logs.run",
+			runs:   runs[:1],
+			want:   "ok\n",
+		},
+		{
+			// the second run has no logs
+			name:   "jsonpath selecting all values",
+			output: "jsonpath={.logs[*]}",
+			runs:   runs,
+			want:   "built ok\n\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := printRuns(t, tt.output, tt.runs)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, out)
+		})
+	}
+
+	t.Run("json", func(t *testing.T) {
+		out, err := printRuns(t, "json", runs[1:])
+		require.NoError(t, err)
+		assert.JSONEq(t, `[{"id": "run-2", "pipeline_id": "p-1", "git_ref": "feature-branch", "status": "running",
+			"created_at": "2024-05-01T12:00:00Z", "updated_at": "2024-05-01T12:00:00Z", "run_status": "running",
+			"build_status": "pending", "deploy_status": "pending", "logs": null}]`, out)
+	})
+
+	t.Run("yaml", func(t *testing.T) {
+		out, err := printRuns(t, "yaml", runs[:1])
+		require.NoError(t, err)
+		assert.Equal(t, `- build_status: succeeded
+  created_at: "2024-05-01T12:00:00Z"
+  deploy_status: succeeded
+  git_ref: main
+  id: run-1
+  logs:
+    build: built
+    run: ok
+  pipeline_id: p-1
+  run_status: succeeded
+  status: succeeded
+  updated_at: "2024-05-01T12:01:00Z"
+`, out)
+	})
+
+	t.Run("empty list", func(t *testing.T) {
+		out, err := printRuns(t, "json", nil)
+		require.NoError(t, err)
+		assert.Equal(t, "[]\n", out)
+	})
+
+	t.Run("missing field", func(t *testing.T) {
+		_, err := printRuns(t, "jsonpath={.commit}", runs)
+		assert.ErrorContains(t, err, `field "commit" not found`)
+		_, err = printRuns(t, "template={{.commit}}", runs)
+		assert.ErrorContains(t, err, `map has no entry for key "commit"`)
+	})
+}
+
+func TestParseOutput(t *testing.T) {
+	tests := []struct {
+		output  string
+		want    outputFormat
+		wantErr string
+	}{
+		{output: "table", want: outputFormat{kind: outputTable}},
+		{output: "json", want: outputFormat{kind: outputJSON}},
+		{output: "template={{.id}}={{.name}}", want: outputFormat{kind: outputTemplate, arg: "{{.id}}={{.name}}"}},
+		{output: "jsonpath={.items[0].id}", want: outputFormat{kind: outputJSONPath, arg: "{.items[0].id}"}},
+		{output: "xml", wantErr: `invalid output "xml"`},
+		{output: "json=x", wantErr: "output json does not take an argument"},
+		{output: "template", wantErr: "output template requires a Go template"},
+		{output: "template={{.id", wantErr: "invalid output template"},
+		{output: "jsonpath=id", wantErr: "must start with . or ["},
+		{output: "jsonpath={.id", wantErr: "missing closing brace"},
+		{output: "jsonpath={.items[x]}", wantErr: `invalid index "x"`},
+		{output: "jsonpath={.a..b}", wantErr: "empty field name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.output, func(t *testing.T) {
+			got, err := parseOutput(tt.output)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestJSONPath(t *testing.T) {
+	v, err := toGeneric(map[string]any{
+		"items": []map[string]any{{"id": "a", "count": 1}, {"id": "b", "count": 2.5}},
+		"ok":    true,
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		expr string
+		want []any
+	}{
+		{expr: ".ok", want: []any{true}},
+		{expr: "{.items[1].id}", want: []any{"b"}},
+		{expr: "{.items[*].count}", want: []any{int64(1), 2.5}},
+		{expr: ".", want: []any{v}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			path, err := parseJSONPath(tt.expr)
+			require.NoError(t, err)
+			got, err := path.eval(v)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	path, err := parseJSONPath("{.items[2]}")
+	require.NoError(t, err)
+	_, err = path.eval(v)
+	assert.ErrorContains(t, err, "index 2 out of range")
+	assert.Equal(t, "[1,2.5]", formatValue([]any{int64(1), 2.5}))
+}
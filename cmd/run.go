@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hphilipps/stagerunner/domain"
+	"github.com/hphilipps/stagerunner/store"
+	"github.com/urfave/cli/v2"
+)
+
+// runCommand is the cli command for running a pipeline file locally without a server
+var runCommand = &cli.Command{
+	Name:      "run",
+	Usage:     "Run a pipeline file locally without a server, exits with an error if the run failed",
+	ArgsUsage: "[flags]",
+	Flags:     runFlags(),
+	Action:    runLocal,
+}
+
+// runFlags returns the flags of the run command
+func runFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "file",
+			Aliases: []string{"f"},
+			Usage:   "Pipeline JSON or YAML file to run, - reads from stdin",
+		},
+		&cli.StringFlag{
+			Name:  "ref",
+			Value: "HEAD",
+			Usage: "Git ref to run the pipeline on",
+		},
+		&cli.IntFlag{
+			Name:    "executor-delay",
+			Aliases: []string{"delay"},
+			Value:   1,
+			Usage:   "Delay in seconds between pipeline run stages",
+			EnvVars: []string{"STAGERUNNER_EXECUTOR_DELAY"},
+		},
+		&cli.Float64Flag{
+			Name:    "fail-probability",
+			Aliases: []string{"fp"},
+			Value:   0.0,
+			Usage:   "Probability of a pipeline run stage failing",
+			EnvVars: []string{"STAGERUNNER_FAIL_PROBABILITY"},
+		},
+		&cli.StringFlag{
+			Name:    "plugin-dir",
+			Usage:   "Directory of stage plugin executables used by the pipeline",
+			EnvVars: []string{"STAGERUNNER_PLUGIN_DIR"},
+		},
+		&cli.DurationFlag{
+			Name:    "plugin-timeout",
+			Value:   30 * time.Minute,
+			Usage:   "Maximum duration of a plugin stage (0 doesn't limit it)",
+			EnvVars: []string{"STAGERUNNER_PLUGIN_TIMEOUT"},
+		},
+	}
+}
+
+func runLocal(c *cli.Context) error {
+	if c.String("file") == "" {
+		return fmt.Errorf("pipeline file required, use --file")
+	}
+	// an interrupt cancels the run, which is then failing like runs cancelled on a server
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// stage types of plugins are required to decode the pipeline
+	if err := loadPlugins(ctx, c.String("plugin-dir"), c.Duration("plugin-timeout"), slog.New(newStageLogHandler(c.App.ErrWriter))); err != nil {
+		return err
+	}
+	req, err := readPipelineRequest(c, "")
+	if err != nil {
+		return err
+	}
+	// the pipeline is built and validated like pipelines created with the API
+	pipeline, err := req.Pipeline()
+	if err != nil {
+		return fmt.Errorf("invalid pipeline: %w", err)
+	}
+
+	delay := time.Duration(c.Int("executor-delay")) * time.Second
+	run, err := runPipeline(ctx, pipeline, c.String("ref"), c.Float64("fail-probability"), delay, c.App.Writer)
+	if err != nil {
+		return err
+	}
+	printRunSummary(c.App.Writer, run)
+	if run.Status != domain.StatusSuccess {
+		return cli.Exit(fmt.Sprintf("run %s", run.Status), 1)
+	}
+	return nil
+}
+
+// runPipeline is running the pipeline with an executor of its own against an in-memory store, just like
+// a server is running triggered pipelines. The log lines of the stages are written to w while they are
+// added to the run. Cancelling ctx cancels the run, the finished run is returned in both cases.
+func runPipeline(ctx context.Context, pipeline *domain.Pipeline, gitRef string, failProbability float64, delay time.Duration, w io.Writer) (*domain.PipelineRun, error) {
+	s := store.NewMemoryStore()
+	if err := s.CreatePipeline(ctx, pipeline); err != nil {
+		return nil, err
+	}
+	executor := domain.NewExecutor(s, 1, 1, 1, failProbability, delay, domain.WithLogger(slog.New(newStageLogHandler(w))))
+
+	// the executor is not stopped by ctx, so cancelled runs are finished by it
+	execCtx, stopExecutor := context.WithCancel(context.Background())
+	defer stopExecutor()
+
+	// watch before triggering, so no status change is missed
+	events, err := s.Watch(execCtx, domain.WatchFilter{
+		PipelineID: pipeline.ID,
+		Types:      []domain.EventType{domain.EventRunStatusChanged},
+	})
+	if err != nil {
+		return nil, err
+	}
+	run, err := executor.TriggerPipeline(ctx, pipeline, gitRef)
+	if err != nil {
+		return nil, err
+	}
+	stopped := make(chan struct{})
+	go func() {
+		executor.Start(execCtx)
+		close(stopped)
+	}()
+	defer func() {
+		stopExecutor()
+		<-stopped
+	}()
+
+	cancelled := ctx.Done()
+	for {
+		select {
+		case <-cancelled:
+			cancelled = nil
+			// the run might have finished in the meantime
+			if err := executor.CancelRun(context.Background(), run.ID); err != nil && !errors.Is(err, domain.ErrNotFound) {
+				return nil, err
+			}
+		case event, ok := <-events:
+			if !ok {
+				return nil, fmt.Errorf("lost the status changes of run %s", run.ID)
+			}
+			if event.RunID == run.ID && (event.Status == domain.StatusSuccess || event.Status == domain.StatusFailed) {
+				return s.GetPipelineRun(ctx, run.ID)
+			}
+		}
+	}
+}
+
+// printRunSummary prints the status and duration of the stages of a finished run
+func printRunSummary(w io.Writer, run *domain.PipelineRun) {
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "STAGE\tSTATUS\tDURATION")
+	for _, t := range domain.StageTypes() {
+		status, ok := run.StageStatuses[t.Name]
+		if !ok {
+			// the pipeline has no stage of this type
+			continue
+		}
+		duration := ""
+		if timing, ok := run.StageTimings[t.Name]; ok && !timing.FinishedAt.IsZero() {
+			duration = timing.FinishedAt.Sub(timing.StartedAt).Round(time.Millisecond).String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", t.Name, status, duration)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\nRun on %s %s after %s\n", run.GitRef, run.Status, run.UpdatedAt.Sub(run.CreatedAt).Round(time.Millisecond))
+}
+
+// stageLogHandler is a slog.Handler printing the log lines of the stages of a run as they are added to it.
+// Other log lines of the executor are only printed if they are warnings or errors.
+type stageLogHandler struct {
+	mu    *sync.Mutex
+	w     io.Writer
+	attrs []slog.Attr
+}
+
+func newStageLogHandler(w io.Writer) *stageLogHandler {
+	return &stageLogHandler{mu: &sync.Mutex{}, w: w}
+}
+
+func (h *stageLogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= slog.LevelInfo
+}
+
+func (h *stageLogHandler) Handle(_ context.Context, r slog.Record) error {
+	var stage string
+	var extra []string
+	collect := func(a slog.Attr) bool {
+		switch a.Key {
+		case "stage":
+			stage = a.Value.String()
+		case "pipeline_id", "run_id", "status":
+			// the same for all lines or part of the message
+		default:
+			extra = append(extra, a.Key+"="+a.Value.String())
+		}
+		return true
+	}
+	for _, a := range h.attrs {
+		collect(a)
+	}
+	r.Attrs(collect)
+
+	line := r.Time.Format("15:04:05") + " "
+	switch {
+	case stage != "":
+		line += fmt.Sprintf("%-7s %s", stage, r.Message)
+	case r.Level >= slog.LevelWarn:
+		line += strings.Join(append([]string{r.Level.String(), r.Message}, extra...), " ")
+	default:
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := fmt.Fprintln(h.w, line)
+	return err
+}
+
+func (h *stageLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &stageLogHandler{mu: h.mu, w: h.w, attrs: append(append([]slog.Attr(nil), h.attrs...), attrs...)}
+}
+
+// WithGroup is ignoring groups, the executor doesn't use them
+func (h *stageLogHandler) WithGroup(string) slog.Handler {
+	return h
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hphilipps/stagerunner/domain"
+	myhttp "github.com/hphilipps/stagerunner/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunPipeline(t *testing.T) {
+	newPipeline := func(t *testing.T) *domain.Pipeline {
+		pipeline, err := myhttp.PipelineRequest{
+			Name: "local",
+			Stages: myhttp.Stages{
+				"run_stage":    json.RawMessage(`{"command":"make test"}`),
+				"build_stage":  json.RawMessage(`{"dockerfile_path":"Dockerfile"}`),
+				"deploy_stage": json.RawMessage(`{"cluster_name":"dev","manifest_path":"k8s/"}`),
+			},
+		}.Pipeline()
+		require.NoError(t, err)
+		return pipeline
+	}
+
+	t.Run("success", func(t *testing.T) {
+		var out bytes.Buffer
+		run, err := runPipeline(context.Background(), newPipeline(t), "HEAD", 0, 0, &out)
+		require.NoError(t, err)
+		assert.Equal(t, domain.StatusSuccess, run.Status)
+		assert.Equal(t, "HEAD", run.GitRef)
+		assert.Regexp(t, `\d\d:\d\d:\d\d run     command: make test\n`, out.String())
+		assert.Contains(t, out.String(), "deploy  deploying to cluster name: dev\n")
+		assert.NotContains(t, out.String(), "worker picked up")
+
+		out.Reset()
+		printRunSummary(&out, run)
+		assert.Regexp(t, `build +success +\d`, out.String())
+		assert.Contains(t, out.String(), "Run on HEAD success after")
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		var out bytes.Buffer
+		run, err := runPipeline(context.Background(), newPipeline(t), "main", 1, 0, &out)
+		require.NoError(t, err)
+		assert.Equal(t, domain.StatusFailed, run.Status)
+		assert.Equal(t, domain.StatusPending, run.StageStatus(domain.StageBuild))
+		assert.Contains(t, out.String(), "run     failed\n")
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		var out bytes.Buffer
+		run, err := runPipeline(ctx, newPipeline(t), "main", 0, time.Minute, &out)
+		require.NoError(t, err)
+		assert.Equal(t, domain.StatusFailed, run.Status)
+		assert.Contains(t, out.String(), "run     cancelled\n")
+	})
+}
@@ -2,20 +2,45 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/hphilipps/stagerunner/domain"
 	myhttp "github.com/hphilipps/stagerunner/http"
+	"github.com/hphilipps/stagerunner/metrics"
+	"github.com/hphilipps/stagerunner/plugin"
 	"github.com/hphilipps/stagerunner/store"
+	"github.com/hphilipps/stagerunner/tracing"
+	"github.com/hphilipps/stagerunner/web"
 	"github.com/urfave/cli/v2"
 )
 
 // serverCommand is the cli command for starting the API server
 var serverCommand = &cli.Command{
-	Name:  "server",
-	Usage: "Start the API server",
-	Flags: []cli.Flag{
+	Name:   "server",
+	Usage:  "Start the API server",
+	Flags:  serverFlags(),
+	Action: runServer,
+}
+
+// serverFlags returns the flags of the server command
+func serverFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "config",
+			Usage:   "YAML (.yaml, .yml) or TOML (.toml) file with the server settings, flags and environment variables take precedence",
+			EnvVars: []string{"STAGERUNNER_CONFIG"},
+		},
+		&cli.BoolFlag{
+			Name:  "print-config",
+			Usage: "Print the effective config with redacted secrets and exit",
+		},
 		&cli.StringFlag{
 			Name:    "addr",
 			Value:   defaultServerAddr,
@@ -56,27 +81,300 @@ var serverCommand = &cli.Command{
 			Usage:   "Probability of a pipeline run stage failing",
 			EnvVars: []string{"STAGERUNNER_FAIL_PROBABILITY"},
 		},
-	},
-	Action: runServer,
+		&cli.StringFlag{
+			Name:    "plugin-dir",
+			Usage:   "Directory of stage plugin executables, which are loaded on start",
+			EnvVars: []string{"STAGERUNNER_PLUGIN_DIR"},
+		},
+		&cli.DurationFlag{
+			Name:    "plugin-timeout",
+			Value:   30 * time.Minute,
+			Usage:   "Maximum duration of a plugin stage (0 doesn't limit it)",
+			EnvVars: []string{"STAGERUNNER_PLUGIN_TIMEOUT"},
+		},
+		&cli.IntFlag{
+			Name:    "keep-runs",
+			Value:   0,
+			Usage:   "Number of finished runs to keep per pipeline (0 keeps all)",
+			EnvVars: []string{"STAGERUNNER_KEEP_RUNS"},
+		},
+		&cli.DurationFlag{
+			Name:    "max-run-age",
+			Value:   0,
+			Usage:   "Maximum age of finished runs, e.g. 168h (0 keeps all)",
+			EnvVars: []string{"STAGERUNNER_MAX_RUN_AGE"},
+		},
+		&cli.BoolFlag{
+			Name:    "keep-last-successful",
+			Value:   true,
+			Usage:   "Always keep the last successful run of a pipeline",
+			EnvVars: []string{"STAGERUNNER_KEEP_LAST_SUCCESSFUL"},
+		},
+		&cli.DurationFlag{
+			Name:    "janitor-interval",
+			Value:   time.Minute,
+			Usage:   "Interval for removing runs according to the retention settings",
+			EnvVars: []string{"STAGERUNNER_JANITOR_INTERVAL"},
+		},
+		&cli.DurationFlag{
+			Name:    "idempotency-ttl",
+			Value:   24 * time.Hour,
+			Usage:   "How long trigger responses are replayed for retries with the same Idempotency-Key",
+			EnvVars: []string{"STAGERUNNER_IDEMPOTENCY_TTL"},
+		},
+		&cli.BoolFlag{
+			Name:    "ui",
+			Value:   true,
+			Usage:   "Serve the web UI at /ui/",
+			EnvVars: []string{"STAGERUNNER_UI"},
+		},
+		&cli.StringFlag{
+			Name:    "log-format",
+			Value:   "json",
+			Usage:   "Format of the logs (json or text)",
+			EnvVars: []string{"STAGERUNNER_LOG_FORMAT"},
+		},
+		&cli.StringFlag{
+			Name:    "log-level",
+			Value:   "info",
+			Usage:   "Minimum level of the logs (debug, info, warn or error)",
+			EnvVars: []string{"STAGERUNNER_LOG_LEVEL"},
+		},
+		&cli.StringFlag{
+			Name:    "trace-exporter",
+			Value:   tracing.ExporterNone,
+			Usage:   "Exporter of the OpenTelemetry traces (none, stdout or otlp)",
+			EnvVars: []string{"STAGERUNNER_TRACE_EXPORTER"},
+		},
+		&cli.StringFlag{
+			Name:    "otlp-endpoint",
+			Usage:   "host:port of the OTLP/HTTP trace collector (defaults to the OTEL_EXPORTER_OTLP_ENDPOINT environment variable or localhost:4318)",
+			EnvVars: []string{"STAGERUNNER_OTLP_ENDPOINT"},
+		},
+		&cli.BoolFlag{
+			Name:    "otlp-insecure",
+			Usage:   "Connect to the OTLP trace collector without TLS",
+			EnvVars: []string{"STAGERUNNER_OTLP_INSECURE"},
+		},
+		&cli.StringSliceFlag{
+			Name:  "otlp-header",
+			Usage: "Header sent to the OTLP trace collector, e.g. api-key=secret (can be repeated)",
+		},
+		&cli.StringFlag{
+			Name:    "tls-cert",
+			Usage:   "Certificate file to serve HTTPS with (requires --tls-key)",
+			EnvVars: []string{"STAGERUNNER_TLS_CERT"},
+		},
+		&cli.StringFlag{
+			Name:    "tls-key",
+			Usage:   "Private key file of the TLS certificate",
+			EnvVars: []string{"STAGERUNNER_TLS_KEY"},
+		},
+		&cli.StringFlag{
+			Name:    "client-ca",
+			Usage:   "CA certificate file to verify client certificates with, enables authentication by client certificate (requires TLS)",
+			EnvVars: []string{"STAGERUNNER_CLIENT_CA"},
+		},
+		&cli.StringSliceFlag{
+			Name:  "client-identity",
+			Usage: "Map the subject or common name of client certificates to an identity, e.g. deployer=CN=ci,O=acme (can be repeated)",
+		},
+		&cli.DurationFlag{
+			Name:    "drain-delay",
+			Value:   5 * time.Second,
+			Usage:   "Time between failing the readiness check and stopping the server on shutdown",
+			EnvVars: []string{"STAGERUNNER_DRAIN_DELAY"},
+		},
+		&cli.DurationFlag{
+			Name:    "shutdown-timeout",
+			Value:   30 * time.Second,
+			Usage:   "Maximum time to wait for open requests on shutdown",
+			EnvVars: []string{"STAGERUNNER_SHUTDOWN_TIMEOUT"},
+		},
+	}
 }
 
 func runServer(c *cli.Context) error {
+	cfg, err := loadServerConfig(c)
+	if err != nil {
+		return err
+	}
+	if c.Bool("print-config") {
+		return cfg.print(c.App.Writer)
+	}
+
+	tlsConfig, err := serverTLSConfig(cfg.TLS.Cert, cfg.TLS.Key, cfg.TLS.ClientCA)
+	if err != nil {
+		return err
+	}
+
+	logger, err := newLogger(cfg.Log.Format, cfg.Log.Level)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tp, shutdownTracing, err := tracing.NewTracerProvider(ctx, tracing.Config{
+		Exporter:     cfg.Tracing.Exporter,
+		ServiceName:  "stagerunner",
+		OTLPEndpoint: cfg.Tracing.OTLPEndpoint,
+		OTLPInsecure: cfg.Tracing.OTLPInsecure,
+		OTLPHeaders:  cfg.Tracing.OTLPHeaders,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			logger.Error("failed to flush traces", "error", err)
+		}
+	}()
+
+	// the stage types of the plugins have to be registered before pipelines are decoded
+	if err := loadPlugins(ctx, cfg.Plugins.Dir, cfg.Plugins.Timeout, logger); err != nil {
+		return err
+	}
+
+	m := metrics.New()
+	auditLog := store.NewMemoryAuditLog()
 	store := store.NewMemoryStore()
 	executor := domain.NewExecutor(
 		store,
-		c.Int("workers"),
-		c.Int("queue-size"),
-		c.Int("per-pipeline-queue"),
-		c.Float64("fail-probability"),
-		time.Duration(c.Int("executor-delay"))*time.Second,
+		cfg.Executor.Workers,
+		cfg.Executor.QueueSize,
+		cfg.Executor.PerPipelineQueue,
+		cfg.Executor.FailProbability,
+		cfg.Executor.Delay,
+		domain.WithLogger(logger),
+		domain.WithMetrics(m),
+		domain.WithTracerProvider(tp),
+	)
+	m.RegisterExecutor(executor)
+	janitor := domain.NewJanitor(
+		store,
+		domain.RetentionPolicy{
+			KeepLast:           cfg.Retention.KeepRuns,
+			MaxAge:             cfg.Retention.MaxRunAge,
+			KeepLastSuccessful: cfg.Retention.KeepLastSuccessful,
+		},
+		cfg.Retention.JanitorInterval,
 	)
-	api := myhttp.NewAPI(store, executor)
+	apiOpts := []myhttp.APIOption{
+		myhttp.WithAuditLog(auditLog),
+		myhttp.WithIdempotencyTTL(cfg.IdempotencyTTL),
+		myhttp.WithLogger(logger),
+		myhttp.WithMetrics(m),
+		myhttp.WithTracerProvider(tp),
+		myhttp.WithClientIdentities(cfg.clientIdentities()),
+	}
+	if cfg.UI {
+		apiOpts = append(apiOpts, myhttp.WithUI(web.Handler()))
+	}
+	api := myhttp.NewAPI(store, executor, apiOpts...)
 	router := api.SetupRouter()
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
 	// start workers and process pipeline runs
 	go executor.Start(ctx)
-	return http.ListenAndServe(c.String("addr"), router)
+	// remove old pipeline runs
+	go janitor.Start(domain.ContextWithLogger(ctx, logger))
+
+	server := &http.Server{Addr: cfg.Addr, Handler: router, TLSConfig: tlsConfig}
+	// event streams only end with their clients, Shutdown would wait for them
+	server.RegisterOnShutdown(api.CloseStreams)
+	errs := make(chan error, 1)
+	go func() {
+		if tlsConfig != nil {
+			logger.Info("starting server", "addr", server.Addr, "tls", true, "client_auth", tlsConfig.ClientCAs != nil)
+			// the certificate is already loaded into the TLS config
+			errs <- server.ListenAndServeTLS("", "")
+			return
+		}
+		logger.Info("starting server", "addr", server.Addr)
+		errs <- server.ListenAndServe()
+	}()
+
+	// shut down gracefully on SIGINT and SIGTERM
+	signals, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	select {
+	case err := <-errs:
+		return err
+	case <-signals.Done():
+	}
+
+	// fail the readiness check first, so load balancers stop sending requests
+	logger.Info("draining server", "delay", cfg.DrainDelay)
+	api.Drain()
+	time.Sleep(cfg.DrainDelay)
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancelShutdown()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to shut down server: %w", err)
+	}
+	logger.Info("server stopped")
+	return nil
+}
+
+// loadPlugins registers the stage types of the plugins in dir, if it is set
+func loadPlugins(ctx context.Context, dir string, timeout time.Duration, logger *slog.Logger) error {
+	if dir == "" {
+		return nil
+	}
+	plugins, err := plugin.Load(ctx, dir, timeout)
+	if err != nil {
+		return err
+	}
+	for _, p := range plugins {
+		logger.Info("loaded stage plugin", "stage_type", p.Name, "path", p.Path)
+	}
+	return nil
+}
+
+// serverTLSConfig returns the TLS config of the server or nil if TLS is not enabled. With a client CA,
+// clients can authenticate with a certificate signed by it instead of a token.
+func serverTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	if certFile == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAFile != "" {
+		pool, err := loadCertPool(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		// clients without a certificate can still authenticate with a token
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
+
+// newLogger returns a logger writing to stderr in the given format and level
+func newLogger(format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log-level %q", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	switch format {
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log-format %q, must be json or text", format)
+	}
 }
@@ -0,0 +1,720 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	myhttp "github.com/hphilipps/stagerunner/http"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/term"
+)
+
+// tuiCommand is the cli command for the interactive terminal dashboard
+var tuiCommand = &cli.Command{
+	Name:  "tui",
+	Usage: "Show a live dashboard of pipelines, the queue and runs in the terminal",
+	Flags: append(tuiFlags(), &cli.DurationFlag{
+		Name:    "refresh",
+		Value:   2 * time.Second,
+		Usage:   "Interval of refreshing the dashboard, changes streamed from the server refresh it immediately",
+		EnvVars: []string{"STAGERUNNER_TUI_REFRESH"},
+	}),
+	Action: runTUI,
+}
+
+// tuiFlags are the connection flags of the client, the dashboard has no output format
+func tuiFlags() []cli.Flag {
+	var flags []cli.Flag
+	for _, flag := range clientFlags() {
+		if flag.Names()[0] != "output" {
+			flags = append(flags, flag)
+		}
+	}
+	return flags
+}
+
+// tuiBackend are the API calls of the dashboard, implemented by the http.Client
+type tuiBackend interface {
+	ListPipelines(ctx context.Context) ([]myhttp.PipelineResponse, error)
+	ListRuns(ctx context.Context) ([]myhttp.PipelineRunResponse, error)
+	GetRun(ctx context.Context, id string) (*myhttp.PipelineRunResponse, error)
+	Queue(ctx context.Context) (*myhttp.QueueResponse, error)
+	TriggerPipeline(ctx context.Context, id string, gitRef string) (*myhttp.TriggerPipelineResponse, error)
+	CancelRun(ctx context.Context, id string) error
+}
+
+func runTUI(c *cli.Context) error {
+	in, out := os.Stdin, os.Stdout
+	if !term.IsTerminal(int(in.Fd())) || !term.IsTerminal(int(out.Fd())) {
+		return fmt.Errorf("the dashboard requires a terminal")
+	}
+	if c.Duration("refresh") <= 0 {
+		return fmt.Errorf("refresh interval must be positive")
+	}
+
+	client, err := newClient(c)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	model := &tuiModel{backend: client}
+	if err := model.refresh(ctx); err != nil {
+		return err
+	}
+
+	state, err := term.MakeRaw(int(in.Fd()))
+	if err != nil {
+		return fmt.Errorf("failed to switch the terminal to raw mode: %w", err)
+	}
+	defer term.Restore(int(in.Fd()), state)
+	// use the alternate screen without cursor, so the terminal is restored on exit
+	fmt.Fprint(out, "\033[?1049h\033[?25l")
+	defer fmt.Fprint(out, "\033[?25h\033[?1049l")
+
+	keys := make(chan string)
+	go readKeys(ctx, in, keys)
+
+	// changes streamed from the server are refreshing the dashboard immediately
+	changed := make(chan struct{}, 1)
+	streamErr := make(chan error)
+	go watchChanges(ctx, client.WatchEvents, changed, streamErr, streamRetryDelay, maxStreamRetryDelay)
+
+	ticker := time.NewTicker(c.Duration("refresh"))
+	defer ticker.Stop()
+	// the clock of the header and elapsed times are updated every second
+	clock := time.NewTicker(time.Second)
+	defer clock.Stop()
+
+	for {
+		width, height, err := term.GetSize(int(out.Fd()))
+		if err != nil {
+			width, height = 80, 24
+		}
+		writeScreen(out, model.render(width, height, time.Now()))
+
+		select {
+		case key, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			if model.handleKey(ctx, key) {
+				return nil
+			}
+		case <-changed:
+			model.refreshOrReport(ctx)
+		case err := <-streamErr:
+			model.message = fmt.Sprintf("not streaming changes: %v", err)
+		case <-ticker.C:
+			model.refreshOrReport(ctx)
+		case <-clock.C:
+		}
+	}
+}
+
+const (
+	// streamRetryDelay is the delay before reconnecting to the event stream, which is doubled
+	// for every failed attempt up to maxStreamRetryDelay
+	streamRetryDelay    = time.Second
+	maxStreamRetryDelay = 30 * time.Second
+)
+
+// watchChanges signals changed for every event streamed by watch. Ended streams are reconnected
+// with backoff, the errors are sent to failed. The delay is reset once events are received again.
+// It returns when the context is done or the server has no event stream, so it's only polled.
+func watchChanges(ctx context.Context, watch func(context.Context, myhttp.EventsQuery, func(myhttp.EventResponse) error) error,
+	changed chan<- struct{}, failed chan<- error, delay, maxDelay time.Duration) {
+	signal := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+
+	backoff := delay
+	for {
+		received := false
+		err := watch(ctx, myhttp.EventsQuery{}, func(myhttp.EventResponse) error {
+			received = true
+			signal()
+			return nil
+		})
+		if ctx.Err() != nil || errors.Is(err, myhttp.ErrNotImplemented) {
+			return
+		}
+		if err == nil {
+			err = errors.New("stream closed by the server")
+		}
+		if received {
+			backoff = delay
+		}
+
+		select {
+		case failed <- fmt.Errorf("%w, reconnecting in %s", err, backoff):
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff = min(2*backoff, maxDelay)
+		// changes while the stream was down have been missed
+		signal()
+	}
+}
+
+// writeScreen draws the lines from the top left of the terminal, clearing the rest of every line and the screen
+func writeScreen(w io.Writer, lines []string) {
+	var b strings.Builder
+	b.WriteString("\033[H")
+	for i, line := range lines {
+		if i > 0 {
+			// the terminal is in raw mode, so newlines don't return the cursor
+			b.WriteString("\r\n")
+		}
+		b.WriteString(line)
+		b.WriteString("\033[K")
+	}
+	b.WriteString("\033[J")
+	io.WriteString(w, b.String())
+}
+
+// readKeys sends the keys read from r until it fails or the context is done
+func readKeys(ctx context.Context, r io.Reader, keys chan<- string) {
+	defer close(keys)
+	buf := make([]byte, 64)
+	for {
+		n, err := r.Read(buf)
+		for _, key := range parseKeys(buf[:n]) {
+			select {
+			case keys <- key:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// escapeKeys are the escape sequences of the keys used by the dashboard
+var escapeKeys = map[string]string{
+	"\033[A":  "up",
+	"\033[B":  "down",
+	"\033[C":  "right",
+	"\033[D":  "left",
+	"\033OA":  "up",
+	"\033OB":  "down",
+	"\033[5~": "pgup",
+	"\033[6~": "pgdown",
+}
+
+// parseKeys returns the names of special keys and the printable characters of the input read from a
+// terminal in raw mode. Unknown escape sequences are ignored, an escape which doesn't start a
+// sequence is the escape key.
+func parseKeys(b []byte) []string {
+	var keys []string
+	for len(b) > 0 {
+		switch b[0] {
+		case 0x03:
+			keys = append(keys, "ctrl+c")
+		case '\r', '\n':
+			keys = append(keys, "enter")
+		case '\t':
+			keys = append(keys, "tab")
+		case 0x7f, 0x08:
+			keys = append(keys, "backspace")
+		case 0x1b:
+			if len(b) == 1 {
+				return append(keys, "esc")
+			}
+			matched := false
+			for seq, key := range escapeKeys {
+				if strings.HasPrefix(string(b), seq) {
+					keys = append(keys, key)
+					b = b[len(seq):]
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				if b[1] != '[' && b[1] != 'O' {
+					// not an escape sequence, the escape key was followed by another key
+					keys = append(keys, "esc")
+					b = b[1:]
+					continue
+				}
+				// skip the unknown sequence up to its final byte
+				end := 2
+				for end < len(b) && (b[end] < 0x40 || b[end] > 0x7e) {
+					end++
+				}
+				end++
+				if end > len(b) {
+					end = len(b)
+				}
+				b = b[end:]
+			}
+			continue
+		default:
+			r, size := utf8.DecodeRune(b)
+			if unicode.IsPrint(r) {
+				keys = append(keys, string(r))
+			}
+			b = b[size:]
+			continue
+		}
+		b = b[1:]
+	}
+	return keys
+}
+
+// Panes of the dashboard which can be focused
+const (
+	panePipelines = iota
+	paneRuns
+)
+
+// Modes of the dashboard
+const (
+	modeDashboard = iota
+	// modeLogs is showing the logs of a run
+	modeLogs
+	// modeTrigger is prompting for the git ref of a pipeline to trigger
+	modeTrigger
+)
+
+// tuiModel is the state of the dashboard, which is changed by keys and refreshes and rendered to lines
+type tuiModel struct {
+	backend   tuiBackend
+	pipelines []myhttp.PipelineResponse
+	// runs are ordered by creation, the newest first
+	runs  []myhttp.PipelineRunResponse
+	queue *myhttp.QueueResponse
+	// queueErr is set if the queue is not available, e.g. because of missing admin permissions
+	queueErr error
+	updated  time.Time
+
+	mode        int
+	pane        int
+	pipelineIdx int
+	runIdx      int
+	// logRun is the run of the log view and logOffset its first shown log line
+	logRun    *myhttp.PipelineRunResponse
+	logOffset int
+	// triggerPipeline and ref are the pipeline and git ref of the trigger prompt
+	triggerPipeline myhttp.PipelineResponse
+	ref             string
+
+	// message is the outcome of the last action or refresh, shown above the footer
+	message string
+}
+
+// refresh fetches the pipelines, runs and the queue, keeping the selected items
+func (m *tuiModel) refresh(ctx context.Context) error {
+	pipelines, err := m.backend.ListPipelines(ctx)
+	if err != nil {
+		return fmt.Errorf("error listing pipelines: %w", err)
+	}
+	runs, err := m.backend.ListRuns(ctx)
+	if err != nil {
+		return fmt.Errorf("error listing runs: %w", err)
+	}
+	sort.SliceStable(pipelines, func(i, j int) bool { return pipelines[i].Name < pipelines[j].Name })
+	sort.SliceStable(runs, func(i, j int) bool { return runs[i].CreatedAt.After(runs[j].CreatedAt) })
+
+	if selected, ok := m.selectedPipeline(); ok {
+		m.pipelineIdx = indexOf(pipelines, func(p myhttp.PipelineResponse) bool { return p.ID == selected.ID }, m.pipelineIdx)
+	}
+	if m.runIdx < len(m.runs) {
+		selected := m.runs[m.runIdx].ID
+		m.runIdx = indexOf(runs, func(r myhttp.PipelineRunResponse) bool { return r.ID == selected }, m.runIdx)
+	}
+	m.pipelines, m.runs = pipelines, runs
+	m.pipelineIdx = clamp(m.pipelineIdx, len(pipelines))
+	m.runIdx = clamp(m.runIdx, len(runs))
+
+	m.queue, m.queueErr = m.backend.Queue(ctx)
+
+	if m.mode == modeLogs {
+		run, err := m.backend.GetRun(ctx, m.logRun.ID)
+		if err != nil {
+			return fmt.Errorf("error getting run: %w", err)
+		}
+		m.logRun = run
+	}
+	m.updated = time.Now()
+	return nil
+}
+
+// refreshOrReport refreshes the dashboard and shows errors in the footer
+func (m *tuiModel) refreshOrReport(ctx context.Context) {
+	if err := m.refresh(ctx); err != nil {
+		m.message = err.Error()
+	}
+}
+
+// indexOf returns the index of the first item matching, or def if there is none
+func indexOf[T any](items []T, match func(T) bool, def int) int {
+	for i, item := range items {
+		if match(item) {
+			return i
+		}
+	}
+	return def
+}
+
+// clamp limits the index to the items of a list of length n
+func clamp(i, n int) int {
+	if i >= n {
+		i = n - 1
+	}
+	if i < 0 {
+		return 0
+	}
+	return i
+}
+
+func (m *tuiModel) selectedPipeline() (myhttp.PipelineResponse, bool) {
+	if m.pipelineIdx < len(m.pipelines) {
+		return m.pipelines[m.pipelineIdx], true
+	}
+	return myhttp.PipelineResponse{}, false
+}
+
+// selectedRun returns the run of the log view or the selected run of the dashboard
+func (m *tuiModel) selectedRun() (myhttp.PipelineRunResponse, bool) {
+	if m.mode == modeLogs && m.logRun != nil {
+		return *m.logRun, true
+	}
+	if m.runIdx < len(m.runs) {
+		return m.runs[m.runIdx], true
+	}
+	return myhttp.PipelineRunResponse{}, false
+}
+
+// pipelineName returns the name of the pipeline with the ID, or the ID if it is not known
+func (m *tuiModel) pipelineName(id string) string {
+	for _, p := range m.pipelines {
+		if p.ID == id && p.Name != "" {
+			return p.Name
+		}
+	}
+	return id
+}
+
+// handleKey changes the state for a key and returns true if the dashboard should quit
+func (m *tuiModel) handleKey(ctx context.Context, key string) bool {
+	if key == "ctrl+c" {
+		return true
+	}
+
+	switch m.mode {
+	case modeTrigger:
+		switch key {
+		case "esc":
+			m.mode = modeDashboard
+		case "enter":
+			m.mode = modeDashboard
+			m.trigger(ctx, m.triggerPipeline.ID, strings.TrimSpace(m.ref))
+		case "backspace":
+			if _, size := utf8.DecodeLastRuneInString(m.ref); size > 0 {
+				m.ref = m.ref[:len(m.ref)-size]
+			}
+		default:
+			if utf8.RuneCountInString(key) == 1 {
+				m.ref += key
+			}
+		}
+		return false
+
+	case modeLogs:
+		switch key {
+		case "q", "esc", "left":
+			m.mode = modeDashboard
+			m.logRun = nil
+		case "up", "k":
+			m.logOffset = max(m.logOffset-1, 0)
+		case "down", "j":
+			m.logOffset++
+		case "pgup":
+			m.logOffset = max(m.logOffset-10, 0)
+		case "pgdown":
+			m.logOffset += 10
+		case "c":
+			m.cancel(ctx)
+		case "r":
+			m.retry(ctx)
+		}
+		return false
+	}
+
+	switch key {
+	case "q":
+		return true
+	case "tab":
+		m.pane = (m.pane + 1) % 2
+	case "up", "k":
+		if m.pane == panePipelines {
+			m.pipelineIdx = clamp(m.pipelineIdx-1, len(m.pipelines))
+		} else {
+			m.runIdx = clamp(m.runIdx-1, len(m.runs))
+		}
+	case "down", "j":
+		if m.pane == panePipelines {
+			m.pipelineIdx = clamp(m.pipelineIdx+1, len(m.pipelines))
+		} else {
+			m.runIdx = clamp(m.runIdx+1, len(m.runs))
+		}
+	case "enter", "l", "right":
+		if m.pane == panePipelines {
+			// show the runs of the pipeline, starting with the newest one
+			if p, ok := m.selectedPipeline(); ok {
+				m.pane = paneRuns
+				m.runIdx = indexOf(m.runs, func(r myhttp.PipelineRunResponse) bool { return r.PipelineID == p.ID }, m.runIdx)
+			}
+			return false
+		}
+		run, ok := m.selectedRun()
+		if !ok {
+			return false
+		}
+		fresh, err := m.backend.GetRun(ctx, run.ID)
+		if err != nil {
+			m.message = fmt.Sprintf("error getting run: %v", err)
+			return false
+		}
+		m.mode, m.logRun, m.logOffset = modeLogs, fresh, 0
+	case "t":
+		// trigger the selected pipeline, or the pipeline of the selected run on its git ref
+		m.ref = "main"
+		if m.pane == paneRuns {
+			run, ok := m.selectedRun()
+			if !ok {
+				return false
+			}
+			m.triggerPipeline = myhttp.PipelineResponse{ID: run.PipelineID, Name: m.pipelineName(run.PipelineID)}
+			m.ref = run.GitRef
+		} else {
+			p, ok := m.selectedPipeline()
+			if !ok {
+				return false
+			}
+			m.triggerPipeline = p
+		}
+		m.mode = modeTrigger
+	case "c":
+		m.cancel(ctx)
+	case "r":
+		m.retry(ctx)
+	}
+	return false
+}
+
+// cancel cancels the selected run
+func (m *tuiModel) cancel(ctx context.Context) {
+	run, ok := m.selectedRun()
+	if !ok {
+		return
+	}
+	if err := m.backend.CancelRun(ctx, run.ID); err != nil {
+		m.message = fmt.Sprintf("error cancelling run %s: %v", run.ID, err)
+		return
+	}
+	m.message = fmt.Sprintf("Cancelling run %s", run.ID)
+	m.refreshOrReport(ctx)
+}
+
+// retry triggers a new run of the pipeline of the selected run on the same git ref
+func (m *tuiModel) retry(ctx context.Context) {
+	run, ok := m.selectedRun()
+	if !ok {
+		return
+	}
+	m.trigger(ctx, run.PipelineID, run.GitRef)
+}
+
+func (m *tuiModel) trigger(ctx context.Context, pipelineID, ref string) {
+	if ref == "" {
+		m.message = "git ref required"
+		return
+	}
+	resp, err := m.backend.TriggerPipeline(ctx, pipelineID, ref)
+	if err != nil {
+		m.message = fmt.Sprintf("error triggering pipeline %s: %v", m.pipelineName(pipelineID), err)
+		return
+	}
+	m.message = fmt.Sprintf("Triggered run %s of pipeline %s on %s", resp.ID, m.pipelineName(pipelineID), ref)
+	m.refreshOrReport(ctx)
+}
+
+// render returns the lines of the dashboard for a terminal of the given size
+func (m *tuiModel) render(width, height int, now time.Time) []string {
+	var lines []string
+	if m.mode == modeLogs {
+		lines = m.renderLogs(height, now)
+	} else {
+		lines = m.renderDashboard(height, now)
+	}
+
+	footer := m.footer()
+	if m.message != "" {
+		footer = append([]string{m.message}, footer...)
+	}
+	// the footer is kept at the bottom of the terminal
+	for len(lines)+len(footer) < height {
+		lines = append(lines, "")
+	}
+	lines = append(lines[:min(len(lines), max(height-len(footer), 0))], footer...)
+
+	for i, line := range lines {
+		lines[i] = truncate(line, width)
+	}
+	return lines
+}
+
+func (m *tuiModel) footer() []string {
+	switch m.mode {
+	case modeTrigger:
+		return []string{fmt.Sprintf("Trigger pipeline %s on git ref: %s_   enter trigger  esc cancel", m.triggerPipeline.Name, m.ref)}
+	case modeLogs:
+		return []string{"up/down scroll  c cancel  r retry  esc back  ctrl+c quit"}
+	default:
+		return []string{"tab switch  up/down select  enter runs/logs  t trigger  c cancel  r retry  q quit"}
+	}
+}
+
+func (m *tuiModel) renderDashboard(height int, now time.Time) []string {
+	header := fmt.Sprintf("stagerunner - %d pipelines, %d runs", len(m.pipelines), len(m.runs))
+	if !m.updated.IsZero() {
+		header += fmt.Sprintf(", updated %s", m.updated.Format(time.TimeOnly))
+	}
+	lines := []string{header}
+	if m.queueErr != nil {
+		lines = append(lines, fmt.Sprintf("queue not available: %v", m.queueErr))
+	} else if m.queue != nil {
+		lines = append(lines, fmt.Sprintf("workers %d/%d busy, %d/%d runs queued",
+			m.queue.BusyWorkers, m.queue.Workers, m.queue.Length, m.queue.QueueSize))
+	}
+
+	// the pipelines and the queue take up to a third of the terminal, the runs the rest
+	listHeight := max((height-12)/3, 3)
+
+	lines = append(lines, "", "PIPELINES")
+	lines = append(lines, renderTable(
+		[]string{"NAME", "REPOSITORY", "ID"},
+		m.pipelines, m.pipelineIdx, m.pane == panePipelines, listHeight,
+		func(p myhttp.PipelineResponse) []string { return []string{p.Name, p.Repository, p.ID} })...)
+
+	if m.queue != nil {
+		lines = append(lines, "", "QUEUE")
+		lines = append(lines, renderTable(
+			[]string{"#", "RUN", "PIPELINE", "GIT REF", "WAITING"},
+			m.queue.Runs, -1, false, listHeight,
+			func(r myhttp.QueuedRunResponse) []string {
+				return []string{fmt.Sprint(r.Position), shortID(r.RunID), m.pipelineName(r.PipelineID), r.GitRef,
+					now.Sub(r.QueuedAt).Round(time.Second).String()}
+			})...)
+	}
+
+	runsHeight := max(height-len(lines)-5, 3)
+	lines = append(lines, "", "RUNS")
+	lines = append(lines, renderTable(
+		[]string{"RUN", "PIPELINE", "GIT REF", "STATUS", "RUN", "BUILD", "DEPLOY", "ELAPSED"},
+		m.runs, m.runIdx, m.pane == paneRuns, runsHeight,
+		func(r myhttp.PipelineRunResponse) []string {
+			return []string{shortID(r.ID), m.pipelineName(r.PipelineID), r.GitRef, r.Status,
+				r.RunStatus, r.BuildStatus, r.DeployStatus, runElapsed(&r, now).String()}
+		})...)
+	return lines
+}
+
+func (m *tuiModel) renderLogs(height int, now time.Time) []string {
+	run := m.logRun
+	lines := []string{
+		fmt.Sprintf("Run %s of pipeline %s on %s: %s (%s)", run.ID, m.pipelineName(run.PipelineID), run.GitRef, run.Status, runElapsed(run, now)),
+		formatStageStatuses(run),
+		"",
+	}
+
+	var logLines []string
+	for _, stage := range runStages(run) {
+		log := strings.TrimRight(run.Logs[stage], "\n")
+		if log == "" {
+			continue
+		}
+		logLines = append(logLines, fmt.Sprintf("== %s ==", stage))
+		logLines = append(logLines, strings.Split(log, "\n")...)
+	}
+	if len(logLines) == 0 {
+		logLines = []string{"no logs yet"}
+	}
+
+	// keep the last page of the logs in view when scrolling down
+	visible := max(height-len(lines)-2, 1)
+	m.logOffset = min(m.logOffset, max(len(logLines)-visible, 0))
+	end := min(m.logOffset+visible, len(logLines))
+	return append(lines, logLines[m.logOffset:end]...)
+}
+
+// renderTable renders the rows of the items visible around the selected item, with a marker for the
+// selected item of the focused table. A selected index of -1 is not selecting any item.
+func renderTable[T any](headers []string, items []T, selected int, focused bool, height int, row func(T) []string) []string {
+	if len(items) == 0 {
+		return []string{"  none"}
+	}
+
+	// scroll the selected item into view
+	start := 0
+	if selected >= height {
+		start = selected - height + 1
+	}
+	end := min(start+height, len(items))
+
+	var b strings.Builder
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  "+strings.Join(headers, "\t"))
+	for i := start; i < end; i++ {
+		marker := "  "
+		if i == selected && focused {
+			marker = "> "
+		}
+		fmt.Fprintln(tw, marker+strings.Join(row(items[i]), "\t"))
+	}
+	tw.Flush()
+
+	lines := strings.Split(strings.TrimRight(b.String(), "\n"), "\n")
+	if hidden := len(items) - (end - start); hidden > 0 {
+		lines = append(lines, fmt.Sprintf("  ... %d more", hidden))
+	}
+	return lines
+}
+
+// shortID returns the first part of a UUID
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+// truncate shortens the line to the width of the terminal
+func truncate(line string, width int) string {
+	if utf8.RuneCountInString(line) <= width {
+		return line
+	}
+	return string([]rune(line)[:max(width, 0)])
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	myhttp "github.com/hphilipps/stagerunner/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBackend is a tuiBackend recording the cancelled and triggered runs
+type fakeBackend struct {
+	pipelines []myhttp.PipelineResponse
+	runs      []myhttp.PipelineRunResponse
+	queueErr  error
+	cancelled []string
+	triggered []string
+}
+
+func (b *fakeBackend) ListPipelines(context.Context) ([]myhttp.PipelineResponse, error) {
+	return append([]myhttp.PipelineResponse(nil), b.pipelines...), nil
+}
+
+func (b *fakeBackend) ListRuns(context.Context) ([]myhttp.PipelineRunResponse, error) {
+	return append([]myhttp.PipelineRunResponse(nil), b.runs...), nil
+}
+
+func (b *fakeBackend) GetRun(_ context.Context, id string) (*myhttp.PipelineRunResponse, error) {
+	for _, run := range b.runs {
+		if run.ID == id {
+			return &run, nil
+		}
+	}
+	return nil, fmt.Errorf("run %s not found", id)
+}
+
+func (b *fakeBackend) Queue(context.Context) (*myhttp.QueueResponse, error) {
+	if b.queueErr != nil {
+		return nil, b.queueErr
+	}
+	return &myhttp.QueueResponse{Workers: 2, BusyWorkers: 1, QueueSize: 10}, nil
+}
+
+func (b *fakeBackend) TriggerPipeline(_ context.Context, id, gitRef string) (*myhttp.TriggerPipelineResponse, error) {
+	b.triggered = append(b.triggered, id+"@"+gitRef)
+	return &myhttp.TriggerPipelineResponse{ID: fmt.Sprintf("new-run-%d", len(b.triggered))}, nil
+}
+
+func (b *fakeBackend) CancelRun(_ context.Context, id string) error {
+	b.cancelled = append(b.cancelled, id)
+	return nil
+}
+
+func TestTUI(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	backend := &fakeBackend{
+		pipelines: []myhttp.PipelineResponse{
+			{ID: "p-2", Name: "web", Repository: "repo-web"},
+			{ID: "p-1", Name: "api", Repository: "repo-api"},
+		},
+		runs: []myhttp.PipelineRunResponse{
+			{
+				ID: "11111111-old", PipelineID: "p-1", GitRef: "main", Status: "success",
+				RunStatus: "success", BuildStatus: "success", DeployStatus: "success",
+				CreatedAt: created, UpdatedAt: created.Add(time.Minute),
+			},
+			{
+				ID: "22222222-new", PipelineID: "p-2", GitRef: "dev", Status: "running",
+				RunStatus: "success", BuildStatus: "running", DeployStatus: "pending",
+				CreatedAt: created.Add(time.Hour), UpdatedAt: created.Add(time.Hour),
+				Logs: map[string]string{"run": "starting\nfinished\n", "build": "building\n"},
+			},
+		},
+	}
+	ctx := context.Background()
+	model := &tuiModel{backend: backend}
+	require.NoError(t, model.refresh(ctx))
+	now := created.Add(time.Hour + 30*time.Second)
+
+	screen := strings.Join(model.render(120, 30, now), "\n")
+	// pipelines are sorted by name and runs by creation, the newest first
+	assert.Contains(t, screen, "workers 1/2 busy, 0/10 runs queued")
+	assert.Regexp(t, `> api +repo-api +p-1\n +web +repo-web +p-2`, screen)
+	assert.Regexp(t, `22222222 +web +dev +running +success +running +pending +30s\n +11111111 +api +main +success`, screen)
+	assert.Len(t, model.render(120, 30, now), 30)
+
+	t.Run("trigger with a chosen ref", func(t *testing.T) {
+		model.handleKey(ctx, "t")
+		assert.Contains(t, model.footer()[0], "Trigger pipeline api on git ref: main_")
+		for _, key := range []string{"backspace", "backspace", "backspace", "backspace", "v", "2", "enter"} {
+			model.handleKey(ctx, key)
+		}
+		assert.Equal(t, []string{"p-1@v2"}, backend.triggered)
+		assert.Equal(t, "Triggered run new-run-1 of pipeline api on v2", model.message)
+	})
+
+	t.Run("cancel and retry runs", func(t *testing.T) {
+		model.handleKey(ctx, "tab")
+		model.handleKey(ctx, "c")
+		assert.Equal(t, []string{"22222222-new"}, backend.cancelled)
+
+		model.handleKey(ctx, "down")
+		model.handleKey(ctx, "r")
+		assert.Equal(t, "p-1@main", backend.triggered[len(backend.triggered)-1])
+		// the selection is clamped to the runs
+		model.handleKey(ctx, "down")
+		assert.Equal(t, 1, model.runIdx)
+	})
+
+	t.Run("logs", func(t *testing.T) {
+		model.handleKey(ctx, "up")
+		model.handleKey(ctx, "enter")
+		assert.Equal(t, modeLogs, model.mode)
+
+		lines := model.render(80, 12, now)
+		assert.Equal(t, "Run 22222222-new of pipeline web on dev: running (30s)", lines[0])
+		assert.Equal(t, []string{"== run ==", "starting", "finished", "== build ==", "building"}, lines[3:8])
+
+		// scrolling stops at the last page
+		for i := 0; i < 10; i++ {
+			model.handleKey(ctx, "down")
+		}
+		lines = model.render(80, 8, now)
+		assert.Equal(t, []string{"finished", "== build ==", "building"}, lines[3:6])
+
+		model.handleKey(ctx, "esc")
+		assert.Equal(t, modeDashboard, model.mode)
+	})
+
+	t.Run("queue not available", func(t *testing.T) {
+		backend.queueErr = fmt.Errorf("forbidden")
+		require.NoError(t, model.refresh(ctx))
+		assert.Contains(t, model.render(120, 30, now)[1], "queue not available: forbidden")
+	})
+
+	assert.True(t, model.handleKey(ctx, "q"))
+}
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{input: "q", want: []string{"q"}},
+		{input: "\033[A\033[B", want: []string{"up", "down"}},
+		{input: "\033", want: []string{"esc"}},
+		{input: "a\rä\t\x7f\x03", want: []string{"a", "enter", "ä", "tab", "backspace", "ctrl+c"}},
+		// unknown sequences like F5 are skipped
+		{input: "\033[15~x", want: []string{"x"}},
+		{input: "\033q", want: []string{"esc", "q"}},
+		{input: "\033\033[A", want: []string{"esc", "up"}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%q", tt.input), func(t *testing.T) {
+			assert.Equal(t, tt.want, parseKeys([]byte(tt.input)))
+		})
+	}
+}
+
+func TestWatchChanges(t *testing.T) {
+	var calls int
+	// the first streams fail, then one is closed by the server after an event and the last finds no event stream
+	watch := func(ctx context.Context, query myhttp.EventsQuery, handle func(myhttp.EventResponse) error) error {
+		calls++
+		switch calls {
+		case 1, 2:
+			return fmt.Errorf("connection refused")
+		case 3:
+			return handle(myhttp.EventResponse{Type: "pipeline.created"})
+		default:
+			return myhttp.ErrNotImplemented
+		}
+	}
+
+	changed := make(chan struct{}, 1)
+	failed := make(chan error)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		watchChanges(context.Background(), watch, changed, failed, time.Millisecond, 3*time.Millisecond)
+	}()
+
+	var errs []string
+	for done := false; !done; {
+		select {
+		case err := <-failed:
+			errs = append(errs, err.Error())
+		case <-stopped:
+			done = true
+		}
+	}
+	assert.Equal(t, []string{
+		"connection refused, reconnecting in 1ms",
+		"connection refused, reconnecting in 2ms",
+		// the delay has been reset by the received event
+		"stream closed by the server, reconnecting in 1ms",
+	}, errs)
+	assert.Equal(t, 4, calls)
+	assert.Len(t, changed, 1)
+}
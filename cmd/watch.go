@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	myhttp "github.com/hphilipps/stagerunner/http"
+	"github.com/urfave/cli/v2"
+)
+
+// watchRefreshInterval is the interval of updating the elapsed time of a watched run
+const watchRefreshInterval = time.Second
+
+func watchRun(c *cli.Context) error {
+	if c.NArg() < 1 {
+		return fmt.Errorf("run ID required")
+	}
+	id := c.Args().Get(0)
+
+	client, err := newClient(c)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// other output formats than table only print the finished run
+	if c.String("output") != outputTable {
+		run, err := client.WatchRun(ctx, id, func(*myhttp.PipelineRunResponse) error { return nil })
+		if err != nil {
+			return fmt.Errorf("error watching run %s: %w", id, err)
+		}
+		if err := printItem(c, *run, runColumns); err != nil {
+			return err
+		}
+		return runResult(run)
+	}
+
+	view := &runView{w: c.App.Writer, redraw: isTerminal(c.App.Writer)}
+	updates := make(chan *myhttp.PipelineRunResponse)
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.WatchRun(ctx, id, func(run *myhttp.PipelineRunResponse) error {
+			select {
+			case updates <- run:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		done <- err
+	}()
+
+	ticker := time.NewTicker(watchRefreshInterval)
+	defer ticker.Stop()
+	var run *myhttp.PipelineRunResponse
+	for {
+		select {
+		case run = <-updates:
+			view.render(run, time.Now())
+		case <-ticker.C:
+			// only redrawn views are showing the elapsed time of unchanged runs
+			if run != nil && view.redraw {
+				view.render(run, time.Now())
+			}
+		case err := <-done:
+			if err != nil {
+				return fmt.Errorf("error watching run %s: %w", id, err)
+			}
+			return runResult(run)
+		}
+	}
+}
+
+// runView is showing the status of a watched run. On terminals the view is redrawn in place,
+// otherwise a line is written for every change of the run.
+type runView struct {
+	w      io.Writer
+	redraw bool
+	// lines is the number of lines of the last drawn view
+	lines int
+}
+
+func (v *runView) render(run *myhttp.PipelineRunResponse, now time.Time) {
+	elapsed := runElapsed(run, now)
+	if !v.redraw {
+		fmt.Fprintf(v.w, "%s %s %s %s\n", run.ID, run.Status, elapsed, formatStageStatuses(run))
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Run %s of pipeline %s on %s: %s (%s)\n", run.ID, run.PipelineID, run.GitRef, run.Status, elapsed)
+	tw := tabwriter.NewWriter(&b, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "  STAGE\tSTATUS")
+	statuses := stageStatuses(run)
+	for _, stage := range runStages(run) {
+		fmt.Fprintf(tw, "  %s\t%s\n", stage, statuses[stage])
+	}
+	tw.Flush()
+
+	if v.lines > 0 {
+		// move the cursor to the start of the last view and clear it
+		fmt.Fprintf(v.w, "\033[%dA\033[J", v.lines)
+	}
+	io.WriteString(v.w, b.String())
+	v.lines = strings.Count(b.String(), "\n")
+}
+
+// runElapsed returns the time the run has been running, or took if it is finished
+func runElapsed(run *myhttp.PipelineRunResponse, now time.Time) time.Duration {
+	end := now
+	if run.Finished() {
+		end = run.UpdatedAt
+	}
+	elapsed := end.Sub(run.CreatedAt).Round(time.Second)
+	// the clocks of client and server might differ
+	if elapsed < 0 {
+		return 0
+	}
+	return elapsed
+}
+
+// isTerminal returns true if w is a terminal
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	myhttp "github.com/hphilipps/stagerunner/http"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunView(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	running := &myhttp.PipelineRunResponse{
+		ID: "run-1", PipelineID: "p-1", GitRef: "main", Status: "running",
+		RunStatus: "success", BuildStatus: "running", DeployStatus: "pending",
+		CreatedAt: created, UpdatedAt: created,
+	}
+	finished := *running
+	finished.Status, finished.BuildStatus, finished.DeployStatus = "failed", "success", "failed"
+	finished.UpdatedAt = created.Add(42 * time.Second)
+	now := created.Add(90 * time.Second)
+
+	t.Run("lines", func(t *testing.T) {
+		var out bytes.Buffer
+		view := &runView{w: &out}
+		view.render(running, now)
+		view.render(&finished, now)
+		assert.Equal(t, "run-1 running 1m30s run=success build=running deploy=pending\n"+
+			"run-1 failed 42s run=success build=success deploy=failed\n", out.String())
+	})
+
+	t.Run("redraw", func(t *testing.T) {
+		var out bytes.Buffer
+		view := &runView{w: &out, redraw: true}
+		view.render(running, created.Add(1500*time.Millisecond))
+		first := "Run run-1 of pipeline p-1 on main: running (2s)\n" +
+			"  STAGE    STATUS\n" +
+			"  run      success\n" +
+			"  build    running\n" +
+			"  deploy   pending\n"
+		assert.Equal(t, first, out.String())
+
+		out.Reset()
+		view.render(&finished, now)
+		// the previous view is cleared
+		assert.Equal(t, "\033[5A\033[J"+
+			"Run run-1 of pipeline p-1 on main: failed (42s)\n"+
+			"  STAGE    STATUS\n"+
+			"  run      success\n"+
+			"  build    success\n"+
+			"  deploy   failed\n", out.String())
+	})
+
+	t.Run("clock skew", func(t *testing.T) {
+		assert.Equal(t, time.Duration(0), runElapsed(running, created.Add(-time.Minute)))
+	})
+}
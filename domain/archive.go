@@ -0,0 +1,418 @@
+package domain
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ArchiveVersion is the version of the archive format written by Export.
+// Import refuses archives with a newer version.
+//
+// Version 2 has the stages and stage statuses of all stage types in maps, archives of version 1
+// have fields of their own for the built-in stages.
+const ArchiveVersion = 2
+
+// ErrInvalidArchive is returned by Import for archives which can't be decoded
+var ErrInvalidArchive = errors.New("invalid archive")
+
+// archive record kinds
+const (
+	recordHeader   = "header"
+	recordPipeline = "pipeline"
+	recordRun      = "run"
+)
+
+// ConflictMode defines how Import handles items which already exist in the store.
+type ConflictMode string
+
+const (
+	// ConflictSkip keeps the existing item and ignores the imported one.
+	ConflictSkip ConflictMode = "skip"
+	// ConflictOverwrite replaces the existing item with the imported one.
+	ConflictOverwrite ConflictMode = "overwrite"
+	// ConflictRename imports the item with a new ID.
+	ConflictRename ConflictMode = "rename"
+)
+
+// ParseConflictMode returns the ConflictMode for the given string.
+func ParseConflictMode(s string) (ConflictMode, error) {
+	switch mode := ConflictMode(s); mode {
+	case ConflictSkip, ConflictOverwrite, ConflictRename:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid conflict mode %q - must be one of skip, overwrite, rename", s)
+}
+
+// archiveRecord is a single line of an archive.
+// The archive is starting with a header record, followed by pipeline records and then run records.
+type archiveRecord struct {
+	Kind      string           `json:"kind"`
+	Version   int              `json:"version,omitempty"`
+	CreatedAt *time.Time       `json:"created_at,omitempty"`
+	Pipeline  *archivePipeline `json:"pipeline,omitempty"`
+	Run       *archiveRun      `json:"run,omitempty"`
+}
+
+type archivePipeline struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Repository string `json:"repository"`
+	// Stages are the JSON settings of the stages by the name of their type
+	Stages     map[string]json.RawMessage `json:"stages,omitempty"`
+	BadgeToken string                     `json:"badge_token,omitempty"`
+	// RunStage, BuildStage and DeployStage are the stages in archives of version 1
+	RunStage    *archiveStageV1 `json:"run_stage,omitempty"`
+	BuildStage  *archiveStageV1 `json:"build_stage,omitempty"`
+	DeployStage *archiveStageV1 `json:"deploy_stage,omitempty"`
+}
+
+// archiveStageV1 are the fields of the built-in stages in archives of version 1
+type archiveStageV1 struct {
+	Command        string `json:"command"`
+	DockerfilePath string `json:"dockerfile_path"`
+	ClusterName    string `json:"cluster_name"`
+	ManifestPath   string `json:"manifest_path"`
+	ContOnError    bool   `json:"cont_on_error"`
+}
+
+type archiveRun struct {
+	ID         string `json:"id"`
+	PipelineID string `json:"pipeline_id"`
+	GitRef     string `json:"git_ref"`
+	Status     string `json:"status"`
+	// StageStatuses are the statuses of the stages by their name
+	StageStatuses map[string]string `json:"stage_statuses,omitempty"`
+	// RunStatus, BuildStatus and DeployStatus are the stage statuses in archives of version 1
+	RunStatus    string            `json:"run_status,omitempty"`
+	BuildStatus  string            `json:"build_status,omitempty"`
+	DeployStatus string            `json:"deploy_status,omitempty"`
+	CreatedAt    time.Time         `json:"created_at"`
+	UpdatedAt    time.Time         `json:"updated_at"`
+	RequestID    string            `json:"request_id,omitempty"`
+	TraceParent  string            `json:"trace_parent,omitempty"`
+	Logs         map[string]string `json:"logs"`
+	// StageTimings are missing in archives of older versions
+	StageTimings map[string]archiveStageTiming `json:"stage_timings,omitempty"`
+}
+
+type archiveStageTiming struct {
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// ImportResult is summarizing what has been imported.
+type ImportResult struct {
+	Pipelines   int
+	Runs        int
+	Skipped     int
+	Overwritten int
+	Renamed     int
+}
+
+// Export is writing all pipelines and pipeline runs of the store to w as a JSON lines archive.
+func Export(ctx context.Context, store Store, w io.Writer) error {
+	enc := json.NewEncoder(w)
+
+	now := time.Now()
+	if err := enc.Encode(archiveRecord{Kind: recordHeader, Version: ArchiveVersion, CreatedAt: &now}); err != nil {
+		return err
+	}
+
+	pipelines, err := store.ListPipelines(ctx, ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, p := range pipelines {
+		ap, err := toArchivePipeline(p)
+		if err != nil {
+			return err
+		}
+		if err := enc.Encode(archiveRecord{Kind: recordPipeline, Pipeline: ap}); err != nil {
+			return err
+		}
+	}
+
+	runs, err := store.ListPipelineRuns(ctx, ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, r := range runs {
+		if err := enc.Encode(archiveRecord{Kind: recordRun, Run: toArchiveRun(r)}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Import is reading an archive written by Export from r and stores its pipelines and runs.
+// Runs which were not finished at the time of the export can't be resumed and are imported as failed.
+// Import stops at the first error, the returned result is counting what has been imported until then.
+// Errors decoding the archive wrap ErrInvalidArchive.
+func Import(ctx context.Context, store Store, r io.Reader, mode ConflictMode) (*ImportResult, error) {
+	if _, err := ParseConflictMode(string(mode)); err != nil {
+		return nil, err
+	}
+
+	result := &ImportResult{}
+	// renamedPipelines is mapping the IDs of renamed pipelines to their new IDs
+	renamedPipelines := make(map[string]string)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	line := 0
+	// the header is the first record, blank lines are ignored
+	headerSeen := false
+	for scanner.Scan() {
+		line++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var record archiveRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return result, fmt.Errorf("%w: line %d: invalid record: %w", ErrInvalidArchive, line, err)
+		}
+
+		if !headerSeen {
+			if record.Kind != recordHeader {
+				return result, fmt.Errorf("%w: line %d: archive header missing", ErrInvalidArchive, line)
+			}
+			if record.Version < 1 || record.Version > ArchiveVersion {
+				return result, fmt.Errorf("%w: line %d: unsupported archive version %d", ErrInvalidArchive, line, record.Version)
+			}
+			headerSeen = true
+			continue
+		}
+
+		var err error
+		switch record.Kind {
+		case recordPipeline:
+			if record.Pipeline == nil {
+				return result, fmt.Errorf("%w: line %d: pipeline record without pipeline", ErrInvalidArchive, line)
+			}
+			pipeline, decodeErr := record.Pipeline.toPipeline()
+			if decodeErr != nil {
+				return result, fmt.Errorf("%w: line %d: %w", ErrInvalidArchive, line, decodeErr)
+			}
+			err = importPipeline(ctx, store, pipeline, mode, result, renamedPipelines)
+		case recordRun:
+			if record.Run == nil {
+				return result, fmt.Errorf("%w: line %d: run record without run", ErrInvalidArchive, line)
+			}
+			err = importRun(ctx, store, record.Run.toPipelineRun(), mode, result, renamedPipelines)
+		default:
+			return result, fmt.Errorf("%w: line %d: unknown record kind %q", ErrInvalidArchive, line, record.Kind)
+		}
+		if err != nil {
+			return result, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return result, fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+	}
+	if !headerSeen {
+		return result, fmt.Errorf("%w: archive is empty", ErrInvalidArchive)
+	}
+
+	return result, nil
+}
+
+// importPipeline is storing a single pipeline and resolves conflicts according to mode
+func importPipeline(ctx context.Context, store Store, pipeline *Pipeline, mode ConflictMode, result *ImportResult, renamed map[string]string) error {
+	err := store.CreatePipeline(ctx, pipeline)
+	if err == nil {
+		result.Pipelines++
+		return nil
+	}
+	if !errors.Is(err, ErrAlreadyExists) {
+		return err
+	}
+
+	switch mode {
+	case ConflictSkip:
+		result.Skipped++
+		return nil
+	case ConflictOverwrite:
+		if err := store.UpdatePipeline(ctx, pipeline); err != nil {
+			return err
+		}
+		result.Pipelines++
+		result.Overwritten++
+		return nil
+	default:
+		oldID := pipeline.ID
+		pipeline.ID = uuid.New().String()
+		if err := store.CreatePipeline(ctx, pipeline); err != nil {
+			return err
+		}
+		renamed[oldID] = pipeline.ID
+		result.Pipelines++
+		result.Renamed++
+		return nil
+	}
+}
+
+// importRun is storing a single pipeline run and resolves conflicts according to mode
+func importRun(ctx context.Context, store Store, run *PipelineRun, mode ConflictMode, result *ImportResult, renamed map[string]string) error {
+	if newID, ok := renamed[run.PipelineID]; ok {
+		run.PipelineID = newID
+	}
+	if !run.Finished() {
+		run.Status = StatusFailed
+		run.Logs[StageRun] += "run was interrupted by an export/import and has been marked as failed\n"
+	}
+
+	err := store.CreatePipelineRun(ctx, run)
+	if err == nil {
+		result.Runs++
+		return nil
+	}
+	if !errors.Is(err, ErrAlreadyExists) {
+		return err
+	}
+
+	switch mode {
+	case ConflictSkip:
+		result.Skipped++
+		return nil
+	case ConflictOverwrite:
+		existing, err := store.GetPipelineRun(ctx, run.ID)
+		if err != nil {
+			return err
+		}
+		// we don't want to overwrite runs which are executed right now
+		if !existing.Finished() {
+			result.Skipped++
+			return nil
+		}
+		if err := store.UpdatePipelineRun(ctx, run); err != nil {
+			return err
+		}
+		result.Runs++
+		result.Overwritten++
+		return nil
+	default:
+		run.ID = uuid.New().String()
+		if err := store.CreatePipelineRun(ctx, run); err != nil {
+			return err
+		}
+		result.Runs++
+		result.Renamed++
+		return nil
+	}
+}
+
+func toArchivePipeline(p *Pipeline) (*archivePipeline, error) {
+	ap := &archivePipeline{
+		ID: p.ID, Name: p.Name, Repository: p.Repository, BadgeToken: p.BadgeToken,
+		Stages: make(map[string]json.RawMessage, len(p.Stages)),
+	}
+	for name, stage := range p.Stages {
+		data, err := EncodeStage(stage)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode %s stage of pipeline %s: %w", name, p.ID, err)
+		}
+		ap.Stages[name] = data
+	}
+	return ap, nil
+}
+
+// toPipeline is decoding the stages with the registered stage types, so they have their default validators
+func (ap *archivePipeline) toPipeline() (*Pipeline, error) {
+	p := NewPipeline(ap.Repository)
+	p.ID = ap.ID
+	p.Name = ap.Name
+	p.BadgeToken = ap.BadgeToken
+	for name, data := range ap.Stages {
+		stage, err := DecodeStage(name, data)
+		if err != nil {
+			return nil, fmt.Errorf("pipeline %s: %w", ap.ID, err)
+		}
+		p.Stages[name] = stage
+	}
+
+	if s := ap.RunStage; s != nil {
+		p.Stages[StageRun] = NewRunStage(StageRun, s.Command, s.ContOnError)
+	}
+	if s := ap.BuildStage; s != nil {
+		p.Stages[StageBuild] = NewBuildStage(StageBuild, s.DockerfilePath, s.ContOnError)
+	}
+	if s := ap.DeployStage; s != nil {
+		p.Stages[StageDeploy] = NewDeployStage(StageDeploy, s.ClusterName, s.ManifestPath, s.ContOnError)
+	}
+	return p, nil
+}
+
+func toArchiveRun(r *PipelineRun) *archiveRun {
+	var timings map[string]archiveStageTiming
+	if len(r.StageTimings) > 0 {
+		timings = make(map[string]archiveStageTiming, len(r.StageTimings))
+		for stage, t := range r.StageTimings {
+			timing := archiveStageTiming{StartedAt: t.StartedAt}
+			if !t.FinishedAt.IsZero() {
+				finished := t.FinishedAt
+				timing.FinishedAt = &finished
+			}
+			timings[stage] = timing
+		}
+	}
+	return &archiveRun{
+		ID:            r.ID,
+		PipelineID:    r.PipelineID,
+		GitRef:        r.GitRef,
+		Status:        r.Status,
+		StageStatuses: r.StageStatuses,
+		CreatedAt:     r.CreatedAt,
+		UpdatedAt:     r.UpdatedAt,
+		RequestID:     r.RequestID,
+		TraceParent:   r.TraceParent,
+		Logs:          r.Logs,
+		StageTimings:  timings,
+	}
+}
+
+func (ar *archiveRun) toPipelineRun() *PipelineRun {
+	logs := ar.Logs
+	if logs == nil {
+		logs = make(map[string]string)
+	}
+	timings := make(map[string]StageTiming, len(ar.StageTimings))
+	for stage, t := range ar.StageTimings {
+		timing := StageTiming{StartedAt: t.StartedAt}
+		if t.FinishedAt != nil {
+			timing.FinishedAt = *t.FinishedAt
+		}
+		timings[stage] = timing
+	}
+	statuses := make(map[string]string, len(ar.StageStatuses))
+	for stage, status := range ar.StageStatuses {
+		statuses[stage] = status
+	}
+	for stage, status := range map[string]string{StageRun: ar.RunStatus, StageBuild: ar.BuildStatus, StageDeploy: ar.DeployStatus} {
+		if status != "" {
+			statuses[stage] = status
+		}
+	}
+	return &PipelineRun{
+		ID:            ar.ID,
+		PipelineID:    ar.PipelineID,
+		GitRef:        ar.GitRef,
+		Status:        ar.Status,
+		StageStatuses: statuses,
+		CreatedAt:     ar.CreatedAt,
+		UpdatedAt:     ar.UpdatedAt,
+		RequestID:     ar.RequestID,
+		TraceParent:   ar.TraceParent,
+		Logs:          logs,
+		StageTimings:  timings,
+	}
+}
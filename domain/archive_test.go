@@ -0,0 +1,190 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newArchiveTestStore is returning a store with a single pipeline and two runs
+func newArchiveTestStore(t *testing.T) Store {
+	ctx := context.Background()
+	store := newTestStore()
+
+	pipeline := NewPipeline("github.com/test/repo")
+	pipeline.ID = "pipeline-1"
+	pipeline.Name = "Pipeline 1"
+	pipeline.BadgeToken = "badge-token"
+	pipeline.Stages[StageRun] = NewRunStage(StageRun, "go test ./...", true)
+	pipeline.Stages[StageBuild] = NewBuildStage(StageBuild, "Dockerfile", false)
+	pipeline.Stages[StageDeploy] = NewDeployStage(StageDeploy, "prod", "k8s/", false)
+	require.NoError(t, store.CreatePipeline(ctx, pipeline))
+
+	finished := NewPipelineRun(pipeline.ID, "main")
+	finished.ID = "run-1"
+	finished.Status = StatusSuccess
+	finished.Logs[StageRun] = "finished\n"
+	finished.StageStatuses[StageRun] = StatusSuccess
+	finished.StageStatuses[StageBuild] = StatusFailed
+	finished.StageTimings[StageRun] = StageTiming{
+		StartedAt:  time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		FinishedAt: time.Date(2024, 5, 1, 12, 0, 5, 0, time.UTC),
+	}
+	finished.StageTimings[StageBuild] = StageTiming{StartedAt: time.Date(2024, 5, 1, 12, 0, 5, 0, time.UTC)}
+	require.NoError(t, store.CreatePipelineRun(ctx, finished))
+
+	running := NewPipelineRun(pipeline.ID, "dev")
+	running.ID = "run-2"
+	running.Status = StatusRunning
+	require.NoError(t, store.CreatePipelineRun(ctx, running))
+
+	return store
+}
+
+func TestArchive_ExportImport(t *testing.T) {
+	ctx := context.Background()
+	source := newArchiveTestStore(t)
+
+	var archive bytes.Buffer
+	require.NoError(t, Export(ctx, source, &archive))
+	assert.Equal(t, 4, strings.Count(archive.String(), "\n"), "expected header, one pipeline and two runs")
+
+	t.Run("into empty store", func(t *testing.T) {
+		target := newTestStore()
+		result, err := Import(ctx, target, bytes.NewReader(archive.Bytes()), ConflictSkip)
+		require.NoError(t, err)
+		assert.Equal(t, &ImportResult{Pipelines: 1, Runs: 2}, result)
+
+		pipeline, err := target.GetPipeline(ctx, "pipeline-1")
+		require.NoError(t, err)
+		assert.Equal(t, "Pipeline 1", pipeline.Name)
+		assert.Equal(t, "badge-token", pipeline.BadgeToken)
+		assert.Equal(t, "go test ./...", pipeline.Stages[StageRun].(*RunStage).Command)
+		assert.True(t, pipeline.Stages[StageRun].ContinueOnError())
+		assert.Equal(t, "prod", pipeline.Stages[StageDeploy].(*DeployStage).ClusterName)
+		// validators are restored
+		assert.NoError(t, pipeline.Stages[StageBuild].Validate())
+		assert.Error(t, NewBuildStage(StageBuild, "", false).Validate())
+
+		run, err := target.GetPipelineRun(ctx, "run-1")
+		require.NoError(t, err)
+		assert.Equal(t, StatusSuccess, run.Status)
+		assert.Equal(t, "finished\n", run.Logs[StageRun])
+		exported, err := source.GetPipelineRun(ctx, "run-1")
+		require.NoError(t, err)
+		assert.Equal(t, exported.StageTimings, run.StageTimings)
+		assert.Equal(t, exported.StageStatuses, run.StageStatuses)
+
+		// unfinished runs can't be resumed
+		run, err = target.GetPipelineRun(ctx, "run-2")
+		require.NoError(t, err)
+		assert.Equal(t, StatusFailed, run.Status)
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		target := newArchiveTestStore(t)
+		result, err := Import(ctx, target, bytes.NewReader(archive.Bytes()), ConflictSkip)
+		require.NoError(t, err)
+		assert.Equal(t, &ImportResult{Skipped: 3}, result)
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		target := newArchiveTestStore(t)
+		pipeline, err := target.GetPipeline(ctx, "pipeline-1")
+		require.NoError(t, err)
+		pipeline.Name = "changed"
+
+		result, err := Import(ctx, target, bytes.NewReader(archive.Bytes()), ConflictOverwrite)
+		require.NoError(t, err)
+		// the running run is not overwritten
+		assert.Equal(t, &ImportResult{Pipelines: 1, Runs: 1, Overwritten: 2, Skipped: 1}, result)
+
+		pipeline, err = target.GetPipeline(ctx, "pipeline-1")
+		require.NoError(t, err)
+		assert.Equal(t, "Pipeline 1", pipeline.Name)
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		target := newArchiveTestStore(t)
+		result, err := Import(ctx, target, bytes.NewReader(archive.Bytes()), ConflictRename)
+		require.NoError(t, err)
+		assert.Equal(t, &ImportResult{Pipelines: 1, Runs: 2, Renamed: 3}, result)
+
+		pipelines, err := target.ListPipelines(ctx, ListOptions{})
+		require.NoError(t, err)
+		require.Len(t, pipelines, 2)
+
+		// imported runs are pointing to the renamed pipeline
+		runs, err := target.ListPipelineRuns(ctx, ListOptions{})
+		require.NoError(t, err)
+		require.Len(t, runs, 4)
+		assert.Equal(t, pipelines[1].ID, runs[2].PipelineID)
+		assert.Equal(t, pipelines[1].ID, runs[3].PipelineID)
+	})
+}
+
+func TestArchive_ImportVersion1(t *testing.T) {
+	ctx := context.Background()
+	archive := `{"kind":"header","version":1}
+{"kind":"pipeline","pipeline":{"id":"pipeline-1","name":"Pipeline 1","repository":"github.com/test/repo",` +
+		`"run_stage":{"command":"make test","cont_on_error":true},"build_stage":{"dockerfile_path":"Dockerfile"},` +
+		`"deploy_stage":{"cluster_name":"prod","manifest_path":"k8s/"}}}
+{"kind":"run","run":{"id":"run-1","pipeline_id":"pipeline-1","git_ref":"main","status":"success",` +
+		`"run_status":"success","build_status":"success","deploy_status":"success","logs":{}}}
+`
+
+	target := newTestStore()
+	result, err := Import(ctx, target, strings.NewReader(archive), ConflictSkip)
+	require.NoError(t, err)
+	assert.Equal(t, &ImportResult{Pipelines: 1, Runs: 1}, result)
+
+	pipeline, err := target.GetPipeline(ctx, "pipeline-1")
+	require.NoError(t, err)
+	assert.Equal(t, "make test", pipeline.Stages[StageRun].(*RunStage).Command)
+	assert.True(t, pipeline.Stages[StageRun].ContinueOnError())
+	assert.Equal(t, "k8s/", pipeline.Stages[StageDeploy].(*DeployStage).ManifestPath)
+	assert.NoError(t, pipeline.Validate())
+
+	run, err := target.GetPipelineRun(ctx, "run-1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{StageRun: StatusSuccess, StageBuild: StatusSuccess, StageDeploy: StatusSuccess}, run.StageStatuses)
+}
+
+func TestArchive_ImportBlankLines(t *testing.T) {
+	archive := "\n" + `{"kind":"header","version":2}` + "\n\n" +
+		`{"kind":"pipeline","pipeline":{"id":"p","name":"blank lines"}}` + "\n \n"
+
+	result, err := Import(context.Background(), newTestStore(), strings.NewReader(archive), ConflictSkip)
+	require.NoError(t, err)
+	assert.Equal(t, &ImportResult{Pipelines: 1}, result)
+}
+
+func TestArchive_ImportErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		archive string
+		mode    ConflictMode
+	}{
+		{name: "empty archive", archive: "", mode: ConflictSkip},
+		{name: "only blank lines", archive: "\n  \n\n", mode: ConflictSkip},
+		{name: "missing header", archive: `{"kind":"pipeline","pipeline":{"id":"p"}}` + "\n", mode: ConflictSkip},
+		{name: "newer version", archive: `{"kind":"header","version":99}` + "\n", mode: ConflictSkip},
+		{name: "unknown kind", archive: `{"kind":"header","version":1}` + "\n" + `{"kind":"revision"}` + "\n", mode: ConflictSkip},
+		{name: "invalid json", archive: `{"kind":"header","version":1}` + "\n{\n", mode: ConflictSkip},
+		{name: "unknown stage type", archive: `{"kind":"header","version":2}` + "\n" +
+			`{"kind":"pipeline","pipeline":{"id":"p","stages":{"lint":{}}}}` + "\n", mode: ConflictSkip},
+		{name: "invalid mode", archive: `{"kind":"header","version":1}` + "\n", mode: "merge"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Import(context.Background(), newTestStore(), strings.NewReader(tt.archive), tt.mode)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// AuditEntry records a single mutating API call.
+type AuditEntry struct {
+	ID        string
+	Time      time.Time
+	RequestID string
+	// Actor is the identity which made the call
+	Actor string
+	// Action is what has been done, e.g. pipeline.update
+	Action     string
+	TargetType string
+	TargetID   string
+	// Status is the HTTP status code of the response
+	Status int
+	// Before and After are JSON snapshots of the target before and after the call
+	Before json.RawMessage
+	After  json.RawMessage
+	// Changes are the fields which differ between Before and After
+	Changes []AuditChange
+}
+
+// AuditChange is a single changed field of an audited target.
+type AuditChange struct {
+	// Field is the path of the field, e.g. run_stage.command
+	Field  string
+	Before interface{}
+	After  interface{}
+}
+
+// AuditFilter is used to select audit entries. Empty fields match all entries.
+type AuditFilter struct {
+	Actor      string
+	Action     string
+	TargetType string
+	TargetID   string
+	Since      time.Time
+	Until      time.Time
+}
+
+// Match returns true if the entry is selected by the filter.
+func (f AuditFilter) Match(e *AuditEntry) bool {
+	switch {
+	case f.Actor != "" && e.Actor != f.Actor:
+		return false
+	case f.Action != "" && e.Action != f.Action:
+		return false
+	case f.TargetType != "" && e.TargetType != f.TargetType:
+		return false
+	case f.TargetID != "" && e.TargetID != f.TargetID:
+		return false
+	case !f.Since.IsZero() && e.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !e.Time.Before(f.Until):
+		return false
+	}
+	return true
+}
+
+// AuditLog is an append-only store for audit entries.
+type AuditLog interface {
+	AppendAuditEntry(ctx context.Context, entry *AuditEntry) error
+	// ListAuditEntries returns the matching entries in the order they were appended.
+	ListAuditEntries(ctx context.Context, filter AuditFilter, opts ListOptions) ([]*AuditEntry, error)
+}
+
+// DiffSnapshots returns the changed fields between two JSON snapshots.
+// Nested objects are compared field by field, other values as a whole.
+func DiffSnapshots(before, after json.RawMessage) ([]AuditChange, error) {
+	beforeFields, err := flattenSnapshot(before)
+	if err != nil {
+		return nil, fmt.Errorf("invalid before snapshot: %w", err)
+	}
+	afterFields, err := flattenSnapshot(after)
+	if err != nil {
+		return nil, fmt.Errorf("invalid after snapshot: %w", err)
+	}
+
+	var changes []AuditChange
+	for field, b := range beforeFields {
+		if a, ok := afterFields[field]; !ok || !reflect.DeepEqual(a, b) {
+			changes = append(changes, AuditChange{Field: field, Before: b, After: a})
+		}
+	}
+	for field, a := range afterFields {
+		if _, ok := beforeFields[field]; !ok {
+			changes = append(changes, AuditChange{Field: field, After: a})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes, nil
+}
+
+// flattenSnapshot is turning a JSON object into a map of dotted field paths to values
+func flattenSnapshot(snapshot json.RawMessage) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	if len(snapshot) == 0 {
+		return fields, nil
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(snapshot, &value); err != nil {
+		return nil, err
+	}
+	flatten("", value, fields)
+	return fields, nil
+}
+
+func flatten(prefix string, value interface{}, fields map[string]interface{}) {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		if prefix != "" {
+			fields[prefix] = value
+		}
+		return
+	}
+	for k, v := range object {
+		if prefix != "" {
+			k = prefix + "." + k
+		}
+		flatten(k, v, fields)
+	}
+}
@@ -0,0 +1,74 @@
+package domain
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffSnapshots(t *testing.T) {
+	tests := []struct {
+		name   string
+		before string
+		after  string
+		want   []AuditChange
+	}{
+		{name: "no snapshots", want: nil},
+		{name: "no changes", before: `{"name":"a"}`, after: `{"name":"a"}`, want: nil},
+		{
+			name:   "changed nested field",
+			before: `{"name":"a","run_stage":{"command":"make test","continue_on_error":false}}`,
+			after:  `{"name":"a","run_stage":{"command":"go test","continue_on_error":false}}`,
+			want:   []AuditChange{{Field: "run_stage.command", Before: "make test", After: "go test"}},
+		},
+		{
+			name:  "created",
+			after: `{"id":"1","name":"a"}`,
+			want:  []AuditChange{{Field: "id", After: "1"}, {Field: "name", After: "a"}},
+		},
+		{
+			name:   "deleted",
+			before: `{"id":"1"}`,
+			want:   []AuditChange{{Field: "id", Before: "1"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes, err := DiffSnapshots(json.RawMessage(tt.before), json.RawMessage(tt.after))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, changes)
+		})
+	}
+
+	_, err := DiffSnapshots(json.RawMessage(`{`), nil)
+	assert.Error(t, err)
+}
+
+func TestAuditFilter_Match(t *testing.T) {
+	now := time.Now()
+	entry := &AuditEntry{Actor: "alice", Action: "pipeline.delete", TargetType: "pipeline", TargetID: "p1", Time: now}
+
+	tests := []struct {
+		name   string
+		filter AuditFilter
+		want   bool
+	}{
+		{name: "empty filter", filter: AuditFilter{}, want: true},
+		{name: "all fields", filter: AuditFilter{Actor: "alice", Action: "pipeline.delete", TargetType: "pipeline", TargetID: "p1"}, want: true},
+		{name: "other actor", filter: AuditFilter{Actor: "bob"}, want: false},
+		{name: "other target", filter: AuditFilter{TargetID: "p2"}, want: false},
+		{name: "since", filter: AuditFilter{Since: now.Add(-time.Minute)}, want: true},
+		{name: "since later", filter: AuditFilter{Since: now.Add(time.Minute)}, want: false},
+		{name: "until", filter: AuditFilter{Until: now}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.filter.Match(entry))
+		})
+	}
+}
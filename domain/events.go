@@ -0,0 +1,73 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrEventsExpired is returned when resuming a watch from an event which is not retained anymore.
+var ErrEventsExpired = errors.New("events expired")
+
+// EventType is the type of a store change event.
+type EventType string
+
+const (
+	EventPipelineCreated  EventType = "pipeline.created"
+	EventPipelineUpdated  EventType = "pipeline.updated"
+	EventPipelineDeleted  EventType = "pipeline.deleted"
+	EventRunCreated       EventType = "run.created"
+	EventRunStatusChanged EventType = "run.status_changed"
+	EventRunDeleted       EventType = "run.deleted"
+)
+
+// Event describes a change of the store.
+type Event struct {
+	// Sequence is increasing with every event and can be used to resume a watch.
+	Sequence uint64
+	Type     EventType
+	Time     time.Time
+	// PipelineID is set for pipeline and run events
+	PipelineID string
+	// RunID is only set for run events
+	RunID string
+	// Status is the status of the run for run events
+	Status string
+}
+
+// WatchFilter is used to select the events of a watch.
+type WatchFilter struct {
+	// Types are the event types to watch - empty means all types.
+	Types []EventType
+	// PipelineID limits the events to a single pipeline and its runs.
+	PipelineID string
+	// After resumes a watch after the event with this sequence number - 0 starts with new events.
+	After uint64
+}
+
+// Match returns true if the event is selected by the filter.
+func (f WatchFilter) Match(e Event) bool {
+	if e.Sequence <= f.After {
+		return false
+	}
+	if f.PipelineID != "" && e.PipelineID != f.PipelineID {
+		return false
+	}
+	if len(f.Types) == 0 {
+		return true
+	}
+	for _, t := range f.Types {
+		if t == e.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// Watcher is an optional capability of a Store to provide a feed of its changes.
+type Watcher interface {
+	// Watch is returning a channel of events matching the filter in the order they happened.
+	// The channel is closed when the context is cancelled or the watcher can't keep up
+	// with the events - in that case the watch can be resumed with the last received sequence.
+	Watch(ctx context.Context, filter WatchFilter) (<-chan Event, error)
+}
@@ -4,55 +4,184 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"math/rand"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Executor is dispatching PipelineRuns to worker go routines for execution.
 type Executor struct {
-	Store               Store
-	workers             int
-	queue               *queue
-	runChan             chan *PipelineRun
-	runStageExecutor    func(ctx context.Context, pipelineRun *PipelineRun, runStage *RunStage) error
-	buildStageExecutor  func(ctx context.Context, pipelineRun *PipelineRun, buildStage *BuildStage) error
-	deployStageExecutor func(ctx context.Context, pipelineRun *PipelineRun, deployStage *DeployStage) error
+	Store   Store
+	workers int
+	queue   *queue
+	runChan chan dispatchedRun
+	// failureRate and delay are simulating the execution of the stages, see StageExecution
+	failureRate float64
+	delay       time.Duration
+	logger      *slog.Logger
+	metrics     Metrics
+	tracer      trace.Tracer
+	// busyWorkers is the number of workers executing a pipeline run
+	busyWorkers atomic.Int64
+	// running is true while the event loop of Start is running
+	running atomic.Bool
+	// active are the cancel funcs of the dequeued runs which are not finished yet
+	active   map[string]context.CancelCauseFunc
+	activeMu sync.Mutex
+}
+
+// dispatchedRun is a run sent to the workers with the context it is cancelled with
+type dispatchedRun struct {
+	run *PipelineRun
+	ctx context.Context
+}
+
+// ExecutorOption allows for customizing the executor
+type ExecutorOption func(*Executor)
+
+// WithLogger sets the logger of the executor. Log lines of pipeline runs carry
+// the pipeline_id, run_id and stage attributes.
+func WithLogger(logger *slog.Logger) ExecutorOption {
+	return func(e *Executor) {
+		e.logger = logger
+	}
 }
 
-func NewExecutor(store Store, workers, queueSize int, maxQueuedPerPipeline int, failureRate float64, delay time.Duration) *Executor {
-	return &Executor{
-		Store:               store,
-		workers:             workers,
-		queue:               newQueue(queueSize, maxQueuedPerPipeline),
-		runChan:             make(chan *PipelineRun, 1),
-		runStageExecutor:    runExecFuncConstructor(failureRate, delay),
-		buildStageExecutor:  buildExecFuncConstructor(failureRate, delay),
-		deployStageExecutor: deployExecFuncConstructor(failureRate, delay),
+// WithMetrics sets the metrics recording the outcome and duration of runs and stages.
+func WithMetrics(metrics Metrics) ExecutorOption {
+	return func(e *Executor) {
+		e.metrics = metrics
 	}
 }
 
-// TriggerPipeline is creating a new pipeline run and enqueuing it for execution
+// WithTracerProvider sets the tracer provider for the spans of pipeline runs and their stages.
+// The global tracer provider is used by default.
+func WithTracerProvider(tp trace.TracerProvider) ExecutorOption {
+	return func(e *Executor) {
+		e.tracer = tp.Tracer(tracerName)
+	}
+}
+
+func NewExecutor(store Store, workers, queueSize int, maxQueuedPerPipeline int, failureRate float64, delay time.Duration, opts ...ExecutorOption) *Executor {
+	e := &Executor{
+		Store:       store,
+		workers:     workers,
+		queue:       newQueue(queueSize, maxQueuedPerPipeline),
+		runChan:     make(chan dispatchedRun, 1),
+		failureRate: failureRate,
+		delay:       delay,
+		logger:      slog.Default(),
+		metrics:     noopMetrics{},
+		tracer:      otel.GetTracerProvider().Tracer(tracerName),
+		active:      make(map[string]context.CancelCauseFunc),
+	}
+
+	for _, opt := range opts {
+		opt(e)
+	}
+
+	return e
+}
+
+// TriggerPipeline is creating a new pipeline run and enqueuing it for execution.
+// The trace context of ctx is kept with the run, so its execution is part of the same trace.
 func (e *Executor) TriggerPipeline(ctx context.Context, pipeline *Pipeline, gitRef string) (*PipelineRun, error) {
 
 	pipelineRun := NewPipelineRun(pipeline.ID, gitRef)
+	for name := range pipeline.Stages {
+		pipelineRun.setStageStatus(name, StatusPending)
+	}
+	pipelineRun.RequestID = RequestIDFromContext(ctx)
+	logger := e.runLogger(pipelineRun)
+
+	ctx, span := e.tracer.Start(ctx, "trigger pipeline", trace.WithAttributes(runAttributes(pipelineRun)...))
+	defer span.End()
+	pipelineRun.TraceParent = traceParentFromContext(ctx)
 
 	if err := e.Store.CreatePipelineRun(ctx, pipelineRun); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	// the pipeline might have been deleted in the meantime, its runs would be left behind. Deleting a
+	// pipeline with its runs fails for the unfinished run now, so the pipeline is still there afterwards.
+	if _, err := e.Store.GetPipeline(ctx, pipeline.ID); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		if err := e.Store.DeletePipelineRun(ctx, pipelineRun.ID); err != nil {
+			logger.Warn("failed to delete pipeline run of deleted pipeline", "error", err)
+		}
 		return nil, err
 	}
 
-	if err := e.queue.Enqueue(pipelineRun); err != nil {
+	// the queued run is modified by a worker, the caller gets a copy
+	queued := pipelineRun.Clone()
+	if err := e.queue.Enqueue(queued); err != nil {
+		logger.Warn("failed to enqueue pipeline run", "error", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		pipelineRun.Status = StatusFailed
 		e.Store.UpdatePipelineRun(ctx, pipelineRun)
 		return nil, err
 	}
 
+	logger.Info("pipeline run queued", "git_ref", gitRef)
 	return pipelineRun, nil
 }
 
+// Stats returns a snapshot of the queue and worker utilisation.
+func (e *Executor) Stats() ExecutorStats {
+	return ExecutorStats{
+		Workers:           e.workers,
+		BusyWorkers:       int(e.busyWorkers.Load()),
+		Queued:            e.queue.Len(),
+		QueuedPerPipeline: e.queue.PipelineCounts(),
+	}
+}
+
+// QueueSnapshot returns the runs waiting in the queue in the order they will be executed.
+func (e *Executor) QueueSnapshot() QueueSnapshot {
+	return e.queue.Snapshot()
+}
+
+// CancelRun is cancelling a queued or running pipeline run. Queued runs are removed from the queue and
+// marked as failed, running runs fail as soon as their current stage has been interrupted.
+// ErrNotFound is returned if the run is neither queued nor running.
+func (e *Executor) CancelRun(ctx context.Context, id string) error {
+	e.activeMu.Lock()
+	defer e.activeMu.Unlock()
+
+	if pipelineRun := e.queue.Remove(id); pipelineRun != nil {
+		addLog(ContextWithLogger(ctx, e.runLogger(pipelineRun)), pipelineRun, StageRun, StatusFailed, "cancelled")
+		pipelineRun.Status = StatusFailed
+		pipelineRun.UpdatedAt = time.Now()
+		return e.Store.UpdatePipelineRun(ctx, pipelineRun)
+	}
+
+	cancel, ok := e.active[id]
+	if !ok {
+		return fmt.Errorf("%w: pipeline run %s is not queued or running", ErrNotFound, id)
+	}
+	cancel(ErrRunCancelled)
+	return nil
+}
+
+// Running returns true while the executor is dispatching pipeline runs to its workers.
+func (e *Executor) Running() bool {
+	return e.running.Load()
+}
+
 // Start is starting the executor workersand will block until the context is cancelled
 func (e *Executor) Start(ctx context.Context) {
+	e.running.Store(true)
+	defer e.running.Store(false)
 
 	wg := sync.WaitGroup{}
 
@@ -64,12 +193,27 @@ func (e *Executor) Start(ctx context.Context) {
 
 	// event loop of the executor
 	for {
-		// get the next pipeline run from the queue
+		// get the next pipeline run from the queue. It is registered as active at the same time,
+		// so CancelRun finds it either in the queue or as active run.
+		e.activeMu.Lock()
 		pipelineRun, err := e.queue.Dequeue()
+		var dispatched dispatchedRun
+		if err == nil {
+			// runs are not interrupted by a shutdown, only by being cancelled
+			runCtx, cancel := context.WithCancelCause(context.WithoutCancel(ctx))
+			e.active[pipelineRun.ID] = cancel
+			dispatched = dispatchedRun{run: pipelineRun, ctx: runCtx}
+		}
+		e.activeMu.Unlock()
 		if err != nil {
 			if err == ErrQueueEmpty {
 				// look for new pipeline runs every second
-				time.Sleep(1 * time.Second)
+				select {
+				case <-ctx.Done():
+					wg.Wait()
+					return
+				case <-time.After(1 * time.Second):
+				}
 				continue
 			}
 			// TODO: handle error
@@ -78,11 +222,12 @@ func (e *Executor) Start(ctx context.Context) {
 
 		select {
 		case <-ctx.Done():
+			e.finishRun(pipelineRun.ID)
 			// wait for all workers to finish
 			wg.Wait()
 			return
 		// send the pipeline run to the workers
-		case e.runChan <- pipelineRun:
+		case e.runChan <- dispatched:
 			continue
 		}
 	}
@@ -95,48 +240,97 @@ func (e *Executor) worker(ctx context.Context, wg *sync.WaitGroup) {
 		select {
 		case <-ctx.Done():
 			return
-		case pipelineRun := <-e.runChan:
-			log.Println("worker: picked up next pipeline run", pipelineRun.ID)
-			e.execute(ctx, pipelineRun)
+		case dispatched := <-e.runChan:
+			pipelineRun := dispatched.run
+			logger := e.runLogger(pipelineRun)
+			logger.Info("worker picked up pipeline run")
+			e.busyWorkers.Add(1)
+			e.execute(ContextWithLogger(dispatched.ctx, logger), pipelineRun)
+			e.busyWorkers.Add(-1)
+			e.finishRun(pipelineRun.ID)
 		}
 	}
 }
 
+// finishRun is releasing the context of an active run
+func (e *Executor) finishRun(id string) {
+	e.activeMu.Lock()
+	defer e.activeMu.Unlock()
+
+	if cancel, ok := e.active[id]; ok {
+		cancel(nil)
+		delete(e.active, id)
+	}
+}
+
+// runLogger returns a logger with the attributes of the pipeline run
+func (e *Executor) runLogger(pipelineRun *PipelineRun) *slog.Logger {
+	logger := e.logger.With("pipeline_id", pipelineRun.PipelineID, "run_id", pipelineRun.ID)
+	if pipelineRun.RequestID != "" {
+		logger = logger.With("request_id", pipelineRun.RequestID)
+	}
+	return logger
+}
+
 // logTmpl is the template for logging pipeline run events.
 var logTmpl = "Pipeline: %s, Run: %s, Stage: %s, Status: %s - %s\n"
 
-// addLog is adding a log message to the pipeline run logs and also printing it to the console
-func addLog(pipelineRun *PipelineRun, stage string, status string, content string) {
+// addLog is adding a log message to the pipeline run logs and also logs it with the logger of the context
+func addLog(ctx context.Context, pipelineRun *PipelineRun, stage string, status string, content string) {
 	msg := fmt.Sprintf(logTmpl, pipelineRun.PipelineID, pipelineRun.ID, stage, status, content)
 	pipelineRun.Logs[stage] += msg
-	log.Println(msg)
+
+	level := slog.LevelInfo
+	if status == StatusFailed {
+		level = slog.LevelWarn
+	}
+	LoggerFromContext(ctx).Log(ctx, level, content, "stage", stage, "status", status)
 }
 
 // execute is the main logic for executing a pipeline run through all stages and is called by workers
 func (e *Executor) execute(ctx context.Context, pipelineRun *PipelineRun) {
+	logger := LoggerFromContext(ctx)
+	start := time.Now()
+
+	// the run is traced as part of the trace which triggered it. The time in the queue is
+	// recorded as separate span from the creation of the run until a worker picked it up.
+	ctx = contextWithTraceParent(ctx, pipelineRun.TraceParent)
+	queueCtx, queueSpan := e.tracer.Start(ctx, "queued", trace.WithTimestamp(pipelineRun.CreatedAt),
+		trace.WithAttributes(runAttributes(pipelineRun)...))
+	queueSpan.End(trace.WithTimestamp(start))
+	ctx, span := e.tracer.Start(ctx, "pipeline run",
+		trace.WithLinks(trace.LinkFromContext(queueCtx)),
+		trace.WithAttributes(runAttributes(pipelineRun)...))
+
+	defer func() {
+		duration := time.Since(start)
+		e.metrics.RunFinished(pipelineRun.PipelineID, pipelineRun.Status, duration)
+		span.SetAttributes(attribute.String("run.status", pipelineRun.Status))
+		if pipelineRun.Status == StatusFailed {
+			span.SetStatus(codes.Error, "pipeline run failed")
+		}
+		span.End()
+		logger.Info("pipeline run finished", "status", pipelineRun.Status, "duration", duration)
+	}()
 
 	// get the pipeline definition from the store
 	pipeline, err := e.Store.GetPipeline(ctx, pipelineRun.PipelineID)
 	if err != nil {
-		addLog(pipelineRun, StageRun, StatusFailed, fmt.Sprintf("error getting pipeline from store: %v", err))
+		addLog(ctx, pipelineRun, StageRun, StatusFailed, fmt.Sprintf("error getting pipeline from store: %v", err))
 		pipelineRun.Status = StatusFailed
-		if err := e.Store.UpdatePipelineRun(ctx, pipelineRun); err != nil {
-			log.Println(err)
-		}
+		e.updateRun(ctx, pipelineRun)
 		return
 	}
 
 	// if we find another run for this pipeline which is not finished, we need wait for it to finish
 	repeat := true
 	for repeat {
-		otherPipelineRuns, err := e.Store.ListPipelineRuns(ctx)
+		otherPipelineRuns, err := e.Store.ListPipelineRuns(ctx, ListOptions{})
 		if err != nil {
 			if err != ErrNotFound {
-				addLog(pipelineRun, StageRun, StatusFailed, fmt.Sprintf("error getting other pipeline runs from store: %v", err))
+				addLog(ctx, pipelineRun, StageRun, StatusFailed, fmt.Sprintf("error getting other pipeline runs from store: %v", err))
 				pipelineRun.Status = StatusFailed
-				if err := e.Store.UpdatePipelineRun(ctx, pipelineRun); err != nil {
-					log.Println(err)
-				}
+				e.updateRun(ctx, pipelineRun)
 				return
 			}
 		}
@@ -151,8 +345,14 @@ func (e *Executor) execute(ctx context.Context, pipelineRun *PipelineRun) {
 			if run.PipelineID == pipeline.ID {
 				// if the other run for this pipeline is not finished, we need to wait for it to finish
 				if run.Status == StatusRunning {
-					addLog(pipelineRun, StageRun, StatusPending, fmt.Sprintf("waiting for previous run %s to finish", run.ID))
-					time.Sleep(1 * time.Second)
+					addLog(ctx, pipelineRun, StageRun, StatusPending, fmt.Sprintf("waiting for previous run %s to finish", run.ID))
+					if err := sleep(ctx, 1*time.Second); err != nil {
+						addLog(ctx, pipelineRun, StageRun, StatusFailed, "cancelled")
+						pipelineRun.Status = StatusFailed
+						pipelineRun.UpdatedAt = time.Now()
+						e.updateRun(ctx, pipelineRun)
+						return
+					}
 					repeat = true
 					break
 				}
@@ -161,147 +361,110 @@ func (e *Executor) execute(ctx context.Context, pipelineRun *PipelineRun) {
 	}
 
 	pipelineRun.Status = StatusRunning
-
-	// execute the run stage
-	if err := e.runStageExecutor(ctx, pipelineRun, pipeline.Stages[StageRun].(*RunStage)); err != nil {
-		pipelineRun.UpdatedAt = time.Now()
-		if !pipeline.Stages[StageRun].ContinueOnError() {
-			pipelineRun.Status = StatusFailed
-			if err := e.Store.UpdatePipelineRun(ctx, pipelineRun); err != nil {
-				log.Println(err)
-			}
-			return
-		}
-	}
-
 	pipelineRun.UpdatedAt = time.Now()
-	if err := e.Store.UpdatePipelineRun(ctx, pipelineRun); err != nil {
-		log.Println(err)
-	}
+	e.updateRun(ctx, pipelineRun)
 
-	// execute the build stage
-	if err := e.buildStageExecutor(ctx, pipelineRun, pipeline.Stages[StageBuild].(*BuildStage)); err != nil {
-		if !pipeline.Stages[StageBuild].ContinueOnError() {
-			pipelineRun.Status = StatusFailed
-			pipelineRun.UpdatedAt = time.Now()
-			if err := e.Store.UpdatePipelineRun(ctx, pipelineRun); err != nil {
-				log.Println(err)
-			}
-			return
+	// execute the stages in the order of their types
+	for _, stageType := range StageTypes() {
+		stage, ok := pipeline.Stages[stageType.Name]
+		if !ok {
+			continue
 		}
-	}
-
-	pipelineRun.UpdatedAt = time.Now()
-	if err := e.Store.UpdatePipelineRun(ctx, pipelineRun); err != nil {
-		log.Println(err)
-	}
-
-	// execute the deploy stage
-	if err := e.deployStageExecutor(ctx, pipelineRun, pipeline.Stages[StageDeploy].(*DeployStage)); err != nil {
-		pipelineRun.Status = StatusFailed
+		x := &StageExecution{
+			Pipeline: pipeline, Run: pipelineRun, Name: stageType.Name, Stage: stage,
+			FailureRate: e.failureRate, Delay: e.delay,
+			update: func(ctx context.Context) { e.updateRun(ctx, pipelineRun) },
+		}
+		err := e.observeStage(ctx, pipelineRun, stageType.Name, stage, func(ctx context.Context) error {
+			return stageType.Execute(ctx, x)
+		})
 		pipelineRun.UpdatedAt = time.Now()
-		if err := e.Store.UpdatePipelineRun(ctx, pipelineRun); err != nil {
-			log.Println(err)
+		if err != nil {
+			pipelineRun.setStageStatus(stageType.Name, StatusFailed)
+			// cancelled runs fail even if the stage may continue on errors
+			if stageType.FailsRun || !stage.ContinueOnError() || ctx.Err() != nil {
+				pipelineRun.Status = StatusFailed
+				e.updateRun(ctx, pipelineRun)
+				return
+			}
+		} else {
+			pipelineRun.setStageStatus(stageType.Name, StatusSuccess)
 		}
-		return
+		e.updateRun(ctx, pipelineRun)
 	}
 
 	pipelineRun.Status = StatusSuccess
 	pipelineRun.UpdatedAt = time.Now()
+	e.updateRun(ctx, pipelineRun)
+}
+
+// updateRun is storing the changes of a pipeline run, errors are only logged as the run goes on
+func (e *Executor) updateRun(ctx context.Context, pipelineRun *PipelineRun) {
 	if err := e.Store.UpdatePipelineRun(ctx, pipelineRun); err != nil {
-		log.Println(err)
+		LoggerFromContext(ctx).Error("failed to update pipeline run", "error", err)
 	}
 }
 
-// runExecFuncConstructor is a factory function that returns a run stage executor function
-// with a given failure rate and delay for testing purposes
-func runExecFuncConstructor(failureRate float64, delay time.Duration) func(ctx context.Context, pipelineRun *PipelineRun, runStage *RunStage) error {
-	return func(ctx context.Context, pipelineRun *PipelineRun, runStage *RunStage) error {
-
-		if err := runStage.Validate(); err != nil {
-			pipelineRun.Logs[StageRun] = err.Error()
-			pipelineRun.RunStatus = StatusFailed
-			return err
-		}
-
-		addLog(pipelineRun, StageRun, StatusRunning, "starting...")
-		addLog(pipelineRun, StageRun, StatusRunning, fmt.Sprintf("command: %s", runStage.Command))
-
-		// simulate a failure
-		if rand.Float64() < failureRate {
-			addLog(pipelineRun, StageRun, StatusFailed, "failed")
-			pipelineRun.RunStatus = StatusFailed
-			return errors.New("failed")
-		}
-
-		// simulate a long running command
-		time.Sleep(delay)
-
-		addLog(pipelineRun, StageRun, StatusSuccess, "finished")
-		pipelineRun.RunStatus = StatusSuccess
-
-		return nil
+// observeStage is executing a stage in its own span and recording its outcome and duration
+func (e *Executor) observeStage(ctx context.Context, pipelineRun *PipelineRun, name string, stage Stage, exec func(ctx context.Context) error) error {
+	ctx, span := e.tracer.Start(ctx, "stage "+name,
+		trace.WithAttributes(runAttributes(pipelineRun)...),
+		trace.WithAttributes(stageAttributes(name, stage)...))
+	defer span.End()
+
+	start := time.Now()
+	pipelineRun.setStageTiming(name, StageTiming{StartedAt: start})
+	e.updateRun(ctx, pipelineRun)
+	err := exec(ctx)
+	pipelineRun.setStageTiming(name, StageTiming{StartedAt: start, FinishedAt: time.Now()})
+	status := StatusSuccess
+	if err != nil {
+		status = StatusFailed
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 	}
+	span.SetAttributes(attribute.String("stage.status", status))
+	e.metrics.StageFinished(pipelineRun.PipelineID, name, status, time.Since(start))
+	return err
 }
 
-// buildExecFuncConstructor is a factory function that returns a build stage executor function
-// with a given failure rate and delay
-func buildExecFuncConstructor(failureRate float64, delay time.Duration) func(ctx context.Context, pipelineRun *PipelineRun, buildStage *BuildStage) error {
-	return func(ctx context.Context, pipelineRun *PipelineRun, buildStage *BuildStage) error {
-
-		if err := buildStage.Validate(); err != nil {
-			pipelineRun.Logs[StageBuild] = err.Error()
-			pipelineRun.BuildStatus = StatusFailed
+// simulateStage returns the Execute func of the built-in stage types. It is logging the detail of what the
+// stage would do and simulates its execution with the failure rate and the delay of the executor.
+func simulateStage(detail func(stage Stage) string) func(ctx context.Context, x *StageExecution) error {
+	return func(ctx context.Context, x *StageExecution) error {
+		if err := x.Stage.Validate(); err != nil {
+			x.Run.Logs[x.Name] = err.Error()
 			return err
 		}
 
-		addLog(pipelineRun, StageBuild, StatusRunning, "starting...")
-		addLog(pipelineRun, StageBuild, StatusRunning, fmt.Sprintf("dockerfile path: %s", buildStage.DockerfilePath))
+		x.Log(ctx, StatusRunning, "starting...")
+		x.Log(ctx, StatusRunning, detail(x.Stage))
 
 		// simulate a failure
-		if rand.Float64() < failureRate {
-			addLog(pipelineRun, StageBuild, StatusFailed, "failed")
-			pipelineRun.BuildStatus = StatusFailed
+		if rand.Float64() < x.FailureRate {
+			x.Log(ctx, StatusFailed, "failed")
 			return errors.New("failed")
 		}
 
 		// simulate a long running command
-		time.Sleep(delay)
-
-		addLog(pipelineRun, StageBuild, StatusSuccess, "finished")
-		pipelineRun.BuildStatus = StatusSuccess
+		if err := sleep(ctx, x.Delay); err != nil {
+			x.Log(ctx, StatusFailed, "cancelled")
+			return err
+		}
 
+		x.Log(ctx, StatusSuccess, "finished")
 		return nil
 	}
 }
 
-// deployExecFuncConstructor is a factory function that returns a deploy stage executor function
-// with a given failure rate and delay
-func deployExecFuncConstructor(failureRate float64, delay time.Duration) func(ctx context.Context, pipelineRun *PipelineRun, deployStage *DeployStage) error {
-	return func(ctx context.Context, pipelineRun *PipelineRun, deployStage *DeployStage) error {
-
-		if err := deployStage.Validate(); err != nil {
-			pipelineRun.Logs[StageDeploy] = err.Error()
-			pipelineRun.DeployStatus = StatusFailed
-			return err
-		}
-
-		addLog(pipelineRun, StageDeploy, StatusRunning, "starting...")
-		addLog(pipelineRun, StageDeploy, StatusRunning, fmt.Sprintf("deploying to cluster name: %s", deployStage.ClusterName))
-
-		// simulate a failure
-		if rand.Float64() < failureRate {
-			addLog(pipelineRun, StageDeploy, StatusFailed, "failed")
-			pipelineRun.DeployStatus = StatusFailed
-			return errors.New("failed")
-		}
-
-		// simulate a long running command
-		time.Sleep(delay)
-
-		addLog(pipelineRun, StageDeploy, StatusSuccess, "finished")
-		pipelineRun.DeployStatus = StatusSuccess
-
+// sleep is waiting for the duration or until the context is cancelled, returning the cause of the cancellation
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	case <-timer.C:
 		return nil
 	}
 }
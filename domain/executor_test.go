@@ -1,12 +1,21 @@
 package domain
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 var (
@@ -16,7 +25,7 @@ var (
 
 func TestExecutor_Integration(t *testing.T) {
 	// Setup
-	store := NewMemoryStore()
+	store := newTestStore()
 	executor := NewExecutor(store, 2, queueSize, pipelineLimit, 0.0, 10*time.Millisecond)
 
 	// Start the executor
@@ -56,6 +65,11 @@ func TestExecutor_Integration(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, StatusSuccess, updatedRun.Status)
 		assert.Contains(t, updatedRun.Logs[StageDeploy], "finished")
+		for _, stage := range []string{StageRun, StageBuild, StageDeploy} {
+			timing := updatedRun.StageTimings[stage]
+			assert.False(t, timing.FinishedAt.Before(timing.StartedAt), "timing of stage %s", stage)
+			assert.False(t, timing.StartedAt.IsZero(), "start of stage %s", stage)
+		}
 	})
 
 	t.Run("Max per pipeline limits are respected", func(t *testing.T) {
@@ -72,17 +86,19 @@ func TestExecutor_Integration(t *testing.T) {
 
 	t.Run("Queue limits are respected", func(t *testing.T) {
 		// create new store and executor
-		store = NewMemoryStore()
+		store = newTestStore()
 		executor = NewExecutor(store, 1, queueSize, pipelineLimit, 0.0, 10*time.Millisecond)
 
 		// create different pipelines
 		pipelines := []*Pipeline{}
 		for i := 0; i < queueSize+1; i++ {
-			pipelines = append(pipelines, &Pipeline{
+			pipeline := &Pipeline{
 				ID:         fmt.Sprintf("pipeline-%d", i),
 				Name:       fmt.Sprintf("Pipeline %d", i),
 				Repository: fmt.Sprintf("github.com/test/repo%d", i),
-			})
+			}
+			require.NoError(t, store.CreatePipeline(ctx, pipeline))
+			pipelines = append(pipelines, pipeline)
 		}
 
 		for j, pipeline := range pipelines {
@@ -95,3 +111,264 @@ func TestExecutor_Integration(t *testing.T) {
 		}
 	})
 }
+
+func TestExecutor_Logging(t *testing.T) {
+	var buf syncBuffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	store := newTestStore()
+	executor := NewExecutor(store, 1, queueSize, pipelineLimit, 0.0, time.Millisecond, WithLogger(logger))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go executor.Start(ctx)
+
+	pipeline := &Pipeline{
+		ID:         "test-pipeline",
+		Name:       "Test Pipeline",
+		Repository: "github.com/test/repo",
+		Stages: map[string]Stage{
+			StageRun:    &RunStage{Name: StageRun, Command: "go test ./..."},
+			StageBuild:  &BuildStage{Name: StageBuild, DockerfilePath: "Dockerfile"},
+			StageDeploy: &DeployStage{Name: StageDeploy, ClusterName: "test-cluster", ManifestPath: "k8s/"},
+		},
+	}
+	assert.NoError(t, store.CreatePipeline(ctx, pipeline))
+
+	run, err := executor.TriggerPipeline(ContextWithRequestID(ctx, "req-1"), pipeline, "main")
+	assert.NoError(t, err)
+	assert.Equal(t, "req-1", run.RequestID)
+
+	assert.Eventually(t, func() bool {
+		r, err := store.GetPipelineRun(ctx, run.ID)
+		return err == nil && r.Status == StatusSuccess && strings.Contains(buf.String(), "pipeline run finished")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	var stageLogged bool
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(line), &entry))
+		// all log lines of the run can be correlated with the request which triggered it
+		assert.Equal(t, run.ID, entry["run_id"], line)
+		assert.Equal(t, pipeline.ID, entry["pipeline_id"], line)
+		assert.Equal(t, "req-1", entry["request_id"], line)
+		if entry["stage"] == StageRun {
+			stageLogged = true
+		}
+	}
+	assert.True(t, stageLogged, "no log line of the run stage")
+}
+
+// syncBuffer is a bytes.Buffer which is safe for concurrent use
+type syncBuffer struct {
+	buf bytes.Buffer
+	mu  sync.Mutex
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// recordingMetrics is recording the outcomes of runs and stages
+type recordingMetrics struct {
+	runs   []string
+	stages []string
+	mu     sync.Mutex
+}
+
+func (m *recordingMetrics) RunFinished(pipelineID, status string, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.runs = append(m.runs, pipelineID+":"+status)
+}
+
+func (m *recordingMetrics) StageFinished(pipelineID, stage, status string, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stages = append(m.stages, pipelineID+":"+stage+":"+status)
+}
+
+func (m *recordingMetrics) snapshot() ([]string, []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.runs...), append([]string(nil), m.stages...)
+}
+
+func TestExecutor_Metrics(t *testing.T) {
+	metrics := &recordingMetrics{}
+	store := newTestStore()
+	executor := NewExecutor(store, 2, queueSize, pipelineLimit, 0.0, time.Millisecond, WithMetrics(metrics))
+
+	pipeline := &Pipeline{
+		ID:         "test-pipeline",
+		Name:       "Test Pipeline",
+		Repository: "github.com/test/repo",
+		Stages: map[string]Stage{
+			StageRun:    &RunStage{Name: StageRun, Command: "go test ./..."},
+			StageBuild:  &BuildStage{Name: StageBuild, DockerfilePath: "Dockerfile"},
+			StageDeploy: NewDeployStage(StageDeploy, "", "k8s/", false),
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	assert.NoError(t, store.CreatePipeline(ctx, pipeline))
+
+	// the executor is not started yet, so the run stays queued
+	_, err := executor.TriggerPipeline(ctx, pipeline, "main")
+	assert.NoError(t, err)
+	assert.Equal(t, ExecutorStats{
+		Workers:           2,
+		Queued:            1,
+		QueuedPerPipeline: map[string]int{pipeline.ID: 1},
+	}, executor.Stats())
+
+	go executor.Start(ctx)
+
+	// the deploy stage is invalid and fails the run
+	assert.Eventually(t, func() bool {
+		runs, _ := metrics.snapshot()
+		return len(runs) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	runs, stages := metrics.snapshot()
+	assert.Equal(t, []string{pipeline.ID + ":" + StatusFailed}, runs)
+	assert.Equal(t, []string{
+		pipeline.ID + ":" + StageRun + ":" + StatusSuccess,
+		pipeline.ID + ":" + StageBuild + ":" + StatusSuccess,
+		pipeline.ID + ":" + StageDeploy + ":" + StatusFailed,
+	}, stages)
+	assert.Eventually(t, func() bool {
+		return executor.Stats().BusyWorkers == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0, executor.Stats().Queued)
+}
+
+func TestExecutor_Tracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	store := newTestStore()
+	executor := NewExecutor(store, 1, queueSize, pipelineLimit, 0.0, time.Millisecond, WithTracerProvider(tp))
+
+	pipeline := &Pipeline{
+		ID:         "test-pipeline",
+		Name:       "Test Pipeline",
+		Repository: "github.com/test/repo",
+		Stages: map[string]Stage{
+			StageRun:    &RunStage{Name: StageRun, Command: "go test ./..."},
+			StageBuild:  &BuildStage{Name: StageBuild, DockerfilePath: "Dockerfile"},
+			StageDeploy: &DeployStage{Name: StageDeploy, ClusterName: "test-cluster", ManifestPath: "k8s/"},
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	assert.NoError(t, store.CreatePipeline(ctx, pipeline))
+
+	// the trigger is part of the trace of the caller
+	callerCtx, caller := tp.Tracer("test").Start(ctx, "caller")
+	run, err := executor.TriggerPipeline(callerCtx, pipeline, "main")
+	caller.End()
+	assert.NoError(t, err)
+	assert.Contains(t, run.TraceParent, caller.SpanContext().TraceID().String())
+
+	go executor.Start(ctx)
+	assert.Eventually(t, func() bool {
+		for _, s := range recorder.Ended() {
+			if s.Name() == "pipeline run" {
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 10*time.Millisecond)
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, s := range recorder.Ended() {
+		assert.Equal(t, caller.SpanContext().TraceID(), s.SpanContext().TraceID(), s.Name())
+		spans[s.Name()] = s
+	}
+	trigger, queued, runSpan := spans["trigger pipeline"], spans["queued"], spans["pipeline run"]
+	assert.Equal(t, caller.SpanContext().SpanID(), trigger.Parent().SpanID())
+	assert.Equal(t, trigger.SpanContext().SpanID(), queued.Parent().SpanID())
+	assert.Equal(t, trigger.SpanContext().SpanID(), runSpan.Parent().SpanID())
+	assert.Equal(t, run.CreatedAt, queued.StartTime())
+	if assert.Len(t, runSpan.Links(), 1) {
+		assert.Equal(t, queued.SpanContext().SpanID(), runSpan.Links()[0].SpanContext.SpanID())
+	}
+	assert.Contains(t, runSpan.Attributes(), attribute.String("run.status", StatusSuccess))
+
+	for _, stage := range []string{StageRun, StageBuild, StageDeploy} {
+		s := spans["stage "+stage]
+		if assert.NotNil(t, s, stage) {
+			assert.Equal(t, runSpan.SpanContext().SpanID(), s.Parent().SpanID())
+			assert.Contains(t, s.Attributes(), attribute.String("git.ref", "main"))
+			assert.Contains(t, s.Attributes(), attribute.String("stage.status", StatusSuccess))
+		}
+	}
+	assert.Contains(t, spans["stage "+StageDeploy].Attributes(), attribute.String("stage.cluster_name", "test-cluster"))
+}
+
+func TestExecutor_CancelRun(t *testing.T) {
+	store := newTestStore()
+	executor := NewExecutor(store, 1, queueSize, pipelineLimit, 0.0, 10*time.Second)
+	ctx := context.Background()
+
+	pipeline := &Pipeline{
+		ID: "test-pipeline",
+		Stages: map[string]Stage{
+			// the run stage may continue on errors, but not if it has been cancelled
+			StageRun:    &RunStage{Name: StageRun, Command: "go test ./...", ContOnError: true},
+			StageBuild:  &BuildStage{Name: StageBuild, DockerfilePath: "Dockerfile"},
+			StageDeploy: &DeployStage{Name: StageDeploy, ClusterName: "test-cluster", ManifestPath: "k8s/"},
+		},
+	}
+	assert.NoError(t, store.CreatePipeline(ctx, pipeline))
+
+	t.Run("queued run", func(t *testing.T) {
+		run, err := executor.TriggerPipeline(ctx, pipeline, "main")
+		assert.NoError(t, err)
+		assert.NoError(t, executor.CancelRun(ctx, run.ID))
+
+		stored, err := store.GetPipelineRun(ctx, run.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, StatusFailed, stored.Status)
+		assert.Contains(t, stored.Logs[StageRun], "cancelled")
+		assert.Equal(t, 0, executor.Stats().Queued)
+	})
+
+	t.Run("running run", func(t *testing.T) {
+		runCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go executor.Start(runCtx)
+
+		run, err := executor.TriggerPipeline(ctx, pipeline, "main")
+		assert.NoError(t, err)
+		assert.Eventually(t, func() bool {
+			stored, err := store.GetPipelineRun(ctx, run.ID)
+			return err == nil && stored.Status == StatusRunning
+		}, 5*time.Second, 10*time.Millisecond)
+
+		assert.NoError(t, executor.CancelRun(ctx, run.ID))
+		assert.Eventually(t, func() bool {
+			stored, err := store.GetPipelineRun(ctx, run.ID)
+			return err == nil && stored.Finished()
+		}, 5*time.Second, 10*time.Millisecond)
+
+		stored, err := store.GetPipelineRun(ctx, run.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, StatusFailed, stored.Status)
+		assert.Equal(t, StatusFailed, stored.StageStatus(StageRun))
+		assert.Equal(t, StatusPending, stored.StageStatus(StageBuild))
+		assert.Contains(t, stored.Logs[StageRun], "cancelled")
+	})
+
+	t.Run("unknown run", func(t *testing.T) {
+		assert.ErrorIs(t, executor.CancelRun(ctx, "unknown"), ErrNotFound)
+	})
+}
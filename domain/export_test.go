@@ -0,0 +1,10 @@
+package domain
+
+// newTestStore returns an empty store for the tests. The tests of this package can't import the store
+// package, so its memory store is set by the external tests, see store_test.go.
+var newTestStore func() Store
+
+// SetTestStore sets the store the tests of the package are running against.
+func SetTestStore(newStore func() Store) {
+	newTestStore = newStore
+}
@@ -0,0 +1,141 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+const (
+	// SeverityError is the severity of problems making a pipeline invalid
+	SeverityError = "error"
+	// SeverityWarning is the severity of problems of valid pipelines which are probably not intended
+	SeverityWarning = "warning"
+)
+
+// Problem is a problem of a pipeline found by Lint.
+type Problem struct {
+	Severity string
+	// Stage is the name of the stage with the problem, empty for problems of the pipeline itself
+	Stage string
+	// Field is the name of the field with the problem, as used in the API
+	Field   string
+	Message string
+}
+
+// Lint checks the pipeline and returns all of its problems, not only the first one like the validation
+// of the stages. The pipeline is valid if none of the problems has SeverityError.
+func (p *Pipeline) Lint() []Problem {
+	var problems []Problem
+	if p.Name == "" {
+		problems = append(problems, Problem{Severity: SeverityWarning, Field: "name", Message: "pipeline has no name"})
+	}
+	if p.Repository == "" {
+		problems = append(problems, Problem{Severity: SeverityWarning, Field: "repository", Message: "pipeline has no repository"})
+	}
+
+	for _, t := range StageTypes() {
+		stage, ok := p.Stages[t.Name]
+		if !ok || stage == nil {
+			if !t.Optional {
+				problems = append(problems, Problem{Severity: SeverityError, Stage: t.Name, Message: fmt.Sprintf("%s stage is missing", t.Name)})
+			}
+			continue
+		}
+		problems = append(problems, stageProblems(t.Name, stage.Validate())...)
+		if t.FailsRun && stage.ContinueOnError() {
+			problems = append(problems, Problem{
+				Severity: SeverityWarning, Stage: t.Name, Field: "continue_on_error",
+				Message: fmt.Sprintf("continue on error has no effect on the %s stage, a failed %s stage always fails the run", t.Name, t.Name),
+			})
+		}
+	}
+	for _, name := range sortedKeys(p.Stages) {
+		if _, ok := LookupStageType(name); !ok {
+			problems = append(problems, Problem{Severity: SeverityError, Stage: name, Message: fmt.Sprintf("unknown stage type %q", name)})
+		}
+	}
+
+	// a failed build would not stop the deployment of the previous image
+	if build, ok := p.Stages[StageBuild]; ok && build != nil && build.ContinueOnError() {
+		problems = append(problems, Problem{
+			Severity: SeverityWarning, Stage: StageBuild, Field: "continue_on_error",
+			Message: "deploy stage runs even if the build failed, deploying an image which has not been built",
+		})
+	}
+	return problems
+}
+
+// Validate returns the errors found by Lint joined, or nil if the pipeline is valid.
+func (p *Pipeline) Validate() error {
+	var errs []error
+	for _, problem := range p.Lint() {
+		if problem.Severity == SeverityError {
+			errs = append(errs, &ValidationError{Stage: problem.Stage, Field: problem.Field, Message: problem.Message})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ErrorProblems returns the problems of validation errors, which may be joined
+func ErrorProblems(err error) []Problem {
+	return stageProblems("", err)
+}
+
+// stageProblems returns the problems of the validation error of a stage, which may join multiple errors
+func stageProblems(stage string, err error) []Problem {
+	if err == nil {
+		return nil
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var problems []Problem
+		for _, err := range joined.Unwrap() {
+			problems = append(problems, stageProblems(stage, err)...)
+		}
+		return problems
+	}
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return []Problem{{Severity: SeverityError, Stage: validationErr.Stage, Field: validationErr.Field, Message: validationErr.Message}}
+	}
+	return []Problem{{Severity: SeverityError, Stage: stage, Message: err.Error()}}
+}
+
+// PlanStep is a step of the execution plan of a pipeline.
+type PlanStep struct {
+	Stage       string
+	Description string
+	// ContinueOnError is true if the run continues with the next step if this step fails
+	ContinueOnError bool
+}
+
+// Plan returns the steps a run of the pipeline is executing, in the order they are executed.
+func (p *Pipeline) Plan() []PlanStep {
+	var steps []PlanStep
+	for _, t := range StageTypes() {
+		stage, ok := p.Stages[t.Name]
+		if !ok || stage == nil {
+			continue
+		}
+		description := t.Name
+		if t.Describe != nil {
+			description = t.Describe(stage)
+		}
+		steps = append(steps, PlanStep{
+			Stage:           t.Name,
+			Description:     description,
+			ContinueOnError: !t.FailsRun && stage.ContinueOnError(),
+		})
+	}
+	return steps
+}
+
+// sortedKeys returns the keys of a map in ascending order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,55 @@
+package domain
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPipeline_Lint(t *testing.T) {
+	p := NewPipeline("github.com/test/repo")
+	p.Name = "lint"
+	p.Stages[StageRun] = NewRunStage(StageRun, "make test", false)
+	p.Stages[StageBuild] = NewBuildStage(StageBuild, "/Dockerfile", false)
+	p.Stages[StageDeploy] = NewDeployStage(StageDeploy, "", "", true)
+
+	assert.Equal(t, []Problem{
+		{Severity: SeverityError, Stage: StageBuild, Field: "dockerfile_path", Message: "dockerfile path must be relative to the repository"},
+		{Severity: SeverityError, Stage: StageDeploy, Field: "cluster_name", Message: "cluster name is required for deploy stage"},
+		{Severity: SeverityError, Stage: StageDeploy, Field: "manifest_path", Message: "manifest path is required for deploy stage"},
+		{Severity: SeverityWarning, Stage: StageDeploy, Field: "continue_on_error", Message: "continue on error has no effect on the deploy stage, a failed deploy stage always fails the run"},
+	}, p.Lint())
+
+	err := p.Validate()
+	assert.True(t, errors.Is(err, ErrValidation))
+	var validationErr *ValidationError
+	assert.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, "dockerfile_path", validationErr.Field)
+
+	// the deploy stage never continues on errors
+	assert.Equal(t, []PlanStep{
+		{Stage: StageRun, Description: `run "make test"`},
+		{Stage: StageBuild, Description: "build the image of /Dockerfile"},
+		{Stage: StageDeploy, Description: "deploy  to cluster "},
+	}, p.Plan())
+
+	p.Stages[StageBuild] = NewBuildStage(StageBuild, "docker/Dockerfile", false)
+	p.Stages[StageDeploy] = NewDeployStage(StageDeploy, "prod", "k8s/", false)
+	assert.Empty(t, p.Lint())
+	assert.NoError(t, p.Validate())
+}
+
+func TestRepositoryPath(t *testing.T) {
+	for path, want := range map[string]bool{
+		"Dockerfile":       true,
+		"k8s/":             true,
+		"./k8s/../deploy":  true,
+		"/etc/k8s":         false,
+		"..":               false,
+		"../k8s":           false,
+		"k8s/../../deploy": false,
+	} {
+		assert.Equal(t, want, repositoryPath(path), path)
+	}
+}
@@ -0,0 +1,35 @@
+package domain
+
+import (
+	"context"
+	"log/slog"
+)
+
+type loggerContextKey struct{}
+
+type requestIDContextKey struct{}
+
+// ContextWithLogger returns a copy of the context carrying the logger.
+func ContextWithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKey{}, logger)
+}
+
+// LoggerFromContext returns the logger of the context or the default logger if there is none.
+func LoggerFromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerContextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// ContextWithRequestID returns a copy of the context carrying the ID of the API request
+// which is handled with the context.
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey{}, requestID)
+}
+
+// RequestIDFromContext returns the request ID of the context or an empty string if there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDContextKey{}).(string)
+	return requestID
+}
@@ -0,0 +1,27 @@
+package domain
+
+import "time"
+
+// Metrics is recording the outcome and duration of pipeline runs and their stages.
+type Metrics interface {
+	// RunFinished is called when a worker is done with a pipeline run
+	RunFinished(pipelineID, status string, duration time.Duration)
+	// StageFinished is called when a stage of a pipeline run has been executed
+	StageFinished(pipelineID, stage, status string, duration time.Duration)
+}
+
+// noopMetrics is discarding all metrics
+type noopMetrics struct{}
+
+func (noopMetrics) RunFinished(string, string, time.Duration)           {}
+func (noopMetrics) StageFinished(string, string, string, time.Duration) {}
+
+// ExecutorStats is a snapshot of the utilisation of an executor.
+type ExecutorStats struct {
+	Workers     int
+	BusyWorkers int
+	// Queued is the number of runs waiting for a worker
+	Queued int
+	// QueuedPerPipeline is the number of queued runs by pipeline ID
+	QueuedPerPipeline map[string]int
+}
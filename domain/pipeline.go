@@ -1,7 +1,11 @@
 package domain
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
 	"errors"
+	"maps"
 
 	"github.com/google/uuid"
 )
@@ -10,6 +14,13 @@ var (
 	ErrQueueEmpty    = errors.New("queue is empty")
 	ErrNotFound      = errors.New("not found")
 	ErrAlreadyExists = errors.New("already exists")
+	ErrValidation    = errors.New("validation failed")
+	// ErrQueueFull is returned if no more runs can be queued for execution at all
+	ErrQueueFull = errors.New("queue is full")
+	// ErrPipelineQueueFull is returned if no more runs can be queued for a single pipeline
+	ErrPipelineQueueFull = errors.New("pipeline has reached its maximum queued runs")
+	// ErrRunCancelled is the cause of the cancellation of the context of cancelled runs
+	ErrRunCancelled = errors.New("pipeline run cancelled")
 )
 
 type Pipeline struct {
@@ -17,6 +28,9 @@ type Pipeline struct {
 	Name       string
 	Repository string
 	Stages     map[string]Stage
+	// BadgeToken allows reading the status badge of the pipeline without API authentication.
+	// It is empty if the badge is not public.
+	BadgeToken string
 }
 
 func NewPipeline(repository string) *Pipeline {
@@ -30,3 +44,34 @@ func NewPipeline(repository string) *Pipeline {
 		},
 	}
 }
+
+// Clone returns a copy of the pipeline which can be modified independently. The stages are shared,
+// they are replaced when a pipeline is changed, never modified.
+func (p *Pipeline) Clone() *Pipeline {
+	clone := *p
+	clone.Stages = maps.Clone(p.Stages)
+	return &clone
+}
+
+// SetPublicBadge makes the status badge of the pipeline readable with a badge token, or private again.
+// A new token is generated when the badge becomes public, an existing token is kept.
+func (p *Pipeline) SetPublicBadge(public bool) error {
+	if !public {
+		p.BadgeToken = ""
+		return nil
+	}
+	if p.BadgeToken != "" {
+		return nil
+	}
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return err
+	}
+	p.BadgeToken = hex.EncodeToString(token)
+	return nil
+}
+
+// BadgeTokenMatches returns true if the badge of the pipeline is public and token is its badge token
+func (p *Pipeline) BadgeTokenMatches(token string) bool {
+	return p.BadgeToken != "" && subtle.ConstantTimeCompare([]byte(p.BadgeToken), []byte(token)) == 1
+}
@@ -0,0 +1,30 @@
+package domain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipeline_SetPublicBadge(t *testing.T) {
+	p := NewPipeline("github.com/test/repo")
+	assert.False(t, p.BadgeTokenMatches(""))
+
+	require.NoError(t, p.SetPublicBadge(true))
+	token := p.BadgeToken
+	assert.Len(t, token, 32)
+	assert.True(t, p.BadgeTokenMatches(token))
+	assert.False(t, p.BadgeTokenMatches("wrong"))
+
+	// the token is kept while the badge stays public
+	require.NoError(t, p.SetPublicBadge(true))
+	assert.Equal(t, token, p.BadgeToken)
+
+	require.NoError(t, p.SetPublicBadge(false))
+	assert.Empty(t, p.BadgeToken)
+	assert.False(t, p.BadgeTokenMatches(token))
+
+	require.NoError(t, p.SetPublicBadge(true))
+	assert.NotEqual(t, token, p.BadgeToken)
+}
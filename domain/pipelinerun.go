@@ -1,6 +1,7 @@
 package domain
 
 import (
+	"maps"
 	"time"
 
 	"github.com/google/uuid"
@@ -18,29 +19,77 @@ type PipelineRun struct {
 	ID         string
 	PipelineID string
 	// GitRef is the git reference (branch) that is used for this run
-	GitRef       string
-	RunStatus    string
-	BuildStatus  string
-	DeployStatus string
-	Status       string
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
+	GitRef string
+	// StageStatuses is a map of the names of the stages of the pipeline to their status, see StageStatus
+	StageStatuses map[string]string
+	Status        string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	// RequestID is the ID of the API request which triggered the run, if any
+	RequestID string
+	// TraceParent is the W3C trace context of the span which triggered the run, if it has been traced
+	TraceParent string
 	// Logs is a map of stage names to logs
 	Logs map[string]string
+	// StageTimings is a map of the names of started stages to their start and end time
+	StageTimings map[string]StageTiming
+}
+
+// StageTiming is when a stage of a run has been started and finished. FinishedAt is zero while the stage is running.
+type StageTiming struct {
+	StartedAt  time.Time
+	FinishedAt time.Time
 }
 
 func NewPipelineRun(pipelineID, gitRef string) *PipelineRun {
 	now := time.Now()
 	return &PipelineRun{
-		ID:           uuid.New().String(),
-		PipelineID:   pipelineID,
-		GitRef:       gitRef,
-		RunStatus:    StatusPending,
-		BuildStatus:  StatusPending,
-		DeployStatus: StatusPending,
-		Status:       StatusPending,
-		CreatedAt:    now,
-		UpdatedAt:    now,
-		Logs:         make(map[string]string),
+		ID:            uuid.New().String(),
+		PipelineID:    pipelineID,
+		GitRef:        gitRef,
+		StageStatuses: make(map[string]string),
+		Status:        StatusPending,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		Logs:          make(map[string]string),
+		StageTimings:  make(map[string]StageTiming),
+	}
+}
+
+// Clone returns a deep copy of the run which can be modified independently.
+func (r *PipelineRun) Clone() *PipelineRun {
+	clone := *r
+	clone.StageStatuses = maps.Clone(r.StageStatuses)
+	clone.Logs = maps.Clone(r.Logs)
+	clone.StageTimings = maps.Clone(r.StageTimings)
+	return &clone
+}
+
+// StageStatus returns the status of a stage of the run, stages without status are pending
+func (r *PipelineRun) StageStatus(stage string) string {
+	if status, ok := r.StageStatuses[stage]; ok {
+		return status
 	}
+	return StatusPending
+}
+
+// setStageStatus records the status of a stage
+func (r *PipelineRun) setStageStatus(stage, status string) {
+	if r.StageStatuses == nil {
+		r.StageStatuses = make(map[string]string)
+	}
+	r.StageStatuses[stage] = status
+}
+
+// setStageTiming records the timing of a stage, runs created before timings were recorded have no map yet
+func (r *PipelineRun) setStageTiming(stage string, timing StageTiming) {
+	if r.StageTimings == nil {
+		r.StageTimings = make(map[string]StageTiming)
+	}
+	r.StageTimings[stage] = timing
+}
+
+// Finished returns true if the pipeline run reached a terminal state.
+func (r *PipelineRun) Finished() bool {
+	return r.Status == StatusSuccess || r.Status == StatusFailed
 }
@@ -4,6 +4,7 @@ import (
 	"container/list"
 	"fmt"
 	"sync"
+	"time"
 )
 
 // queue implements a FIFO queue for pipeline runs which ensures we do not queue
@@ -34,12 +35,12 @@ func (q *queue) Enqueue(item *PipelineRun) error {
 
 	// Check if the overall queue is full
 	if q.queue.Len() >= q.queueSize {
-		return fmt.Errorf("queue is full - can not enqueue more than %d runs, consider using more workers", q.queueSize)
+		return fmt.Errorf("%w - can not enqueue more than %d runs, consider using more workers", ErrQueueFull, q.queueSize)
 	}
 
 	// Check if the pipeline has exceeded its maximum queued runs
 	if q.pipelineCounts[item.PipelineID] >= q.maxQueuedPerPipeline {
-		return fmt.Errorf("pipeline %s has reached its maximum queued runs - can not enqueue more than %d runs per pipeline", item.PipelineID, q.maxQueuedPerPipeline)
+		return fmt.Errorf("%w - can not enqueue more than %d runs for pipeline %s", ErrPipelineQueueFull, q.maxQueuedPerPipeline, item.PipelineID)
 	}
 
 	// Add item to the queue
@@ -69,3 +70,88 @@ func (q *queue) Dequeue() (*PipelineRun, error) {
 
 	return item, nil
 }
+
+// Remove removes the run with the given ID from the queue. It returns nil if the run is not queued.
+func (q *queue) Remove(id string) *PipelineRun {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for e := q.queue.Front(); e != nil; e = e.Next() {
+		item := e.Value.(*PipelineRun)
+		if item.ID != id {
+			continue
+		}
+		q.queue.Remove(e)
+		q.pipelineCounts[item.PipelineID]--
+		if q.pipelineCounts[item.PipelineID] == 0 {
+			delete(q.pipelineCounts, item.PipelineID)
+		}
+		return item
+	}
+	return nil
+}
+
+// Len returns the number of queued runs.
+func (q *queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.queue.Len()
+}
+
+// PipelineCounts returns the number of queued runs per pipeline.
+func (q *queue) PipelineCounts() map[string]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	counts := make(map[string]int, len(q.pipelineCounts))
+	for id, n := range q.pipelineCounts {
+		counts[id] = n
+	}
+	return counts
+}
+
+// QueuedRun is a run waiting in the queue.
+type QueuedRun struct {
+	RunID      string
+	PipelineID string
+	GitRef     string
+	// QueuedAt is when the run has been created and enqueued
+	QueuedAt time.Time
+}
+
+// QueueSnapshot is the content of the queue at a point in time.
+type QueueSnapshot struct {
+	// Runs are the queued runs in the order they will be executed
+	Runs []QueuedRun
+	// PipelineCounts is the number of queued runs per pipeline ID
+	PipelineCounts       map[string]int
+	QueueSize            int
+	MaxQueuedPerPipeline int
+}
+
+// Snapshot returns a consistent copy of the queued runs and counts.
+func (q *queue) Snapshot() QueueSnapshot {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	snapshot := QueueSnapshot{
+		Runs:                 make([]QueuedRun, 0, q.queue.Len()),
+		PipelineCounts:       make(map[string]int, len(q.pipelineCounts)),
+		QueueSize:            q.queueSize,
+		MaxQueuedPerPipeline: q.maxQueuedPerPipeline,
+	}
+	for e := q.queue.Front(); e != nil; e = e.Next() {
+		run := e.Value.(*PipelineRun)
+		snapshot.Runs = append(snapshot.Runs, QueuedRun{
+			RunID:      run.ID,
+			PipelineID: run.PipelineID,
+			GitRef:     run.GitRef,
+			QueuedAt:   run.CreatedAt,
+		})
+	}
+	for id, n := range q.pipelineCounts {
+		snapshot.PipelineCounts[id] = n
+	}
+	return snapshot
+}
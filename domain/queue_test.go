@@ -1,6 +1,7 @@
 package domain
 
 import (
+	"errors"
 	"testing"
 )
 
@@ -41,8 +42,8 @@ func TestQueue_EnqueueDequeue(t *testing.T) {
 
 		// Try to exceed capacity
 		err := q.Enqueue(run3)
-		if err == nil {
-			t.Error("expected error when exceeding queue capacity")
+		if !errors.Is(err, ErrQueueFull) {
+			t.Errorf("expected ErrQueueFull when exceeding queue capacity, got %v", err)
 		}
 	})
 
@@ -63,8 +64,8 @@ func TestQueue_EnqueueDequeue(t *testing.T) {
 
 		// Try to exceed per-pipeline limit
 		err := q.Enqueue(run3)
-		if err == nil {
-			t.Error("expected error when exceeding per-pipeline limit")
+		if !errors.Is(err, ErrPipelineQueueFull) {
+			t.Errorf("expected ErrPipelineQueueFull when exceeding per-pipeline limit, got %v", err)
 		}
 	})
 
@@ -103,3 +104,64 @@ func TestQueue_EnqueueDequeue(t *testing.T) {
 		}
 	})
 }
+
+func TestQueue_Snapshot(t *testing.T) {
+	q := newQueue(5, 2)
+	run1 := NewPipelineRun("pipeline1", "main")
+	run2 := NewPipelineRun("pipeline2", "dev")
+	run3 := NewPipelineRun("pipeline1", "main")
+	for _, run := range []*PipelineRun{run1, run2, run3} {
+		if err := q.Enqueue(run); err != nil {
+			t.Fatalf("unexpected error on enqueue: %v", err)
+		}
+	}
+	if _, err := q.Dequeue(); err != nil {
+		t.Fatalf("unexpected error on dequeue: %v", err)
+	}
+
+	snapshot := q.Snapshot()
+	if snapshot.QueueSize != 5 || snapshot.MaxQueuedPerPipeline != 2 {
+		t.Errorf("unexpected limits: %d, %d", snapshot.QueueSize, snapshot.MaxQueuedPerPipeline)
+	}
+	if len(snapshot.Runs) != 2 || snapshot.Runs[0].RunID != run2.ID || snapshot.Runs[1].RunID != run3.ID {
+		t.Fatalf("expected runs %s and %s in order, got %+v", run2.ID, run3.ID, snapshot.Runs)
+	}
+	if snapshot.Runs[0].GitRef != "dev" || !snapshot.Runs[0].QueuedAt.Equal(run2.CreatedAt) {
+		t.Errorf("unexpected queued run: %+v", snapshot.Runs[0])
+	}
+	if snapshot.PipelineCounts["pipeline1"] != 1 || snapshot.PipelineCounts["pipeline2"] != 1 {
+		t.Errorf("unexpected pipeline counts: %v", snapshot.PipelineCounts)
+	}
+
+	// the snapshot is a copy
+	snapshot.PipelineCounts["pipeline1"] = 10
+	if q.PipelineCounts()["pipeline1"] != 1 {
+		t.Error("snapshot is sharing the counts of the queue")
+	}
+}
+
+func TestQueue_Remove(t *testing.T) {
+	q := newQueue(5, 2)
+	run1 := NewPipelineRun("pipeline1", "main")
+	run2 := NewPipelineRun("pipeline1", "dev")
+	for _, run := range []*PipelineRun{run1, run2} {
+		if err := q.Enqueue(run); err != nil {
+			t.Fatalf("unexpected error on enqueue: %v", err)
+		}
+	}
+
+	if removed := q.Remove(run1.ID); removed != run1 {
+		t.Fatalf("expected run %s to be removed, got %v", run1.ID, removed)
+	}
+	if removed := q.Remove(run1.ID); removed != nil {
+		t.Errorf("expected removed run not to be found, got %v", removed)
+	}
+	if q.Len() != 1 || q.PipelineCounts()["pipeline1"] != 1 {
+		t.Errorf("unexpected queue after remove: len %d, counts %v", q.Len(), q.PipelineCounts())
+	}
+
+	q.Remove(run2.ID)
+	if _, exists := q.PipelineCounts()["pipeline1"]; exists {
+		t.Error("expected pipeline count to be cleaned up")
+	}
+}
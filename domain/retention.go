@@ -0,0 +1,117 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+// RetentionPolicy defines which finished pipeline runs are kept in the store.
+// Pending and running pipeline runs are never removed.
+type RetentionPolicy struct {
+	// KeepLast is the number of finished runs to keep per pipeline - 0 means no limit.
+	KeepLast int
+	// MaxAge is the maximum age of a finished run since its last update - 0 means no limit.
+	MaxAge time.Duration
+	// KeepLastSuccessful always keeps the last successful run of a pipeline.
+	KeepLastSuccessful bool
+}
+
+// Janitor is periodically removing pipeline runs according to a RetentionPolicy.
+// Finished runs of pipelines which have been deleted are always removed.
+type Janitor struct {
+	store    Store
+	policy   RetentionPolicy
+	interval time.Duration
+	now      func() time.Time
+}
+
+func NewJanitor(store Store, policy RetentionPolicy, interval time.Duration) *Janitor {
+	return &Janitor{
+		store:    store,
+		policy:   policy,
+		interval: interval,
+		now:      time.Now,
+	}
+}
+
+// Start is running a collection every interval and will block until the context is cancelled.
+// It logs with the logger of the context.
+func (j *Janitor) Start(ctx context.Context) {
+	logger := LoggerFromContext(ctx).With("component", "janitor")
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := j.Collect(ctx)
+			if err != nil {
+				logger.Error("failed to collect pipeline runs", "error", err)
+			}
+			if deleted > 0 {
+				logger.Info("deleted pipeline runs", "count", deleted)
+			}
+		}
+	}
+}
+
+// Collect is doing a single pass over all pipeline runs and deletes the ones which are
+// not retained by the policy. It returns the number of deleted runs.
+func (j *Janitor) Collect(ctx context.Context) (int, error) {
+	pipelines, err := j.store.ListPipelines(ctx, ListOptions{})
+	if err != nil {
+		return 0, err
+	}
+	exists := make(map[string]bool, len(pipelines))
+	for _, p := range pipelines {
+		exists[p.ID] = true
+	}
+
+	runs, err := j.store.ListPipelineRuns(ctx, ListOptions{})
+	if err != nil {
+		return 0, err
+	}
+
+	// runs are in creation order, so we walk backwards to see the newest runs first
+	kept := make(map[string]int)
+	seenSuccess := make(map[string]bool)
+	deleted := 0
+	for i := len(runs) - 1; i >= 0; i-- {
+		run := runs[i]
+		if !run.Finished() {
+			continue
+		}
+
+		if exists[run.PipelineID] && j.retain(run, kept[run.PipelineID], seenSuccess[run.PipelineID]) {
+			kept[run.PipelineID]++
+		} else {
+			if err := j.store.DeletePipelineRun(ctx, run.ID); err != nil {
+				return deleted, err
+			}
+			deleted++
+		}
+
+		if run.Status == StatusSuccess {
+			seenSuccess[run.PipelineID] = true
+		}
+	}
+
+	return deleted, nil
+}
+
+// retain decides whether a finished run is kept, given the number of newer runs of the
+// same pipeline which have been kept and whether a newer successful run exists.
+func (j *Janitor) retain(run *PipelineRun, kept int, seenSuccess bool) bool {
+	if j.policy.KeepLastSuccessful && run.Status == StatusSuccess && !seenSuccess {
+		return true
+	}
+	if j.policy.KeepLast > 0 && kept >= j.policy.KeepLast {
+		return false
+	}
+	if j.policy.MaxAge > 0 && j.now().Sub(run.UpdatedAt) > j.policy.MaxAge {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,88 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJanitor_Collect(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// runs for pipeline "p1", from oldest to newest
+	statuses := []string{StatusSuccess, StatusFailed, StatusSuccess, StatusFailed, StatusFailed, StatusRunning, StatusPending}
+
+	tests := []struct {
+		name   string
+		policy RetentionPolicy
+		// wantRuns are the indexes of the runs in statuses which are expected to be kept
+		wantRuns []int
+	}{
+		{name: "no policy", policy: RetentionPolicy{}, wantRuns: []int{0, 1, 2, 3, 4, 5, 6}},
+		{name: "keep last", policy: RetentionPolicy{KeepLast: 2}, wantRuns: []int{3, 4, 5, 6}},
+		{name: "keep last and last successful", policy: RetentionPolicy{KeepLast: 2, KeepLastSuccessful: true}, wantRuns: []int{2, 3, 4, 5, 6}},
+		{name: "max age", policy: RetentionPolicy{MaxAge: 270 * time.Minute}, wantRuns: []int{3, 4, 5, 6}},
+		{name: "max age and last successful", policy: RetentionPolicy{MaxAge: 210 * time.Minute, KeepLastSuccessful: true}, wantRuns: []int{2, 4, 5, 6}},
+		{name: "keep nothing", policy: RetentionPolicy{MaxAge: time.Nanosecond}, wantRuns: []int{5, 6}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := newTestStore()
+			require.NoError(t, store.CreatePipeline(ctx, &Pipeline{ID: "p1"}))
+
+			ids := make([]string, 0, len(statuses))
+			for i, status := range statuses {
+				run := &PipelineRun{
+					ID:         fmt.Sprintf("run-%d", i),
+					PipelineID: "p1",
+					Status:     status,
+					UpdatedAt:  now.Add(-time.Duration(len(statuses)-i) * time.Hour),
+				}
+				require.NoError(t, store.CreatePipelineRun(ctx, run))
+				ids = append(ids, run.ID)
+			}
+
+			janitor := NewJanitor(store, tt.policy, time.Minute)
+			janitor.now = func() time.Time { return now }
+
+			deleted, err := janitor.Collect(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, len(statuses)-len(tt.wantRuns), deleted)
+
+			runs, err := store.ListPipelineRuns(ctx, ListOptions{})
+			require.NoError(t, err)
+			gotIDs := make([]string, 0, len(runs))
+			for _, run := range runs {
+				gotIDs = append(gotIDs, run.ID)
+			}
+			wantIDs := make([]string, 0, len(tt.wantRuns))
+			for _, i := range tt.wantRuns {
+				wantIDs = append(wantIDs, ids[i])
+			}
+			assert.Equal(t, wantIDs, gotIDs)
+		})
+	}
+
+	t.Run("orphaned runs", func(t *testing.T) {
+		ctx := context.Background()
+		store := newTestStore()
+		require.NoError(t, store.CreatePipelineRun(ctx, &PipelineRun{ID: "finished", PipelineID: "deleted", Status: StatusSuccess}))
+		require.NoError(t, store.CreatePipelineRun(ctx, &PipelineRun{ID: "running", PipelineID: "deleted", Status: StatusRunning}))
+
+		janitor := NewJanitor(store, RetentionPolicy{KeepLastSuccessful: true}, time.Minute)
+		deleted, err := janitor.Collect(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, deleted)
+
+		_, err = store.GetPipelineRun(ctx, "finished")
+		assert.ErrorIs(t, err, ErrNotFound)
+		_, err = store.GetPipelineRun(ctx, "running")
+		assert.NoError(t, err)
+	})
+}
@@ -1,13 +1,116 @@
 package domain
 
-import "fmt"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"path"
+	"strings"
+)
 
+// Names of the built-in stage types, registered in this order
 const (
 	StageRun    = "run"
 	StageBuild  = "build"
 	StageDeploy = "deploy"
 )
 
+// legacyContOnError is the former name of the continue_on_error setting of the built-in stages,
+// which is still accepted when decoding them
+const legacyContOnError = "cont_on_error"
+
+func init() {
+	stringSchema := map[string]interface{}{"type": "string"}
+	boolSchema := map[string]interface{}{"type": "boolean"}
+
+	RegisterStageType(StageType{
+		Name:   StageRun,
+		Schema: builtinSchema(map[string]interface{}{"command": stringSchema, "continue_on_error": boolSchema}),
+		Decode: decodeBuiltin(func() *RunStage { return NewRunStage(StageRun, "", false) }),
+		Execute: simulateStage(func(s Stage) string {
+			return fmt.Sprintf("command: %s", s.(*RunStage).Command)
+		}),
+		Describe: func(s Stage) string {
+			return fmt.Sprintf("run %q", s.(*RunStage).Command)
+		},
+	})
+	RegisterStageType(StageType{
+		Name:   StageBuild,
+		Schema: builtinSchema(map[string]interface{}{"dockerfile_path": stringSchema, "continue_on_error": boolSchema}),
+		Decode: decodeBuiltin(func() *BuildStage { return NewBuildStage(StageBuild, "", false) }),
+		Execute: simulateStage(func(s Stage) string {
+			return fmt.Sprintf("dockerfile path: %s", s.(*BuildStage).DockerfilePath)
+		}),
+		Describe: func(s Stage) string {
+			return fmt.Sprintf("build the image of %s", s.(*BuildStage).DockerfilePath)
+		},
+	})
+	RegisterStageType(StageType{
+		Name: StageDeploy,
+		Schema: builtinSchema(map[string]interface{}{
+			"cluster_name": stringSchema, "manifest_path": stringSchema, "continue_on_error": boolSchema,
+		}),
+		Decode: decodeBuiltin(func() *DeployStage { return NewDeployStage(StageDeploy, "", "", false) }),
+		Execute: simulateStage(func(s Stage) string {
+			return fmt.Sprintf("deploying to cluster name: %s", s.(*DeployStage).ClusterName)
+		}),
+		Describe: func(s Stage) string {
+			deploy := s.(*DeployStage)
+			return fmt.Sprintf("deploy %s to cluster %s", deploy.ManifestPath, deploy.ClusterName)
+		},
+		// a failed deployment can't be ignored
+		FailsRun: true,
+	})
+}
+
+// decodeBuiltin returns the Decode func of a built-in stage type, which accepts cont_on_error
+// instead of continue_on_error as well
+func decodeBuiltin[S Stage](newStage func() S) func(data []byte) (Stage, error) {
+	decode := decodeJSON(newStage)
+	return func(data []byte) (Stage, error) {
+		var settings map[string]json.RawMessage
+		if err := json.Unmarshal(data, &settings); err == nil {
+			if value, ok := settings[legacyContOnError]; ok {
+				delete(settings, legacyContOnError)
+				if _, ok := settings["continue_on_error"]; !ok {
+					settings["continue_on_error"] = value
+				}
+				if data, err = json.Marshal(settings); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return decode(data)
+	}
+}
+
+// builtinSchema returns the schema of the settings of a built-in stage type, with the deprecated cont_on_error
+func builtinSchema(properties map[string]interface{}) map[string]interface{} {
+	schema := objectSchema(properties)
+	withLegacy := maps.Clone(properties)
+	withLegacy[legacyContOnError] = map[string]interface{}{"type": "boolean", "deprecated": true}
+	schema["properties"] = withLegacy
+	return schema
+}
+
+// ValidationError is returned if a stage is not valid.
+type ValidationError struct {
+	Stage string
+	// Field is the name of the invalid field, as used in the API
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// Unwrap makes ValidationErrors match ErrValidation
+func (e *ValidationError) Unwrap() error {
+	return ErrValidation
+}
+
 type Stage interface {
 	Validate() error
 	ContinueOnError() bool
@@ -15,10 +118,10 @@ type Stage interface {
 
 // RunStage is a stage that runs an arbitrary command (lint, test) and implements the Stage interface
 type RunStage struct {
-	Name        string                  `json:"name"`
+	Name        string                  `json:"-"`
 	Command     string                  `json:"command"`
 	Validator   func(s *RunStage) error `json:"-"`
-	ContOnError bool                    `json:"cont_on_error"`
+	ContOnError bool                    `json:"continue_on_error"`
 }
 
 func NewRunStage(name, command string, continueOnError bool) *RunStage {
@@ -36,20 +139,26 @@ func (s *RunStage) ContinueOnError() bool {
 	return s.ContOnError
 }
 
+// repositoryPath returns true if p is a path inside of the repository, i.e. relative and not leaving it with ".."
+func repositoryPath(p string) bool {
+	clean := path.Clean(p)
+	return !path.IsAbs(clean) && clean != ".." && !strings.HasPrefix(clean, "../")
+}
+
 // defaultRunStageValidator is the default validator func for a "run" stage.
 func defaultRunStageValidator(s *RunStage) error {
 	if s.Command == "" {
-		return fmt.Errorf("command is required for run stage")
+		return &ValidationError{Stage: StageRun, Field: "command", Message: "command is required for run stage"}
 	}
 	return nil
 }
 
 // BuildStage is a stage that builds a docker image from a given Dockerfile and implements the Stage interface
 type BuildStage struct {
-	Name           string                    `json:"name"`
+	Name           string                    `json:"-"`
 	DockerfilePath string                    `json:"dockerfile_path"`
 	Validator      func(s *BuildStage) error `json:"-"`
-	ContOnError    bool                      `json:"cont_on_error"`
+	ContOnError    bool                      `json:"continue_on_error"`
 }
 
 func NewBuildStage(name, dockerfilePath string, continueOnError bool) *BuildStage {
@@ -70,18 +179,21 @@ func (s *BuildStage) ContinueOnError() bool {
 // defaultBuildStageValidator is the default validator func for a "build" stage.
 func defaultBuildStageValidator(s *BuildStage) error {
 	if s.DockerfilePath == "" {
-		return fmt.Errorf("dockerfile path is required for build stage")
+		return &ValidationError{Stage: StageBuild, Field: "dockerfile_path", Message: "dockerfile path is required for build stage"}
+	}
+	if !repositoryPath(s.DockerfilePath) {
+		return &ValidationError{Stage: StageBuild, Field: "dockerfile_path", Message: "dockerfile path must be relative to the repository"}
 	}
 	return nil
 }
 
 // DeployStage is a stage that deploys a kubernetes manifest to a given cluster and implements the Stage interface
 type DeployStage struct {
-	Name         string                     `json:"name"`
+	Name         string                     `json:"-"`
 	ClusterName  string                     `json:"cluster_name"`
 	ManifestPath string                     `json:"manifest_path"`
 	Validator    func(s *DeployStage) error `json:"-"`
-	ContOnError  bool                       `json:"cont_on_error"`
+	ContOnError  bool                       `json:"continue_on_error"`
 }
 
 func NewDeployStage(name, clusterName, manifestPath string, continueOnError bool) *DeployStage {
@@ -100,12 +212,17 @@ func (s *DeployStage) ContinueOnError() bool {
 }
 
 // defaultDeployStageValidator is the default validator for a "deploy" stage.
+// All invalid fields are reported, joined with errors.Join.
 func defaultDeployStageValidator(s *DeployStage) error {
+	var errs []error
 	if s.ClusterName == "" {
-		return fmt.Errorf("cluster name is required for deploy stage")
+		errs = append(errs, &ValidationError{Stage: StageDeploy, Field: "cluster_name", Message: "cluster name is required for deploy stage"})
 	}
-	if s.ManifestPath == "" {
-		return fmt.Errorf("manifest path is required for deploy stage")
+	switch {
+	case s.ManifestPath == "":
+		errs = append(errs, &ValidationError{Stage: StageDeploy, Field: "manifest_path", Message: "manifest path is required for deploy stage"})
+	case !repositoryPath(s.ManifestPath):
+		errs = append(errs, &ValidationError{Stage: StageDeploy, Field: "manifest_path", Message: "manifest path must be relative to the repository"})
 	}
-	return nil
+	return errors.Join(errs...)
 }
@@ -1,6 +1,9 @@
 package domain
 
-import "testing"
+import (
+	"errors"
+	"testing"
+)
 
 func TestRunStage_Validate(t *testing.T) {
 	type fields struct {
@@ -29,6 +32,9 @@ func TestRunStage_Validate(t *testing.T) {
 			if err := s.Validate(); (err != nil) != tt.wantErr {
 				t.Errorf("RunStage.Validate() error = %v, wantErr %v", err, tt.wantErr)
 			}
+			if err := s.Validate(); err != nil && !errors.Is(err, ErrValidation) {
+				t.Errorf("RunStage.Validate() error = %v, want ErrValidation", err)
+			}
 		})
 	}
 }
@@ -60,6 +66,9 @@ func TestBuildStage_Validate(t *testing.T) {
 			if err := s.Validate(); (err != nil) != tt.wantErr {
 				t.Errorf("BuildStage.Validate() error = %v, wantErr %v", err, tt.wantErr)
 			}
+			if err := s.Validate(); err != nil && !errors.Is(err, ErrValidation) {
+				t.Errorf("BuildStage.Validate() error = %v, want ErrValidation", err)
+			}
 		})
 	}
 }
@@ -93,6 +102,9 @@ func TestDeployStage_Validate(t *testing.T) {
 			if err := s.Validate(); (err != nil) != tt.wantErr {
 				t.Errorf("DeployStage.Validate() error = %v, wantErr %v", err, tt.wantErr)
 			}
+			if err := s.Validate(); err != nil && !errors.Is(err, ErrValidation) {
+				t.Errorf("DeployStage.Validate() error = %v, want ErrValidation", err)
+			}
 		})
 	}
 }
@@ -0,0 +1,142 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// StageType is a kind of stage pipelines are built of. Stage types are registered with RegisterStageType,
+// usually by an init func of the package implementing them. A pipeline has at most one stage of every type,
+// named like its type, and the stages of a run are executed in the order their types have been registered.
+type StageType struct {
+	// Name is the name of the type and of its stage in pipelines, e.g. "run"
+	Name string
+	// Schema is the JSON schema of the settings of a stage, as they are used in the API
+	Schema map[string]interface{}
+	// Decode returns a stage of its JSON settings, without validating it. Stages are encoded with
+	// json.Marshal, so the settings decoded have to be the JSON fields of the stage.
+	Decode func(data []byte) (Stage, error)
+	// Execute is executing a stage of the type in a pipeline run and returns an error if the stage failed.
+	// It has to stop when ctx is cancelled.
+	Execute func(ctx context.Context, x *StageExecution) error
+	// Describe returns what a stage is doing for the execution plan, e.g. run "make test".
+	// The name of the type is used if it is nil.
+	Describe func(stage Stage) string
+	// Optional stages may be missing in pipelines, all other stages are required
+	Optional bool
+	// FailsRun is true if a failed stage always fails the run, even if it should continue on errors
+	FailsRun bool
+}
+
+// StageExecution is a stage of a pipeline run which is executed by the Execute func of its type.
+type StageExecution struct {
+	Pipeline *Pipeline
+	Run      *PipelineRun
+	// Name is the name of the stage in the pipeline
+	Name  string
+	Stage Stage
+	// FailureRate and Delay are the settings of the executor for simulated stages
+	FailureRate float64
+	Delay       time.Duration
+	// update is storing the changes of the run, so the log can be followed while the stage is executed
+	update func(ctx context.Context)
+}
+
+// Log adds a line to the log of the stage, which is also logged with the logger of the context
+func (x *StageExecution) Log(ctx context.Context, status, message string) {
+	addLog(ctx, x.Run, x.Name, status, message)
+	if x.update != nil {
+		x.update(ctx)
+	}
+}
+
+// stageTypes are the registered stage types in the order of their registration
+var stageTypes struct {
+	mu    sync.RWMutex
+	types []StageType
+}
+
+// RegisterStageType makes a stage type available to pipelines. It panics if the type is
+// incomplete or if a type of the same name has already been registered.
+func RegisterStageType(t StageType) {
+	if t.Name == "" || t.Decode == nil || t.Execute == nil {
+		panic("stage type requires a name, a Decode and an Execute func")
+	}
+	stageTypes.mu.Lock()
+	defer stageTypes.mu.Unlock()
+	for _, registered := range stageTypes.types {
+		if registered.Name == t.Name {
+			panic(fmt.Sprintf("stage type %q registered twice", t.Name))
+		}
+	}
+	stageTypes.types = append(stageTypes.types, t)
+}
+
+// StageTypes returns the registered stage types in the order their stages are executed in.
+func StageTypes() []StageType {
+	stageTypes.mu.RLock()
+	defer stageTypes.mu.RUnlock()
+	return append([]StageType(nil), stageTypes.types...)
+}
+
+// LookupStageType returns the registered stage type of the name.
+func LookupStageType(name string) (StageType, bool) {
+	stageTypes.mu.RLock()
+	defer stageTypes.mu.RUnlock()
+	for _, t := range stageTypes.types {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return StageType{}, false
+}
+
+// DecodeStage decodes the JSON settings of a stage of the named type. A ValidationError is
+// returned for unknown types and settings which can't be decoded.
+func DecodeStage(name string, data []byte) (Stage, error) {
+	t, ok := LookupStageType(name)
+	if !ok {
+		return nil, &ValidationError{Stage: name, Message: fmt.Sprintf("unknown stage type %q", name)}
+	}
+	stage, err := t.Decode(data)
+	if err != nil {
+		return nil, &ValidationError{Stage: name, Message: fmt.Sprintf("invalid %s stage: %v", name, err)}
+	}
+	return stage, nil
+}
+
+// EncodeStage returns the JSON settings of a stage, as decoded by DecodeStage.
+func EncodeStage(stage Stage) (json.RawMessage, error) {
+	return json.Marshal(stage)
+}
+
+// decodeJSON returns a Decode func unmarshalling the settings into a new stage with the default settings
+func decodeJSON[S Stage](newStage func() S) func(data []byte) (Stage, error) {
+	return func(data []byte) (Stage, error) {
+		stage := newStage()
+		if err := json.Unmarshal(data, stage); err != nil {
+			return nil, err
+		}
+		return stage, nil
+	}
+}
+
+// objectSchema returns the JSON schema of stage settings with the JSON schemas of their properties.
+// All properties are required, like the fields of the API types without omitempty.
+func objectSchema(properties map[string]interface{}) map[string]interface{} {
+	required := make([]string, 0, len(properties))
+	for name := range properties {
+		required = append(required, name)
+	}
+	sort.Strings(required)
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
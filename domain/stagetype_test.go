@@ -0,0 +1,177 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const stageNotify = "notify"
+
+// notifyStage is an optional stage type of the tests, registered like stage types of other packages
+type notifyStage struct {
+	Channel     string `json:"channel"`
+	ContOnError bool   `json:"continue_on_error"`
+}
+
+func (s *notifyStage) Validate() error {
+	if s.Channel == "" {
+		return &ValidationError{Stage: stageNotify, Field: "channel", Message: "channel is required"}
+	}
+	return nil
+}
+
+func (s *notifyStage) ContinueOnError() bool {
+	return s.ContOnError
+}
+
+func init() {
+	RegisterStageType(StageType{
+		Name:   stageNotify,
+		Schema: objectSchema(map[string]interface{}{"channel": map[string]interface{}{"type": "string"}}),
+		Decode: decodeJSON(func() *notifyStage { return &notifyStage{} }),
+		Execute: func(ctx context.Context, x *StageExecution) error {
+			channel := x.Stage.(*notifyStage).Channel
+			if channel == "#broken" {
+				return errors.New("channel is broken")
+			}
+			x.Log(ctx, StatusSuccess, "notified "+channel)
+			return nil
+		},
+		Optional: true,
+	})
+}
+
+func TestRegisterStageType(t *testing.T) {
+	var names []string
+	for _, stageType := range StageTypes() {
+		names = append(names, stageType.Name)
+	}
+	assert.Equal(t, []string{StageRun, StageBuild, StageDeploy, stageNotify}, names)
+
+	stageType, ok := LookupStageType(stageNotify)
+	require.True(t, ok)
+	assert.True(t, stageType.Optional)
+	_, ok = LookupStageType("lint")
+	assert.False(t, ok)
+
+	assert.Panics(t, func() { RegisterStageType(stageType) }, "registered twice")
+	assert.Panics(t, func() { RegisterStageType(StageType{Name: "lint"}) }, "incomplete")
+	_, ok = LookupStageType("lint")
+	assert.False(t, ok)
+}
+
+func TestDecodeStage(t *testing.T) {
+	stage, err := DecodeStage(StageRun, []byte(`{"command":"make test","continue_on_error":true}`))
+	require.NoError(t, err)
+	assert.Equal(t, "make test", stage.(*RunStage).Command)
+	assert.True(t, stage.ContinueOnError())
+	// the validators of the built-in stages are set
+	assert.NoError(t, stage.Validate())
+
+	data, err := EncodeStage(stage)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"command":"make test","continue_on_error":true}`, string(data))
+
+	// the former name of continue_on_error is accepted for the built-in stages
+	stage, err = DecodeStage(StageDeploy, []byte(`{"cluster_name":"prod","manifest_path":"k8s/","cont_on_error":true}`))
+	require.NoError(t, err)
+	assert.True(t, stage.ContinueOnError())
+	data, err = EncodeStage(stage)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"cluster_name":"prod","manifest_path":"k8s/","continue_on_error":true}`, string(data))
+	stage, err = DecodeStage(StageRun, []byte(`{"command":"make","cont_on_error":true,"continue_on_error":false}`))
+	require.NoError(t, err)
+	assert.False(t, stage.ContinueOnError())
+
+	stage, err = DecodeStage(stageNotify, []byte(`{"channel":"#builds"}`))
+	require.NoError(t, err)
+	assert.Equal(t, &notifyStage{Channel: "#builds"}, stage)
+
+	var validationErr *ValidationError
+	_, err = DecodeStage("lint", []byte(`{}`))
+	require.ErrorAs(t, err, &validationErr)
+	assert.Equal(t, `unknown stage type "lint"`, validationErr.Message)
+
+	_, err = DecodeStage(stageNotify, []byte(`{"channel":1}`))
+	require.ErrorAs(t, err, &validationErr)
+	assert.Equal(t, stageNotify, validationErr.Stage)
+}
+
+// newNotifyPipeline returns a valid pipeline with all built-in stages and a notify stage
+func newNotifyPipeline(channel string, contOnError bool) *Pipeline {
+	pipeline := NewPipeline("github.com/test/repo")
+	pipeline.Name = "notify"
+	pipeline.Stages[StageRun] = NewRunStage(StageRun, "go test ./...", false)
+	pipeline.Stages[StageBuild] = NewBuildStage(StageBuild, "Dockerfile", false)
+	pipeline.Stages[StageDeploy] = NewDeployStage(StageDeploy, "prod", "k8s/", false)
+	pipeline.Stages[stageNotify] = &notifyStage{Channel: channel, ContOnError: contOnError}
+	return pipeline
+}
+
+func TestStageType_LintAndPlan(t *testing.T) {
+	pipeline := newNotifyPipeline("#builds", true)
+	assert.Empty(t, pipeline.Lint())
+
+	plan := pipeline.Plan()
+	require.Len(t, plan, 4)
+	assert.Equal(t, PlanStep{Stage: stageNotify, Description: stageNotify, ContinueOnError: true}, plan[3])
+
+	pipeline.Stages[stageNotify] = &notifyStage{}
+	assert.Equal(t, []Problem{{Severity: SeverityError, Stage: stageNotify, Field: "channel", Message: "channel is required"}}, pipeline.Lint())
+
+	// optional stages may be missing
+	delete(pipeline.Stages, stageNotify)
+	assert.Empty(t, pipeline.Lint())
+	assert.Len(t, pipeline.Plan(), 3)
+}
+
+func TestStageType_Execute(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := newTestStore()
+	executor := NewExecutor(store, 1, 10, 10, 0.0, time.Millisecond)
+	go executor.Start(ctx)
+
+	tests := []struct {
+		name        string
+		channel     string
+		contOnError bool
+		status      string
+		stageStatus string
+	}{
+		{name: "success", channel: "#builds", status: StatusSuccess, stageStatus: StatusSuccess},
+		{name: "failure", channel: "#broken", status: StatusFailed, stageStatus: StatusFailed},
+		{name: "continue on error", channel: "#broken", contOnError: true, status: StatusSuccess, stageStatus: StatusFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipeline := newNotifyPipeline(tt.channel, tt.contOnError)
+			require.NoError(t, store.CreatePipeline(ctx, pipeline))
+			run, err := executor.TriggerPipeline(ctx, pipeline, "main")
+			require.NoError(t, err)
+			assert.Equal(t, StatusPending, run.StageStatus(stageNotify))
+
+			require.Eventually(t, func() bool {
+				run, err := store.GetPipelineRun(ctx, run.ID)
+				return err == nil && (run.Status == StatusSuccess || run.Status == StatusFailed)
+			}, 2*time.Second, 10*time.Millisecond)
+
+			run, err = store.GetPipelineRun(ctx, run.ID)
+			require.NoError(t, err)
+			assert.Equal(t, tt.status, run.Status)
+			assert.Equal(t, StatusSuccess, run.StageStatus(StageDeploy))
+			assert.Equal(t, tt.stageStatus, run.StageStatus(stageNotify))
+			// the notify stage is executed after the built-in stages
+			assert.False(t, run.StageTimings[stageNotify].StartedAt.Before(run.StageTimings[StageDeploy].FinishedAt))
+			if tt.stageStatus == StatusSuccess {
+				assert.Contains(t, run.Logs[stageNotify], "notified #builds")
+			}
+		})
+	}
+}
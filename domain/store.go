@@ -1,6 +1,44 @@
 package domain
 
-import "context"
+import (
+	"context"
+	"fmt"
+)
+
+// ListOptions is used to page through list results.
+// Stores return items in the order they were created, so paging is stable.
+type ListOptions struct {
+	// Offset is the number of items to skip.
+	Offset int
+	// Limit is the maximum number of items to return - 0 means no limit.
+	Limit int
+}
+
+// Bounds returns the start and end index of the page described by the options
+// for a result set of n items.
+func (o ListOptions) Bounds(n int) (int, int) {
+	start := o.Offset
+	if start < 0 {
+		start = 0
+	}
+	if start > n {
+		start = n
+	}
+	end := n
+	if o.Limit > 0 && start+o.Limit < n {
+		end = start + o.Limit
+	}
+	return start, end
+}
+
+// RunNotFinishedError is returned if a pipeline run can't be deleted as it is not finished yet
+type RunNotFinishedError struct {
+	RunID string
+}
+
+func (e *RunNotFinishedError) Error() string {
+	return fmt.Sprintf("pipeline run %s is not finished yet", e.RunID)
+}
 
 // PipelineStore supports basic CRUD operations for pipelines.
 type PipelineStore interface {
@@ -8,7 +46,11 @@ type PipelineStore interface {
 	GetPipeline(ctx context.Context, id string) (*Pipeline, error)
 	UpdatePipeline(ctx context.Context, pipeline *Pipeline) error
 	DeletePipeline(ctx context.Context, id string) error
-	ListPipelines(ctx context.Context) ([]*Pipeline, error)
+	// DeletePipelineWithRuns deletes a pipeline and all of its runs at once. Nothing is deleted
+	// and a *RunNotFinishedError is returned if a run of the pipeline is not finished.
+	DeletePipelineWithRuns(ctx context.Context, id string) error
+	// ListPipelines returns pipelines in creation order.
+	ListPipelines(ctx context.Context, opts ListOptions) ([]*Pipeline, error)
 }
 
 // PipelineRunStore supports basic CRUD operations for pipeline runs.
@@ -16,11 +58,15 @@ type PipelineRunStore interface {
 	CreatePipelineRun(ctx context.Context, pipelineRun *PipelineRun) error
 	GetPipelineRun(ctx context.Context, id string) (*PipelineRun, error)
 	UpdatePipelineRun(ctx context.Context, run *PipelineRun) error
-	ListPipelineRuns(ctx context.Context) ([]*PipelineRun, error)
+	DeletePipelineRun(ctx context.Context, id string) error
+	// ListPipelineRuns returns pipeline runs in creation order.
+	ListPipelineRuns(ctx context.Context, opts ListOptions) ([]*PipelineRun, error)
 }
 
 // Store is an interface for storing Pipelines and PipelineRuns.
 // For simplicity, we're providing a single interface here.
+// Stores keep copies of the pipelines and runs and return copies, so changes of them are only
+// stored by the update methods, and callers can't affect each other by modifying them.
 type Store interface {
 	PipelineStore
 	PipelineRunStore
@@ -0,0 +1,12 @@
+package domain_test
+
+import (
+	"github.com/hphilipps/stagerunner/domain"
+	"github.com/hphilipps/stagerunner/store"
+)
+
+// the tests of the domain package are running against the memory store, which is covered by the
+// conformance tests of the store package
+func init() {
+	domain.SetTestStore(func() domain.Store { return store.NewMemoryStore() })
+}
@@ -0,0 +1,59 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// tracerName is the instrumentation scope of the spans created by the executor
+const tracerName = "github.com/hphilipps/stagerunner/domain"
+
+// traceContext is encoding span contexts in the W3C trace context format
+var traceContext = propagation.TraceContext{}
+
+// traceParentFromContext returns the W3C traceparent of the span of the context,
+// or an empty string if there is no valid span.
+func traceParentFromContext(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	traceContext.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// contextWithTraceParent returns a copy of the context with the span of the W3C traceparent as remote parent.
+func contextWithTraceParent(ctx context.Context, traceParent string) context.Context {
+	if traceParent == "" {
+		return ctx
+	}
+	return traceContext.Extract(ctx, propagation.MapCarrier{"traceparent": traceParent})
+}
+
+// runAttributes returns the span attributes of a pipeline run
+func runAttributes(pipelineRun *PipelineRun) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("pipeline.id", pipelineRun.PipelineID),
+		attribute.String("run.id", pipelineRun.ID),
+		attribute.String("git.ref", pipelineRun.GitRef),
+	}
+}
+
+// stageAttributes returns the span attributes of a stage, with its settings which are strings
+func stageAttributes(name string, stage Stage) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{attribute.String("stage.type", name)}
+	data, err := EncodeStage(stage)
+	if err != nil {
+		return attrs
+	}
+	var settings map[string]interface{}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return attrs
+	}
+	for _, key := range sortedKeys(settings) {
+		if value, ok := settings[key].(string); ok {
+			attrs = append(attrs, attribute.String("stage."+key, value))
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,77 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/hphilipps/stagerunner/domain"
+)
+
+// ImportResponse is used to construct a response for an import request
+type ImportResponse struct {
+	Pipelines   int `json:"pipelines"`
+	Runs        int `json:"runs"`
+	Skipped     int `json:"skipped"`
+	Overwritten int `json:"overwritten"`
+	Renamed     int `json:"renamed"`
+}
+
+// exportState is a handler streaming all pipelines and pipeline runs as a JSON lines archive
+func (api *API) exportState(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="stagerunner-export.jsonl"`)
+	w.WriteHeader(http.StatusOK)
+
+	// the status code has already been sent, so we can only log errors at this point
+	if err := domain.Export(r.Context(), api.store, w); err != nil {
+		domain.LoggerFromContext(r.Context()).Error("export failed", "error", err)
+	}
+}
+
+// importState is a handler restoring pipelines and pipeline runs from a JSON lines archive.
+// The conflict query parameter selects how existing items are handled and defaults to skip.
+func (api *API) importState(w http.ResponseWriter, r *http.Request) {
+	auditTarget(r, "admin.import", "", "")
+
+	conflict := r.URL.Query().Get("conflict")
+	if conflict == "" {
+		conflict = string(domain.ConflictSkip)
+	}
+	mode, err := domain.ParseConflictMode(conflict)
+	if err != nil {
+		respondWithError(w, r, newError(ErrInvalidRequest, "%s", err))
+		return
+	}
+
+	result, err := domain.Import(r.Context(), api.store, r.Body, mode)
+	if err != nil {
+		var errResp *ErrorResponse
+		if errors.Is(err, domain.ErrInvalidArchive) {
+			errResp = newError(ErrInvalidRequest, "%s", err)
+		} else {
+			errResp = errorFromDomain(err)
+		}
+		// the import stops at the first error, so the client needs to know what has been imported before
+		if result != nil {
+			errResp.withDetail("pipelines", strconv.Itoa(result.Pipelines)).
+				withDetail("runs", strconv.Itoa(result.Runs)).
+				withDetail("skipped", strconv.Itoa(result.Skipped)).
+				withDetail("overwritten", strconv.Itoa(result.Overwritten)).
+				withDetail("renamed", strconv.Itoa(result.Renamed))
+		}
+		respondWithError(w, r, errResp)
+		return
+	}
+
+	resp := ImportResponse{
+		Pipelines:   result.Pipelines,
+		Runs:        result.Runs,
+		Skipped:     result.Skipped,
+		Overwritten: result.Overwritten,
+		Renamed:     result.Renamed,
+	}
+	auditAfter(r, resp)
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
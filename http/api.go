@@ -1,59 +1,365 @@
 package http
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/hphilipps/stagerunner/domain"
+	"github.com/hphilipps/stagerunner/metrics"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type API struct {
-	store    domain.Store
-	executor *domain.Executor
+	store       domain.Store
+	executor    *domain.Executor
+	auditLog    domain.AuditLog
+	idempotency *idempotencyCache
+	logger      *slog.Logger
+	metrics     *metrics.Metrics
+	tracer      trace.Tracer
+	// draining is set once the server is shutting down
+	draining atomic.Bool
+	// streamsClosed is closed by CloseStreams to end the event streams
+	streamsClosed chan struct{}
+	closeStreams  sync.Once
+	// clientIdentities maps the subjects of client certificates to identities
+	clientIdentities map[string]string
+	// ui is serving the web UI, if it is enabled
+	ui http.Handler
 }
 
-func NewAPI(store domain.Store, executor *domain.Executor) *API {
-	return &API{
-		store:    store,
-		executor: executor,
+// APIOption allows for customizing the API
+type APIOption func(*API)
+
+// WithAuditLog enables recording all mutating requests to the given audit log
+func WithAuditLog(auditLog domain.AuditLog) APIOption {
+	return func(api *API) {
+		api.auditLog = auditLog
+	}
+}
+
+// WithLogger sets the logger for access logs and errors. The log lines of a request carry its request ID.
+func WithLogger(logger *slog.Logger) APIOption {
+	return func(api *API) {
+		api.logger = logger
 	}
 }
 
+// WithMetrics enables recording metrics of all requests and serving them at /metrics
+func WithMetrics(m *metrics.Metrics) APIOption {
+	return func(api *API) {
+		api.metrics = m
+	}
+}
+
+// WithUI enables serving the web UI at /ui/. The UI is served without authentication,
+// it is using the API with the token entered by the user.
+func WithUI(ui http.Handler) APIOption {
+	return func(api *API) {
+		api.ui = ui
+	}
+}
+
+// WithTracerProvider sets the tracer provider for the spans of requests. The global tracer provider is used by default.
+func WithTracerProvider(tp trace.TracerProvider) APIOption {
+	return func(api *API) {
+		api.tracer = tp.Tracer(tracerName)
+	}
+}
+
+// WithClientIdentities maps the subjects (e.g. "CN=ci,O=acme") or common names of client certificates
+// to the identities of the callers, which are recorded in the audit log
+func WithClientIdentities(identities map[string]string) APIOption {
+	return func(api *API) {
+		api.clientIdentities = identities
+	}
+}
+
+// WithIdempotencyTTL sets how long responses are replayed for retried requests with the same Idempotency-Key
+func WithIdempotencyTTL(ttl time.Duration) APIOption {
+	return func(api *API) {
+		api.idempotency = newIdempotencyCache(ttl)
+	}
+}
+
+func NewAPI(store domain.Store, executor *domain.Executor, opts ...APIOption) *API {
+	api := &API{
+		store:         store,
+		executor:      executor,
+		idempotency:   newIdempotencyCache(defaultIdempotencyTTL),
+		logger:        slog.Default(),
+		tracer:        otel.GetTracerProvider().Tracer(tracerName),
+		streamsClosed: make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(api)
+	}
+
+	return api
+}
+
+// apiPrefix is the path prefix of the current API version
+const apiPrefix = "/v1"
+
+// uiPrefix is the path prefix of the web UI
+const uiPrefix = "/ui"
+
 // SetupRouter configures all routes and middleware
 func (api *API) SetupRouter() *mux.Router {
 	r := mux.NewRouter()
+	// mux doesn't run middleware for unmatched requests, so they get request IDs, traces, access logs and metrics here
+	r.NotFoundHandler = api.unmatchedHandler(notFoundHandler)
+	r.MethodNotAllowedHandler = api.unmatchedHandler(methodNotAllowedHandler)
 
 	// Middleware
-	r.Use(loggingMiddleware)
+	r.Use(api.requestIDMiddleware)
+	r.Use(api.tracingMiddleware)
+	r.Use(api.loggingMiddleware)
+	r.Use(api.metricsMiddleware)
 	r.Use(rateLimitMiddleware)
-	r.Use(authMiddleware)
-
-	// Pipeline routes
-	r.HandleFunc("/pipelines", api.listPipelines).Methods(http.MethodGet)
-	r.HandleFunc("/pipelines", api.createPipeline).Methods(http.MethodPost)
-	r.HandleFunc("/pipelines/{id}", api.getPipeline).Methods(http.MethodGet)
-	r.HandleFunc("/pipelines/{id}", api.updatePipeline).Methods(http.MethodPut)
-	r.HandleFunc("/pipelines/{id}", api.deletePipeline).Methods(http.MethodDelete)
-	r.HandleFunc("/pipelines/{id}/trigger", api.triggerPipeline).Methods(http.MethodPost)
-	r.HandleFunc("/runs", api.listPipelineRuns).Methods(http.MethodGet)
-	r.HandleFunc("/runs/{run_id}", api.getPipelineRun).Methods(http.MethodGet)
+
+	// unauthenticated routes for monitoring
+	r.HandleFunc("/healthz", api.healthz).Methods(http.MethodGet)
+	r.HandleFunc("/readyz", api.readyz).Methods(http.MethodGet)
+	if api.metrics != nil {
+		r.Handle("/metrics", api.metrics.Handler()).Methods(http.MethodGet)
+	}
+	if api.ui != nil {
+		r.Handle("/", http.RedirectHandler(uiPrefix+"/", http.StatusFound)).Methods(http.MethodGet)
+		r.Handle(uiPrefix, http.RedirectHandler(uiPrefix+"/", http.StatusMovedPermanently)).Methods(http.MethodGet)
+		r.PathPrefix(uiPrefix+"/").Handler(http.StripPrefix(uiPrefix, api.ui)).Methods(http.MethodGet, http.MethodHead)
+	}
+
+	ops := api.operations()
+	spec, err := json.Marshal(openAPIDocument(ops))
+	if err != nil {
+		// the document is generated from static types, so this is a programming error
+		panic(err)
+	}
+
+	// The routes are registered with their full path, as routes of a PathPrefix subrouter
+	// inherit its matcher, which makes mux respond with 404 instead of 405 on method mismatches.
+	v1 := r.NewRoute().Subrouter()
+	v1.HandleFunc(apiPrefix+"/openapi.json", serveOpenAPI(spec)).Methods(http.MethodGet)
+	api.registerOperations(v1, apiPrefix, ops)
+
+	// unversioned routes are kept for clients which don't use the /v1 prefix yet
+	legacy := r.NewRoute().Subrouter()
+	legacy.Use(deprecationMiddleware)
+	api.registerOperations(legacy, "", ops)
 
 	return r
 }
 
-// Helper functions for HTTP responses
-func respondWithError(w http.ResponseWriter, code int, message string) {
-	respondWithJSON(w, code, map[string]string{"error": message})
+// unmatchedHandler is wrapping a handler for unmatched requests with the middleware of matched requests
+func (api *API) unmatchedHandler(h http.HandlerFunc) http.Handler {
+	return api.requestIDMiddleware(api.tracingMiddleware(api.loggingMiddleware(api.metricsMiddleware(h))))
+}
+
+// registerOperations adds authenticated routes for all operations with the given path prefix to the router
+func (api *API) registerOperations(r *mux.Router, prefix string, ops []operation) {
+	private := r.NewRoute().Subrouter()
+	private.Use(api.authMiddleware)
+	private.Use(api.auditMiddleware)
+	readOnly := r.NewRoute().Subrouter()
+	readOnly.Use(api.authMiddleware)
+	public := r.NewRoute().Subrouter()
+
+	for _, op := range ops {
+		handler := op.handler
+		if op.idempotent {
+			handler = api.idempotent(op.id, handler)
+		}
+		router := private
+		switch {
+		case len(op.security) > 0:
+			router = public
+		case op.readOnly:
+			router = readOnly
+		}
+		router.HandleFunc(prefix+op.path, handler).Methods(op.method)
+	}
+}
+
+// operations returns all operations of the API
+func (api *API) operations() []operation {
+	listParams := []parameter{
+		{name: "offset", typ: "integer", description: "Number of items to skip"},
+		{name: "limit", typ: "integer", description: "Maximum number of items to return"},
+	}
+
+	return []operation{
+		// Pipeline routes
+		{
+			id: "listPipelines", method: http.MethodGet, path: "/pipelines", handler: api.listPipelines,
+			summary: "List all pipelines", query: listParams,
+			responses: []response{{status: http.StatusOK, body: []PipelineResponse{}}},
+		},
+		{
+			id: "createPipeline", method: http.MethodPost, path: "/pipelines", handler: api.createPipeline,
+			summary: "Create a pipeline", request: PipelineRequest{},
+			responses: []response{{status: http.StatusCreated, body: CreatePipelineResponse{}}},
+		},
+		{
+			id: "validatePipeline", method: http.MethodPost, path: "/pipelines/validate", handler: api.validatePipeline,
+			summary: "Validate a pipeline without creating it, reporting all problems and the execution plan", request: PipelineRequest{},
+			readOnly:  true,
+			responses: []response{{status: http.StatusOK, body: ValidatePipelineResponse{}}},
+		},
+		{
+			id: "getPipeline", method: http.MethodGet, path: "/pipelines/{id}", handler: api.getPipeline,
+			summary:   "Get a pipeline",
+			responses: []response{{status: http.StatusOK, body: PipelineResponse{}}},
+		},
+		{
+			id: "updatePipeline", method: http.MethodPut, path: "/pipelines/{id}", handler: api.updatePipeline,
+			summary: "Update a pipeline", request: PipelineRequest{},
+			responses: []response{{status: http.StatusOK, body: PipelineResponse{}}},
+		},
+		{
+			id: "deletePipeline", method: http.MethodDelete, path: "/pipelines/{id}", handler: api.deletePipeline,
+			summary:   "Delete a pipeline",
+			query:     []parameter{{name: "cascade", typ: "boolean", description: "Delete the runs of the pipeline as well"}},
+			responses: []response{{status: http.StatusNoContent}},
+		},
+		{
+			id: "triggerPipeline", method: http.MethodPost, path: "/pipelines/{id}/trigger", handler: api.triggerPipeline,
+			summary: "Trigger a pipeline run", request: TriggerPipelineRequest{}, idempotent: true,
+			responses: []response{{status: http.StatusAccepted, body: TriggerPipelineResponse{}}},
+		},
+		{
+			id: "getPipelineBadge", method: http.MethodGet, path: "/pipelines/{id}/badge.svg", handler: api.getPipelineBadge,
+			summary:  "Get a status badge of the latest run of a pipeline, readable with the badge token of pipelines with a public badge",
+			query:    []parameter{{name: "ref", typ: "string", description: "Only consider the runs of this git ref"}},
+			security: []string{"token", "badgeToken"},
+			responses: []response{
+				{status: http.StatusOK, contentType: badgeContentType},
+				{status: http.StatusNotModified},
+			},
+		},
+		{
+			id: "listPipelineRuns", method: http.MethodGet, path: "/runs", handler: api.listPipelineRuns,
+			summary: "List all pipeline runs", query: listParams,
+			responses: []response{{status: http.StatusOK, body: []PipelineRunResponse{}}},
+		},
+		{
+			id: "getPipelineRun", method: http.MethodGet, path: "/runs/{run_id}", handler: api.getPipelineRun,
+			summary: "Get a pipeline run",
+			responses: []response{
+				{status: http.StatusOK, body: PipelineRunResponse{}},
+				{status: http.StatusNotModified},
+			},
+		},
+		{
+			id: "deletePipelineRun", method: http.MethodDelete, path: "/runs/{run_id}", handler: api.deletePipelineRun,
+			summary:   "Delete a finished pipeline run",
+			responses: []response{{status: http.StatusNoContent}},
+		},
+		{
+			id: "cancelPipelineRun", method: http.MethodPost, path: "/runs/{run_id}/cancel", handler: api.cancelPipelineRun,
+			summary:   "Cancel a queued or running pipeline run, which is failing once it has been stopped",
+			responses: []response{{status: http.StatusAccepted}},
+		},
+
+		// Change feed
+		{
+			id: "streamEvents", method: http.MethodGet, path: "/events", handler: api.streamEvents,
+			summary: "Stream store changes as server-sent events",
+			query: []parameter{
+				{name: "type", typ: "string", repeated: true, description: "Event types to watch"},
+				{name: "pipeline_id", typ: "string", description: "Only watch the events of this pipeline"},
+				{name: "after", typ: "string", description: "Resume after the event with this ID"},
+			},
+			responses: []response{{status: http.StatusOK, contentType: "text/event-stream", body: EventResponse{}}},
+		},
+
+		// Admin routes
+		{
+			id: "exportState", method: http.MethodGet, path: "/admin/export", handler: api.exportState,
+			summary:   "Export all pipelines and pipeline runs as a JSON lines archive",
+			responses: []response{{status: http.StatusOK, contentType: "application/x-ndjson"}},
+		},
+		{
+			id: "getQueue", method: http.MethodGet, path: "/admin/queue", handler: api.getQueue,
+			summary:   "List the queued pipeline runs in the order they will be executed",
+			responses: []response{{status: http.StatusOK, body: QueueResponse{}}},
+		},
+		{
+			id: "importState", method: http.MethodPost, path: "/admin/import", handler: api.importState,
+			summary: "Import pipelines and pipeline runs from a JSON lines archive", requestContentType: "application/x-ndjson",
+			query:     []parameter{{name: "conflict", typ: "string", description: "How to handle existing items: skip, overwrite or rename"}},
+			responses: []response{{status: http.StatusOK, body: ImportResponse{}}},
+		},
+
+		// Audit log
+		{
+			id: "listAuditEntries", method: http.MethodGet, path: "/audit", handler: api.listAuditEntries,
+			summary: "Query the audit log of mutating API calls",
+			query: append([]parameter{
+				{name: "actor", typ: "string"},
+				{name: "action", typ: "string"},
+				{name: "target_type", typ: "string"},
+				{name: "target_id", typ: "string"},
+				{name: "since", typ: "string", format: "date-time"},
+				{name: "until", typ: "string", format: "date-time"},
+			}, listParams...),
+			responses: []response{{status: http.StatusOK, body: []AuditEntryResponse{}}},
+		},
+	}
+}
+
+// legacyContextKey is the context key marking requests of the unversioned routes
+const legacyContextKey contextKey = "legacy"
+
+// deprecationMiddleware is a middleware marking the responses of unversioned routes as deprecated
+func deprecationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", fmt.Sprintf("<%s%s>; rel=\"successor-version\"", apiPrefix, r.URL.Path))
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), legacyContextKey, true)))
+	})
+}
+
+// isLegacyRequest returns true for requests of the unversioned routes, which keep the responses they had before
+func isLegacyRequest(r *http.Request) bool {
+	legacy, _ := r.Context().Value(legacyContextKey).(bool)
+	return legacy
 }
 
+// Helper functions for HTTP responses
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	response, err := json.Marshal(payload)
 	if err != nil {
-		log.Println(err)
+		slog.Error("failed to marshal response", "error", err)
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
 	w.Write(response)
 }
+
+// listOptionsFromRequest parses the optional offset and limit query parameters
+func listOptionsFromRequest(r *http.Request) (domain.ListOptions, error) {
+	var opts domain.ListOptions
+	for name, dst := range map[string]*int{"offset": &opts.Offset, "limit": &opts.Limit} {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return opts, newError(ErrInvalidRequest, "invalid %s: %q", name, value)
+		}
+		*dst = n
+	}
+	return opts, nil
+}
@@ -4,16 +4,20 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/hphilipps/stagerunner/domain"
 	"github.com/hphilipps/stagerunner/store"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestApi_Pipeline(t *testing.T) {
@@ -35,19 +39,9 @@ func TestApi_Pipeline(t *testing.T) {
 					Name:       "test-pipeline",
 					Repository: "github.com/test/repo",
 					Stages: Stages{
-						RunStage: RunStage{
-							Command:       "go test ./...",
-							ContinueOnErr: false,
-						},
-						BuildStage: BuildStage{
-							DockerfilePath: "Dockerfile",
-							ContinueOnErr:  false,
-						},
-						DeployStage: DeployStage{
-							ClusterName:   "prod",
-							ManifestPath:  "k8s/",
-							ContinueOnErr: false,
-						},
+						"run_stage":    json.RawMessage(`{"command":"go test ./..."}`),
+						"build_stage":  json.RawMessage(`{"dockerfile_path":"Dockerfile"}`),
+						"deploy_stage": json.RawMessage(`{"cluster_name":"prod","manifest_path":"k8s/"}`),
 					},
 				},
 				wantStatus: http.StatusCreated,
@@ -57,7 +51,7 @@ func TestApi_Pipeline(t *testing.T) {
 				payload: PipelineRequest{
 					Name: "test-pipeline",
 				},
-				wantStatus: http.StatusBadRequest,
+				wantStatus: http.StatusUnprocessableEntity,
 			},
 		}
 
@@ -68,7 +62,7 @@ func TestApi_Pipeline(t *testing.T) {
 				if err != nil {
 					t.Fatalf("failed to marshal request body: %v", err)
 				}
-				req := httptest.NewRequest(http.MethodPost, "/pipelines", bytes.NewBuffer(payload))
+				req := httptest.NewRequest(http.MethodPost, "/v1/pipelines", bytes.NewBuffer(payload))
 				req.Header.Set("Authorization", "test-token")
 				w := httptest.NewRecorder()
 
@@ -91,7 +85,7 @@ func TestApi_Pipeline(t *testing.T) {
 	})
 
 	t.Run("ListPipelines", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodGet, "/pipelines", nil)
+		req := httptest.NewRequest(http.MethodGet, "/v1/pipelines", nil)
 		req.Header.Set("Authorization", "test-token")
 		w := httptest.NewRecorder()
 
@@ -141,7 +135,7 @@ func TestApi_Pipeline(t *testing.T) {
 
 		for _, tt := range tests {
 			t.Run(tt.name, func(t *testing.T) {
-				req := httptest.NewRequest(http.MethodGet, "/pipelines/"+tt.pipelineID, nil)
+				req := httptest.NewRequest(http.MethodGet, "/v1/pipelines/"+tt.pipelineID, nil)
 				req.Header.Set("Authorization", "test-token")
 				w := httptest.NewRecorder()
 
@@ -153,24 +147,41 @@ func TestApi_Pipeline(t *testing.T) {
 		}
 	})
 
+	t.Run("LegacyStages", func(t *testing.T) {
+		get := func(path string) map[string]json.RawMessage {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			req.Header.Set("Authorization", "test-token")
+			w := httptest.NewRecorder()
+			api.SetupRouter().ServeHTTP(w, req)
+			require.Equal(t, http.StatusOK, w.Code)
+			var resp map[string]json.RawMessage
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			return resp
+		}
+
+		// the built-in stages are only in stages
+		resp := get("/v1/pipelines/" + id)
+		assert.Contains(t, string(resp["stages"]), "run_stage")
+		for _, key := range []string{"run_stage", "build_stage", "deploy_stage"} {
+			assert.NotContains(t, resp, key)
+		}
+
+		// unversioned routes keep them at the top level
+		resp = get("/pipelines/" + id)
+		assert.Contains(t, string(resp["stages"]), "run_stage")
+		assert.JSONEq(t, `{"command":"go test ./...","continue_on_error":false}`, string(resp["run_stage"]))
+		assert.JSONEq(t, `{"dockerfile_path":"Dockerfile","continue_on_error":false}`, string(resp["build_stage"]))
+		assert.JSONEq(t, `{"cluster_name":"prod","manifest_path":"k8s/","continue_on_error":false}`, string(resp["deploy_stage"]))
+	})
+
 	t.Run("UpdatePipeline", func(t *testing.T) {
 		pipeline := PipelineRequest{
 			Name:       "test-pipeline-updated",
 			Repository: "github.com/test/repo-updated",
 			Stages: Stages{
-				RunStage: RunStage{
-					Command:       "go test -v ./...",
-					ContinueOnErr: false,
-				},
-				BuildStage: BuildStage{
-					DockerfilePath: "Dockerfile",
-					ContinueOnErr:  false,
-				},
-				DeployStage: DeployStage{
-					ClusterName:   "prod",
-					ManifestPath:  "k8s/",
-					ContinueOnErr: false,
-				},
+				"run_stage":    json.RawMessage(`{"command":"go test -v ./..."}`),
+				"build_stage":  json.RawMessage(`{"dockerfile_path":"Dockerfile"}`),
+				"deploy_stage": json.RawMessage(`{"cluster_name":"prod","manifest_path":"k8s/"}`),
 			},
 		}
 		payload, err := json.Marshal(pipeline)
@@ -178,7 +189,7 @@ func TestApi_Pipeline(t *testing.T) {
 			t.Fatalf("failed to marshal request body: %v", err)
 		}
 
-		req := httptest.NewRequest(http.MethodPut, "/pipelines/"+id, bytes.NewBuffer(payload))
+		req := httptest.NewRequest(http.MethodPut, "/v1/pipelines/"+id, bytes.NewBuffer(payload))
 		req.Header.Set("Authorization", "test-token")
 		req.Body = io.NopCloser(bytes.NewBuffer(payload))
 		w := httptest.NewRecorder()
@@ -197,7 +208,7 @@ func TestApi_Pipeline(t *testing.T) {
 	})
 
 	t.Run("TriggerPipeline", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodPost, "/pipelines/"+id+"/trigger", nil)
+		req := httptest.NewRequest(http.MethodPost, "/v1/pipelines/"+id+"/trigger", nil)
 		req.Header.Set("Authorization", "test-token")
 		req.Body = io.NopCloser(bytes.NewBufferString(`{"git_ref": "test-ref"}`))
 		w := httptest.NewRecorder()
@@ -222,7 +233,7 @@ func TestApi_Pipeline(t *testing.T) {
 	})
 
 	t.Run("GetPipelineRun", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodGet, "/runs/"+runID, nil)
+		req := httptest.NewRequest(http.MethodGet, "/v1/runs/"+runID, nil)
 		req.Header.Set("Authorization", "test-token")
 		w := httptest.NewRecorder()
 
@@ -234,7 +245,7 @@ func TestApi_Pipeline(t *testing.T) {
 			t.Fatalf("failed to read response body: %v", err)
 		}
 
-		var response pipelineRunResponse
+		var response PipelineRunResponse
 		if err := json.Unmarshal(body, &response); err != nil {
 			log.Println(string(body))
 		}
@@ -245,7 +256,7 @@ func TestApi_Pipeline(t *testing.T) {
 	})
 
 	t.Run("ListPipelineRuns", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodGet, "/runs", nil)
+		req := httptest.NewRequest(http.MethodGet, "/v1/runs", nil)
 		req.Header.Set("Authorization", "test-token")
 		w := httptest.NewRecorder()
 
@@ -259,7 +270,7 @@ func TestApi_Pipeline(t *testing.T) {
 			t.Fatalf("failed to read response body: %v", err)
 		}
 
-		var runs []pipelineRunResponse
+		var runs []PipelineRunResponse
 		if err := json.Unmarshal(body, &runs); err != nil {
 			t.Fatalf("failed to unmarshal response body: %v", err)
 		}
@@ -271,7 +282,7 @@ func TestApi_Pipeline(t *testing.T) {
 	})
 
 	t.Run("DeletePipeline", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodDelete, "/pipelines/"+id, nil)
+		req := httptest.NewRequest(http.MethodDelete, "/v1/pipelines/"+id, nil)
 		req.Header.Set("Authorization", "test-token")
 		w := httptest.NewRecorder()
 
@@ -282,7 +293,7 @@ func TestApi_Pipeline(t *testing.T) {
 	})
 
 	t.Run("DeletePipelineNotFound", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodDelete, "/pipelines/123", nil)
+		req := httptest.NewRequest(http.MethodDelete, "/v1/pipelines/123", nil)
 		req.Header.Set("Authorization", "test-token")
 		w := httptest.NewRecorder()
 
@@ -293,7 +304,7 @@ func TestApi_Pipeline(t *testing.T) {
 	})
 
 	t.Run("ListPipelinesAfterDelete", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodGet, "/pipelines", nil)
+		req := httptest.NewRequest(http.MethodGet, "/v1/pipelines", nil)
 		req.Header.Set("Authorization", "test-token")
 		w := httptest.NewRecorder()
 
@@ -314,3 +325,543 @@ func TestApi_Pipeline(t *testing.T) {
 		assert.Equal(t, 0, len(pipelines))
 	})
 }
+
+func TestApi_CancelRun(t *testing.T) {
+	store := store.NewMemoryStore()
+	// the executor is not started, so triggered runs stay queued
+	executor := domain.NewExecutor(store, 1, 5, 2, 0.0, 10*time.Millisecond)
+	router := NewAPI(store, executor).SetupRouter()
+	ctx := context.Background()
+
+	pipeline := domain.NewPipeline("github.com/test/repo")
+	assert.NoError(t, store.CreatePipeline(ctx, pipeline))
+	queued, err := executor.TriggerPipeline(ctx, pipeline, "main")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+	}{
+		{name: "cancel queued run", path: "/v1/runs/" + queued.ID + "/cancel", wantStatus: http.StatusAccepted},
+		{name: "cancel cancelled run", path: "/v1/runs/" + queued.ID + "/cancel", wantStatus: http.StatusConflict},
+		{name: "cancel unknown run", path: "/v1/runs/unknown/cancel", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			req.Header.Set("Authorization", "test-token")
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+
+	run, err := store.GetPipelineRun(ctx, queued.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, domain.StatusFailed, run.Status)
+}
+
+func TestApi_DeleteRuns(t *testing.T) {
+	store := store.NewMemoryStore()
+	executor := domain.NewExecutor(store, 2, 5, 2, 0.0, 10*time.Millisecond)
+	api := NewAPI(store, executor)
+	router := api.SetupRouter()
+	ctx := context.Background()
+
+	pipeline := domain.NewPipeline("github.com/test/repo")
+	assert.NoError(t, store.CreatePipeline(ctx, pipeline))
+
+	finished := domain.NewPipelineRun(pipeline.ID, "main")
+	finished.Status = domain.StatusSuccess
+	assert.NoError(t, store.CreatePipelineRun(ctx, finished))
+
+	running := domain.NewPipelineRun(pipeline.ID, "main")
+	running.Status = domain.StatusRunning
+	assert.NoError(t, store.CreatePipelineRun(ctx, running))
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+	}{
+		{name: "delete finished run", method: http.MethodDelete, path: "/v1/runs/" + finished.ID, wantStatus: http.StatusNoContent},
+		{name: "delete deleted run", method: http.MethodDelete, path: "/v1/runs/" + finished.ID, wantStatus: http.StatusNotFound},
+		{name: "delete running run", method: http.MethodDelete, path: "/v1/runs/" + running.ID, wantStatus: http.StatusConflict},
+		{name: "cascade with running run", method: http.MethodDelete, path: "/v1/pipelines/" + pipeline.ID + "?cascade=true", wantStatus: http.StatusConflict},
+		{name: "invalid cascade", method: http.MethodDelete, path: "/v1/pipelines/" + pipeline.ID + "?cascade=maybe", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Authorization", "test-token")
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+
+	t.Run("cascade", func(t *testing.T) {
+		running.Status = domain.StatusFailed
+		require.NoError(t, store.UpdatePipelineRun(ctx, running))
+
+		req := httptest.NewRequest(http.MethodDelete, "/v1/pipelines/"+pipeline.ID+"?cascade=true", nil)
+		req.Header.Set("Authorization", "test-token")
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		runs, err := store.ListPipelineRuns(ctx, domain.ListOptions{})
+		assert.NoError(t, err)
+		assert.Empty(t, runs)
+	})
+}
+
+func TestApi_CascadeDeleteWithTrigger(t *testing.T) {
+	store := store.NewMemoryStore()
+	// the executor is not started, so triggered runs stay queued
+	router := NewAPI(store, domain.NewExecutor(store, 1, 100, 100, 0.0, 10*time.Millisecond)).SetupRouter()
+	ctx := context.Background()
+
+	serve := func(method, path, body string) int {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "test-token")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	for i := 0; i < 50; i++ {
+		pipeline := domain.NewPipeline("github.com/test/repo")
+		require.NoError(t, store.CreatePipeline(ctx, pipeline))
+
+		var wg sync.WaitGroup
+		var triggerStatus, deleteStatus int
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			triggerStatus = serve(http.MethodPost, "/v1/pipelines/"+pipeline.ID+"/trigger", `{"git_ref": "main"}`)
+		}()
+		go func() {
+			defer wg.Done()
+			deleteStatus = serve(http.MethodDelete, "/v1/pipelines/"+pipeline.ID+"?cascade=true", "")
+		}()
+		wg.Wait()
+
+		runs, err := store.ListPipelineRuns(ctx, domain.ListOptions{})
+		require.NoError(t, err)
+		var pipelineRuns int
+		for _, run := range runs {
+			if run.PipelineID == pipeline.ID {
+				pipelineRuns++
+			}
+		}
+		_, err = store.GetPipeline(ctx, pipeline.ID)
+		if deleteStatus == http.StatusNoContent {
+			// the run was triggered for the deleted pipeline and has been removed again
+			assert.ErrorIs(t, err, domain.ErrNotFound)
+			assert.Equal(t, http.StatusNotFound, triggerStatus)
+			assert.Zero(t, pipelineRuns, "run of the deleted pipeline left behind")
+		} else {
+			// the delete was refused for the queued run
+			assert.Equal(t, http.StatusConflict, deleteStatus)
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusAccepted, triggerStatus)
+			assert.Equal(t, 1, pipelineRuns)
+		}
+	}
+}
+
+func TestApi_ExportImport(t *testing.T) {
+	source := store.NewMemoryStore()
+	sourceAPI := NewAPI(source, domain.NewExecutor(source, 1, 5, 2, 0.0, 10*time.Millisecond))
+	ctx := context.Background()
+
+	pipeline := domain.NewPipeline("github.com/test/repo")
+	pipeline.Stages[domain.StageRun] = domain.NewRunStage(domain.StageRun, "go test ./...", false)
+	assert.NoError(t, source.CreatePipeline(ctx, pipeline))
+	run := domain.NewPipelineRun(pipeline.ID, "main")
+	run.Status = domain.StatusSuccess
+	assert.NoError(t, source.CreatePipelineRun(ctx, run))
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/admin/export", nil)
+	req.Header.Set("Authorization", "test-token")
+	w := httptest.NewRecorder()
+	sourceAPI.SetupRouter().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	archive := w.Body.Bytes()
+
+	target := store.NewMemoryStore()
+	targetAPI := NewAPI(target, domain.NewExecutor(target, 1, 5, 2, 0.0, 10*time.Millisecond))
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		want       ImportResponse
+	}{
+		{name: "import", query: "", wantStatus: http.StatusOK, want: ImportResponse{Pipelines: 1, Runs: 1}},
+		{name: "import again", query: "?conflict=skip", wantStatus: http.StatusOK, want: ImportResponse{Skipped: 2}},
+		{name: "import renamed", query: "?conflict=rename", wantStatus: http.StatusOK, want: ImportResponse{Pipelines: 1, Runs: 1, Renamed: 2}},
+		{name: "invalid conflict mode", query: "?conflict=merge", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/admin/import"+tt.query, bytes.NewReader(archive))
+			req.Header.Set("Authorization", "test-token")
+			w := httptest.NewRecorder()
+			targetAPI.SetupRouter().ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var resp ImportResponse
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			assert.Equal(t, tt.want, resp)
+		})
+	}
+
+	imported, err := target.GetPipeline(ctx, pipeline.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "go test ./...", imported.Stages[domain.StageRun].(*domain.RunStage).Command)
+}
+
+// runlessStore is a store failing to store pipeline runs
+type runlessStore struct {
+	domain.Store
+}
+
+func (s runlessStore) CreatePipelineRun(ctx context.Context, run *domain.PipelineRun) error {
+	return errors.New("disk full")
+}
+
+func TestApi_ImportFailure(t *testing.T) {
+	source := store.NewMemoryStore()
+	ctx := context.Background()
+	pipeline := domain.NewPipeline("github.com/test/repo")
+	require.NoError(t, source.CreatePipeline(ctx, pipeline))
+	run := domain.NewPipelineRun(pipeline.ID, "main")
+	run.Status = domain.StatusSuccess
+	require.NoError(t, source.CreatePipelineRun(ctx, run))
+	var archive bytes.Buffer
+	require.NoError(t, domain.Export(ctx, source, &archive))
+	lines := strings.SplitAfter(archive.String(), "\n")
+
+	tests := []struct {
+		name       string
+		store      domain.Store
+		archive    string
+		wantStatus int
+		wantCode   ErrorCode
+	}{
+		{
+			name:       "store failure",
+			store:      runlessStore{Store: store.NewMemoryStore()},
+			archive:    archive.String(),
+			wantStatus: http.StatusInternalServerError,
+			wantCode:   CodeInternal,
+		},
+		{
+			name:       "truncated archive",
+			store:      store.NewMemoryStore(),
+			archive:    lines[0] + lines[1] + lines[2][:len(lines[2])/2],
+			wantStatus: http.StatusBadRequest,
+			wantCode:   CodeInvalidRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := NewAPI(tt.store, domain.NewExecutor(tt.store, 1, 5, 2, 0.0, 10*time.Millisecond))
+			req := httptest.NewRequest(http.MethodPost, "/v1/admin/import", strings.NewReader(tt.archive))
+			req.Header.Set("Authorization", "test-token")
+			w := httptest.NewRecorder()
+			api.SetupRouter().ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			var resp ErrorResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			assert.Equal(t, tt.wantCode, resp.Code)
+			// the pipeline has been imported before the failure
+			assert.Equal(t, "1", resp.Details["pipelines"])
+			assert.Equal(t, "0", resp.Details["runs"])
+			_, err := tt.store.GetPipeline(ctx, pipeline.ID)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestApi_Events(t *testing.T) {
+	store := store.NewMemoryStore()
+	api := NewAPI(store, domain.NewExecutor(store, 1, 5, 2, 0.0, 10*time.Millisecond))
+	server := httptest.NewServer(api.SetupRouter())
+	defer server.Close()
+	client := NewClient(server.URL, WithToken("test-token"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// collect is watching events until n events have been received
+	collect := func(query EventsQuery, n int) ([]EventResponse, error) {
+		var events []EventResponse
+		err := client.WatchEvents(ctx, query, func(event EventResponse) error {
+			events = append(events, event)
+			if len(events) == n {
+				return ErrStopWatching
+			}
+			return nil
+		})
+		return events, err
+	}
+
+	pipeline := domain.NewPipeline("github.com/test/repo")
+	assert.NoError(t, store.CreatePipeline(ctx, domain.NewPipeline("github.com/test/other")))
+	assert.NoError(t, store.CreatePipeline(ctx, pipeline))
+	assert.NoError(t, store.DeletePipeline(ctx, pipeline.ID))
+
+	// resume after the first event and only watch the events of the deleted pipeline
+	events, err := collect(EventsQuery{PipelineID: pipeline.ID, After: "1"}, 2)
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+	assert.Equal(t, "pipeline.created", events[0].Type)
+	assert.Equal(t, "pipeline.deleted", events[1].Type)
+	assert.Equal(t, pipeline.ID, events[1].PipelineID)
+	assert.Equal(t, "3", events[1].ID)
+
+	t.Run("live", func(t *testing.T) {
+		done := make(chan []EventResponse)
+		go func() {
+			events, err := collect(EventsQuery{Types: []string{"pipeline.updated"}}, 1)
+			assert.NoError(t, err)
+			done <- events
+		}()
+
+		other := domain.NewPipeline("github.com/test/live")
+		assert.NoError(t, store.CreatePipeline(ctx, other))
+		// keep updating until the watch has been established and received an event
+		for {
+			select {
+			case events := <-done:
+				assert.Equal(t, other.ID, events[0].PipelineID)
+				return
+			case <-time.After(10 * time.Millisecond):
+				assert.NoError(t, store.UpdatePipeline(ctx, other))
+			}
+		}
+	})
+
+	t.Run("invalid resume token", func(t *testing.T) {
+		_, err := collect(EventsQuery{After: "abc"}, 1)
+		assert.ErrorContains(t, err, "400")
+	})
+}
+
+func TestApi_Audit(t *testing.T) {
+	s := store.NewMemoryStore()
+	auditLog := store.NewMemoryAuditLog()
+	api := NewAPI(s, domain.NewExecutor(s, 1, 5, 2, 0.0, 10*time.Millisecond), WithAuditLog(auditLog))
+	router := api.SetupRouter()
+
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", token)
+		req.Header.Set("X-Request-ID", "req-"+method)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	pipeline := `{"name": "p1", "repository": "repo", "stages": {"run_stage": {"command": "make test"}, "build_stage": {"dockerfile_path": "Dockerfile"}, "deploy_stage": {"cluster_name": "prod", "manifest_path": "k8s/"}}}`
+	w := do(http.MethodPost, "/v1/pipelines", "alice-token", pipeline)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var created CreatePipelineResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+
+	updated := strings.Replace(pipeline, "make test", "go test", 1)
+	assert.Equal(t, http.StatusOK, do(http.MethodPut, "/v1/pipelines/"+created.ID, "alice-token", updated).Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/v1/pipelines/"+created.ID, "alice-token", "").Code)
+	assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/v1/pipelines/"+created.ID, "bob-token", "").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/v1/pipelines/"+created.ID, "bob-token", "").Code)
+
+	w = do(http.MethodGet, "/v1/audit", "alice-token", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var entries []AuditEntryResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &entries))
+
+	// reads are not audited
+	require.Len(t, entries, 4)
+	assert.Equal(t, "pipeline.create", entries[0].Action)
+	assert.Equal(t, created.ID, entries[0].TargetID)
+	assert.Equal(t, "req-POST", entries[0].RequestID)
+	assert.Equal(t, http.StatusCreated, entries[0].Status)
+
+	assert.Equal(t, "pipeline.update", entries[1].Action)
+	assert.Equal(t, []AuditChangeResponse{{Field: "stages.run_stage.command", Before: "make test", After: "go test"}}, entries[1].Changes)
+
+	// the actor is derived from the token, but must not reveal it
+	assert.Equal(t, entries[0].Actor, entries[1].Actor)
+	assert.NotEqual(t, entries[0].Actor, entries[2].Actor)
+	assert.NotContains(t, entries[0].Actor, "alice-token")
+
+	assert.Equal(t, "pipeline.delete", entries[2].Action)
+	assert.NotEmpty(t, entries[2].Before)
+	assert.Equal(t, "pipeline.delete", entries[3].Action)
+	assert.Equal(t, http.StatusNotFound, entries[3].Status)
+	assert.Empty(t, entries[3].Before)
+
+	t.Run("filter", func(t *testing.T) {
+		w := do(http.MethodGet, "/v1/audit?action=pipeline.delete&actor="+entries[2].Actor, "alice-token", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var filtered []AuditEntryResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &filtered))
+		assert.Len(t, filtered, 2)
+
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/v1/audit?since=yesterday", "alice-token", "").Code)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		api := NewAPI(s, domain.NewExecutor(s, 1, 5, 2, 0.0, 10*time.Millisecond))
+		req := httptest.NewRequest(http.MethodGet, "/v1/audit", nil)
+		req.Header.Set("Authorization", "test-token")
+		w := httptest.NewRecorder()
+		api.SetupRouter().ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotImplemented, w.Code)
+	})
+}
+
+func TestApi_ValidatePipeline(t *testing.T) {
+	s := store.NewMemoryStore()
+	auditLog := store.NewMemoryAuditLog()
+	router := NewAPI(s, domain.NewExecutor(s, 1, 5, 2, 0.0, 10*time.Millisecond), WithAuditLog(auditLog)).SetupRouter()
+
+	validate := func(t *testing.T, body string) ValidatePipelineResponse {
+		req := httptest.NewRequest(http.MethodPost, "/v1/pipelines/validate", strings.NewReader(body))
+		req.Header.Set("Authorization", "test-token")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var resp ValidatePipelineResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		resp := validate(t, `{"name": "p1", "repository": "repo", "stages": {"run_stage": {"command": "make test", "continue_on_error": true}, "build_stage": {"dockerfile_path": "Dockerfile"}, "deploy_stage": {"cluster_name": "prod", "manifest_path": "k8s/"}}}`)
+		assert.True(t, resp.Valid)
+		assert.Empty(t, resp.Errors)
+		assert.Empty(t, resp.Warnings)
+		assert.Equal(t, []PlanStepResponse{
+			{Stage: "run", Description: `run "make test"`, ContinueOnError: true},
+			{Stage: "build", Description: "build the image of Dockerfile"},
+			{Stage: "deploy", Description: "deploy k8s/ to cluster prod"},
+		}, resp.Plan)
+	})
+
+	t.Run("all problems", func(t *testing.T) {
+		resp := validate(t, `{"repository": "repo", "stages": {"run_stage": {"comand": "make"}, "build_stage": {"dockerfile_path": "Dockerfile", "continue_on_error": true}, "deploy_stage": {"manifest_path": "../k8s"}}}`)
+		assert.False(t, resp.Valid)
+		assert.Equal(t, []ValidationProblem{
+			{Path: "stages.run_stage.command", Message: "command is required for run stage"},
+			{Path: "stages.deploy_stage.cluster_name", Message: "cluster name is required for deploy stage"},
+			{Path: "stages.deploy_stage.manifest_path", Message: "manifest path must be relative to the repository"},
+		}, resp.Errors)
+		assert.Equal(t, []ValidationProblem{
+			{Path: "name", Message: "pipeline has no name"},
+			{Path: "stages.build_stage.continue_on_error", Message: "deploy stage runs even if the build failed, deploying an image which has not been built"},
+			{Path: "stages.run_stage.comand", Message: "unknown field is ignored"},
+		}, resp.Warnings)
+		assert.Len(t, resp.Plan, 3)
+	})
+
+	t.Run("unknown stages", func(t *testing.T) {
+		resp := validate(t, `{"name": "test", "repository": "repo", "stages": {"run_stage": {"command": "make"}, "build_stage": {"dockerfile_path": "Dockerfile"}, "deploy_stage": {"cluster_name": "prod", "manifest_path": "k8s/"}, "notify_stage": {}, "lint": {}}}`)
+		assert.False(t, resp.Valid)
+		assert.Equal(t, []ValidationProblem{
+			{Path: "stages.lint", Message: `unknown stage "lint"`},
+			{Path: "stages.notify_stage", Message: `unknown stage type "notify"`},
+		}, resp.Errors)
+		assert.Empty(t, resp.Warnings)
+	})
+
+	t.Run("malformed body", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/v1/pipelines/validate", strings.NewReader("{"))
+		req.Header.Set("Authorization", "test-token")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	// validating neither creates pipelines nor is it audited
+	pipelines, err := s.ListPipelines(context.Background(), domain.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, pipelines)
+	entries, err := auditLog.ListAuditEntries(context.Background(), domain.AuditFilter{}, domain.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestApi_UI(t *testing.T) {
+	s := store.NewMemoryStore()
+	ui := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ui " + r.URL.Path))
+	})
+
+	tests := []struct {
+		name         string
+		opts         []APIOption
+		path         string
+		wantStatus   int
+		wantLocation string
+		wantBody     string
+	}{
+		{name: "assets without token", opts: []APIOption{WithUI(ui)}, path: "/ui/app.js", wantStatus: http.StatusOK, wantBody: "ui /app.js"},
+		{name: "index", opts: []APIOption{WithUI(ui)}, path: "/ui/", wantStatus: http.StatusOK, wantBody: "ui /"},
+		{name: "root redirect", opts: []APIOption{WithUI(ui)}, path: "/", wantStatus: http.StatusFound, wantLocation: "/ui/"},
+		{name: "prefix redirect", opts: []APIOption{WithUI(ui)}, path: "/ui", wantStatus: http.StatusMovedPermanently, wantLocation: "/ui/"},
+		{name: "disabled", path: "/ui/", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := NewAPI(s, domain.NewExecutor(s, 1, 5, 2, 0.0, 10*time.Millisecond), tt.opts...).SetupRouter()
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			assert.Equal(t, tt.wantLocation, w.Header().Get("Location"))
+			if tt.wantBody != "" {
+				assert.Equal(t, tt.wantBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestCreatePipelineRunResponse_StageTimings(t *testing.T) {
+	started := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	run := domain.NewPipelineRun("pipeline-1", "main")
+	run.StageTimings[domain.StageRun] = domain.StageTiming{StartedAt: started, FinishedAt: started.Add(5 * time.Second)}
+	run.StageTimings[domain.StageBuild] = domain.StageTiming{StartedAt: started.Add(5 * time.Second)}
+
+	data, err := json.Marshal(createPipelineRunResponse(run))
+	require.NoError(t, err)
+	var resp struct {
+		StageTimings map[string]map[string]string `json:"stage_timings"`
+	}
+	require.NoError(t, json.Unmarshal(data, &resp))
+	assert.Equal(t, map[string]map[string]string{
+		"run":   {"started_at": "2024-05-01T12:00:00Z", "finished_at": "2024-05-01T12:00:05Z"},
+		"build": {"started_at": "2024-05-01T12:00:05Z"},
+	}, resp.StageTimings)
+}
@@ -0,0 +1,214 @@
+package http
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hphilipps/stagerunner/domain"
+)
+
+// auditContextKey is the context key of the audit entry of a mutating request
+const auditContextKey contextKey = "audit"
+
+// AuditChangeResponse is used to construct a changed field of an audit entry response
+type AuditChangeResponse struct {
+	Field  string      `json:"field"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// AuditEntryResponse is used to construct a response for an audit entry
+type AuditEntryResponse struct {
+	ID         string                `json:"id"`
+	Time       time.Time             `json:"time"`
+	RequestID  string                `json:"request_id,omitempty"`
+	Actor      string                `json:"actor"`
+	Action     string                `json:"action"`
+	TargetType string                `json:"target_type,omitempty"`
+	TargetID   string                `json:"target_id,omitempty"`
+	Status     int                   `json:"status"`
+	Before     json.RawMessage       `json:"before,omitempty"`
+	After      json.RawMessage       `json:"after,omitempty"`
+	Changes    []AuditChangeResponse `json:"changes,omitempty"`
+}
+
+// createAuditEntryResponse is used to construct an audit entry response from an audit entry domain object
+func createAuditEntryResponse(entry *domain.AuditEntry) AuditEntryResponse {
+	changes := make([]AuditChangeResponse, 0, len(entry.Changes))
+	for _, c := range entry.Changes {
+		changes = append(changes, AuditChangeResponse{Field: c.Field, Before: c.Before, After: c.After})
+	}
+	return AuditEntryResponse{
+		ID:         entry.ID,
+		Time:       entry.Time,
+		RequestID:  entry.RequestID,
+		Actor:      entry.Actor,
+		Action:     entry.Action,
+		TargetType: entry.TargetType,
+		TargetID:   entry.TargetID,
+		Status:     entry.Status,
+		Before:     entry.Before,
+		After:      entry.After,
+		Changes:    changes,
+	}
+}
+
+// actorFromContext returns the identity of the caller. Callers authenticated by a client certificate
+// have the identity of its subject. As tokens are opaque, the identity of callers authenticated by a
+// token is a fingerprint of the token - we never want to record the token itself.
+func actorFromContext(ctx context.Context) string {
+	if identity, ok := ctx.Value(identityContextKey).(string); ok {
+		return identity
+	}
+	token := tokenFromContext(ctx)
+	if token == "" {
+		return "anonymous"
+	}
+	sum := sha256.Sum256([]byte(token))
+	return "token:" + hex.EncodeToString(sum[:])[:12]
+}
+
+// auditMiddleware is a middleware that records an audit entry for every mutating request.
+// Handlers add the target and its snapshots with auditTarget, auditBefore and auditAfter.
+func (api *API) auditMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if api.auditLog == nil || r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		entry := &domain.AuditEntry{
+			Time:      time.Now(),
+			RequestID: requestIDFromRequest(r),
+			Actor:     actorFromContext(r.Context()),
+			Action:    r.Method + " " + r.URL.Path,
+		}
+		if route := mux.CurrentRoute(r); route != nil {
+			if tmpl, err := route.GetPathTemplate(); err == nil {
+				entry.Action = r.Method + " " + tmpl
+			}
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), auditContextKey, entry)))
+		logger := domain.LoggerFromContext(r.Context())
+		entry.Status = rec.status
+
+		if len(entry.Before) > 0 || len(entry.After) > 0 {
+			changes, err := domain.DiffSnapshots(entry.Before, entry.After)
+			if err != nil {
+				logger.Error("failed to diff audit snapshots", "error", err)
+			}
+			entry.Changes = changes
+		}
+
+		// we use a fresh context, as the request might have been cancelled already
+		if err := api.auditLog.AppendAuditEntry(context.Background(), entry); err != nil {
+			logger.Error("failed to record audit entry", "error", err)
+		}
+	})
+}
+
+// auditEntryFromContext returns the audit entry of the request or nil if the request is not audited
+func auditEntryFromContext(ctx context.Context) *domain.AuditEntry {
+	entry, _ := ctx.Value(auditContextKey).(*domain.AuditEntry)
+	return entry
+}
+
+// auditTarget sets the action and the target of the audit entry of the request
+func auditTarget(r *http.Request, action, targetType, targetID string) {
+	if entry := auditEntryFromContext(r.Context()); entry != nil {
+		entry.Action = action
+		entry.TargetType = targetType
+		entry.TargetID = targetID
+	}
+}
+
+// auditTargetID sets the ID of a target which is only known once it has been created
+func auditTargetID(r *http.Request, targetID string) {
+	if entry := auditEntryFromContext(r.Context()); entry != nil {
+		entry.TargetID = targetID
+	}
+}
+
+// auditBefore records a snapshot of the target before it is changed
+func auditBefore(r *http.Request, v interface{}) {
+	if entry := auditEntryFromContext(r.Context()); entry != nil {
+		entry.Before = auditSnapshot(r, v)
+	}
+}
+
+// auditAfter records a snapshot of the target after it has been changed
+func auditAfter(r *http.Request, v interface{}) {
+	if entry := auditEntryFromContext(r.Context()); entry != nil {
+		entry.After = auditSnapshot(r, v)
+	}
+}
+
+func auditSnapshot(r *http.Request, v interface{}) json.RawMessage {
+	snapshot, err := json.Marshal(v)
+	if err != nil {
+		domain.LoggerFromContext(r.Context()).Error("failed to take audit snapshot", "error", err)
+		return nil
+	}
+	return snapshot
+}
+
+// auditFilterFromRequest parses the filter query parameters of the audit log
+func auditFilterFromRequest(r *http.Request) (domain.AuditFilter, error) {
+	q := r.URL.Query()
+	filter := domain.AuditFilter{
+		Actor:      q.Get("actor"),
+		Action:     q.Get("action"),
+		TargetType: q.Get("target_type"),
+		TargetID:   q.Get("target_id"),
+	}
+	for name, dst := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		value := q.Get(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return filter, newError(ErrInvalidRequest, "invalid %s: %q - must be an RFC3339 timestamp", name, value)
+		}
+		*dst = t
+	}
+	return filter, nil
+}
+
+// listAuditEntries is a handler for querying the audit log
+func (api *API) listAuditEntries(w http.ResponseWriter, r *http.Request) {
+	if api.auditLog == nil {
+		respondWithError(w, r, newError(ErrNotImplemented, "Audit log is not enabled"))
+		return
+	}
+
+	filter, err := auditFilterFromRequest(r)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	opts, err := listOptionsFromRequest(r)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	entries, err := api.auditLog.ListAuditEntries(r.Context(), filter, opts)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	responses := make([]AuditEntryResponse, 0, len(entries))
+	for _, entry := range entries {
+		responses = append(responses, createAuditEntryResponse(entry))
+	}
+	respondWithJSON(w, http.StatusOK, responses)
+}
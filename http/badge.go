@@ -0,0 +1,148 @@
+package http
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"text/template"
+
+	"github.com/gorilla/mux"
+	"github.com/hphilipps/stagerunner/domain"
+)
+
+const (
+	// badgeTokenParam is the query parameter carrying the badge token of a pipeline
+	badgeTokenParam = "token"
+	// badgeContentType is the content type of status badges
+	badgeContentType = "image/svg+xml"
+	// badgeLabel is the text on the left side of status badges
+	badgeLabel = "pipeline"
+)
+
+// badge is the status shown on the right side of a status badge
+type badge struct {
+	status string
+	color  string
+}
+
+var (
+	badgePassing = badge{status: "passing", color: "#4c1"}
+	badgeFailing = badge{status: "failing", color: "#e05d44"}
+	badgeRunning = badge{status: "running", color: "#007ec6"}
+	badgeUnknown = badge{status: "unknown", color: "#9f9f9f"}
+)
+
+// badgeFor returns the badge of the latest run of a pipeline, run is nil if the pipeline has never run
+func badgeFor(run *domain.PipelineRun) badge {
+	if run == nil {
+		return badgeUnknown
+	}
+	switch run.Status {
+	case domain.StatusSuccess:
+		return badgePassing
+	case domain.StatusFailed:
+		return badgeFailing
+	case domain.StatusPending, domain.StatusRunning:
+		return badgeRunning
+	}
+	return badgeUnknown
+}
+
+// badgeTemplate is rendering a badge in the flat style common in READMEs
+var badgeTemplate = template.Must(template.New("badge").Parse(`<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="20" role="img" aria-label="{{.Label}}: {{.Status}}">
+<title>{{.Label}}: {{.Status}}</title>
+<linearGradient id="s" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="{{.Width}}" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)"><rect width="{{.LabelWidth}}" height="20" fill="#555"/><rect x="{{.LabelWidth}}" width="{{.StatusWidth}}" height="20" fill="{{.Color}}"/><rect width="{{.Width}}" height="20" fill="url(#s)"/></g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="{{.LabelX}}" y="15" fill="#010101" fill-opacity=".3">{{.Label}}</text><text x="{{.LabelX}}" y="14">{{.Label}}</text>
+<text x="{{.StatusX}}" y="15" fill="#010101" fill-opacity=".3">{{.Status}}</text><text x="{{.StatusX}}" y="14">{{.Status}}</text>
+</g>
+</svg>
+`))
+
+// textWidth is approximating the width of a text in Verdana 11px, which is good enough for the short texts of badges
+func textWidth(text string) int {
+	return 7*len(text) + 10
+}
+
+// svg returns the SVG image of the badge
+func (b badge) svg() []byte {
+	labelWidth, statusWidth := textWidth(badgeLabel), textWidth(b.status)
+	var buf bytes.Buffer
+	// the template is only rendering constants, so it can't fail
+	_ = badgeTemplate.Execute(&buf, map[string]interface{}{
+		"Label":       badgeLabel,
+		"Status":      b.status,
+		"Color":       b.color,
+		"Width":       labelWidth + statusWidth,
+		"LabelWidth":  labelWidth,
+		"StatusWidth": statusWidth,
+		"LabelX":      labelWidth / 2,
+		"StatusX":     labelWidth + statusWidth/2,
+	})
+	return buf.Bytes()
+}
+
+// latestRun returns the most recently created run of a pipeline on the git ref, or of any git ref if ref is empty.
+// It returns nil if there is no such run.
+func latestRun(runs []*domain.PipelineRun, pipelineID, ref string) *domain.PipelineRun {
+	var latest *domain.PipelineRun
+	for _, run := range runs {
+		if run.PipelineID != pipelineID || (ref != "" && run.GitRef != ref) {
+			continue
+		}
+		if latest == nil || !run.CreatedAt.Before(latest.CreatedAt) {
+			latest = run
+		}
+	}
+	return latest
+}
+
+// getPipelineBadge is a handler for getting the status badge of a pipeline. Requests are either
+// authenticated like all other API requests, or carry the badge token of a pipeline with a public badge.
+func (api *API) getPipelineBadge(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	pipeline, err := api.store.GetPipeline(r.Context(), vars["id"])
+	if err != nil && !errors.Is(err, domain.ErrNotFound) {
+		respondWithError(w, r, err)
+		return
+	}
+
+	if token := r.URL.Query().Get(badgeTokenParam); token != "" {
+		// unknown pipelines are not revealed to callers without API access
+		if pipeline == nil || !pipeline.BadgeTokenMatches(token) {
+			respondWithError(w, r, newError(ErrUnauthorized, "Invalid badge token"))
+			return
+		}
+	} else {
+		if _, err := api.authenticate(r); err != nil {
+			respondWithError(w, r, err)
+			return
+		}
+		if pipeline == nil {
+			respondWithError(w, r, newError(domain.ErrNotFound, "Pipeline not found"))
+			return
+		}
+	}
+
+	runs, err := api.store.ListPipelineRuns(r.Context(), domain.ListOptions{})
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	svg := badgeFor(latestRun(runs, pipeline.ID, r.URL.Query().Get("ref"))).svg()
+
+	// badges are embedded by READMEs, so caches have to revalidate them with the ETag
+	etag := contentETag(svg)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", badgeContentType)
+	w.Header().Set("Content-Security-Policy", "default-src 'none'")
+	w.WriteHeader(http.StatusOK)
+	w.Write(svg)
+}
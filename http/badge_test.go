@@ -0,0 +1,138 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hphilipps/stagerunner/domain"
+	"github.com/hphilipps/stagerunner/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApi_Badge(t *testing.T) {
+	store := store.NewMemoryStore()
+	router := NewAPI(store, domain.NewExecutor(store, 1, 5, 2, 0.0, 10*time.Millisecond)).SetupRouter()
+	ctx := context.Background()
+
+	// serve is sending a request with the authorization header, if auth is set
+	serve := func(method, path string, body []byte, auth bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(body))
+		if auth {
+			req.Header.Set("Authorization", "test-token")
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	payload, err := json.Marshal(PipelineRequest{
+		Name:       "badged",
+		Repository: "github.com/test/repo",
+		Stages: Stages{
+			"run_stage":    json.RawMessage(`{"command":"make test"}`),
+			"build_stage":  json.RawMessage(`{"dockerfile_path":"Dockerfile"}`),
+			"deploy_stage": json.RawMessage(`{"cluster_name":"prod","manifest_path":"k8s/"}`),
+		},
+		PublicBadge: true,
+	})
+	require.NoError(t, err)
+	w := serve(http.MethodPost, "/v1/pipelines", payload, true)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var created CreatePipelineResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+
+	w = serve(http.MethodGet, "/v1/pipelines/"+created.ID, nil, true)
+	var pipeline PipelineResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &pipeline))
+	assert.True(t, pipeline.PublicBadge)
+	require.Len(t, pipeline.BadgeToken, 32)
+
+	private := domain.NewPipeline("github.com/test/private")
+	require.NoError(t, store.CreatePipeline(ctx, private))
+
+	start := time.Now().Add(-time.Hour)
+	for i, status := range []string{domain.StatusSuccess, domain.StatusFailed} {
+		run := domain.NewPipelineRun(created.ID, "main")
+		run.Status = status
+		run.CreatedAt = start.Add(time.Duration(i) * time.Minute)
+		require.NoError(t, store.CreatePipelineRun(ctx, run))
+	}
+	running := domain.NewPipelineRun(created.ID, "dev")
+	running.Status = domain.StatusRunning
+	require.NoError(t, store.CreatePipelineRun(ctx, running))
+
+	badgePath := "/v1/pipelines/" + created.ID + "/badge.svg"
+	tests := []struct {
+		name       string
+		path       string
+		auth       bool
+		wantStatus int
+		wantBadge  string
+	}{
+		{name: "latest run of the ref", path: badgePath + "?ref=main", auth: true, wantStatus: http.StatusOK, wantBadge: "failing"},
+		{name: "running run", path: badgePath + "?ref=dev", auth: true, wantStatus: http.StatusOK, wantBadge: "running"},
+		{name: "latest run of any ref", path: badgePath, auth: true, wantStatus: http.StatusOK, wantBadge: "running"},
+		{name: "ref without runs", path: badgePath + "?ref=v1", auth: true, wantStatus: http.StatusOK, wantBadge: "unknown"},
+		{name: "badge token", path: badgePath + "?ref=main&token=" + pipeline.BadgeToken, wantStatus: http.StatusOK, wantBadge: "failing"},
+		{name: "legacy route", path: "/pipelines/" + created.ID + "/badge.svg?token=" + pipeline.BadgeToken, wantStatus: http.StatusOK, wantBadge: "running"},
+		{name: "wrong badge token", path: badgePath + "?token=wrong", wantStatus: http.StatusUnauthorized},
+		{name: "badge token of another pipeline", path: "/v1/pipelines/" + private.ID + "/badge.svg?token=" + pipeline.BadgeToken, wantStatus: http.StatusUnauthorized},
+		{name: "private badge", path: "/v1/pipelines/" + private.ID + "/badge.svg", auth: true, wantStatus: http.StatusOK, wantBadge: "unknown"},
+		{name: "unauthenticated", path: badgePath, wantStatus: http.StatusUnauthorized},
+		{name: "unknown pipeline", path: "/v1/pipelines/unknown/badge.svg", auth: true, wantStatus: http.StatusNotFound},
+		{name: "unknown pipeline with badge token", path: "/v1/pipelines/unknown/badge.svg?token=" + pipeline.BadgeToken, wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(http.MethodGet, tt.path, nil, tt.auth)
+			require.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			if tt.wantBadge == "" {
+				return
+			}
+			assert.Equal(t, "image/svg+xml", w.Header().Get("Content-Type"))
+			assert.Equal(t, "no-cache", w.Header().Get("Cache-Control"))
+			assert.Contains(t, w.Body.String(), "<title>pipeline: "+tt.wantBadge+"</title>")
+		})
+	}
+
+	t.Run("ETag", func(t *testing.T) {
+		w := serve(http.MethodGet, badgePath+"?ref=main", nil, true)
+		etag := w.Header().Get("ETag")
+		require.NotEmpty(t, etag)
+
+		req := httptest.NewRequest(http.MethodGet, badgePath+"?ref=main", nil)
+		req.Header.Set("Authorization", "test-token")
+		req.Header.Set("If-None-Match", etag)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotModified, w.Code)
+		assert.Empty(t, w.Body.String())
+
+		// the badge of a changed status has another ETag
+		w = serve(http.MethodGet, badgePath+"?ref=dev", nil, true)
+		assert.NotEqual(t, etag, w.Header().Get("ETag"))
+	})
+
+	t.Run("disable public badge", func(t *testing.T) {
+		var req PipelineRequest
+		require.NoError(t, json.Unmarshal(payload, &req))
+		req.PublicBadge = false
+		body, err := json.Marshal(req)
+		require.NoError(t, err)
+		w := serve(http.MethodPut, "/v1/pipelines/"+created.ID, body, true)
+		require.Equal(t, http.StatusOK, w.Code)
+		var updated PipelineResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &updated))
+		assert.False(t, updated.PublicBadge)
+		assert.Empty(t, updated.BadgeToken)
+
+		w = serve(http.MethodGet, badgePath+"?token="+pipeline.BadgeToken, nil, false)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
@@ -1,18 +1,42 @@
 package http
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
 	"time"
+
+	"github.com/google/uuid"
+	"github.com/hphilipps/stagerunner/domain"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+const (
+	// triggerAttempts is the number of attempts to send a trigger request
+	triggerAttempts = 3
+	// triggerRetryDelay is the delay before the first retry of a trigger request, which increases with every attempt
+	triggerRetryDelay = 500 * time.Millisecond
 )
 
+// ErrStopWatching can be returned by the handler of WatchEvents to stop watching
+var ErrStopWatching = errors.New("stop watching")
+
 type Client struct {
 	baseURL    string
 	httpClient *http.Client
 	token      string
+	// pollInterval is the interval of polling runs if the server doesn't support streaming events
+	pollInterval time.Duration
 }
 
 // ClientOption allows for customizing the client
@@ -32,6 +56,41 @@ func WithToken(token string) ClientOption {
 	}
 }
 
+// WithPollInterval sets the interval of polling runs in WatchRun, if the server is not streaming events
+func WithPollInterval(interval time.Duration) ClientOption {
+	return func(c *Client) {
+		c.pollInterval = interval
+	}
+}
+
+// WithCACert sets the certificate authorities used to verify the certificate of the server,
+// instead of the system certificate pool
+func WithCACert(pool *x509.CertPool) ClientOption {
+	return func(c *Client) {
+		c.tlsConfig().RootCAs = pool
+	}
+}
+
+// WithClientCert sets the certificate the client is authenticating with on servers using mutual TLS
+func WithClientCert(cert tls.Certificate) ClientOption {
+	return func(c *Client) {
+		c.tlsConfig().Certificates = []tls.Certificate{cert}
+	}
+}
+
+// tlsConfig returns the TLS config of the transport of the client, which is created on first use
+func (c *Client) tlsConfig() *tls.Config {
+	transport, ok := c.httpClient.Transport.(*http.Transport)
+	if !ok {
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+		c.httpClient.Transport = transport
+	}
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	return transport.TLSClientConfig
+}
+
 // NewClient creates a new API client
 func NewClient(baseURL string, opts ...ClientOption) *Client {
 	c := &Client{
@@ -39,6 +98,7 @@ func NewClient(baseURL string, opts ...ClientOption) *Client {
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
+		pollInterval: time.Second,
 	}
 
 	for _, opt := range opts {
@@ -58,6 +118,17 @@ func (c *Client) CreatePipeline(ctx context.Context, req PipelineRequest) (*Pipe
 	return &resp, nil
 }
 
+// ValidatePipeline validates a pipeline without creating it and returns its problems and execution plan.
+// The pipeline is a PipelineRequest or its JSON as json.RawMessage, which is checked for unknown fields too.
+func (c *Client) ValidatePipeline(ctx context.Context, pipeline interface{}) (*ValidatePipelineResponse, error) {
+	var resp ValidatePipelineResponse
+	err := c.doRequest(ctx, http.MethodPost, "/pipelines/validate", pipeline, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
 // GetPipeline retrieves a pipeline by ID
 func (c *Client) GetPipeline(ctx context.Context, id string) (*PipelineResponse, error) {
 	var resp PipelineResponse
@@ -93,20 +164,64 @@ func (c *Client) DeletePipeline(ctx context.Context, id string) error {
 	return c.doRequest(ctx, http.MethodDelete, fmt.Sprintf("/pipelines/%s", id), nil, nil)
 }
 
-// TriggerPipeline triggers a pipeline run
+// DeletePipelineWithRuns deletes a pipeline by ID together with all of its runs
+func (c *Client) DeletePipelineWithRuns(ctx context.Context, id string) error {
+	return c.doRequest(ctx, http.MethodDelete, fmt.Sprintf("/pipelines/%s?cascade=true", id), nil, nil)
+}
+
+// TriggerPipeline triggers a pipeline run. The request is sent with a new idempotency key
+// and retried on network errors, without the risk of triggering duplicate runs.
 func (c *Client) TriggerPipeline(ctx context.Context, id string, gitRef string) (*TriggerPipelineResponse, error) {
-	req := TriggerPipelineRequest{GitRef: gitRef}
-	var resp TriggerPipelineResponse
-	err := c.doRequest(ctx, http.MethodPost, fmt.Sprintf("/pipelines/%s/trigger", id), req, &resp)
+	return c.TriggerPipelineWithKey(ctx, id, gitRef, uuid.New().String())
+}
+
+// TriggerPipelineWithKey triggers a pipeline run with the given idempotency key. Calls with the
+// same key and git ref return the run of the first successful call, instead of triggering a new run.
+func (c *Client) TriggerPipelineWithKey(ctx context.Context, id, gitRef, key string) (*TriggerPipelineResponse, error) {
+	body, err := json.Marshal(TriggerPipelineRequest{GitRef: gitRef})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	var resp *http.Response
+	for attempt := 1; ; attempt++ {
+		var req *http.Request
+		req, err = c.newRequest(ctx, http.MethodPost, fmt.Sprintf("/pipelines/%s/trigger", id), bytes.NewReader(body), "application/json")
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(idempotencyKeyHeader, key)
+
+		resp, err = c.do(c.httpClient, req)
+		// Network errors are retried, as well as conflicts with a previous attempt which is still in progress.
+		// The server has responded to all other errors, so retrying them won't help.
+		var errResp *ErrorResponse
+		retry := err != nil && (!errors.As(err, &errResp) || errors.Is(err, ErrConflict))
+		if !retry || attempt == triggerAttempts || ctx.Err() != nil {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Duration(attempt) * triggerRetryDelay):
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
-	return &resp, nil
+	defer resp.Body.Close()
+
+	var trigger TriggerPipelineResponse
+	if err := json.NewDecoder(resp.Body).Decode(&trigger); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &trigger, nil
 }
 
 // ListRuns retrieves all pipeline runs
-func (c *Client) ListRuns(ctx context.Context) ([]pipelineRunResponse, error) {
-	var resp []pipelineRunResponse
+func (c *Client) ListRuns(ctx context.Context) ([]PipelineRunResponse, error) {
+	var resp []PipelineRunResponse
 	err := c.doRequest(ctx, http.MethodGet, "/runs", nil, &resp)
 	if err != nil {
 		return nil, err
@@ -115,8 +230,8 @@ func (c *Client) ListRuns(ctx context.Context) ([]pipelineRunResponse, error) {
 }
 
 // GetRun retrieves a pipeline run by ID
-func (c *Client) GetRun(ctx context.Context, id string) (*pipelineRunResponse, error) {
-	var resp pipelineRunResponse
+func (c *Client) GetRun(ctx context.Context, id string) (*PipelineRunResponse, error) {
+	var resp PipelineRunResponse
 	err := c.doRequest(ctx, http.MethodGet, fmt.Sprintf("/runs/%s", id), nil, &resp)
 	if err != nil {
 		return nil, err
@@ -124,6 +239,308 @@ func (c *Client) GetRun(ctx context.Context, id string) (*pipelineRunResponse, e
 	return &resp, nil
 }
 
+// DeleteRun deletes a finished pipeline run by ID
+func (c *Client) DeleteRun(ctx context.Context, id string) error {
+	return c.doRequest(ctx, http.MethodDelete, fmt.Sprintf("/runs/%s", id), nil, nil)
+}
+
+// CancelRun cancels a queued or running pipeline run
+func (c *Client) CancelRun(ctx context.Context, id string) error {
+	return c.doRequest(ctx, http.MethodPost, fmt.Sprintf("/runs/%s/cancel", id), nil, nil)
+}
+
+// Queue returns the queued pipeline runs in the order they will be executed
+func (c *Client) Queue(ctx context.Context) (*QueueResponse, error) {
+	var resp QueueResponse
+	if err := c.doRequest(ctx, http.MethodGet, "/admin/queue", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Export writes an archive of all pipelines and pipeline runs to w
+func (c *Client) Export(ctx context.Context, w io.Writer) error {
+	resp, err := c.send(ctx, http.MethodGet, "/admin/export", nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("failed to read export: %w", err)
+	}
+	return nil
+}
+
+// Import restores the pipelines and pipeline runs of an archive read from r.
+// The conflict mode is one of skip, overwrite or rename.
+func (c *Client) Import(ctx context.Context, r io.Reader, conflict string) (*ImportResponse, error) {
+	path := "/admin/import?conflict=" + url.QueryEscape(conflict)
+	resp, err := c.send(ctx, http.MethodPost, path, r, "application/x-ndjson")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result ImportResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &result, nil
+}
+
+// AuditQuery is used to filter the entries of ListAuditEntries. Empty fields match all entries.
+type AuditQuery struct {
+	Actor      string
+	Action     string
+	TargetType string
+	TargetID   string
+	Since      time.Time
+	Until      time.Time
+}
+
+// ListAuditEntries retrieves the audit log entries matching the query
+func (c *Client) ListAuditEntries(ctx context.Context, query AuditQuery) ([]AuditEntryResponse, error) {
+	params := url.Values{}
+	for name, value := range map[string]string{
+		"actor":       query.Actor,
+		"action":      query.Action,
+		"target_type": query.TargetType,
+		"target_id":   query.TargetID,
+	} {
+		if value != "" {
+			params.Set(name, value)
+		}
+	}
+	if !query.Since.IsZero() {
+		params.Set("since", query.Since.Format(time.RFC3339))
+	}
+	if !query.Until.IsZero() {
+		params.Set("until", query.Until.Format(time.RFC3339))
+	}
+
+	var resp []AuditEntryResponse
+	err := c.doRequest(ctx, http.MethodGet, "/audit?"+params.Encode(), nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// EventsQuery is used to select the events of WatchEvents
+type EventsQuery struct {
+	// Types are the event types to watch - empty means all types
+	Types []string
+	// PipelineID limits the events to a single pipeline and its runs
+	PipelineID string
+	// After resumes the stream after the event with this ID
+	After string
+}
+
+// WatchEvents is streaming events to handle until the context is cancelled, the server
+// closes the stream or handle returns an error. Returning ErrStopWatching from handle
+// ends the watch without an error.
+func (c *Client) WatchEvents(ctx context.Context, query EventsQuery, handle func(EventResponse) error) error {
+	return c.watchEvents(ctx, query, nil, handle)
+}
+
+// watchEvents is implementing WatchEvents. The optional connected function is called when the
+// server is streaming the events, so it can be used to read the state the events are applying to.
+func (c *Client) watchEvents(ctx context.Context, query EventsQuery, connected func() error, handle func(EventResponse) error) error {
+	params := url.Values{}
+	for _, t := range query.Types {
+		params.Add("type", t)
+	}
+	if query.PipelineID != "" {
+		params.Set("pipeline_id", query.PipelineID)
+	}
+	if query.After != "" {
+		params.Set("after", query.After)
+	}
+
+	req, err := c.newRequest(ctx, http.MethodGet, "/events?"+params.Encode(), nil, "")
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	// the stream is long-lived, so we can't use the client timeout
+	streamClient := *c.httpClient
+	streamClient.Timeout = 0
+	resp, err := c.do(&streamClient, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if connected != nil {
+		if err := connected(); err != nil {
+			if errors.Is(err, ErrStopWatching) {
+				return nil
+			}
+			return err
+		}
+	}
+
+	var data strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			// an empty line is dispatching the event
+			if data.Len() == 0 {
+				continue
+			}
+			var event EventResponse
+			if err := json.Unmarshal([]byte(data.String()), &event); err != nil {
+				return fmt.Errorf("failed to decode event: %w", err)
+			}
+			data.Reset()
+			if err := handle(event); err != nil {
+				if errors.Is(err, ErrStopWatching) {
+					return nil
+				}
+				return err
+			}
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("failed to read events: %w", err)
+	}
+	return ctx.Err()
+}
+
+// WatchRun calls handle with the run and again whenever the status of the run or one of its stages
+// changes, until the run is finished, the context is cancelled or handle returns an error. It returns
+// the finished run. The changes are streamed from the events endpoint. If the server doesn't support it,
+// the run is polled with conditional requests.
+func (c *Client) WatchRun(ctx context.Context, id string, handle func(*PipelineRunResponse) error) (*PipelineRunResponse, error) {
+	run, err := c.GetRun(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := handle(run); err != nil || run.Finished() {
+		return run, err
+	}
+
+	// update is reading the run and calls handle if it has changed
+	update := func() error {
+		latest, err := c.GetRun(ctx, id)
+		if err != nil {
+			return err
+		}
+		if reflect.DeepEqual(latest, run) {
+			return nil
+		}
+		run = latest
+		if err := handle(run); err != nil {
+			return err
+		}
+		if run.Finished() {
+			return ErrStopWatching
+		}
+		return nil
+	}
+
+	query := EventsQuery{
+		Types:      []string{string(domain.EventRunStatusChanged), string(domain.EventRunDeleted)},
+		PipelineID: run.PipelineID,
+	}
+	for {
+		// the run is read again when the stream is connected, so changes in between are not missed
+		err := c.watchEvents(ctx, query, update, func(event EventResponse) error {
+			if event.RunID != id {
+				return nil
+			}
+			if event.Type == string(domain.EventRunDeleted) {
+				return fmt.Errorf("%w: pipeline run %s was deleted", domain.ErrNotFound, id)
+			}
+			return update()
+		})
+		switch {
+		case errors.Is(err, ErrNotImplemented):
+			return c.pollRun(ctx, run, handle)
+		case err != nil:
+			return nil, err
+		case run.Finished():
+			return run, nil
+		}
+
+		// the server has closed the stream, reconnect after a delay
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(c.pollInterval):
+		}
+	}
+}
+
+// pollRun is polling the run until it is finished and calls handle for every change
+func (c *Client) pollRun(ctx context.Context, run *PipelineRunResponse, handle func(*PipelineRunResponse) error) (*PipelineRunResponse, error) {
+	etag := ""
+	ticker := time.NewTicker(c.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+
+		latest, latestETag, err := c.getRunIfNoneMatch(ctx, run.ID, etag)
+		if err != nil {
+			return nil, err
+		}
+		etag = latestETag
+		if latest == nil || reflect.DeepEqual(latest, run) {
+			continue
+		}
+		run = latest
+		if err := handle(run); err != nil {
+			return nil, err
+		}
+		if run.Finished() {
+			return run, nil
+		}
+	}
+}
+
+// getRunIfNoneMatch gets a pipeline run if its ETag is not matching etag.
+// It returns a nil run if the run has not been modified.
+func (c *Client) getRunIfNoneMatch(ctx context.Context, id, etag string) (*PipelineRunResponse, string, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/runs/"+id, nil, "")
+	if err != nil {
+		return nil, "", err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := c.do(c.httpClient, req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, etag, nil
+	}
+	var run PipelineRunResponse
+	if err := json.NewDecoder(resp.Body).Decode(&run); err != nil {
+		return nil, "", fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &run, resp.Header.Get("ETag"), nil
+}
+
+// maxErrorBodySize is the maximum number of bytes read from the body of an error response
+const maxErrorBodySize = 64 << 10
+
 // Generic request handler
 func (c *Client) doRequest(ctx context.Context, method, path string, body interface{}, response interface{}) error {
 	var reqBody []byte
@@ -136,37 +553,65 @@ func (c *Client) doRequest(ctx context.Context, method, path string, body interf
 		}
 	}
 
-	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bytes.NewBuffer(reqBody))
+	resp, err := c.send(ctx, method, path, bytes.NewBuffer(reqBody), "application/json")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if response != nil {
+		if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// send is sending a request and returns the response if it was successful.
+// The caller is responsible for closing the response body.
+func (c *Client) send(ctx context.Context, method, path string, body io.Reader, contentType string) (*http.Response, error) {
+	req, err := c.newRequest(ctx, method, path, body, contentType)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return nil, err
 	}
+	return c.do(c.httpClient, req)
+}
 
-	req.Header.Set("Content-Type", "application/json")
+// newRequest is creating a request for a versioned API path with the authorization header set
+func (c *Client) newRequest(ctx context.Context, method, path string, body io.Reader, contentType string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+apiPrefix+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
 	if c.token != "" {
 		req.Header.Set("Authorization", c.token)
 	}
+	// continue the trace of the caller on the server
+	propagation.TraceContext{}.Inject(ctx, propagation.HeaderCarrier(req.Header))
+	return req, nil
+}
 
-	resp, err := c.httpClient.Do(req)
+// do is sending the request with the given http client and turns error responses into
+// an *ErrorResponse, which can be checked with errors.Is and errors.As.
+func (c *Client) do(httpClient *http.Client, req *http.Request) (*http.Response, error) {
+	resp, err := httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
+		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode >= 400 {
-		var errResp struct {
-			Error string `json:"error"`
-		}
-		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
-			return fmt.Errorf("request failed with status %d", resp.StatusCode)
-		}
-		return fmt.Errorf("request failed with status %d: %s", resp.StatusCode, errResp.Error)
-	}
-
-	if response != nil {
-		if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
-			return fmt.Errorf("failed to decode response: %w", err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+		if err != nil {
+			return nil, fmt.Errorf("request failed with status %d: %w", resp.StatusCode, err)
 		}
+		return nil, errorFromResponse(resp.StatusCode, body)
 	}
 
-	return nil
+	return resp, nil
 }
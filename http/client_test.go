@@ -1,20 +1,25 @@
 package http
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/hphilipps/stagerunner/domain"
+	"github.com/hphilipps/stagerunner/store"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 func TestClient(t *testing.T) {
 	// Setup test server
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	server := httptest.NewServer(http.StripPrefix(apiPrefix, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Check authorization header
 		token := r.Header.Get("Authorization")
 		if token != "test-token" {
@@ -97,7 +102,7 @@ func TestClient(t *testing.T) {
 		case "/runs":
 			switch r.Method {
 			case http.MethodGet:
-				json.NewEncoder(w).Encode([]pipelineRunResponse{
+				json.NewEncoder(w).Encode([]PipelineRunResponse{
 					{
 						ID: "run-id",
 					},
@@ -107,15 +112,46 @@ func TestClient(t *testing.T) {
 		case "/runs/run-id":
 			switch r.Method {
 			case http.MethodGet:
-				json.NewEncoder(w).Encode(pipelineRunResponse{
+				json.NewEncoder(w).Encode(PipelineRunResponse{
 					ID: "run-id",
 				})
+			case http.MethodDelete:
+				w.WriteHeader(http.StatusNoContent)
+			}
+
+		case "/runs/run-id/cancel":
+			if r.Method != http.MethodPost {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			w.WriteHeader(http.StatusAccepted)
+
+		case "/admin/queue":
+			json.NewEncoder(w).Encode(QueueResponse{Length: 1, Runs: []QueuedRunResponse{{Position: 1, RunID: "run-id"}}})
+
+		case "/audit":
+			if r.URL.Query().Get("action") != "pipeline.delete" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode([]AuditEntryResponse{{ID: "audit-id", Action: "pipeline.delete"}})
+
+		case "/admin/export":
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.Write([]byte(`{"kind":"header","version":1}` + "\n"))
+
+		case "/admin/import":
+			if r.URL.Query().Get("conflict") != "rename" {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": "invalid conflict mode"})
+				return
 			}
+			json.NewEncoder(w).Encode(ImportResponse{Pipelines: 1, Renamed: 1})
 
 		default:
 			w.WriteHeader(http.StatusNotFound)
 		}
-	}))
+	})))
 	defer server.Close()
 
 	// Create client
@@ -132,10 +168,7 @@ func TestClient(t *testing.T) {
 			Name:       "test-pipeline",
 			Repository: "test-repo",
 			Stages: Stages{
-				RunStage: RunStage{
-					Command:       "go test ./...",
-					ContinueOnErr: false,
-				},
+				"run_stage": json.RawMessage(`{"command":"go test ./..."}`),
 			},
 		}
 
@@ -199,6 +232,47 @@ func TestClient(t *testing.T) {
 		assert.Equal(t, "run-id", resp.ID)
 	})
 
+	t.Run("DeleteRun", func(t *testing.T) {
+		err := client.DeleteRun(ctx, "run-id")
+		require.NoError(t, err)
+	})
+
+	t.Run("CancelRun", func(t *testing.T) {
+		err := client.CancelRun(ctx, "run-id")
+		require.NoError(t, err)
+	})
+
+	t.Run("Queue", func(t *testing.T) {
+		resp, err := client.Queue(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, resp.Length)
+		assert.Equal(t, "run-id", resp.Runs[0].RunID)
+	})
+
+	t.Run("ListAuditEntries", func(t *testing.T) {
+		resp, err := client.ListAuditEntries(ctx, AuditQuery{Action: "pipeline.delete", Since: time.Now()})
+		require.NoError(t, err)
+		assert.Len(t, resp, 1)
+		assert.Equal(t, "audit-id", resp[0].ID)
+	})
+
+	t.Run("Export", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := client.Export(ctx, &buf)
+		require.NoError(t, err)
+		assert.Equal(t, `{"kind":"header","version":1}`+"\n", buf.String())
+	})
+
+	t.Run("Import", func(t *testing.T) {
+		resp, err := client.Import(ctx, strings.NewReader("{}"), "rename")
+		require.NoError(t, err)
+		assert.Equal(t, &ImportResponse{Pipelines: 1, Renamed: 1}, resp)
+
+		_, err = client.Import(ctx, strings.NewReader("{}"), "merge")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid conflict mode")
+	})
+
 	t.Run("UnauthorizedRequest", func(t *testing.T) {
 		unauthorizedClient := NewClient(
 			server.URL,
@@ -214,6 +288,7 @@ func TestClient(t *testing.T) {
 		_, err := client.GetPipeline(ctx, "non-existent")
 		require.Error(t, err)
 		assert.Contains(t, err.Error(), "404")
+		assert.ErrorIs(t, err, domain.ErrNotFound)
 	})
 
 	t.Run("ClientTimeout", func(t *testing.T) {
@@ -232,3 +307,144 @@ func TestClient(t *testing.T) {
 		assert.Contains(t, err.Error(), "deadline exceeded")
 	})
 }
+
+func TestClient_Errors(t *testing.T) {
+	s := store.NewMemoryStore()
+	// the executor is not started, so the queue fills up
+	server := httptest.NewServer(NewAPI(s, domain.NewExecutor(s, 1, 1, 1, 0.0, 10*time.Millisecond)).SetupRouter())
+	defer server.Close()
+	client := NewClient(server.URL, WithToken("test-token"))
+	ctx := context.Background()
+
+	_, err := client.GetPipeline(ctx, "non-existent")
+	assert.ErrorIs(t, err, domain.ErrNotFound)
+
+	_, err = client.CreatePipeline(ctx, PipelineRequest{Name: "invalid", Stages: Stages{"run_stage": json.RawMessage(`{}`)}})
+	assert.ErrorIs(t, err, domain.ErrValidation)
+	var errResp *ErrorResponse
+	require.True(t, errors.As(err, &errResp))
+	assert.Equal(t, http.StatusUnprocessableEntity, errResp.Status)
+	assert.Equal(t, CodeValidationFailed, errResp.Code)
+	assert.Equal(t, "command", errResp.Details["field"])
+
+	pipeline := domain.NewPipeline("github.com/test/repo")
+	require.NoError(t, s.CreatePipeline(ctx, pipeline))
+	_, err = client.TriggerPipeline(ctx, pipeline.ID, "main")
+	require.NoError(t, err)
+	_, err = client.TriggerPipeline(ctx, pipeline.ID, "main")
+	assert.ErrorIs(t, err, domain.ErrQueueFull)
+	assert.False(t, errors.Is(err, domain.ErrPipelineQueueFull))
+}
+
+func TestClient_ValidatePipeline(t *testing.T) {
+	s := store.NewMemoryStore()
+	server := httptest.NewServer(NewAPI(s, domain.NewExecutor(s, 1, 1, 1, 0.0, 10*time.Millisecond)).SetupRouter())
+	defer server.Close()
+	client := NewClient(server.URL, WithToken("test-token"))
+	ctx := context.Background()
+
+	resp, err := client.ValidatePipeline(ctx, json.RawMessage(`{"name":"test","stages":{"run_stage":{"command":"make"},"deploy_stage":{"cluster":"prod"}}}`))
+	require.NoError(t, err)
+	assert.False(t, resp.Valid)
+	assert.Contains(t, resp.Errors, ValidationProblem{Path: "stages.build_stage", Message: "build stage is missing"})
+	assert.Contains(t, resp.Errors, ValidationProblem{Path: "stages.deploy_stage.cluster_name", Message: "cluster name is required for deploy stage"})
+	assert.Contains(t, resp.Warnings, ValidationProblem{Path: "stages.deploy_stage.cluster", Message: "unknown field is ignored"})
+
+	pipelines, err := client.ListPipelines(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, pipelines)
+}
+
+func TestClient_WatchRun(t *testing.T) {
+	s := store.NewMemoryStore()
+	executor := domain.NewExecutor(s, 2, 10, 5, 0.0, 10*time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go executor.Start(ctx)
+
+	succeeding := domain.NewPipeline("github.com/test/repo")
+	require.NoError(t, s.CreatePipeline(ctx, succeeding))
+	failing := domain.NewPipeline("github.com/test/failing")
+	// the deploy stage is failing validation
+	failing.Stages[domain.StageDeploy] = domain.NewDeployStage(domain.StageDeploy, "", "k8s/", false)
+	require.NoError(t, s.CreatePipeline(ctx, failing))
+
+	tests := []struct {
+		name  string
+		store domain.Store
+	}{
+		{name: "events", store: s},
+		// the store is not implementing domain.Watcher, so the run is polled
+		{name: "polling", store: struct{ domain.Store }{s}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(NewAPI(tt.store, executor).SetupRouter())
+			defer server.Close()
+			client := NewClient(server.URL, WithToken("test-token"), WithPollInterval(10*time.Millisecond))
+
+			for pipeline, wantStatus := range map[*domain.Pipeline]string{succeeding: domain.StatusSuccess, failing: domain.StatusFailed} {
+				triggered, err := client.TriggerPipeline(ctx, pipeline.ID, "main")
+				require.NoError(t, err)
+
+				var updates []PipelineRunResponse
+				run, err := client.WatchRun(ctx, triggered.ID, func(run *PipelineRunResponse) error {
+					updates = append(updates, *run)
+					return nil
+				})
+				require.NoError(t, err)
+				assert.Equal(t, wantStatus, run.Status)
+				assert.True(t, run.Finished())
+
+				require.NotEmpty(t, updates)
+				assert.Equal(t, *run, updates[len(updates)-1])
+				for i := 1; i < len(updates); i++ {
+					assert.NotEqual(t, updates[i-1], updates[i], "unchanged run was passed to handle")
+				}
+			}
+		})
+	}
+
+	t.Run("not found", func(t *testing.T) {
+		server := httptest.NewServer(NewAPI(s, executor).SetupRouter())
+		defer server.Close()
+		_, err := NewClient(server.URL, WithToken("test-token")).WatchRun(ctx, "non-existent", func(*PipelineRunResponse) error { return nil })
+		assert.ErrorIs(t, err, domain.ErrNotFound)
+	})
+}
+
+func TestApi_GetRunETag(t *testing.T) {
+	s := store.NewMemoryStore()
+	router := NewAPI(s, domain.NewExecutor(s, 1, 5, 2, 0.0, 10*time.Millisecond)).SetupRouter()
+	run := domain.NewPipelineRun("pipeline", "main")
+	require.NoError(t, s.CreatePipelineRun(context.Background(), run))
+
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/v1/runs/"+run.ID, nil)
+		req.Header.Set("Authorization", "test-token")
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("")
+	require.Equal(t, http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	w = get(etag)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+	assert.Equal(t, http.StatusNotModified, get(`"other", W/`+etag).Code)
+
+	// the ETag is changing with the run
+	updated := *run
+	updated.Status = domain.StatusRunning
+	require.NoError(t, s.UpdatePipelineRun(context.Background(), &updated))
+	w = get(etag)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+}
@@ -0,0 +1,193 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hphilipps/stagerunner/domain"
+)
+
+// ErrorCode is the machine readable code of an error response
+type ErrorCode string
+
+const (
+	CodeInvalidRequest       ErrorCode = "invalid_request"
+	CodeUnauthorized         ErrorCode = "unauthorized"
+	CodeNotFound             ErrorCode = "not_found"
+	CodeMethodNotAllowed     ErrorCode = "method_not_allowed"
+	CodeAlreadyExists        ErrorCode = "already_exists"
+	CodeConflict             ErrorCode = "conflict"
+	CodeIdempotencyKeyReused ErrorCode = "idempotency_key_reused"
+	CodeEventsExpired        ErrorCode = "events_expired"
+	CodeValidationFailed     ErrorCode = "validation_failed"
+	CodePipelineQueueFull    ErrorCode = "pipeline_queue_full"
+	CodeInternal             ErrorCode = "internal"
+	CodeNotImplemented       ErrorCode = "not_implemented"
+	CodeQueueFull            ErrorCode = "queue_full"
+)
+
+// Errors of the API which have no equivalent in the domain package
+var (
+	ErrInvalidRequest   = errors.New("invalid request")
+	ErrUnauthorized     = errors.New("unauthorized")
+	ErrMethodNotAllowed = errors.New("method not allowed")
+	ErrConflict         = errors.New("conflict")
+	// ErrIdempotencyKeyReused is returned if an idempotency key is reused for a different request
+	ErrIdempotencyKeyReused = errors.New("idempotency key reused")
+	ErrInternal             = errors.New("internal error")
+	ErrNotImplemented       = errors.New("not implemented")
+)
+
+// errorCodes maps the errors returned by the API to their error code and HTTP status
+var errorCodes = []struct {
+	err    error
+	code   ErrorCode
+	status int
+}{
+	{err: ErrInvalidRequest, code: CodeInvalidRequest, status: http.StatusBadRequest},
+	{err: ErrUnauthorized, code: CodeUnauthorized, status: http.StatusUnauthorized},
+	{err: domain.ErrNotFound, code: CodeNotFound, status: http.StatusNotFound},
+	{err: ErrMethodNotAllowed, code: CodeMethodNotAllowed, status: http.StatusMethodNotAllowed},
+	{err: domain.ErrAlreadyExists, code: CodeAlreadyExists, status: http.StatusConflict},
+	{err: ErrConflict, code: CodeConflict, status: http.StatusConflict},
+	{err: ErrIdempotencyKeyReused, code: CodeIdempotencyKeyReused, status: http.StatusConflict},
+	{err: domain.ErrEventsExpired, code: CodeEventsExpired, status: http.StatusGone},
+	{err: domain.ErrValidation, code: CodeValidationFailed, status: http.StatusUnprocessableEntity},
+	{err: domain.ErrPipelineQueueFull, code: CodePipelineQueueFull, status: http.StatusTooManyRequests},
+	{err: ErrInternal, code: CodeInternal, status: http.StatusInternalServerError},
+	{err: ErrNotImplemented, code: CodeNotImplemented, status: http.StatusNotImplemented},
+	{err: domain.ErrQueueFull, code: CodeQueueFull, status: http.StatusServiceUnavailable},
+}
+
+// ErrorResponse is used to construct an error response. The client is returning it as error
+// for failed requests, so callers can check the kind of error with errors.Is, e.g.
+//
+//	errors.Is(err, domain.ErrNotFound)
+type ErrorResponse struct {
+	// Status is the HTTP status code of the response
+	Status    int               `json:"-"`
+	Code      ErrorCode         `json:"code"`
+	Message   string            `json:"message"`
+	Details   map[string]string `json:"details,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+}
+
+func (e *ErrorResponse) Error() string {
+	if e.Status == 0 {
+		return fmt.Sprintf("%s: %s", e.Code, e.Message)
+	}
+	return fmt.Sprintf("request failed with status %d (%s): %s", e.Status, e.Code, e.Message)
+}
+
+// Is makes error responses match the error of their error code
+func (e *ErrorResponse) Is(target error) bool {
+	for _, c := range errorCodes {
+		if c.code == e.Code {
+			return target == c.err
+		}
+	}
+	return false
+}
+
+// newError is creating an error response of the given kind, which has to be one of the errors in errorCodes
+func newError(kind error, format string, args ...interface{}) *ErrorResponse {
+	e := &ErrorResponse{
+		Status:  http.StatusInternalServerError,
+		Code:    CodeInternal,
+		Message: fmt.Sprintf(format, args...),
+	}
+	for _, c := range errorCodes {
+		if c.err == kind {
+			e.Status, e.Code = c.status, c.code
+			break
+		}
+	}
+	return e
+}
+
+// withDetail adds a detail to the error response
+func (e *ErrorResponse) withDetail(key, value string) *ErrorResponse {
+	if e.Details == nil {
+		e.Details = make(map[string]string)
+	}
+	e.Details[key] = value
+	return e
+}
+
+// errorFromDomain is turning an error returned by the domain into an error response
+// by the domain error it wraps. Unknown errors are internal errors.
+func errorFromDomain(err error) *ErrorResponse {
+	var resp *ErrorResponse
+	if errors.As(err, &resp) {
+		return resp
+	}
+
+	for _, c := range errorCodes {
+		if errors.Is(err, c.err) {
+			resp = newError(c.err, "%s", err.Error())
+			break
+		}
+	}
+	if resp == nil {
+		resp = newError(ErrInternal, "%s", err.Error())
+	}
+
+	var validationErr *domain.ValidationError
+	if errors.As(err, &validationErr) {
+		resp.withDetail("stage", validationErr.Stage).withDetail("field", validationErr.Field)
+	}
+	return resp
+}
+
+// errorFromResponse is constructing an error from a failed response. Bodies which are not
+// an error response, e.g. from a proxy, are used as message. Their code is derived from the status
+// if only one code is using it, otherwise it stays empty, so the error doesn't match any kind.
+func errorFromResponse(status int, body []byte) *ErrorResponse {
+	var resp ErrorResponse
+	if err := json.Unmarshal(body, &resp); err != nil || resp.Code == "" {
+		resp = ErrorResponse{Message: strings.TrimSpace(string(body))}
+		matches := 0
+		for _, c := range errorCodes {
+			if c.status == status {
+				resp.Code = c.code
+				matches++
+			}
+		}
+		if matches != 1 {
+			resp.Code = ""
+		}
+	}
+	resp.Status = status
+	return &resp
+}
+
+// requestIDFromRequest returns the ID assigned to the request by requestIDMiddleware
+func requestIDFromRequest(r *http.Request) string {
+	return domain.RequestIDFromContext(r.Context())
+}
+
+// respondWithError is writing the error as error response. Errors which are not an
+// *ErrorResponse are mapped to an error code by the domain error they wrap.
+func respondWithError(w http.ResponseWriter, r *http.Request, err error) {
+	resp := errorFromDomain(err)
+	if resp.RequestID == "" {
+		resp.RequestID = requestIDFromRequest(r)
+	}
+	if resp.Status >= http.StatusInternalServerError {
+		domain.LoggerFromContext(r.Context()).Error("request failed", "method", r.Method, "path", r.URL.Path, "error", err)
+	}
+	respondWithJSON(w, resp.Status, resp)
+}
+
+// notFoundHandler is responding to requests of unknown routes with an error response
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	respondWithError(w, r, newError(domain.ErrNotFound, "No route for %s", r.URL.Path))
+}
+
+// methodNotAllowedHandler is responding to requests with a method not supported by the route
+func methodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	respondWithError(w, r, newError(ErrMethodNotAllowed, "Method %s is not allowed for %s", r.Method, r.URL.Path))
+}
@@ -0,0 +1,128 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hphilipps/stagerunner/domain"
+	"github.com/hphilipps/stagerunner/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApi_Errors(t *testing.T) {
+	s := store.NewMemoryStore()
+	// the executor is not started, so runs stay queued
+	api := NewAPI(s, domain.NewExecutor(s, 1, 2, 1, 0.0, 10*time.Millisecond))
+	router := api.SetupRouter()
+	ctx := context.Background()
+
+	pipeline1 := domain.NewPipeline("github.com/test/repo1")
+	require.NoError(t, s.CreatePipeline(ctx, pipeline1))
+	pipeline2 := domain.NewPipeline("github.com/test/repo2")
+	require.NoError(t, s.CreatePipeline(ctx, pipeline2))
+	running := domain.NewPipelineRun(pipeline1.ID, "main")
+	running.Status = domain.StatusRunning
+	require.NoError(t, s.CreatePipelineRun(ctx, running))
+
+	invalidStages := `{"name": "p1", "repository": "repo", "stages": {"run_stage": {"command": "make test"}, "build_stage": {}, "deploy_stage": {"cluster_name": "prod", "manifest_path": "k8s/"}}}`
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		token       string
+		body        string
+		wantStatus  int
+		wantCode    ErrorCode
+		wantDetails map[string]string
+	}{
+		{name: "unauthorized", method: http.MethodGet, path: "/v1/pipelines", wantStatus: http.StatusUnauthorized, wantCode: CodeUnauthorized},
+		{name: "unknown route", method: http.MethodGet, path: "/v1/unknown", token: "test-token", wantStatus: http.StatusNotFound, wantCode: CodeNotFound},
+		{name: "method not allowed", method: http.MethodPatch, path: "/v1/pipelines", token: "test-token", wantStatus: http.StatusMethodNotAllowed, wantCode: CodeMethodNotAllowed},
+		{name: "not found", method: http.MethodGet, path: "/v1/pipelines/unknown", token: "test-token", wantStatus: http.StatusNotFound, wantCode: CodeNotFound},
+		{name: "malformed body", method: http.MethodPost, path: "/v1/pipelines", token: "test-token", body: "{", wantStatus: http.StatusBadRequest, wantCode: CodeInvalidRequest},
+		{name: "invalid query", method: http.MethodGet, path: "/v1/runs?limit=-1", token: "test-token", wantStatus: http.StatusBadRequest, wantCode: CodeInvalidRequest},
+		{
+			name: "validation", method: http.MethodPost, path: "/v1/pipelines", token: "test-token", body: invalidStages,
+			wantStatus: http.StatusUnprocessableEntity, wantCode: CodeValidationFailed,
+			wantDetails: map[string]string{"stage": "build", "field": "dockerfile_path"},
+		},
+		{
+			name: "run not finished", method: http.MethodDelete, path: "/v1/runs/" + running.ID, token: "test-token",
+			wantStatus: http.StatusConflict, wantCode: CodeConflict,
+			wantDetails: map[string]string{"run_id": running.ID},
+		},
+		{name: "trigger", method: http.MethodPost, path: "/v1/pipelines/" + pipeline1.ID + "/trigger", token: "test-token", body: "{}", wantStatus: http.StatusAccepted},
+		{name: "pipeline queue full", method: http.MethodPost, path: "/v1/pipelines/" + pipeline1.ID + "/trigger", token: "test-token", body: "{}", wantStatus: http.StatusTooManyRequests, wantCode: CodePipelineQueueFull},
+		{name: "trigger other pipeline", method: http.MethodPost, path: "/v1/pipelines/" + pipeline2.ID + "/trigger", token: "test-token", body: "{}", wantStatus: http.StatusAccepted},
+		{name: "queue full", method: http.MethodPost, path: "/v1/pipelines/" + pipeline2.ID + "/trigger", token: "test-token", body: "{}", wantStatus: http.StatusServiceUnavailable, wantCode: CodeQueueFull},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, bytes.NewBufferString(tt.body))
+			if tt.token != "" {
+				req.Header.Set("Authorization", tt.token)
+			}
+			req.Header.Set("X-Request-ID", "req-1")
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			if tt.wantCode == "" {
+				return
+			}
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+			var resp ErrorResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			assert.Equal(t, tt.wantCode, resp.Code)
+			assert.NotEmpty(t, resp.Message)
+			assert.Equal(t, "req-1", resp.RequestID)
+			assert.Equal(t, tt.wantDetails, resp.Details)
+		})
+	}
+}
+
+func TestErrorResponse_Is(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr error
+	}{
+		{name: "not found", status: http.StatusNotFound, body: `{"code": "not_found", "message": "Pipeline not found"}`, wantErr: domain.ErrNotFound},
+		{name: "already exists", status: http.StatusConflict, body: `{"code": "already_exists", "message": "exists"}`, wantErr: domain.ErrAlreadyExists},
+		{name: "conflict", status: http.StatusConflict, body: `{"code": "conflict", "message": "not finished"}`, wantErr: ErrConflict},
+		{name: "validation", status: http.StatusUnprocessableEntity, body: `{"code": "validation_failed", "message": "invalid"}`, wantErr: domain.ErrValidation},
+		{name: "queue full", status: http.StatusServiceUnavailable, body: `{"code": "queue_full", "message": "full"}`, wantErr: domain.ErrQueueFull},
+		{name: "pipeline queue full", status: http.StatusTooManyRequests, body: `{"code": "pipeline_queue_full", "message": "full"}`, wantErr: domain.ErrPipelineQueueFull},
+		{name: "not an error response", status: http.StatusNotFound, body: "404 page not found", wantErr: domain.ErrNotFound},
+		// 409 is used by several codes, so the kind of the error is unknown
+		{name: "ambiguous status", status: http.StatusConflict, body: "409 conflict"},
+		{name: "unknown status", status: http.StatusBadGateway, body: "bad gateway"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := error(errorFromResponse(tt.status, []byte(tt.body)))
+
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			}
+			for _, c := range errorCodes {
+				if c.err != tt.wantErr {
+					assert.False(t, errors.Is(err, c.err), "unexpectedly matching %v", c.err)
+				}
+			}
+			assert.Contains(t, err.Error(), "status")
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hphilipps/stagerunner/domain"
+)
+
+// keepAliveInterval is the interval of comments sent to keep idle event streams open
+var keepAliveInterval = 15 * time.Second
+
+// EventResponse is used to construct a server-sent event
+type EventResponse struct {
+	// ID is the resume token of the event
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	Time       time.Time `json:"time"`
+	PipelineID string    `json:"pipeline_id,omitempty"`
+	RunID      string    `json:"run_id,omitempty"`
+	Status     string    `json:"status,omitempty"`
+}
+
+// createEventResponse is used to construct an event response from a domain event
+func createEventResponse(event domain.Event) EventResponse {
+	return EventResponse{
+		ID:         strconv.FormatUint(event.Sequence, 10),
+		Type:       string(event.Type),
+		Time:       event.Time,
+		PipelineID: event.PipelineID,
+		RunID:      event.RunID,
+		Status:     event.Status,
+	}
+}
+
+// watchFilterFromRequest parses the type, pipeline_id and after query parameters.
+// The Last-Event-ID header sent by reconnecting SSE clients takes precedence over after.
+func watchFilterFromRequest(r *http.Request) (domain.WatchFilter, error) {
+	filter := domain.WatchFilter{PipelineID: r.URL.Query().Get("pipeline_id")}
+	for _, t := range r.URL.Query()["type"] {
+		filter.Types = append(filter.Types, domain.EventType(t))
+	}
+
+	after := r.URL.Query().Get("after")
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		after = lastEventID
+	}
+	if after != "" {
+		seq, err := strconv.ParseUint(after, 10, 64)
+		if err != nil {
+			return filter, newError(ErrInvalidRequest, "invalid resume token: %q", after)
+		}
+		filter.After = seq
+	}
+	return filter, nil
+}
+
+// streamEvents is a handler streaming store changes as server-sent events
+func (api *API) streamEvents(w http.ResponseWriter, r *http.Request) {
+	watcher, ok := api.store.(domain.Watcher)
+	if !ok {
+		respondWithError(w, r, newError(ErrNotImplemented, "Store does not support watching for changes"))
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondWithError(w, r, newError(ErrInternal, "Streaming not supported"))
+		return
+	}
+
+	filter, err := watchFilterFromRequest(r)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	events, err := watcher.Watch(r.Context(), filter)
+	if err != nil {
+		// expired events are mapped to 410 Gone
+		respondWithError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-api.streamsClosed:
+			// the server is shutting down
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case event, ok := <-events:
+			if !ok {
+				// the watch was dropped, the client has to reconnect with the last event ID
+				return
+			}
+			data, err := json.Marshal(createEventResponse(event))
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Sequence, event.Type, data)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/hphilipps/stagerunner/domain"
+)
+
+// readinessTimeout is the maximum time the readiness checks may take
+const readinessTimeout = 2 * time.Second
+
+// HealthResponse is the response of the health and readiness checks
+type HealthResponse struct {
+	Status string `json:"status"`
+	// Checks are the results of the readiness checks, "ok" or the reason why the check failed
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// QueuedRunResponse is a run waiting in the queue
+type QueuedRunResponse struct {
+	// Position is the position of the run in the queue, starting at 1 for the next run to be executed
+	Position   int       `json:"position"`
+	RunID      string    `json:"run_id"`
+	PipelineID string    `json:"pipeline_id"`
+	GitRef     string    `json:"git_ref"`
+	QueuedAt   time.Time `json:"queued_at"`
+	// WaitSeconds is how long the run is waiting for a worker
+	WaitSeconds float64 `json:"wait_seconds"`
+}
+
+// QueueResponse is describing the queue of the executor
+type QueueResponse struct {
+	Length               int                 `json:"length"`
+	QueueSize            int                 `json:"queue_size"`
+	MaxQueuedPerPipeline int                 `json:"max_queued_per_pipeline"`
+	Workers              int                 `json:"workers"`
+	BusyWorkers          int                 `json:"busy_workers"`
+	PipelineCounts       map[string]int      `json:"pipeline_counts"`
+	Runs                 []QueuedRunResponse `json:"runs"`
+}
+
+// Drain is failing the readiness check, so load balancers stop sending requests before the server is shut down
+func (api *API) Drain() {
+	api.draining.Store(true)
+}
+
+// CloseStreams ends all event streams, which are not ended by http.Server.Shutdown as it waits for
+// their handlers. Clients reconnect with the ID of their last event. Register it with
+// http.Server.RegisterOnShutdown, so the listeners are closed already.
+func (api *API) CloseStreams() {
+	api.closeStreams.Do(func() { close(api.streamsClosed) })
+}
+
+// healthz is a handler for the liveness check, which succeeds as long as the server is responding
+func (api *API) healthz(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, HealthResponse{Status: "ok"})
+}
+
+// readyz is a handler for the readiness check, which fails if the store is not reachable,
+// the executor is not dispatching runs or the server is draining
+func (api *API) readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	checks := map[string]string{"store": "ok", "executor": "ok", "draining": "ok"}
+	ready := true
+	if _, err := api.store.ListPipelines(ctx, domain.ListOptions{Limit: 1}); err != nil {
+		checks["store"] = err.Error()
+		ready = false
+	}
+	if !api.executor.Running() {
+		checks["executor"] = "executor is not running"
+		ready = false
+	}
+	if api.draining.Load() {
+		checks["draining"] = "server is draining"
+		ready = false
+	}
+
+	if !ready {
+		respondWithJSON(w, http.StatusServiceUnavailable, HealthResponse{Status: "unavailable", Checks: checks})
+		return
+	}
+	respondWithJSON(w, http.StatusOK, HealthResponse{Status: "ready", Checks: checks})
+}
+
+// getQueue is a handler listing the queued runs in the order they will be executed
+func (api *API) getQueue(w http.ResponseWriter, r *http.Request) {
+	snapshot := api.executor.QueueSnapshot()
+	stats := api.executor.Stats()
+	now := time.Now()
+
+	resp := QueueResponse{
+		Length:               len(snapshot.Runs),
+		QueueSize:            snapshot.QueueSize,
+		MaxQueuedPerPipeline: snapshot.MaxQueuedPerPipeline,
+		Workers:              stats.Workers,
+		BusyWorkers:          stats.BusyWorkers,
+		PipelineCounts:       snapshot.PipelineCounts,
+		Runs:                 make([]QueuedRunResponse, 0, len(snapshot.Runs)),
+	}
+	for i, run := range snapshot.Runs {
+		resp.Runs = append(resp.Runs, QueuedRunResponse{
+			Position:    i + 1,
+			RunID:       run.RunID,
+			PipelineID:  run.PipelineID,
+			GitRef:      run.GitRef,
+			QueuedAt:    run.QueuedAt,
+			WaitSeconds: now.Sub(run.QueuedAt).Seconds(),
+		})
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
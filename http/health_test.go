@@ -0,0 +1,178 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hphilipps/stagerunner/domain"
+	"github.com/hphilipps/stagerunner/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// unreachableStore is a store failing to list pipelines
+type unreachableStore struct {
+	domain.Store
+}
+
+func (s unreachableStore) ListPipelines(ctx context.Context, opts domain.ListOptions) ([]*domain.Pipeline, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestApi_Health(t *testing.T) {
+	// get is sending an unauthenticated request and decodes the response
+	get := func(t *testing.T, router http.Handler, path string) (int, HealthResponse) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var resp HealthResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return w.Code, resp
+	}
+
+	s := store.NewMemoryStore()
+	executor := domain.NewExecutor(s, 1, 5, 2, 0.0, 10*time.Millisecond)
+	api := NewAPI(s, executor)
+	router := api.SetupRouter()
+
+	status, resp := get(t, router, "/healthz")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "ok", resp.Status)
+
+	t.Run("executor not running", func(t *testing.T) {
+		status, resp := get(t, router, "/readyz")
+		assert.Equal(t, http.StatusServiceUnavailable, status)
+		assert.Equal(t, "executor is not running", resp.Checks["executor"])
+		assert.Equal(t, "ok", resp.Checks["store"])
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		executor.Start(ctx)
+		close(stopped)
+	}()
+	require.Eventually(t, executor.Running, time.Second, time.Millisecond)
+
+	t.Run("ready", func(t *testing.T) {
+		status, resp := get(t, router, "/readyz")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "ready", resp.Status)
+		assert.Equal(t, map[string]string{"store": "ok", "executor": "ok", "draining": "ok"}, resp.Checks)
+	})
+
+	t.Run("store unreachable", func(t *testing.T) {
+		status, resp := get(t, NewAPI(unreachableStore{s}, executor).SetupRouter(), "/readyz")
+		assert.Equal(t, http.StatusServiceUnavailable, status)
+		assert.Equal(t, "connection refused", resp.Checks["store"])
+		assert.Equal(t, "ok", resp.Checks["executor"])
+	})
+
+	t.Run("draining", func(t *testing.T) {
+		api.Drain()
+		status, resp := get(t, router, "/readyz")
+		assert.Equal(t, http.StatusServiceUnavailable, status)
+		assert.Equal(t, "server is draining", resp.Checks["draining"])
+
+		// the server is still alive while draining
+		status, _ = get(t, router, "/healthz")
+		assert.Equal(t, http.StatusOK, status)
+	})
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("executor has not stopped")
+	}
+	assert.False(t, executor.Running())
+}
+
+func TestApi_Queue(t *testing.T) {
+	s := store.NewMemoryStore()
+	// the executor is not started, so runs stay queued
+	router := NewAPI(s, domain.NewExecutor(s, 2, 10, 5, 0.0, 10*time.Millisecond)).SetupRouter()
+	ctx := context.Background()
+
+	pipeline1 := domain.NewPipeline("github.com/test/repo1")
+	require.NoError(t, s.CreatePipeline(ctx, pipeline1))
+	pipeline2 := domain.NewPipeline("github.com/test/repo2")
+	require.NoError(t, s.CreatePipeline(ctx, pipeline2))
+
+	var runIDs []string
+	for _, id := range []string{pipeline1.ID, pipeline2.ID, pipeline1.ID} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/pipelines/"+id+"/trigger", bytes.NewBufferString(`{"git_ref": "main"}`))
+		req.Header.Set("Authorization", "test-token")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusAccepted, w.Code)
+
+		var resp TriggerPipelineResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		runIDs = append(runIDs, resp.ID)
+	}
+
+	t.Run("unauthorized", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/admin/queue", nil))
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/admin/queue", nil)
+	req.Header.Set("Authorization", "test-token")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resp QueueResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 3, resp.Length)
+	assert.Equal(t, 10, resp.QueueSize)
+	assert.Equal(t, 5, resp.MaxQueuedPerPipeline)
+	assert.Equal(t, 2, resp.Workers)
+	assert.Equal(t, 0, resp.BusyWorkers)
+	assert.Equal(t, map[string]int{pipeline1.ID: 2, pipeline2.ID: 1}, resp.PipelineCounts)
+
+	require.Len(t, resp.Runs, 3)
+	for i, run := range resp.Runs {
+		assert.Equal(t, i+1, run.Position)
+		assert.Equal(t, runIDs[i], run.RunID)
+		assert.Equal(t, "main", run.GitRef)
+		assert.Greater(t, run.WaitSeconds, 0.0)
+	}
+	assert.Equal(t, pipeline2.ID, resp.Runs[1].PipelineID)
+	// runs which have been queued earlier have been waiting longer
+	assert.GreaterOrEqual(t, resp.Runs[0].WaitSeconds, resp.Runs[2].WaitSeconds)
+}
+
+func TestApi_ShutdownWithEventStream(t *testing.T) {
+	s := store.NewMemoryStore()
+	api := NewAPI(s, domain.NewExecutor(s, 1, 5, 2, 0.0, 10*time.Millisecond))
+	server := httptest.NewServer(api.SetupRouter())
+	defer server.Close()
+	server.Config.RegisterOnShutdown(api.CloseStreams)
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/v1/events", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "test-token")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	require.NoError(t, server.Config.Shutdown(ctx))
+	assert.Less(t, time.Since(start), time.Second)
+	// the stream has been ended by the server
+	_, err = io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+}
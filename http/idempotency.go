@@ -0,0 +1,218 @@
+package http
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// idempotencyKeyHeader is the header of requests which are safe to retry
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotentReplayedHeader is set on responses which have been replayed for a retried request
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	// defaultIdempotencyTTL is how long responses are replayed for retried requests by default
+	defaultIdempotencyTTL = 24 * time.Hour
+	// maxIdempotencyKeyLength is the maximum length of an idempotency key
+	maxIdempotencyKeyLength = 255
+	// maxIdempotentBodySize is the maximum size of the body of an idempotent request
+	maxIdempotentBodySize = 1 << 20
+)
+
+// perRequestHeaders are the headers of a response which belong to the request, they are not replayed
+var perRequestHeaders = []string{requestIDHeader}
+
+// idempotencyRecord is the first response to a request with an idempotency key
+type idempotencyRecord struct {
+	key string
+	// fingerprint is a hash of the request, to detect reuse of a key for a different request
+	fingerprint string
+	// done is false while the first request is still being handled
+	done      bool
+	status    int
+	header    http.Header
+	body      []byte
+	expiresAt time.Time
+}
+
+// idempotencyCache is keeping the responses of requests with an idempotency key for a TTL.
+// As all records have the same TTL, they expire in the order they have been added.
+type idempotencyCache struct {
+	ttl     time.Duration
+	now     func() time.Time
+	records map[string]*list.Element
+	order   *list.List
+	mu      sync.Mutex
+}
+
+func newIdempotencyCache(ttl time.Duration) *idempotencyCache {
+	return &idempotencyCache{
+		ttl:     ttl,
+		now:     time.Now,
+		records: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// reserve returns the record of the key if there is one. Otherwise a new record is reserved
+// for the request, which has to be completed with complete or released with release.
+func (c *idempotencyCache) reserve(key, fingerprint string) (record idempotencyRecord, reserved bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expire()
+	if e, ok := c.records[key]; ok {
+		return *e.Value.(*idempotencyRecord), false
+	}
+
+	r := &idempotencyRecord{key: key, fingerprint: fingerprint, expiresAt: c.now().Add(c.ttl)}
+	c.records[key] = c.order.PushBack(r)
+	return *r, true
+}
+
+// complete stores the response of a reserved request
+func (c *idempotencyCache) complete(key string, status int, header http.Header, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.records[key]; ok {
+		r := e.Value.(*idempotencyRecord)
+		r.done, r.status, r.header, r.body = true, status, header, body
+	}
+}
+
+// release forgets a reserved request, so it can be retried
+func (c *idempotencyCache) release(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.records[key]; ok {
+		c.order.Remove(e)
+		delete(c.records, key)
+	}
+}
+
+// expire removes all expired records. The caller has to hold the lock.
+func (c *idempotencyCache) expire() {
+	now := c.now()
+	for e := c.order.Front(); e != nil; e = c.order.Front() {
+		r := e.Value.(*idempotencyRecord)
+		if now.Before(r.expiresAt) {
+			return
+		}
+		c.order.Remove(e)
+		delete(c.records, r.key)
+	}
+}
+
+// responseRecorder is a ResponseWriter keeping a copy of the response
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// requestFingerprint is hashing the operation, its path variables and the body of a request
+func requestFingerprint(operationID string, vars map[string]string, body []byte) string {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	h.Write([]byte(operationID))
+	for _, name := range names {
+		h.Write([]byte("\x00" + name + "=" + vars[name]))
+	}
+	h.Write([]byte("\x00"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// idempotent is wrapping a handler, so requests with an Idempotency-Key header are only handled once.
+// Successful responses are replayed for retries with the same key and body within the TTL, with the
+// request ID of the retry.
+// Failed requests are not recorded, so they can be retried with the same key.
+// Requests are identified by the ID of their operation instead of their path, so retries
+// of requests to the legacy routes match the ones to the /v1 routes.
+func (api *API) idempotent(operationID string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			respondWithError(w, r, newError(ErrInvalidRequest, "%s must not be longer than %d characters", idempotencyKeyHeader, maxIdempotencyKeyLength))
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBodySize))
+		if err != nil {
+			respondWithError(w, r, newError(ErrInvalidRequest, "failed to read request body: %s", err))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		// keys are scoped to the caller, so callers can't replay the responses of others
+		scopedKey := actorFromContext(r.Context()) + "\x00" + key
+		fingerprint := requestFingerprint(operationID, mux.Vars(r), body)
+
+		record, reserved := api.idempotency.reserve(scopedKey, fingerprint)
+		if !reserved {
+			switch {
+			case record.fingerprint != fingerprint:
+				respondWithError(w, r, newError(ErrIdempotencyKeyReused, "%s has already been used for a different request", idempotencyKeyHeader).
+					withDetail("idempotency_key", key))
+			case !record.done:
+				respondWithError(w, r, newError(ErrConflict, "A request with this %s is still in progress", idempotencyKeyHeader).
+					withDetail("idempotency_key", key))
+			default:
+				for k, v := range record.header {
+					w.Header()[k] = v
+				}
+				w.Header().Set(idempotentReplayedHeader, "true")
+				w.WriteHeader(record.status)
+				w.Write(record.body)
+			}
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			if p := recover(); p != nil {
+				api.idempotency.release(scopedKey)
+				panic(p)
+			}
+			if rec.status >= 200 && rec.status < 300 {
+				header := w.Header().Clone()
+				for _, k := range perRequestHeaders {
+					header.Del(k)
+				}
+				api.idempotency.complete(scopedKey, rec.status, header, rec.body.Bytes())
+				return
+			}
+			api.idempotency.release(scopedKey)
+		}()
+		next(rec, r)
+	}
+}
@@ -0,0 +1,207 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hphilipps/stagerunner/domain"
+	"github.com/hphilipps/stagerunner/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotencyCache(t *testing.T) {
+	now := time.Now()
+	c := newIdempotencyCache(time.Hour)
+	c.now = func() time.Time { return now }
+
+	_, reserved := c.reserve("key1", "fp1")
+	assert.True(t, reserved)
+
+	// still in progress
+	record, reserved := c.reserve("key1", "fp1")
+	assert.False(t, reserved)
+	assert.False(t, record.done)
+
+	c.complete("key1", http.StatusAccepted, http.Header{}, []byte("body"))
+	record, reserved = c.reserve("key1", "fp2")
+	assert.False(t, reserved)
+	assert.True(t, record.done)
+	assert.Equal(t, "fp1", record.fingerprint)
+	assert.Equal(t, http.StatusAccepted, record.status)
+	assert.Equal(t, []byte("body"), record.body)
+
+	// released keys can be reserved again
+	_, reserved = c.reserve("key2", "fp1")
+	assert.True(t, reserved)
+	c.release("key2")
+	_, reserved = c.reserve("key2", "fp1")
+	assert.True(t, reserved)
+
+	// expired keys can be reserved again
+	now = now.Add(time.Hour)
+	_, reserved = c.reserve("key1", "fp2")
+	assert.True(t, reserved)
+	assert.Len(t, c.records, 1)
+	assert.Equal(t, 1, c.order.Len())
+}
+
+func TestApi_Idempotency(t *testing.T) {
+	s := store.NewMemoryStore()
+	router := NewAPI(s, domain.NewExecutor(s, 1, 10, 10, 0.0, 10*time.Millisecond)).SetupRouter()
+	ctx := context.Background()
+
+	pipeline := domain.NewPipeline("github.com/test/repo")
+	require.NoError(t, s.CreatePipeline(ctx, pipeline))
+
+	trigger := func(pipelineID, token, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/pipelines/"+pipelineID+"/trigger", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", token)
+		if key != "" {
+			req.Header.Set(idempotencyKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	runCount := func() int {
+		runs, err := s.ListPipelineRuns(ctx, domain.ListOptions{})
+		require.NoError(t, err)
+		return len(runs)
+	}
+
+	first := trigger(pipeline.ID, "alice", "key-1", `{"git_ref": "main"}`)
+	require.Equal(t, http.StatusAccepted, first.Code)
+	assert.Empty(t, first.Header().Get(idempotentReplayedHeader))
+	assert.Equal(t, 1, runCount())
+
+	t.Run("replay", func(t *testing.T) {
+		w := trigger(pipeline.ID, "alice", "key-1", `{"git_ref": "main"}`)
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		assert.Equal(t, "true", w.Header().Get(idempotentReplayedHeader))
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		assert.JSONEq(t, first.Body.String(), w.Body.String())
+		assert.Equal(t, 1, runCount())
+		// the request ID of the first request is not replayed
+		assert.NotEmpty(t, w.Header().Get(requestIDHeader))
+		assert.NotEqual(t, first.Header().Get(requestIDHeader), w.Header().Get(requestIDHeader))
+	})
+
+	t.Run("replay with request ID", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/v1/pipelines/"+pipeline.ID+"/trigger", bytes.NewBufferString(`{"git_ref": "main"}`))
+		req.Header.Set("Authorization", "alice")
+		req.Header.Set(idempotencyKeyHeader, "key-1")
+		req.Header.Set(requestIDHeader, "retry-1")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, "true", w.Header().Get(idempotentReplayedHeader))
+		assert.Equal(t, []string{"retry-1"}, w.Header().Values(requestIDHeader))
+	})
+
+	t.Run("replay on legacy route", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/pipelines/"+pipeline.ID+"/trigger", bytes.NewBufferString(`{"git_ref": "main"}`))
+		req.Header.Set("Authorization", "alice")
+		req.Header.Set(idempotencyKeyHeader, "key-1")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		assert.Equal(t, "true", w.Header().Get(idempotentReplayedHeader))
+		assert.Equal(t, 1, runCount())
+	})
+
+	t.Run("different pipeline", func(t *testing.T) {
+		other := domain.NewPipeline("github.com/test/other")
+		require.NoError(t, s.CreatePipeline(ctx, other))
+		w := trigger(other.ID, "alice", "key-1", `{"git_ref": "main"}`)
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Equal(t, 1, runCount())
+	})
+
+	t.Run("different body", func(t *testing.T) {
+		w := trigger(pipeline.ID, "alice", "key-1", `{"git_ref": "dev"}`)
+		assert.Equal(t, http.StatusConflict, w.Code)
+		var resp ErrorResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, CodeIdempotencyKeyReused, resp.Code)
+		assert.Equal(t, 1, runCount())
+	})
+
+	t.Run("different caller", func(t *testing.T) {
+		w := trigger(pipeline.ID, "bob", "key-1", `{"git_ref": "main"}`)
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		assert.Empty(t, w.Header().Get(idempotentReplayedHeader))
+		assert.Equal(t, 2, runCount())
+	})
+
+	t.Run("without key", func(t *testing.T) {
+		assert.Equal(t, http.StatusAccepted, trigger(pipeline.ID, "alice", "", `{"git_ref": "main"}`).Code)
+		assert.Equal(t, http.StatusAccepted, trigger(pipeline.ID, "alice", "", `{"git_ref": "main"}`).Code)
+		assert.Equal(t, 4, runCount())
+	})
+
+	t.Run("failed requests are not replayed", func(t *testing.T) {
+		w := trigger("unknown", "alice", "key-2", `{"git_ref": "main"}`)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		w = trigger("unknown", "alice", "key-2", `{"git_ref": "main"}`)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Empty(t, w.Header().Get(idempotentReplayedHeader))
+	})
+}
+
+func TestClient_TriggerPipelineRetry(t *testing.T) {
+	var keys []string
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		keys = append(keys, r.Header.Get(idempotencyKeyHeader))
+		attempt := len(keys)
+		mu.Unlock()
+
+		if attempt == 1 {
+			// drop the connection to simulate a network error
+			panic(http.ErrAbortHandler)
+		}
+		respondWithJSON(w, http.StatusAccepted, TriggerPipelineResponse{ID: "run-1"})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, WithToken("test-token"))
+	resp, err := client.TriggerPipeline(context.Background(), "pipeline-1", "main")
+	require.NoError(t, err)
+	assert.Equal(t, "run-1", resp.ID)
+
+	require.Len(t, keys, 2)
+	assert.NotEmpty(t, keys[0])
+	assert.Equal(t, keys[0], keys[1])
+
+	// every call is using a new key
+	_, err = client.TriggerPipeline(context.Background(), "pipeline-1", "main")
+	require.NoError(t, err)
+	require.Len(t, keys, 3)
+	assert.NotEqual(t, keys[0], keys[2])
+
+	t.Run("conflict without error response", func(t *testing.T) {
+		var attempts atomic.Int32
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts.Add(1)
+			http.Error(w, "conflict", http.StatusConflict)
+		}))
+		defer proxy.Close()
+
+		// the status alone doesn't tell that the request is still in progress, so it's not retried
+		_, err := NewClient(proxy.URL, WithToken("test-token")).TriggerPipeline(context.Background(), "pipeline-1", "main")
+		assert.Error(t, err)
+		assert.False(t, errors.Is(err, ErrConflict))
+		assert.Equal(t, int32(1), attempts.Load())
+	})
+}
@@ -2,19 +2,184 @@ package http
 
 import (
 	"context"
-	"log"
 	"net/http"
 	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/hphilipps/stagerunner/domain"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// contextKey is the type of the keys for values added to the request context
+type contextKey string
+
+const (
+	// tokenContextKey is the context key of the authorization token
+	tokenContextKey contextKey = "token"
+	// identityContextKey is the context key of the identity of a client certificate
+	identityContextKey contextKey = "identity"
 )
 
-// loggingMiddleware is a middleware that logs the request method, path, and duration
-func loggingMiddleware(next http.Handler) http.Handler {
+// tokenFromContext returns the authorization token added to the context by authMiddleware
+func tokenFromContext(ctx context.Context) string {
+	token, _ := ctx.Value(tokenContextKey).(string)
+	return token
+}
+
+// requestIDHeader is the header carrying the ID of a request
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the maximum length of request IDs sent by callers
+const maxRequestIDLength = 128
+
+// validRequestID returns true if the request ID sent by a caller is safe to use in logs and headers
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDMiddleware is a middleware that assigns an ID to every request. A valid X-Request-ID header
+// of the caller is used, otherwise a new ID is generated. The ID is returned in the X-Request-ID header
+// and added to the context, together with a logger carrying the request ID.
+func (api *API) requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.New().String()
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		ctx := domain.ContextWithRequestID(r.Context(), id)
+		ctx = domain.ContextWithLogger(ctx, api.logger.With("request_id", id))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// statusRecorder is a ResponseWriter remembering the status code and size of the response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// Flush is supporting streaming responses like server-sent events
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// loggingMiddleware is a middleware that writes an access log line for every request
+func (api *API) loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		defer func() {
-			log.Printf("%s %s %v", r.Method, r.URL.Path, time.Since(start))
-		}()
-		next.ServeHTTP(w, r)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		attrs := []any{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"bytes", rec.bytes,
+			"duration", time.Since(start),
+			"remote_addr", r.RemoteAddr,
+		}
+		if route := routeTemplate(r); route != "" {
+			attrs = append(attrs, "route", route)
+		}
+		domain.LoggerFromContext(r.Context()).Info("request", attrs...)
+	})
+}
+
+// routeTemplate returns the path template of the route matching the request or an empty string for unmatched requests
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tmpl, err := route.GetPathTemplate(); err == nil {
+			return tmpl
+		}
+	}
+	return ""
+}
+
+// tracerName is the instrumentation scope of the spans created by the API
+const tracerName = "github.com/hphilipps/stagerunner/http"
+
+// tracingMiddleware is a middleware that records a server span for every request. The W3C trace
+// context of the caller is used as parent, so the spans are part of the trace of the caller.
+// The trace ID is added to the logger of the request.
+func (api *API) tracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagation.TraceContext{}.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		route := routeTemplate(r)
+		name := r.Method
+		if route != "" {
+			name += " " + route
+		}
+		ctx, span := api.tracer.Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("http.route", route),
+				attribute.String("request.id", domain.RequestIDFromContext(ctx)),
+			))
+		defer span.End()
+
+		if sc := span.SpanContext(); sc.HasTraceID() {
+			ctx = domain.ContextWithLogger(ctx, domain.LoggerFromContext(ctx).With("trace_id", sc.TraceID().String()))
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.response.status_code", rec.status))
+		if rec.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	})
+}
+
+// unmatchedRoute is the route label of the metrics of requests which don't match any route
+const unmatchedRoute = "unmatched"
+
+// metricsMiddleware is a middleware that records the number and latency of requests by route
+func (api *API) metricsMiddleware(next http.Handler) http.Handler {
+	if api.metrics == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		route := routeTemplate(r)
+		if route == "" {
+			route = unmatchedRoute
+		}
+		api.metrics.ObserveRequest(r.Method, route, rec.status, time.Since(start))
 	})
 }
 
@@ -26,20 +191,52 @@ func rateLimitMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// authMiddleware is a middleware that checks for an authorization header
-func authMiddleware(next http.Handler) http.Handler {
+// authMiddleware is a middleware that authenticates requests with a verified client certificate
+// or an authorization header
+func (api *API) authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Check for auth token in header
-		token := r.Header.Get("Authorization")
-		if token == "" {
-			respondWithError(w, http.StatusUnauthorized, "Missing authorization token")
+		ctx, err := api.authenticate(r)
+		if err != nil {
+			respondWithError(w, r, err)
 			return
 		}
-
-		// TODO: Implement token validation
-
-		// add token to context
-		ctx := context.WithValue(r.Context(), "token", token)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// authenticate returns the context of the request with the identity of its client certificate
+// or its authorization token added, or an error if the request is not authenticated
+func (api *API) authenticate(r *http.Request) (context.Context, error) {
+	// clients with a certificate verified by the TLS handshake are authenticated by their subject
+	if identity, ok := api.certIdentity(r); ok {
+		return context.WithValue(r.Context(), identityContextKey, identity), nil
+	}
+
+	// Check for auth token in header
+	token := r.Header.Get("Authorization")
+	if token == "" {
+		return nil, newError(ErrUnauthorized, "Missing authorization token")
+	}
+
+	// TODO: Implement token validation
+
+	// add token to context
+	return context.WithValue(r.Context(), tokenContextKey, token), nil
+}
+
+// certIdentity returns the identity of the verified client certificate of the request. The subject
+// (e.g. "CN=ci,O=acme") or common name of the certificate is mapped to an identity with the client
+// identities of the API, certificates which are not mapped have the identity "cert:<common name>".
+func (api *API) certIdentity(r *http.Request) (string, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	subject := r.TLS.VerifiedChains[0][0].Subject
+	if identity, ok := api.clientIdentities[subject.String()]; ok {
+		return identity, true
+	}
+	if identity, ok := api.clientIdentities[subject.CommonName]; ok {
+		return identity, true
+	}
+	return "cert:" + subject.CommonName, true
+}
@@ -0,0 +1,185 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hphilipps/stagerunner/domain"
+	"github.com/hphilipps/stagerunner/metrics"
+	"github.com/hphilipps/stagerunner/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestApi_RequestID(t *testing.T) {
+	s := store.NewMemoryStore()
+	router := NewAPI(s, domain.NewExecutor(s, 1, 10, 10, 0.0, 10*time.Millisecond)).SetupRouter()
+	ctx := context.Background()
+
+	pipeline := domain.NewPipeline("github.com/test/repo")
+	require.NoError(t, s.CreatePipeline(ctx, pipeline))
+
+	tests := []struct {
+		name      string
+		requestID string
+		wantID    string
+	}{
+		{name: "honoured", requestID: "req-1", wantID: "req-1"},
+		{name: "generated", requestID: ""},
+		{name: "invalid", requestID: "req 1\n"},
+		{name: "too long", requestID: strings.Repeat("a", maxRequestIDLength+1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/pipelines/"+pipeline.ID+"/trigger", bytes.NewBufferString(`{"git_ref": "main"}`))
+			req.Header.Set("Authorization", "test-token")
+			if tt.requestID != "" {
+				req.Header.Set(requestIDHeader, tt.requestID)
+			}
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, http.StatusAccepted, w.Code)
+			id := w.Header().Get(requestIDHeader)
+			if tt.wantID != "" {
+				assert.Equal(t, tt.wantID, id)
+			} else {
+				assert.True(t, validRequestID(id))
+				assert.NotEqual(t, tt.requestID, id)
+			}
+
+			// the run remembers the request which triggered it
+			var resp TriggerPipelineResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			run, err := s.GetPipelineRun(ctx, resp.ID)
+			require.NoError(t, err)
+			assert.Equal(t, id, run.RequestID)
+		})
+	}
+}
+
+func TestApi_AccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	s := store.NewMemoryStore()
+	router := NewAPI(s, domain.NewExecutor(s, 1, 10, 10, 0.0, 10*time.Millisecond), WithLogger(logger)).SetupRouter()
+
+	for _, path := range []string{"/v1/pipelines", "/v1/unknown"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "test-token")
+		req.Header.Set(requestIDHeader, "req-"+strings.TrimPrefix(path, "/v1/"))
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, "request", entry["msg"])
+	assert.Equal(t, "req-pipelines", entry["request_id"])
+	assert.Equal(t, http.MethodGet, entry["method"])
+	assert.Equal(t, "/v1/pipelines", entry["path"])
+	assert.Equal(t, "/v1/pipelines", entry["route"])
+	assert.Equal(t, float64(http.StatusOK), entry["status"])
+	assert.Contains(t, entry, "duration")
+
+	// unmatched requests are logged as well
+	entry = nil
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+	assert.Equal(t, "req-unknown", entry["request_id"])
+	assert.Equal(t, float64(http.StatusNotFound), entry["status"])
+	assert.NotContains(t, entry, "route")
+}
+
+func TestApi_Metrics(t *testing.T) {
+	s := store.NewMemoryStore()
+	m := metrics.New()
+	executor := domain.NewExecutor(s, 2, 10, 10, 0.0, 10*time.Millisecond, domain.WithMetrics(m))
+	m.RegisterExecutor(executor)
+	router := NewAPI(s, executor, WithMetrics(m)).SetupRouter()
+
+	pipeline := domain.NewPipeline("github.com/test/repo")
+	require.NoError(t, s.CreatePipeline(context.Background(), pipeline))
+
+	for _, path := range []string{"/v1/pipelines/" + pipeline.ID, "/v1/pipelines/unknown", "/v1/unknown"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "test-token")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/v1/pipelines/"+pipeline.ID+"/trigger", bytes.NewBufferString(`{"git_ref": "main"}`))
+	req.Header.Set("Authorization", "test-token")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	// metrics are served without authorization
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+
+	for _, line := range []string{
+		`stagerunner_http_requests_total{code="200",method="GET",route="/v1/pipelines/{id}"} 1`,
+		`stagerunner_http_requests_total{code="404",method="GET",route="/v1/pipelines/{id}"} 1`,
+		`stagerunner_http_requests_total{code="404",method="GET",route="unmatched"} 1`,
+		`stagerunner_http_requests_total{code="202",method="POST",route="/v1/pipelines/{id}/trigger"} 1`,
+		`stagerunner_queue_length 1`,
+		`stagerunner_queue_pipeline_runs{pipeline_id="` + pipeline.ID + `"} 1`,
+		`stagerunner_workers{state="idle"} 2`,
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+}
+
+func TestApi_Tracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	s := store.NewMemoryStore()
+	executor := domain.NewExecutor(s, 1, 10, 10, 0.0, 10*time.Millisecond, domain.WithTracerProvider(tp))
+	server := httptest.NewServer(NewAPI(s, executor, WithTracerProvider(tp)).SetupRouter())
+	defer server.Close()
+
+	pipeline := domain.NewPipeline("github.com/test/repo")
+	require.NoError(t, s.CreatePipeline(context.Background(), pipeline))
+
+	// the client is propagating the trace context of the caller
+	ctx, caller := tp.Tracer("test").Start(context.Background(), "caller")
+	resp, err := NewClient(server.URL, WithToken("test-token")).TriggerPipeline(ctx, pipeline.ID, "main")
+	caller.End()
+	require.NoError(t, err)
+
+	var serverSpan, triggerSpan sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		switch span.Name() {
+		case "POST /v1/pipelines/{id}/trigger":
+			serverSpan = span
+		case "trigger pipeline":
+			triggerSpan = span
+		}
+	}
+	require.NotNil(t, serverSpan)
+	require.NotNil(t, triggerSpan)
+
+	assert.Equal(t, trace.SpanKindServer, serverSpan.SpanKind())
+	assert.Equal(t, caller.SpanContext().TraceID(), serverSpan.SpanContext().TraceID())
+	assert.Equal(t, caller.SpanContext().SpanID(), serverSpan.Parent().SpanID())
+	assert.True(t, serverSpan.Parent().IsRemote())
+	assert.Contains(t, serverSpan.Attributes(), attribute.Int("http.response.status_code", http.StatusAccepted))
+	assert.Equal(t, serverSpan.SpanContext().SpanID(), triggerSpan.Parent().SpanID())
+
+	run, err := s.GetPipelineRun(context.Background(), resp.ID)
+	require.NoError(t, err)
+	assert.Contains(t, run.TraceParent, caller.SpanContext().TraceID().String())
+}
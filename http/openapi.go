@@ -0,0 +1,293 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// operation describes a single API endpoint. The router and the OpenAPI document
+// are both generated from the list of operations, so they can't drift apart.
+type operation struct {
+	id      string
+	method  string
+	path    string
+	summary string
+	handler http.HandlerFunc
+	query   []parameter
+	// request is a value of the JSON request body type, nil if there is no JSON body
+	request interface{}
+	// requestContentType is the content type of a non-JSON request body
+	requestContentType string
+	responses          []response
+	// idempotent operations replay their response for retries with the same Idempotency-Key header
+	idempotent bool
+	// readOnly operations don't change anything, so they are not audited even if they are no GET requests
+	readOnly bool
+	// security are the alternative security schemes of the operation, the token scheme if it is empty.
+	// Operations with their own schemes are routed without the auth middleware and authenticate requests themselves.
+	security []string
+}
+
+// parameter describes a query parameter of an operation
+type parameter struct {
+	name        string
+	typ         string
+	format      string
+	repeated    bool
+	description string
+}
+
+// response describes a successful response of an operation
+type response struct {
+	status int
+	// body is a value of the response body type, nil if there is no body or it isn't described
+	body        interface{}
+	contentType string
+}
+
+// pathParamRegexp matches the path variables of a route template
+var pathParamRegexp = regexp.MustCompile(`{([^}]+)}`)
+
+// openAPIDocument returns an OpenAPI 3.0 document describing the operations
+func openAPIDocument(ops []operation) map[string]interface{} {
+	schemas := make(map[string]interface{})
+	paths := make(map[string]map[string]interface{})
+
+	for _, op := range ops {
+		var params []interface{}
+		for _, m := range pathParamRegexp.FindAllStringSubmatch(op.path, -1) {
+			params = append(params, map[string]interface{}{
+				"name":     m[1],
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "string"},
+			})
+		}
+		for _, p := range op.query {
+			schema := map[string]interface{}{"type": p.typ}
+			if p.format != "" {
+				schema["format"] = p.format
+			}
+			if p.repeated {
+				schema = map[string]interface{}{"type": "array", "items": schema}
+			}
+			param := map[string]interface{}{"name": p.name, "in": "query", "schema": schema}
+			if p.description != "" {
+				param["description"] = p.description
+			}
+			params = append(params, param)
+		}
+
+		if op.idempotent {
+			params = append(params, map[string]interface{}{
+				"name":        idempotencyKeyHeader,
+				"in":          "header",
+				"description": "Retries with the same key and body get the response of the first successful request replayed",
+				"schema":      map[string]interface{}{"type": "string", "maxLength": maxIdempotencyKeyLength},
+			})
+		}
+
+		responses := map[string]interface{}{
+			"default": map[string]interface{}{
+				"description": "Error",
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": schemaFor(reflect.TypeOf(ErrorResponse{}), schemas)},
+				},
+			},
+		}
+		for _, resp := range op.responses {
+			r := map[string]interface{}{"description": http.StatusText(resp.status)}
+			contentType := resp.contentType
+			if contentType == "" && resp.body != nil {
+				contentType = "application/json"
+			}
+			if contentType != "" {
+				media := map[string]interface{}{}
+				if resp.body != nil {
+					media["schema"] = schemaFor(reflect.TypeOf(resp.body), schemas)
+				}
+				r["content"] = map[string]interface{}{contentType: media}
+			}
+			responses[strconv.Itoa(resp.status)] = r
+		}
+
+		o := map[string]interface{}{
+			"operationId": op.id,
+			"summary":     op.summary,
+			"responses":   responses,
+		}
+		if len(params) > 0 {
+			o["parameters"] = params
+		}
+		if len(op.security) > 0 {
+			var security []interface{}
+			for _, scheme := range op.security {
+				security = append(security, map[string]interface{}{scheme: []interface{}{}})
+			}
+			o["security"] = security
+		}
+		switch {
+		case op.request != nil:
+			o["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": schemaFor(reflect.TypeOf(op.request), schemas)},
+				},
+			}
+		case op.requestContentType != "":
+			o["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  map[string]interface{}{op.requestContentType: map[string]interface{}{}},
+			}
+		}
+
+		if paths[op.path] == nil {
+			paths[op.path] = make(map[string]interface{})
+		}
+		paths[op.path][strings.ToLower(op.method)] = o
+	}
+
+	paths["/openapi.json"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"operationId": "getOpenAPI",
+			"summary":     "Get this OpenAPI document",
+			"security":    []interface{}{},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": http.StatusText(http.StatusOK),
+					"content":     map[string]interface{}{"application/json": map[string]interface{}{}},
+				},
+			},
+		},
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "stagerunner API",
+			"version": strings.TrimPrefix(apiPrefix, "/"),
+		},
+		"servers": []interface{}{map[string]interface{}{"url": apiPrefix}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"token":      map[string]interface{}{"type": "apiKey", "in": "header", "name": "Authorization"},
+				"badgeToken": map[string]interface{}{"type": "apiKey", "in": "query", "name": badgeTokenParam},
+			},
+		},
+		"security": []interface{}{map[string]interface{}{"token": []interface{}{}}},
+	}
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+	schemaType     = reflect.TypeOf((*jsonSchema)(nil)).Elem()
+)
+
+// jsonSchema is implemented by types with a JSON encoding of their own, which provide their schema themselves
+type jsonSchema interface {
+	JSONSchema() map[string]interface{}
+}
+
+// schemaFor returns the JSON schema of a Go type. Named structs are added to schemas
+// and referenced, fields are required unless they are tagged with omitempty.
+func schemaFor(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == rawMessageType:
+		return map[string]interface{}{}
+	case (t.Kind() == reflect.Struct || t.Kind() == reflect.Map) && t.Implements(schemaType):
+		schema := reflect.Zero(t).Interface().(jsonSchema).JSONSchema()
+		if t.Name() == "" {
+			return schema
+		}
+		schemas[t.Name()] = schema
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := schemaFor(t.Elem(), schemas)
+		if _, ok := schema["$ref"]; ok {
+			return map[string]interface{}{"allOf": []interface{}{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaFor(t.Elem(), schemas)}
+	case reflect.Struct:
+		ref := map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+		if t.Name() != "" {
+			if _, ok := schemas[t.Name()]; ok {
+				return ref
+			}
+			// register the name before recursing, so recursive types terminate
+			schemas[t.Name()] = nil
+		}
+
+		properties := make(map[string]interface{})
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			properties[name] = schemaFor(f.Type, schemas)
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+		sort.Strings(required)
+
+		schema := map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		if t.Name() == "" {
+			return schema
+		}
+		schemas[t.Name()] = schema
+		return ref
+	}
+	// interface{} and anything else can hold any value
+	return map[string]interface{}{}
+}
+
+// serveOpenAPI is returning a handler serving the OpenAPI document
+func serveOpenAPI(spec []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(spec)
+	}
+}
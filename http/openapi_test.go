@@ -0,0 +1,305 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hphilipps/stagerunner/domain"
+	"github.com/hphilipps/stagerunner/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fetchOpenAPI is fetching and decoding the OpenAPI document without authorization
+func fetchOpenAPI(t *testing.T, router http.Handler) map[string]interface{} {
+	req := httptest.NewRequest(http.MethodGet, "/v1/openapi.json", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var doc map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+	return doc
+}
+
+func TestOpenAPI_Routes(t *testing.T) {
+	s := store.NewMemoryStore()
+	router := NewAPI(s, domain.NewExecutor(s, 1, 5, 2, 0.0, 10*time.Millisecond)).SetupRouter()
+	doc := fetchOpenAPI(t, router)
+	assert.Equal(t, "3.0.3", doc["openapi"])
+
+	var documented []string
+	for path, item := range doc["paths"].(map[string]interface{}) {
+		for method := range item.(map[string]interface{}) {
+			documented = append(documented, strings.ToUpper(method)+" "+path)
+		}
+	}
+
+	var routed []string
+	err := router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		tmpl, err := route.GetPathTemplate()
+		if err != nil || !strings.HasPrefix(tmpl, apiPrefix+"/") {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		for _, m := range methods {
+			routed = append(routed, m+" "+strings.TrimPrefix(tmpl, apiPrefix))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	sort.Strings(documented)
+	sort.Strings(routed)
+	assert.Equal(t, routed, documented)
+}
+
+func TestOpenAPI_Responses(t *testing.T) {
+	s := store.NewMemoryStore()
+	api := NewAPI(s, domain.NewExecutor(s, 1, 5, 2, 0.0, 10*time.Millisecond), WithAuditLog(store.NewMemoryAuditLog()))
+	router := api.SetupRouter()
+	doc := fetchOpenAPI(t, router)
+	ctx := context.Background()
+
+	finished := domain.NewPipelineRun("other-pipeline", "main")
+	finished.Status = domain.StatusSuccess
+	require.NoError(t, s.CreatePipelineRun(ctx, finished))
+
+	pipeline := `{"name": "p1", "repository": "repo", "stages": {"run_stage": {"command": "make test"}, "build_stage": {"dockerfile_path": "Dockerfile"}, "deploy_stage": {"cluster_name": "prod", "manifest_path": "k8s/"}}}`
+	exercised := make(map[string]bool)
+
+	// call is sending a request and validates the response against the documented schema
+	call := func(method, path, body string) []byte {
+		t.Helper()
+		req := httptest.NewRequest(method, apiPrefix+path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "test-token")
+		if strings.HasPrefix(path, "/events") {
+			// the event stream only ends when the request is done
+			reqCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+			defer cancel()
+			req = req.WithContext(reqCtx)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		route := path
+		if i := strings.Index(route, "?"); i >= 0 {
+			route = route[:i]
+		}
+		match, op := findOperation(t, doc, method, route)
+		exercised[method+" "+match] = true
+
+		responses := op["responses"].(map[string]interface{})
+		resp, ok := responses[strconv.Itoa(w.Code)].(map[string]interface{})
+		if !ok && w.Code >= http.StatusBadRequest {
+			resp, ok = responses["default"].(map[string]interface{})
+		}
+		require.True(t, ok, "%s %s: status %d is not documented: %s", method, path, w.Code, w.Body.String())
+		content, ok := resp["content"].(map[string]interface{})
+		if !ok {
+			assert.Empty(t, w.Body.String(), "%s %s: undocumented body", method, path)
+			return w.Body.Bytes()
+		}
+		contentType := w.Header().Get("Content-Type")
+		media, ok := content[contentType].(map[string]interface{})
+		require.True(t, ok, "%s %s: content type %q is not documented", method, path, contentType)
+		if schema, ok := media["schema"].(map[string]interface{}); ok && contentType == "application/json" {
+			var value interface{}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &value))
+			assert.Empty(t, validateSchema(doc, schema, value, "body"), "%s %s", method, path)
+		}
+		return w.Body.Bytes()
+	}
+
+	var created CreatePipelineResponse
+	require.NoError(t, json.Unmarshal(call(http.MethodPost, "/pipelines", pipeline), &created))
+	call(http.MethodGet, "/pipelines", "")
+	call(http.MethodPost, "/pipelines/validate", `{"name": "p1", "stages": {"deploy_stage": {"manifest_path": "/k8s"}}, "unknown": 1}`)
+	call(http.MethodGet, "/pipelines/"+created.ID, "")
+	call(http.MethodPut, "/pipelines/"+created.ID, pipeline)
+
+	var triggered TriggerPipelineResponse
+	require.NoError(t, json.Unmarshal(call(http.MethodPost, "/pipelines/"+created.ID+"/trigger", `{"git_ref": "main"}`), &triggered))
+	call(http.MethodGet, "/runs?limit=10", "")
+	call(http.MethodGet, "/runs/"+triggered.ID, "")
+	call(http.MethodGet, "/pipelines/"+created.ID+"/badge.svg?ref=main", "")
+	call(http.MethodDelete, "/runs/"+finished.ID, "")
+	call(http.MethodPost, "/runs/"+triggered.ID+"/cancel", "")
+	call(http.MethodGet, "/events?after=1", "")
+
+	archive := call(http.MethodGet, "/admin/export", "")
+	call(http.MethodPost, "/admin/import?conflict=skip", string(archive))
+	call(http.MethodGet, "/admin/queue", "")
+	call(http.MethodGet, "/audit", "")
+	call(http.MethodDelete, "/pipelines/"+created.ID, "")
+	call(http.MethodGet, "/pipelines/"+created.ID, "")
+
+	for path, item := range doc["paths"].(map[string]interface{}) {
+		for method, op := range item.(map[string]interface{}) {
+			if op.(map[string]interface{})["operationId"] == "getOpenAPI" {
+				continue
+			}
+			assert.True(t, exercised[strings.ToUpper(method)+" "+path], "%s %s has not been exercised", method, path)
+		}
+	}
+}
+
+func TestApi_LegacyRoutes(t *testing.T) {
+	s := store.NewMemoryStore()
+	router := NewAPI(s, domain.NewExecutor(s, 1, 5, 2, 0.0, 10*time.Millisecond)).SetupRouter()
+
+	tests := []struct {
+		name           string
+		path           string
+		token          string
+		wantStatus     int
+		wantDeprecated bool
+	}{
+		{name: "versioned", path: "/v1/pipelines", token: "test-token", wantStatus: http.StatusOK},
+		{name: "unversioned", path: "/pipelines", token: "test-token", wantStatus: http.StatusOK, wantDeprecated: true},
+		{name: "unauthorized", path: "/v1/pipelines", wantStatus: http.StatusUnauthorized},
+		{name: "unknown version", path: "/v2/pipelines", token: "test-token", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", tt.token)
+			}
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantDeprecated {
+				assert.Equal(t, "true", w.Header().Get("Deprecation"))
+				assert.Equal(t, `</v1/pipelines>; rel="successor-version"`, w.Header().Get("Link"))
+			} else {
+				assert.Empty(t, w.Header().Get("Deprecation"))
+			}
+		})
+	}
+}
+
+// findOperation is returning the documented path template and operation matching the request
+func findOperation(t *testing.T, doc map[string]interface{}, method, path string) (string, map[string]interface{}) {
+	t.Helper()
+	for tmpl, item := range doc["paths"].(map[string]interface{}) {
+		if !matchTemplate(tmpl, path) {
+			continue
+		}
+		if op, ok := item.(map[string]interface{})[strings.ToLower(method)].(map[string]interface{}); ok {
+			return tmpl, op
+		}
+	}
+	t.Fatalf("%s %s is not documented", method, path)
+	return "", nil
+}
+
+func matchTemplate(tmpl, path string) bool {
+	tmplParts, pathParts := strings.Split(tmpl, "/"), strings.Split(path, "/")
+	if len(tmplParts) != len(pathParts) {
+		return false
+	}
+	for i := range tmplParts {
+		if !strings.HasPrefix(tmplParts[i], "{") && tmplParts[i] != pathParts[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// validateSchema is checking a decoded JSON value against the subset of JSON schema used by the document
+func validateSchema(doc map[string]interface{}, schema map[string]interface{}, value interface{}, at string) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		resolved, ok := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})[name].(map[string]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: unknown schema %s", at, ref)}
+		}
+		return validateSchema(doc, resolved, value, at)
+	}
+	if value == nil {
+		if schema["nullable"] == true || schema["type"] == nil {
+			return nil
+		}
+		return []string{fmt.Sprintf("%s: must not be null", at)}
+	}
+
+	var errs []string
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected object, got %T", at, value)}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := object[name.(string)]; !ok {
+				errs = append(errs, fmt.Sprintf("%s: missing required field %s", at, name))
+			}
+		}
+		for name, v := range object {
+			if propSchema, ok := properties[name].(map[string]interface{}); ok {
+				errs = append(errs, validateSchema(doc, propSchema, v, at+"."+name)...)
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					errs = append(errs, fmt.Sprintf("%s: unexpected field %s", at, name))
+				}
+			case map[string]interface{}:
+				errs = append(errs, validateSchema(doc, additional, v, at+"."+name)...)
+			}
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected array, got %T", at, value)}
+		}
+		for i, item := range items {
+			errs = append(errs, validateSchema(doc, schema["items"].(map[string]interface{}), item, fmt.Sprintf("%s[%d]", at, i))...)
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected string, got %T", at, value)}
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: invalid date-time %q", at, s))
+			}
+		}
+	case "integer":
+		n, ok := value.(float64)
+		if !ok || n != float64(int64(n)) {
+			errs = append(errs, fmt.Sprintf("%s: expected integer, got %v", at, value))
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			errs = append(errs, fmt.Sprintf("%s: expected number, got %T", at, value))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			errs = append(errs, fmt.Sprintf("%s: expected boolean, got %T", at, value))
+		}
+	}
+	return errs
+}
@@ -4,36 +4,54 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/hphilipps/stagerunner/domain"
 )
 
-// RunStage is used to construct a run stage for requests and responses
-type RunStage struct {
-	Command       string `json:"command"`
-	ContinueOnErr bool   `json:"continue_on_error"`
-}
-
-// BuildStage is used to construct a build stage for requests and responses
-type BuildStage struct {
-	DockerfilePath string `json:"dockerfile_path"`
-	ContinueOnErr  bool   `json:"continue_on_error"`
-}
-
-// DeployStage is used to construct a deploy stage for requests and responses
-type DeployStage struct {
-	ClusterName   string `json:"cluster_name"`
-	ManifestPath  string `json:"manifest_path"`
-	ContinueOnErr bool   `json:"continue_on_error"`
+// stageSuffix is appended to the name of a stage type for the name of its stage in requests and responses
+const stageSuffix = "_stage"
+
+// Stages are the JSON settings of the stages of a pipeline for requests and responses. The settings of
+// every stage are named after its type with the _stage suffix, e.g. run_stage, and are decoded and
+// encoded with the registered stage type, see domain.StageType.
+type Stages map[string]json.RawMessage
+
+// JSONSchema returns the JSON schema of the stages of all registered stage types
+func (Stages) JSONSchema() map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+	for _, t := range domain.StageTypes() {
+		properties[t.Name+stageSuffix] = t.Schema
+		if !t.Optional {
+			required = append(required, t.Name+stageSuffix)
+		}
+	}
+	sort.Strings(required)
+	schema := map[string]interface{}{"type": "object", "properties": properties, "additionalProperties": false}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
 }
 
-// Stages is used to construct a pipeline with multiple stages for requests and responses
-type Stages struct {
-	RunStage    RunStage    `json:"run_stage"`
-	BuildStage  BuildStage  `json:"build_stage"`
-	DeployStage DeployStage `json:"deploy_stage"`
+// stagesOf returns the stages of a pipeline
+func stagesOf(pipeline *domain.Pipeline) (Stages, error) {
+	stages := make(Stages, len(pipeline.Stages))
+	for name, stage := range pipeline.Stages {
+		data, err := domain.EncodeStage(stage)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode %s stage: %w", name, err)
+		}
+		stages[name+stageSuffix] = data
+	}
+	return stages, nil
 }
 
 // PipelineRequest is used to construct a pipeline for requests
@@ -41,32 +59,63 @@ type PipelineRequest struct {
 	Name       string `json:"name"`
 	Repository string `json:"repository"`
 	Stages     Stages `json:"stages"`
+	// PublicBadge makes the status badge of the pipeline readable with its badge token
+	PublicBadge bool `json:"public_badge,omitempty"`
 }
 
 // PipelineResponse is used to construct a pipeline from a response
 type PipelineResponse struct {
-	ID          string      `json:"id"`
-	Name        string      `json:"name"`
-	Repository  string      `json:"repository"`
-	RunStage    RunStage    `json:"run_stage"`
-	BuildStage  BuildStage  `json:"build_stage"`
-	DeployStage DeployStage `json:"deploy_stage"`
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Repository string `json:"repository"`
+	// Stages are all stages of the pipeline, as in PipelineRequest
+	Stages      Stages `json:"stages"`
+	PublicBadge bool   `json:"public_badge"`
+	// BadgeToken is the token of the public status badge, see PipelineRequest.PublicBadge
+	BadgeToken string `json:"badge_token,omitempty"`
+}
+
+// legacyPipelineResponse is the pipeline response of the unversioned routes. The settings of the
+// built-in stages are at the top level as well, where they have been before the stages were added.
+type legacyPipelineResponse struct {
+	PipelineResponse
+	RunStage    json.RawMessage `json:"run_stage,omitempty"`
+	BuildStage  json.RawMessage `json:"build_stage,omitempty"`
+	DeployStage json.RawMessage `json:"deploy_stage,omitempty"`
+}
+
+// pipelineResponseFor returns the response of the route of the request
+func pipelineResponseFor(r *http.Request, resp PipelineResponse) interface{} {
+	if !isLegacyRequest(r) {
+		return resp
+	}
+	return legacyPipelineResponse{
+		PipelineResponse: resp,
+		RunStage:         resp.Stages[domain.StageRun+stageSuffix],
+		BuildStage:       resp.Stages[domain.StageBuild+stageSuffix],
+		DeployStage:      resp.Stages[domain.StageDeploy+stageSuffix],
+	}
 }
 
 // String is a helper function to print the pipeline response in a friendly format
 func (p *PipelineResponse) String() string {
+	names := make([]string, 0, len(p.Stages))
+	for name := range p.Stages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var stages strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&stages, "\n    %s: %s", name, p.Stages[name])
+	}
 	return fmt.Sprintf(`ID: %s
   Name: %s
   Repository: %s
-  RunStage: %+v
-  BuildStage: %+v 
-  DeployStage: %+v`,
+  Stages:%s`,
 		p.ID,
 		p.Name,
 		p.Repository,
-		p.RunStage,
-		p.BuildStage,
-		p.DeployStage)
+		stages.String())
 }
 
 // CreatePipelineResponse is used to construct a response for a pipeline creation request
@@ -75,67 +124,102 @@ type CreatePipelineResponse struct {
 }
 
 // createPipelineResponse is used to construct a pipeline response from a pipeline domain object
-func createPipelineResponse(pipeline *domain.Pipeline) PipelineResponse {
-	runStage := RunStage{
-		Command:       pipeline.Stages[domain.StageRun].(*domain.RunStage).Command,
-		ContinueOnErr: pipeline.Stages[domain.StageRun].(*domain.RunStage).ContOnError,
-	}
-	buildStage := BuildStage{
-		DockerfilePath: pipeline.Stages[domain.StageBuild].(*domain.BuildStage).DockerfilePath,
-		ContinueOnErr:  pipeline.Stages[domain.StageBuild].(*domain.BuildStage).ContOnError,
-	}
-	deployStage := DeployStage{
-		ClusterName:   pipeline.Stages[domain.StageDeploy].(*domain.DeployStage).ClusterName,
-		ManifestPath:  pipeline.Stages[domain.StageDeploy].(*domain.DeployStage).ManifestPath,
-		ContinueOnErr: pipeline.Stages[domain.StageDeploy].(*domain.DeployStage).ContOnError,
+func createPipelineResponse(pipeline *domain.Pipeline) (PipelineResponse, error) {
+	stages, err := stagesOf(pipeline)
+	if err != nil {
+		return PipelineResponse{}, fmt.Errorf("pipeline %s: %w", pipeline.ID, err)
 	}
 
 	return PipelineResponse{
 		ID:          pipeline.ID,
 		Name:        pipeline.Name,
 		Repository:  pipeline.Repository,
-		RunStage:    runStage,
-		BuildStage:  buildStage,
-		DeployStage: deployStage,
+		Stages:      stages,
+		PublicBadge: pipeline.BadgeToken != "",
+		BadgeToken:  pipeline.BadgeToken,
+	}, nil
+}
+
+// Pipeline validates the request and returns a new pipeline of it. Pipelines of the server and of
+// local runs are both built with it, so they can't behave differently.
+func (req PipelineRequest) Pipeline() (*domain.Pipeline, error) {
+	pipeline := domain.NewPipeline(req.Repository)
+	if err := req.applyTo(pipeline); err != nil {
+		return nil, err
+	}
+	return pipeline, nil
+}
+
+// applyTo validates the request and sets the fields of the pipeline, which is only changed if the request is valid
+func (req PipelineRequest) applyTo(pipeline *domain.Pipeline) error {
+	draft, err := req.draft()
+	if err != nil {
+		return err
+	}
+	if err := draft.Validate(); err != nil {
+		return err
 	}
+
+	pipeline.Name = draft.Name
+	pipeline.Repository = draft.Repository
+	pipeline.Stages = draft.Stages
+	return pipeline.SetPublicBadge(req.PublicBadge)
+}
+
+// draft returns a pipeline of the request without validating it. The stages are decoded with their
+// registered stage types, the pipeline is returned without the stages which can't be decoded.
+func (req PipelineRequest) draft() (*domain.Pipeline, error) {
+	pipeline := domain.NewPipeline(req.Repository)
+	pipeline.Name = req.Name
+	pipeline.Stages = make(map[string]domain.Stage)
+
+	keys := make([]string, 0, len(req.Stages))
+	for key := range req.Stages {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var errs []error
+	for _, key := range keys {
+		name, ok := strings.CutSuffix(key, stageSuffix)
+		if !ok {
+			errs = append(errs, &domain.ValidationError{Field: "stages." + key, Message: fmt.Sprintf("unknown stage %q", key)})
+			continue
+		}
+		stage, err := domain.DecodeStage(name, req.Stages[key])
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		pipeline.Stages[name] = stage
+	}
+	return pipeline, errors.Join(errs...)
 }
 
 // createPipeline is a handler for creating a pipeline
 func (api *API) createPipeline(w http.ResponseWriter, r *http.Request) {
+	auditTarget(r, "pipeline.create", "pipeline", "")
+
 	var req PipelineRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		respondWithError(w, r, newError(ErrInvalidRequest, "Invalid request payload"))
 		return
 	}
 
-	// validate request
-	runStage := domain.NewRunStage(domain.StageRun, req.Stages.RunStage.Command, req.Stages.RunStage.ContinueOnErr)
-	if err := runStage.Validate(); err != nil {
-		respondWithError(w, http.StatusBadRequest, err.Error())
-		return
-	}
-	buildStage := domain.NewBuildStage(domain.StageBuild, req.Stages.BuildStage.DockerfilePath, req.Stages.BuildStage.ContinueOnErr)
-	if err := buildStage.Validate(); err != nil {
-		respondWithError(w, http.StatusBadRequest, err.Error())
-		return
-	}
-	deployStage := domain.NewDeployStage(domain.StageDeploy, req.Stages.DeployStage.ClusterName, req.Stages.DeployStage.ManifestPath, req.Stages.DeployStage.ContinueOnErr)
-	if err := deployStage.Validate(); err != nil {
-		respondWithError(w, http.StatusBadRequest, err.Error())
+	pipeline, err := req.Pipeline()
+	if err != nil {
+		respondWithError(w, r, err)
 		return
 	}
 
-	pipeline := domain.NewPipeline(req.Repository)
-	pipeline.Name = req.Name
-	pipeline.Repository = req.Repository
-	pipeline.Stages[domain.StageRun] = runStage
-	pipeline.Stages[domain.StageBuild] = buildStage
-	pipeline.Stages[domain.StageDeploy] = deployStage
-
 	if err := api.store.CreatePipeline(r.Context(), pipeline); err != nil {
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		respondWithError(w, r, err)
 		return
 	}
+	auditTargetID(r, pipeline.ID)
+	if resp, err := createPipelineResponse(pipeline); err == nil {
+		auditAfter(r, resp)
+	}
 
 	respondWithJSON(w, http.StatusCreated, CreatePipelineResponse{ID: pipeline.ID})
 }
@@ -146,24 +230,30 @@ func (api *API) getPipeline(w http.ResponseWriter, r *http.Request) {
 	pipeline, err := api.store.GetPipeline(r.Context(), vars["id"])
 	if err != nil {
 		if errors.Is(err, domain.ErrNotFound) {
-			respondWithError(w, http.StatusNotFound, "Pipeline not found")
+			respondWithError(w, r, newError(domain.ErrNotFound, "Pipeline not found"))
 			return
 		}
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		respondWithError(w, r, err)
 		return
 	}
 
-	resp := createPipelineResponse(pipeline)
+	resp, err := createPipelineResponse(pipeline)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
 
-	respondWithJSON(w, http.StatusOK, resp)
+	respondWithJSON(w, http.StatusOK, pipelineResponseFor(r, resp))
 }
 
 // updatePipeline is a handler for updating a pipeline
 func (api *API) updatePipeline(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
+	auditTarget(r, "pipeline.update", "pipeline", vars["id"])
+
 	var req PipelineRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		respondWithError(w, r, newError(ErrInvalidRequest, "Invalid request payload"))
 		return
 	}
 
@@ -171,54 +261,85 @@ func (api *API) updatePipeline(w http.ResponseWriter, r *http.Request) {
 	pipeline, err := api.store.GetPipeline(r.Context(), vars["id"])
 	if err != nil {
 		if errors.Is(err, domain.ErrNotFound) {
-			respondWithError(w, http.StatusNotFound, "Pipeline not found")
+			respondWithError(w, r, newError(domain.ErrNotFound, "Pipeline not found"))
 			return
 		}
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		respondWithError(w, r, err)
 		return
 	}
-
-	// validate request
-	runStage := domain.NewRunStage(domain.StageRun, req.Stages.RunStage.Command, req.Stages.RunStage.ContinueOnErr)
-	if err := runStage.Validate(); err != nil {
-		respondWithError(w, http.StatusBadRequest, err.Error())
-		return
-	}
-	buildStage := domain.NewBuildStage(domain.StageBuild, req.Stages.BuildStage.DockerfilePath, req.Stages.BuildStage.ContinueOnErr)
-	if err := buildStage.Validate(); err != nil {
-		respondWithError(w, http.StatusBadRequest, err.Error())
+	before, err := createPipelineResponse(pipeline)
+	if err != nil {
+		respondWithError(w, r, err)
 		return
 	}
-	deployStage := domain.NewDeployStage(domain.StageDeploy, req.Stages.DeployStage.ClusterName, req.Stages.DeployStage.ManifestPath, req.Stages.DeployStage.ContinueOnErr)
-	if err := deployStage.Validate(); err != nil {
-		respondWithError(w, http.StatusBadRequest, err.Error())
+	auditBefore(r, before)
+
+	if err := req.applyTo(pipeline); err != nil {
+		respondWithError(w, r, err)
 		return
 	}
 
-	// Update pipeline fields
-	pipeline.Name = req.Name
-	pipeline.Repository = req.Repository
-	pipeline.Stages[domain.StageRun] = runStage
-	pipeline.Stages[domain.StageBuild] = buildStage
-	pipeline.Stages[domain.StageDeploy] = deployStage
-
 	if err := api.store.UpdatePipeline(r.Context(), pipeline); err != nil {
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		respondWithError(w, r, err)
 		return
 	}
+	resp, err := createPipelineResponse(pipeline)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	auditAfter(r, resp)
 
-	respondWithJSON(w, http.StatusOK, pipeline)
+	respondWithJSON(w, http.StatusOK, pipelineResponseFor(r, resp))
 }
 
-// deletePipeline is a handler for deleting a pipeline
+// deletePipeline is a handler for deleting a pipeline.
+// With the cascade query parameter set to true, the runs of the pipeline are deleted as well.
 func (api *API) deletePipeline(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	if err := api.store.DeletePipeline(r.Context(), vars["id"]); err != nil {
+	auditTarget(r, "pipeline.delete", "pipeline", vars["id"])
+
+	cascade := false
+	if value := r.URL.Query().Get("cascade"); value != "" {
+		var err error
+		if cascade, err = strconv.ParseBool(value); err != nil {
+			respondWithError(w, r, newError(ErrInvalidRequest, "invalid cascade: %q", value))
+			return
+		}
+	}
+
+	pipeline, err := api.store.GetPipeline(r.Context(), vars["id"])
+	if err != nil {
 		if errors.Is(err, domain.ErrNotFound) {
-			respondWithError(w, http.StatusNotFound, "Pipeline not found")
+			respondWithError(w, r, newError(domain.ErrNotFound, "Pipeline not found"))
 			return
 		}
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		respondWithError(w, r, err)
+		return
+	}
+	before, err := createPipelineResponse(pipeline)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	auditBefore(r, before)
+
+	deletePipeline := api.store.DeletePipeline
+	if cascade {
+		// the runs are checked and deleted at once, so no run can be triggered in between
+		deletePipeline = api.store.DeletePipelineWithRuns
+	}
+	if err := deletePipeline(r.Context(), pipeline.ID); err != nil {
+		var notFinished *domain.RunNotFinishedError
+		switch {
+		case errors.Is(err, domain.ErrNotFound):
+			respondWithError(w, r, newError(domain.ErrNotFound, "Pipeline not found"))
+		case errors.As(err, &notFinished):
+			// we don't want to pull runs from under the feet of the executor
+			respondWithError(w, r, newError(ErrConflict, "Pipeline run %s is not finished yet", notFinished.RunID).withDetail("run_id", notFinished.RunID))
+		default:
+			respondWithError(w, r, err)
+		}
 		return
 	}
 
@@ -227,15 +348,26 @@ func (api *API) deletePipeline(w http.ResponseWriter, r *http.Request) {
 
 // listPipelines is a handler for listing pipelines
 func (api *API) listPipelines(w http.ResponseWriter, r *http.Request) {
-	pipelines, err := api.store.ListPipelines(r.Context())
+	opts, err := listOptionsFromRequest(r)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	pipelines, err := api.store.ListPipelines(r.Context(), opts)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		respondWithError(w, r, err)
 		return
 	}
 
-	pipelineResponses := make([]PipelineResponse, 0, len(pipelines))
+	pipelineResponses := make([]interface{}, 0, len(pipelines))
 	for _, pipeline := range pipelines {
-		pipelineResponses = append(pipelineResponses, createPipelineResponse(pipeline))
+		resp, err := createPipelineResponse(pipeline)
+		if err != nil {
+			respondWithError(w, r, err)
+			return
+		}
+		pipelineResponses = append(pipelineResponses, pipelineResponseFor(r, resp))
 	}
 	respondWithJSON(w, http.StatusOK, pipelineResponses)
 }
@@ -254,27 +386,159 @@ type TriggerPipelineResponse struct {
 // triggerPipeline is a handler for triggering a pipeline
 func (api *API) triggerPipeline(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
+	auditTarget(r, "pipeline.trigger", "pipeline", vars["id"])
+
 	var req TriggerPipelineRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		respondWithError(w, r, newError(ErrInvalidRequest, "Invalid request payload"))
 		return
 	}
 
 	pipeline, err := api.store.GetPipeline(r.Context(), vars["id"])
 	if err != nil {
 		if errors.Is(err, domain.ErrNotFound) {
-			respondWithError(w, http.StatusNotFound, "Pipeline not found")
+			respondWithError(w, r, newError(domain.ErrNotFound, "Pipeline not found"))
 			return
 		}
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		respondWithError(w, r, err)
 		return
 	}
 
 	run, err := api.executor.TriggerPipeline(r.Context(), pipeline, req.GitRef)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		respondWithError(w, r, err)
 		return
 	}
+	auditAfter(r, struct {
+		RunID  string `json:"run_id"`
+		GitRef string `json:"git_ref"`
+	}{RunID: run.ID, GitRef: run.GitRef})
 
 	respondWithJSON(w, http.StatusAccepted, TriggerPipelineResponse{ID: run.ID})
 }
+
+// ValidatePipelineResponse is used to construct a response for validating a pipeline without creating it
+type ValidatePipelineResponse struct {
+	// Valid is true if the pipeline has no errors and can be created
+	Valid    bool                `json:"valid"`
+	Errors   []ValidationProblem `json:"errors"`
+	Warnings []ValidationProblem `json:"warnings"`
+	// Plan are the steps of a run of the pipeline, in the order they are executed
+	Plan []PlanStepResponse `json:"plan"`
+}
+
+// ValidationProblem is a problem of a validated pipeline
+type ValidationProblem struct {
+	// Path is the path of the field with the problem in the request, e.g. stages.run_stage.command
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// PlanStepResponse is a step of the execution plan of a pipeline
+type PlanStepResponse struct {
+	Stage           string `json:"stage"`
+	Description     string `json:"description"`
+	ContinueOnError bool   `json:"continue_on_error"`
+}
+
+// problemPath returns the path of a field of a stage in the pipeline request
+func problemPath(stage, field string) string {
+	if stage == "" {
+		return field
+	}
+	p := "stages." + stage + "_stage"
+	if field != "" {
+		p += "." + field
+	}
+	return p
+}
+
+// validatePipeline is a handler for validating a pipeline without creating it. It reports all problems
+// of the pipeline with the paths of their fields and the execution plan of the pipeline.
+func (api *API) validatePipeline(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondWithError(w, r, newError(ErrInvalidRequest, "Invalid request payload"))
+		return
+	}
+	var req PipelineRequest
+	var raw interface{}
+	if err := json.Unmarshal(data, &req); err != nil {
+		respondWithError(w, r, newError(ErrInvalidRequest, "Invalid request payload: %s", err))
+		return
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		respondWithError(w, r, newError(ErrInvalidRequest, "Invalid request payload: %s", err))
+		return
+	}
+
+	resp := ValidatePipelineResponse{Errors: []ValidationProblem{}, Warnings: []ValidationProblem{}, Plan: []PlanStepResponse{}}
+	pipeline, err := req.draft()
+	problems := append(domain.ErrorProblems(err), pipeline.Lint()...)
+	for _, problem := range problems {
+		p := ValidationProblem{Path: problemPath(problem.Stage, problem.Field), Message: problem.Message}
+		if problem.Severity == domain.SeverityError {
+			resp.Errors = append(resp.Errors, p)
+		} else {
+			resp.Warnings = append(resp.Warnings, p)
+		}
+	}
+	// unknown fields are ignored when creating pipelines, but they are likely typos
+	schemas := make(map[string]interface{})
+	for _, path := range unknownFields(raw, schemaFor(reflect.TypeOf(req), schemas), schemas, "") {
+		if !hasProblem(resp.Errors, path) {
+			resp.Warnings = append(resp.Warnings, ValidationProblem{Path: path, Message: "unknown field is ignored"})
+		}
+	}
+	for _, step := range pipeline.Plan() {
+		resp.Plan = append(resp.Plan, PlanStepResponse{Stage: step.Stage, Description: step.Description, ContinueOnError: step.ContinueOnError})
+	}
+	resp.Valid = len(resp.Errors) == 0
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// hasProblem returns true if there is a problem with the field of the path
+func hasProblem(problems []ValidationProblem, path string) bool {
+	for _, problem := range problems {
+		if problem.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// unknownFields returns the paths of the fields of a decoded JSON value which are not allowed by its JSON schema.
+// References to named schemas are resolved with schemas, as returned by schemaFor.
+func unknownFields(value interface{}, schema map[string]interface{}, schemas map[string]interface{}, prefix string) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		named, _ := schemas[strings.TrimPrefix(ref, "#/components/schemas/")].(map[string]interface{})
+		return unknownFields(value, named, schemas, prefix)
+	}
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	properties, _ := schema["properties"].(map[string]interface{})
+
+	var unknown []string
+	for name, v := range object {
+		var fieldSchema map[string]interface{}
+		switch additional := schema["additionalProperties"].(type) {
+		case map[string]interface{}:
+			// maps have no properties
+			fieldSchema = additional
+		default:
+			if fieldSchema, ok = properties[name].(map[string]interface{}); !ok {
+				// schemas of plugins may allow any other fields
+				if additional != true {
+					unknown = append(unknown, prefix+name)
+				}
+				continue
+			}
+		}
+		unknown = append(unknown, unknownFields(v, fieldSchema, schemas, prefix+name+".")...)
+	}
+	sort.Strings(unknown)
+	return unknown
+}
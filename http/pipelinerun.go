@@ -1,31 +1,48 @@
 package http
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/hphilipps/stagerunner/domain"
 )
 
-// pipelineRunResponse is used to construct a response for a pipeline run
-type pipelineRunResponse struct {
-	ID           string            `json:"id"`
-	PipelineID   string            `json:"pipeline_id"`
-	GitRef       string            `json:"git_ref"`
-	Status       string            `json:"status"`
-	CreatedAt    time.Time         `json:"created_at"`
-	UpdatedAt    time.Time         `json:"updated_at"`
+// PipelineRunResponse is used to construct a response for a pipeline run
+type PipelineRunResponse struct {
+	ID         string    `json:"id"`
+	PipelineID string    `json:"pipeline_id"`
+	GitRef     string    `json:"git_ref"`
+	Status     string    `json:"status"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	// StageStatuses are the statuses of all stages of the pipeline by their name
+	StageStatuses map[string]string `json:"stage_statuses,omitempty"`
+	// RunStatus, BuildStatus and DeployStatus are the statuses of the built-in stages, as in StageStatuses
 	RunStatus    string            `json:"run_status"`
 	BuildStatus  string            `json:"build_status"`
 	DeployStatus string            `json:"deploy_status"`
 	Logs         map[string]string `json:"logs"`
+	// StageTimings are the start and end times of the started stages
+	StageTimings map[string]StageTimingResponse `json:"stage_timings,omitempty"`
+	// RequestID is the ID of the request which triggered the run
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// StageTimingResponse is when a stage has been started and finished, finished_at is missing while the stage is running
+type StageTimingResponse struct {
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
 }
 
 // String is a helper function to print the pipeline run response in a friendly format
-func (p *pipelineRunResponse) String() string {
+func (p *PipelineRunResponse) String() string {
 	return fmt.Sprintf(`ID: %s
   PipelineID: %s
   GitRef: %s
@@ -48,19 +65,43 @@ func (p *pipelineRunResponse) String() string {
 		p.Logs)
 }
 
+// Finished returns true if the pipeline run reached a terminal state
+func (p *PipelineRunResponse) Finished() bool {
+	return p.Status == domain.StatusSuccess || p.Status == domain.StatusFailed
+}
+
 // createPipelineRunResponse is used to construct a pipeline run response from a pipeline run domain object
-func createPipelineRunResponse(run *domain.PipelineRun) pipelineRunResponse {
-	return pipelineRunResponse{
-		ID:           run.ID,
-		PipelineID:   run.PipelineID,
-		GitRef:       run.GitRef,
-		Status:       run.Status,
-		CreatedAt:    run.CreatedAt,
-		UpdatedAt:    run.UpdatedAt,
-		RunStatus:    run.RunStatus,
-		BuildStatus:  run.BuildStatus,
-		DeployStatus: run.DeployStatus,
-		Logs:         run.Logs,
+func createPipelineRunResponse(run *domain.PipelineRun) PipelineRunResponse {
+	var timings map[string]StageTimingResponse
+	if len(run.StageTimings) > 0 {
+		timings = make(map[string]StageTimingResponse, len(run.StageTimings))
+		for stage, t := range run.StageTimings {
+			timing := StageTimingResponse{StartedAt: t.StartedAt}
+			if !t.FinishedAt.IsZero() {
+				finished := t.FinishedAt
+				timing.FinishedAt = &finished
+			}
+			timings[stage] = timing
+		}
+	}
+	statuses := make(map[string]string, len(run.StageStatuses))
+	for stage, status := range run.StageStatuses {
+		statuses[stage] = status
+	}
+	return PipelineRunResponse{
+		ID:            run.ID,
+		PipelineID:    run.PipelineID,
+		GitRef:        run.GitRef,
+		Status:        run.Status,
+		CreatedAt:     run.CreatedAt,
+		UpdatedAt:     run.UpdatedAt,
+		StageStatuses: statuses,
+		RunStatus:     run.StageStatus(domain.StageRun),
+		BuildStatus:   run.StageStatus(domain.StageBuild),
+		DeployStatus:  run.StageStatus(domain.StageDeploy),
+		Logs:          run.Logs,
+		StageTimings:  timings,
+		RequestID:     run.RequestID,
 	}
 }
 
@@ -70,27 +111,126 @@ func (api *API) getPipelineRun(w http.ResponseWriter, r *http.Request) {
 	run, err := api.store.GetPipelineRun(r.Context(), vars["run_id"])
 	if err != nil {
 		if errors.Is(err, domain.ErrNotFound) {
-			respondWithError(w, http.StatusNotFound, "Pipeline run not found")
+			respondWithError(w, r, newError(domain.ErrNotFound, "Pipeline run not found"))
 			return
 		}
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		respondWithError(w, r, err)
+		return
+	}
+
+	// the ETag allows clients polling the run to skip unchanged responses with If-None-Match
+	resp := createPipelineRunResponse(run)
+	etag := jsonETag(resp)
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// jsonETag returns a strong ETag of the JSON representation of a response
+func jsonETag(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return contentETag(data)
+}
 
-	respondWithJSON(w, http.StatusOK, createPipelineRunResponse(run))
+// contentETag returns a strong ETag of the content of a response
+func contentETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches returns true if the If-None-Match header is matching the ETag
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
 }
 
 // listPipelineRuns is a handler for listing pipeline runs
 func (api *API) listPipelineRuns(w http.ResponseWriter, r *http.Request) {
-	runs, err := api.store.ListPipelineRuns(r.Context())
+	opts, err := listOptionsFromRequest(r)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	runs, err := api.store.ListPipelineRuns(r.Context(), opts)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		respondWithError(w, r, err)
 		return
 	}
 
-	runResponses := make([]pipelineRunResponse, 0, len(runs))
+	runResponses := make([]PipelineRunResponse, 0, len(runs))
 	for _, run := range runs {
 		runResponses = append(runResponses, createPipelineRunResponse(run))
 	}
 	respondWithJSON(w, http.StatusOK, runResponses)
 }
+
+// deletePipelineRun is a handler for deleting a finished pipeline run
+func (api *API) deletePipelineRun(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	auditTarget(r, "run.delete", "run", vars["run_id"])
+
+	run, err := api.store.GetPipelineRun(r.Context(), vars["run_id"])
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			respondWithError(w, r, newError(domain.ErrNotFound, "Pipeline run not found"))
+			return
+		}
+		respondWithError(w, r, err)
+		return
+	}
+
+	auditBefore(r, createPipelineRunResponse(run))
+
+	if !run.Finished() {
+		respondWithError(w, r, newError(ErrConflict, "Pipeline run is not finished yet").withDetail("run_id", run.ID))
+		return
+	}
+
+	if err := api.store.DeletePipelineRun(r.Context(), run.ID); err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			respondWithError(w, r, newError(domain.ErrNotFound, "Pipeline run not found"))
+			return
+		}
+		respondWithError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// cancelPipelineRun is a handler for cancelling a queued or running pipeline run
+func (api *API) cancelPipelineRun(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	auditTarget(r, "run.cancel", "run", vars["run_id"])
+
+	run, err := api.store.GetPipelineRun(r.Context(), vars["run_id"])
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			respondWithError(w, r, newError(domain.ErrNotFound, "Pipeline run not found"))
+			return
+		}
+		respondWithError(w, r, err)
+		return
+	}
+
+	if err := api.executor.CancelRun(r.Context(), run.ID); err != nil {
+		// the run might have finished in the meantime
+		if errors.Is(err, domain.ErrNotFound) {
+			respondWithError(w, r, newError(ErrConflict, "Pipeline run is not queued or running").withDetail("run_id", run.ID))
+			return
+		}
+		respondWithError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
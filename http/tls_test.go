@@ -0,0 +1,145 @@
+package http
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hphilipps/stagerunner/domain"
+	"github.com/hphilipps/stagerunner/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCA is a certificate authority issuing certificates for tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue returns a certificate for the subject signed by the CA
+func (ca *testCA) issue(t *testing.T, subject pkix.Name, usage x509.ExtKeyUsage) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestApi_MutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	s := store.NewMemoryStore()
+	auditLog := store.NewMemoryAuditLog()
+	api := NewAPI(s, domain.NewExecutor(s, 1, 5, 2, 0.0, 10*time.Millisecond),
+		WithAuditLog(auditLog),
+		WithClientIdentities(map[string]string{"CN=ci,O=acme": "deployer"}),
+	)
+
+	server := httptest.NewUnstartedServer(api.SetupRouter())
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, pkix.Name{CommonName: "server"}, x509.ExtKeyUsageServerAuth)},
+		ClientCAs:    ca.pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	ctx := context.Background()
+	pipeline := PipelineRequest{
+		Name:       "p1",
+		Repository: "repo",
+		Stages: Stages{
+			"run_stage":    json.RawMessage(`{"command":"make test"}`),
+			"build_stage":  json.RawMessage(`{"dockerfile_path":"Dockerfile"}`),
+			"deploy_stage": json.RawMessage(`{"cluster_name":"prod","manifest_path":"k8s/"}`),
+		},
+	}
+
+	t.Run("unknown server certificate", func(t *testing.T) {
+		_, err := NewClient(server.URL, WithToken("test-token")).ListPipelines(ctx)
+		assert.ErrorContains(t, err, "certificate")
+	})
+
+	t.Run("token", func(t *testing.T) {
+		_, err := NewClient(server.URL, WithCACert(ca.pool), WithToken("test-token")).ListPipelines(ctx)
+		assert.NoError(t, err)
+	})
+
+	t.Run("no credentials", func(t *testing.T) {
+		_, err := NewClient(server.URL, WithCACert(ca.pool)).ListPipelines(ctx)
+		assert.ErrorIs(t, err, ErrUnauthorized)
+	})
+
+	t.Run("client certificate not signed by the client CA", func(t *testing.T) {
+		cert := newTestCA(t).issue(t, pkix.Name{CommonName: "ci", Organization: []string{"acme"}}, x509.ExtKeyUsageClientAuth)
+		_, err := NewClient(server.URL, WithCACert(ca.pool), WithClientCert(cert)).ListPipelines(ctx)
+		assert.Error(t, err)
+	})
+
+	tests := []struct {
+		name      string
+		subject   pkix.Name
+		wantActor string
+	}{
+		{name: "mapped client certificate", subject: pkix.Name{CommonName: "ci", Organization: []string{"acme"}}, wantActor: "deployer"},
+		{name: "unmapped client certificate", subject: pkix.Name{CommonName: "alice"}, wantActor: "cert:alice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert := ca.issue(t, tt.subject, x509.ExtKeyUsageClientAuth)
+			client := NewClient(server.URL, WithCACert(ca.pool), WithClientCert(cert))
+
+			created, err := client.CreatePipeline(ctx, pipeline)
+			require.NoError(t, err)
+
+			entries, err := auditLog.ListAuditEntries(ctx, domain.AuditFilter{TargetID: created.ID}, domain.ListOptions{})
+			require.NoError(t, err)
+			require.Len(t, entries, 1)
+			assert.Equal(t, tt.wantActor, entries[0].Actor)
+		})
+	}
+}
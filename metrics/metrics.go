@@ -0,0 +1,141 @@
+// Package metrics is exposing the metrics of the API server and executor in the Prometheus format.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hphilipps/stagerunner/domain"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace is the prefix of all metric names
+const namespace = "stagerunner"
+
+// runBuckets are the histogram buckets in seconds for runs and stages, which take seconds to minutes
+var runBuckets = prometheus.ExponentialBuckets(0.1, 2, 14)
+
+// Metrics is collecting the metrics of the server. It implements domain.Metrics.
+type Metrics struct {
+	registry      *prometheus.Registry
+	httpRequests  *prometheus.CounterVec
+	httpDuration  *prometheus.HistogramVec
+	runs          *prometheus.CounterVec
+	runDuration   *prometheus.HistogramVec
+	stages        *prometheus.CounterVec
+	stageDuration *prometheus.HistogramVec
+}
+
+// New creates the metrics with their own registry, which also contains the Go runtime and process metrics.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "Number of handled HTTP requests by method, route and status code.",
+		}, []string{"method", "route", "code"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of HTTP requests by method and route.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		runs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "pipeline_runs_total",
+			Help:      "Number of finished pipeline runs by pipeline and status.",
+		}, []string{"pipeline_id", "status"}),
+		runDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "pipeline_run_duration_seconds",
+			Help:      "Duration of pipeline runs from being picked up by a worker until they finished, by pipeline.",
+			Buckets:   runBuckets,
+		}, []string{"pipeline_id"}),
+		stages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "stages_total",
+			Help:      "Number of executed stages by pipeline, stage type and status.",
+		}, []string{"pipeline_id", "stage", "status"}),
+		stageDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "stage_duration_seconds",
+			Help:      "Duration of stages by pipeline and stage type.",
+			Buckets:   runBuckets,
+		}, []string{"pipeline_id", "stage"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests, m.httpDuration,
+		m.runs, m.runDuration,
+		m.stages, m.stageDuration,
+	)
+	return m
+}
+
+// Handler returns the handler serving the metrics in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest records a handled HTTP request. The route is the path template of the request,
+// so the number of series doesn't grow with the IDs in paths.
+func (m *Metrics) ObserveRequest(method, route string, status int, duration time.Duration) {
+	m.httpRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	m.httpDuration.WithLabelValues(method, route).Observe(duration.Seconds())
+}
+
+// RunFinished implements domain.Metrics.
+func (m *Metrics) RunFinished(pipelineID, status string, duration time.Duration) {
+	m.runs.WithLabelValues(pipelineID, status).Inc()
+	m.runDuration.WithLabelValues(pipelineID).Observe(duration.Seconds())
+}
+
+// StageFinished implements domain.Metrics.
+func (m *Metrics) StageFinished(pipelineID, stage, status string, duration time.Duration) {
+	m.stages.WithLabelValues(pipelineID, stage, status).Inc()
+	m.stageDuration.WithLabelValues(pipelineID, stage).Observe(duration.Seconds())
+}
+
+// RegisterExecutor adds the queue and worker gauges of the executor. They are read on every scrape.
+func (m *Metrics) RegisterExecutor(executor interface{ Stats() domain.ExecutorStats }) {
+	m.registry.MustRegister(&executorCollector{executor: executor})
+}
+
+var (
+	queueLengthDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "queue", "length"),
+		"Number of pipeline runs waiting for a worker.", nil, nil)
+	queuePipelineRunsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "queue", "pipeline_runs"),
+		"Number of queued pipeline runs by pipeline.", []string{"pipeline_id"}, nil)
+	workersDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "workers"),
+		"Number of workers by state (busy or idle).", []string{"state"}, nil)
+)
+
+// executorCollector is collecting the gauges of an executor from a snapshot of its stats
+type executorCollector struct {
+	executor interface{ Stats() domain.ExecutorStats }
+}
+
+func (c *executorCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueLengthDesc
+	ch <- queuePipelineRunsDesc
+	ch <- workersDesc
+}
+
+func (c *executorCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.executor.Stats()
+	ch <- prometheus.MustNewConstMetric(queueLengthDesc, prometheus.GaugeValue, float64(stats.Queued))
+	for pipelineID, n := range stats.QueuedPerPipeline {
+		ch <- prometheus.MustNewConstMetric(queuePipelineRunsDesc, prometheus.GaugeValue, float64(n), pipelineID)
+	}
+	ch <- prometheus.MustNewConstMetric(workersDesc, prometheus.GaugeValue, float64(stats.BusyWorkers), "busy")
+	ch <- prometheus.MustNewConstMetric(workersDesc, prometheus.GaugeValue, float64(stats.Workers-stats.BusyWorkers), "idle")
+}
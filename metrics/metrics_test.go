@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hphilipps/stagerunner/domain"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeExecutor is returning fixed stats
+type fakeExecutor struct {
+	stats domain.ExecutorStats
+}
+
+func (e *fakeExecutor) Stats() domain.ExecutorStats {
+	return e.stats
+}
+
+func TestMetrics(t *testing.T) {
+	m := New()
+	m.RegisterExecutor(&fakeExecutor{stats: domain.ExecutorStats{
+		Workers:           3,
+		BusyWorkers:       1,
+		Queued:            4,
+		QueuedPerPipeline: map[string]int{"p1": 3, "p2": 1},
+	}})
+
+	m.ObserveRequest(http.MethodGet, "/v1/pipelines/{id}", http.StatusOK, 10*time.Millisecond)
+	m.ObserveRequest(http.MethodGet, "/v1/pipelines/{id}", http.StatusOK, 20*time.Millisecond)
+	m.ObserveRequest(http.MethodGet, "/v1/pipelines/{id}", http.StatusNotFound, 5*time.Millisecond)
+	m.RunFinished("p1", domain.StatusSuccess, 15*time.Second)
+	m.RunFinished("p1", domain.StatusFailed, 5*time.Second)
+	m.StageFinished("p1", domain.StageRun, domain.StatusSuccess, 5*time.Second)
+	m.StageFinished("p1", domain.StageBuild, domain.StatusFailed, time.Second)
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.httpRequests.WithLabelValues(http.MethodGet, "/v1/pipelines/{id}", "200")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.httpRequests.WithLabelValues(http.MethodGet, "/v1/pipelines/{id}", "404")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.runs.WithLabelValues("p1", domain.StatusSuccess)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.runs.WithLabelValues("p1", domain.StatusFailed)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.stages.WithLabelValues("p1", domain.StageBuild, domain.StatusFailed)))
+
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+
+	for _, line := range []string{
+		`stagerunner_http_request_duration_seconds_count{method="GET",route="/v1/pipelines/{id}"} 3`,
+		`stagerunner_pipeline_run_duration_seconds_count{pipeline_id="p1"} 2`,
+		`stagerunner_pipeline_run_duration_seconds_sum{pipeline_id="p1"} 20`,
+		`stagerunner_stage_duration_seconds_count{pipeline_id="p1",stage="run"} 1`,
+		`stagerunner_queue_length 4`,
+		`stagerunner_queue_pipeline_runs{pipeline_id="p1"} 3`,
+		`stagerunner_queue_pipeline_runs{pipeline_id="p2"} 1`,
+		`stagerunner_workers{state="busy"} 1`,
+		`stagerunner_workers{state="idle"} 2`,
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+	assert.True(t, strings.Contains(body, "go_goroutines"), "missing Go runtime metrics")
+}
@@ -0,0 +1,301 @@
+// Package plugin is providing stage types implemented by executables, which are discovered in a
+// directory. A plugin is started for every request and talks to the server with lines of JSON:
+// the request is written to its stdin, the plugin writes its messages to stdout, see Request and Message.
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hphilipps/stagerunner/domain"
+)
+
+// describeTimeout is the time a plugin has to describe itself
+const describeTimeout = 10 * time.Second
+
+// validName is the format of the names of plugin stage types, which are used in the keys of the API
+var validName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// Plugin is a stage type implemented by an executable.
+type Plugin struct {
+	// Path is the path of the executable
+	Path string
+	// Name is the name of the stage type
+	Name string
+	// Description is describing what a stage is doing in the execution plan
+	Description string
+	// Schema is the JSON schema of the settings of a stage
+	Schema map[string]interface{}
+	// Required is true if every pipeline has to have a stage of the plugin
+	Required bool
+	// FailsRun is true if a failed stage always fails the run
+	FailsRun bool
+	// Timeout is the maximum time a stage may take until the plugin reports its result,
+	// stages are not limited if it is zero
+	Timeout time.Duration
+}
+
+// Describe starts the executable with a describe request and returns the plugin it describes.
+func Describe(ctx context.Context, path string) (*Plugin, error) {
+	var output []string
+	collect := func(line string) { output = append(output, line) }
+	msg, err := call(ctx, path, Request{Type: TypeDescribe, Protocol: ProtocolVersion}, describeTimeout, collect, collect)
+	if err != nil {
+		if len(output) > 0 {
+			err = fmt.Errorf("%w: %s", err, strings.Join(output, "\n"))
+		}
+		return nil, fmt.Errorf("failed to describe plugin %s: %w", path, err)
+	}
+	if msg.Protocol != ProtocolVersion {
+		return nil, fmt.Errorf("plugin %s uses protocol version %d, only version %d is supported", path, msg.Protocol, ProtocolVersion)
+	}
+	if !validName.MatchString(msg.Name) {
+		return nil, fmt.Errorf("plugin %s has the invalid name %q, must be lowercase letters, digits and underscores", path, msg.Name)
+	}
+	return &Plugin{
+		Path:        path,
+		Name:        msg.Name,
+		Description: msg.Description,
+		Schema:      msg.Schema,
+		Required:    msg.Required,
+		FailsRun:    msg.FailsRun,
+	}, nil
+}
+
+// Discover describes the executables in dir, in the order of their file names. Directories, hidden files
+// and files which are not executable are ignored. An error is returned for the first executable which
+// can't be described.
+func Discover(ctx context.Context, dir string) ([]*Plugin, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plugin directory: %w", err)
+	}
+
+	var plugins []*Plugin
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		// symlinks to executables are plugins as well
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read plugin: %w", err)
+		}
+		if !info.Mode().IsRegular() || info.Mode().Perm()&0o111 == 0 {
+			continue
+		}
+		plugin, err := Describe(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		plugins = append(plugins, plugin)
+	}
+	return plugins, nil
+}
+
+// Load discovers the plugins in dir and registers their stage types, stages of the plugins are limited
+// to the timeout. Plugins must not have the name of another stage type.
+func Load(ctx context.Context, dir string, timeout time.Duration) ([]*Plugin, error) {
+	plugins, err := Discover(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, plugin := range plugins {
+		if _, ok := domain.LookupStageType(plugin.Name); ok {
+			return nil, fmt.Errorf("plugin %s has the name of another stage type %q", plugin.Path, plugin.Name)
+		}
+		plugin.Timeout = timeout
+		domain.RegisterStageType(plugin.StageType())
+	}
+	return plugins, nil
+}
+
+// StageType returns the stage type of the plugin, executing its stages with the executable.
+func (p *Plugin) StageType() domain.StageType {
+	return domain.StageType{
+		Name:   p.Name,
+		Schema: p.settingsSchema(),
+		Decode: p.decode,
+		Execute: func(ctx context.Context, x *domain.StageExecution) error {
+			return p.execute(ctx, x)
+		},
+		Describe: func(domain.Stage) string {
+			if p.Description != "" {
+				return p.Description
+			}
+			return fmt.Sprintf("run the %s plugin", p.Name)
+		},
+		Optional: !p.Required,
+		FailsRun: p.FailsRun,
+	}
+}
+
+// settingsSchema returns the schema of the plugin with the continue_on_error setting of all stages
+func (p *Plugin) settingsSchema() map[string]interface{} {
+	schema := maps.Clone(p.Schema)
+	if schema == nil {
+		schema = map[string]interface{}{"type": "object"}
+	}
+	properties, _ := schema["properties"].(map[string]interface{})
+	properties = maps.Clone(properties)
+	if properties == nil {
+		properties = map[string]interface{}{}
+	}
+	properties["continue_on_error"] = map[string]interface{}{"type": "boolean"}
+	schema["properties"] = properties
+	return schema
+}
+
+func (p *Plugin) decode(data []byte) (domain.Stage, error) {
+	stage := &Stage{plugin: p}
+	if err := json.Unmarshal(data, &stage.Settings); err != nil {
+		return nil, err
+	}
+	if raw, ok := stage.Settings["continue_on_error"]; ok {
+		if err := json.Unmarshal(raw, &stage.ContOnError); err != nil {
+			return nil, fmt.Errorf("continue_on_error: %w", err)
+		}
+		delete(stage.Settings, "continue_on_error")
+	}
+	return stage, nil
+}
+
+// execute runs the stage with the executable, passing its log messages to the log of the stage
+func (p *Plugin) execute(ctx context.Context, x *domain.StageExecution) error {
+	stage := x.Stage.(*Stage)
+	if err := stage.Validate(); err != nil {
+		x.Log(ctx, domain.StatusFailed, err.Error())
+		return err
+	}
+	settings, err := json.Marshal(stage.Settings)
+	if err != nil {
+		return err
+	}
+
+	x.Log(ctx, domain.StatusRunning, "starting...")
+	result, err := call(ctx, p.Path, Request{
+		Type:     TypeExecute,
+		Protocol: ProtocolVersion,
+		Stage:    x.Name,
+		Settings: settings,
+		Run: &RunInfo{
+			PipelineID:   x.Pipeline.ID,
+			PipelineName: x.Pipeline.Name,
+			Repository:   x.Pipeline.Repository,
+			RunID:        x.Run.ID,
+			GitRef:       x.Run.GitRef,
+		},
+	}, p.Timeout, func(line string) {
+		x.Log(ctx, domain.StatusRunning, line)
+	}, func(line string) {
+		x.Log(ctx, domain.StatusRunning, "stderr: "+line)
+	})
+	switch {
+	case err != nil && ctx.Err() != nil:
+		x.Log(ctx, domain.StatusFailed, "cancelled")
+		return err
+	case err != nil:
+		x.Log(ctx, domain.StatusFailed, err.Error())
+		return err
+	case result.Status != ResultSuccess:
+		message := result.Error
+		if message == "" {
+			message = "failed"
+		}
+		x.Log(ctx, domain.StatusFailed, message)
+		return fmt.Errorf("plugin %s failed: %s", p.Name, message)
+	}
+	x.Log(ctx, domain.StatusSuccess, "finished")
+	return nil
+}
+
+// Stage is a stage of a plugin type with the settings the plugin is executed with.
+type Stage struct {
+	// Settings are the settings of the stage except for continue_on_error
+	Settings    map[string]json.RawMessage
+	ContOnError bool
+	plugin      *Plugin
+}
+
+func (s *Stage) ContinueOnError() bool {
+	return s.ContOnError
+}
+
+// MarshalJSON returns the settings of the stage, as decoded by the stage type of the plugin
+func (s *Stage) MarshalJSON() ([]byte, error) {
+	settings := maps.Clone(s.Settings)
+	if settings == nil {
+		settings = map[string]json.RawMessage{}
+	}
+	settings["continue_on_error"] = json.RawMessage(fmt.Sprint(s.ContOnError))
+	return json.Marshal(settings)
+}
+
+// Validate checks that the required settings of the schema of the plugin are present
+// and that the settings have the types of the schema.
+func (s *Stage) Validate() error {
+	schema := s.plugin.Schema
+	required, _ := schema["required"].([]interface{})
+	for _, name := range required {
+		name, _ := name.(string)
+		if _, ok := s.Settings[name]; name != "" && !ok {
+			return &domain.ValidationError{
+				Stage: s.plugin.Name, Field: name,
+				Message: fmt.Sprintf("%s is required for %s stage", strings.ReplaceAll(name, "_", " "), s.plugin.Name),
+			}
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	names := make([]string, 0, len(s.Settings))
+	for name := range s.Settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		property, _ := properties[name].(map[string]interface{})
+		want, _ := property["type"].(string)
+		if want == "" {
+			continue
+		}
+		var value interface{}
+		if err := json.Unmarshal(s.Settings[name], &value); err != nil {
+			return err
+		}
+		if !hasType(value, want) {
+			return &domain.ValidationError{
+				Stage: s.plugin.Name, Field: name,
+				Message: fmt.Sprintf("%s must be of type %s", strings.ReplaceAll(name, "_", " "), want),
+			}
+		}
+	}
+	return nil
+}
+
+// hasType returns true if the decoded JSON value has the JSON schema type
+func hasType(value interface{}, schemaType string) bool {
+	switch v := value.(type) {
+	case nil:
+		return schemaType == "null"
+	case bool:
+		return schemaType == "boolean"
+	case float64:
+		return schemaType == "number" || (schemaType == "integer" && v == float64(int64(v)))
+	case string:
+		return schemaType == "string"
+	case []interface{}:
+		return schemaType == "array"
+	case map[string]interface{}:
+		return schemaType == "object"
+	}
+	return false
+}
@@ -0,0 +1,249 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hphilipps/stagerunner/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testPluginEnv makes the test binary behave like a plugin of the name in the variable
+const testPluginEnv = "STAGERUNNER_TEST_PLUGIN"
+
+func TestMain(m *testing.M) {
+	if name := os.Getenv(testPluginEnv); name != "" {
+		os.Exit(runTestPlugin(name))
+	}
+	os.Exit(m.Run())
+}
+
+// runTestPlugin is a plugin sending notifications to channels. The channel of the settings decides how it
+// behaves: #broken fails, #crash exits without a result, #slow doesn't finish and #lingering is slow to exit
+// after its result. Plugins named invalid
+// don't follow the protocol.
+func runTestPlugin(name string) int {
+	var req Request
+	if err := json.NewDecoder(bufio.NewReader(os.Stdin)).Decode(&req); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	enc := json.NewEncoder(os.Stdout)
+	if name == "invalid" {
+		fmt.Println("no protocol here")
+		return 0
+	}
+
+	if req.Type == TypeDescribe {
+		enc.Encode(Message{
+			Type: TypeDescribe, Protocol: ProtocolVersion, Name: name, Description: "send a notification",
+			Schema: map[string]interface{}{
+				"type":       "object",
+				"properties": map[string]interface{}{"channel": map[string]interface{}{"type": "string"}},
+				"required":   []string{"channel"},
+			},
+		})
+		return 0
+	}
+
+	var settings struct {
+		Channel string `json:"channel"`
+	}
+	json.Unmarshal(req.Settings, &settings)
+	enc.Encode(Message{Type: TypeLog, Message: fmt.Sprintf("notifying %s about %s of %s", settings.Channel, req.Run.GitRef, req.Run.PipelineName)})
+	fmt.Fprintln(os.Stderr, "some warning")
+	switch settings.Channel {
+	case "#broken":
+		enc.Encode(Message{Type: TypeResult, Status: ResultFailed, Error: "channel is broken"})
+		return 1
+	case "#crash":
+		return 2
+	case "#slow":
+		time.Sleep(time.Minute)
+	}
+	fmt.Println("plain output")
+	enc.Encode(Message{Type: TypeResult, Status: ResultSuccess})
+	if settings.Channel == "#lingering" {
+		time.Sleep(3 * time.Second)
+	}
+	return 0
+}
+
+// writeTestPlugin writes an executable to dir which runs the test binary as plugin of the name
+func writeTestPlugin(t *testing.T, dir, name string) string {
+	exe, err := os.Executable()
+	require.NoError(t, err)
+	path := filepath.Join(dir, name)
+	script := fmt.Sprintf("#!/bin/sh\n%s=%s exec %q \"$@\"\n", testPluginEnv, name, exe)
+	require.NoError(t, os.WriteFile(path, []byte(script), 0o755))
+	return path
+}
+
+func TestDiscover(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTestPlugin(t, dir, "notify")
+	// ignored files
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README"), []byte("not a plugin"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".hidden"), []byte("#!/bin/sh\nexit 1\n"), 0o755))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "lib"), 0o755))
+
+	plugins, err := Discover(context.Background(), dir)
+	require.NoError(t, err)
+	require.Len(t, plugins, 1)
+	assert.Equal(t, path, plugins[0].Path)
+	assert.Equal(t, "notify", plugins[0].Name)
+	assert.Equal(t, "send a notification", plugins[0].Description)
+	assert.False(t, plugins[0].Required)
+	assert.Equal(t, []interface{}{"channel"}, plugins[0].Schema["required"])
+}
+
+func TestDiscover_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		write   func(t *testing.T, dir string)
+		wantErr string
+	}{
+		{
+			name:    "missing directory",
+			write:   func(t *testing.T, dir string) { require.NoError(t, os.Remove(dir)) },
+			wantErr: "failed to read plugin directory",
+		},
+		{
+			name:    "no describe message",
+			write:   func(t *testing.T, dir string) { writeTestPlugin(t, dir, "invalid") },
+			wantErr: "plugin exited without a describe message: no protocol here",
+		},
+		{
+			name:    "invalid name",
+			write:   func(t *testing.T, dir string) { writeTestPlugin(t, dir, "Notify") },
+			wantErr: `invalid name "Notify"`,
+		},
+		{
+			name: "failing",
+			write: func(t *testing.T, dir string) {
+				require.NoError(t, os.WriteFile(filepath.Join(dir, "fail"), []byte("#!/bin/sh\necho broken >&2\nexit 1\n"), 0o755))
+			},
+			wantErr: "plugin failed: exit status 1: broken",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tt.write(t, dir)
+			_, err := Discover(context.Background(), dir)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	writeTestPlugin(t, dir, "plugin_notify")
+
+	plugins, err := Load(context.Background(), dir, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, plugins, 1)
+	stageType, ok := domain.LookupStageType("plugin_notify")
+	require.True(t, ok)
+	assert.True(t, stageType.Optional)
+
+	// the name is taken now
+	_, err = Load(context.Background(), dir, time.Minute)
+	assert.ErrorContains(t, err, "has the name of another stage type")
+}
+
+func TestStage(t *testing.T) {
+	plugin, err := Describe(context.Background(), writeTestPlugin(t, t.TempDir(), "notify"))
+	require.NoError(t, err)
+	stageType := plugin.StageType()
+
+	stage, err := stageType.Decode([]byte(`{"channel":"#builds","continue_on_error":true}`))
+	require.NoError(t, err)
+	assert.True(t, stage.ContinueOnError())
+	assert.NoError(t, stage.Validate())
+	data, err := json.Marshal(stage)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"channel":"#builds","continue_on_error":true}`, string(data))
+	assert.Equal(t, "send a notification", stageType.Describe(stage))
+
+	stage, err = stageType.Decode([]byte(`{}`))
+	require.NoError(t, err)
+	assert.EqualError(t, stage.Validate(), "channel is required for notify stage")
+
+	stage, err = stageType.Decode([]byte(`{"channel":5}`))
+	require.NoError(t, err)
+	assert.EqualError(t, stage.Validate(), "channel must be of type string")
+
+	_, err = stageType.Decode([]byte(`{"continue_on_error":"yes"}`))
+	assert.Error(t, err)
+}
+
+func TestPlugin_Execute(t *testing.T) {
+	plugin, err := Describe(context.Background(), writeTestPlugin(t, t.TempDir(), "notify"))
+	require.NoError(t, err)
+	// the timeout only limits the time until the result, not the exit of the plugin
+	plugin.Timeout = 2 * time.Second
+
+	tests := []struct {
+		name    string
+		channel string
+		cancel  bool
+		wantErr string
+		wantLog []string
+	}{
+		{
+			name: "success", channel: "#builds",
+			wantLog: []string{"notifying #builds about main of Pipeline 1", "stderr: some warning", "plain output", "success - finished"},
+		},
+		{name: "slow exit after the result", channel: "#lingering", wantLog: []string{"success - finished"}},
+		{name: "failure", channel: "#broken", wantErr: "plugin notify failed: channel is broken", wantLog: []string{"failed - channel is broken"}},
+		{name: "no result", channel: "#crash", wantErr: "plugin failed: exit status 2"},
+		{name: "timeout", channel: "#slow", wantErr: "plugin timed out after 2s"},
+		{name: "cancelled", channel: "#slow", cancel: true, wantErr: domain.ErrRunCancelled.Error(), wantLog: []string{"failed - cancelled"}},
+		{name: "invalid settings", channel: "", wantErr: "channel is required for notify stage"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancelCause(context.Background())
+			defer cancel(nil)
+			if tt.cancel {
+				time.AfterFunc(100*time.Millisecond, func() { cancel(domain.ErrRunCancelled) })
+			}
+
+			pipeline := &domain.Pipeline{ID: "pipeline-1", Name: "Pipeline 1", Repository: "github.com/test/repo"}
+			run := domain.NewPipelineRun(pipeline.ID, "main")
+			settings := `{}`
+			if tt.channel != "" {
+				settings = fmt.Sprintf(`{"channel":%q}`, tt.channel)
+			}
+			stage, err := plugin.StageType().Decode([]byte(settings))
+			require.NoError(t, err)
+
+			start := time.Now()
+			err = plugin.StageType().Execute(ctx, &domain.StageExecution{Pipeline: pipeline, Run: run, Name: "notify", Stage: stage})
+			assert.Less(t, time.Since(start), killDelay, "plugin has not been terminated")
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+				assert.False(t, errors.Is(err, context.Canceled))
+			}
+			for _, line := range tt.wantLog {
+				assert.Contains(t, run.Logs["notify"], line)
+			}
+			if tt.channel != "" {
+				assert.Equal(t, 1, strings.Count(run.Logs["notify"], "starting..."), run.Logs["notify"])
+			}
+		})
+	}
+}
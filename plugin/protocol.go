@@ -0,0 +1,211 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ProtocolVersion is the version of the plugin protocol, sent with every request and
+// expected in the describe message of a plugin
+const ProtocolVersion = 1
+
+// Types of the requests and messages of the protocol
+const (
+	TypeDescribe = "describe"
+	TypeExecute  = "execute"
+	TypeLog      = "log"
+	TypeResult   = "result"
+)
+
+// Statuses of result messages
+const (
+	ResultSuccess = "success"
+	ResultFailed  = "failed"
+)
+
+const (
+	// killDelay is the time a plugin has to exit after SIGTERM before it is killed
+	killDelay = 5 * time.Second
+	// maxLineLength is the maximum length of a line of a plugin, longer lines are split
+	maxLineLength = 1 << 20
+)
+
+// Request is the single line of JSON written to the stdin of a plugin. A plugin is started for every request.
+type Request struct {
+	Type     string `json:"type"`
+	Protocol int    `json:"protocol"`
+	// Stage, Settings and Run are set for execute requests
+	Stage    string          `json:"stage,omitempty"`
+	Settings json.RawMessage `json:"settings,omitempty"`
+	Run      *RunInfo        `json:"run,omitempty"`
+}
+
+// RunInfo is the pipeline run a stage is executed in
+type RunInfo struct {
+	PipelineID   string `json:"pipeline_id"`
+	PipelineName string `json:"pipeline_name"`
+	Repository   string `json:"repository"`
+	RunID        string `json:"run_id"`
+	GitRef       string `json:"git_ref"`
+}
+
+// Message is a line of JSON written by a plugin to stdout. A plugin answers a describe request
+// with a describe message and an execute request with any number of log messages and a result.
+type Message struct {
+	Type string `json:"type"`
+
+	// Protocol, Name, Description, Schema, Required and FailsRun are the fields of describe messages
+	Protocol    int                    `json:"protocol,omitempty"`
+	Name        string                 `json:"name,omitempty"`
+	Description string                 `json:"description,omitempty"`
+	Schema      map[string]interface{} `json:"schema,omitempty"`
+	Required    bool                   `json:"required,omitempty"`
+	FailsRun    bool                   `json:"fails_run,omitempty"`
+
+	// Message is the line of log messages
+	Message string `json:"message,omitempty"`
+
+	// Status and Error are the fields of result messages
+	Status string `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// call starts the executable with the request and returns the describe or result message it wrote.
+// Log messages and lines which are not JSON are passed to logf, lines of stderr to stderrf, one at a time.
+// The plugin is terminated if ctx is done or if it didn't reply within the timeout, which doesn't limit
+// the plugin if it is zero. The cause of the termination is returned then. Once the plugin has replied,
+// the reply is returned even if the plugin exits with an error or has to be terminated.
+func call(ctx context.Context, path string, req Request, timeout time.Duration, logf, stderrf func(line string)) (*Message, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// describe requests are answered with a describe message, execute requests with a result
+	want := TypeResult
+	if req.Type == TypeDescribe {
+		want = TypeDescribe
+	}
+
+	var mu sync.Mutex
+	var reply *Message
+	var protocolErr error
+	replied := make(chan struct{})
+	stdout := &lineWriter{mu: &mu, line: func(line string) {
+		var msg Message
+		if err := json.Unmarshal([]byte(line), &msg); err != nil || msg.Type == "" || reply != nil {
+			// plain output of a plugin and anything after its reply is logged as well
+			logf(line)
+			return
+		}
+		switch msg.Type {
+		case TypeLog:
+			logf(msg.Message)
+		case want:
+			reply = &msg
+			close(replied)
+		default:
+			protocolErr = fmt.Errorf("unexpected %s message in reply to a %s request", msg.Type, req.Type)
+		}
+	}}
+	stderr := &lineWriter{mu: &mu, line: stderrf}
+
+	// procCtx is terminating the plugin, it is not cancelled by ctx after the reply
+	procCtx, terminate := context.WithCancelCause(context.Background())
+	defer terminate(nil)
+	exited := make(chan struct{})
+	go func() {
+		var expired <-chan time.Time
+		if timeout > 0 {
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			expired = timer.C
+		}
+		select {
+		case <-ctx.Done():
+			terminate(context.Cause(ctx))
+		case <-expired:
+			terminate(fmt.Errorf("plugin timed out after %s", timeout))
+		case <-replied:
+			// the plugin is done, but must not keep running forever
+			timer := time.NewTimer(killDelay)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+				terminate(fmt.Errorf("plugin did not exit after its %s message", want))
+			case <-exited:
+			}
+		case <-exited:
+		}
+	}()
+
+	cmd := exec.CommandContext(procCtx, path)
+	cmd.Stdin = bytes.NewReader(append(data, '\n'))
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// plugins can clean up after SIGTERM, they are killed if they don't exit in time
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(syscall.SIGTERM)
+	}
+	cmd.WaitDelay = killDelay
+
+	err = cmd.Run()
+	close(exited)
+	stdout.flush()
+	stderr.flush()
+	switch {
+	case protocolErr != nil:
+		return nil, protocolErr
+	case reply != nil:
+		return reply, nil
+	case procCtx.Err() != nil:
+		return nil, context.Cause(procCtx)
+	case err != nil:
+		return nil, fmt.Errorf("plugin failed: %w", err)
+	}
+	return nil, fmt.Errorf("plugin exited without a %s message", want)
+}
+
+// lineWriter is passing the lines written to it to a func, which is called with the lock held
+type lineWriter struct {
+	mu   *sync.Mutex
+	buf  []byte
+	line func(line string)
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.emit(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+	if len(w.buf) > maxLineLength {
+		w.flush()
+	}
+	return len(p), nil
+}
+
+// flush passes the rest of the output which is not terminated by a newline
+func (w *lineWriter) flush() {
+	if len(w.buf) > 0 {
+		w.emit(w.buf)
+		w.buf = nil
+	}
+}
+
+func (w *lineWriter) emit(line []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.line(strings.TrimSuffix(string(line), "\r"))
+}
@@ -0,0 +1,62 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hphilipps/stagerunner/domain"
+)
+
+// MemoryAuditLog implements AuditLog interface using an in-memory slice
+type MemoryAuditLog struct {
+	entries []*domain.AuditEntry
+	mu      sync.RWMutex
+}
+
+// NewMemoryAuditLog creates a new instance of MemoryAuditLog
+func NewMemoryAuditLog() *MemoryAuditLog {
+	return &MemoryAuditLog{}
+}
+
+// AppendAuditEntry implements AuditLog interface.
+// The log keeps a copy of the entry, so it can't be changed afterwards.
+func (l *MemoryAuditLog) AppendAuditEntry(ctx context.Context, entry *domain.AuditEntry) error {
+	e := *entry
+	if e.ID == "" {
+		e.ID = uuid.New().String()
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Changes = append([]domain.AuditChange(nil), entry.Changes...)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries = append(l.entries, &e)
+	entry.ID, entry.Time = e.ID, e.Time
+	return nil
+}
+
+// ListAuditEntries implements AuditLog interface
+func (l *MemoryAuditLog) ListAuditEntries(ctx context.Context, filter domain.AuditFilter, opts domain.ListOptions) ([]*domain.AuditEntry, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var matches []*domain.AuditEntry
+	for _, e := range l.entries {
+		if filter.Match(e) {
+			matches = append(matches, e)
+		}
+	}
+
+	start, end := opts.Bounds(len(matches))
+	entries := make([]*domain.AuditEntry, 0, end-start)
+	for _, e := range matches[start:end] {
+		entry := *e
+		entries = append(entries, &entry)
+	}
+	return entries, nil
+}
@@ -0,0 +1,43 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hphilipps/stagerunner/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryAuditLog(t *testing.T) {
+	ctx := context.Background()
+	log := NewMemoryAuditLog()
+
+	first := &domain.AuditEntry{Actor: "alice", Action: "pipeline.create", TargetID: "p1"}
+	require.NoError(t, log.AppendAuditEntry(ctx, first))
+	assert.NotEmpty(t, first.ID)
+	assert.False(t, first.Time.IsZero())
+
+	require.NoError(t, log.AppendAuditEntry(ctx, &domain.AuditEntry{Actor: "bob", Action: "pipeline.delete", TargetID: "p1"}))
+	require.NoError(t, log.AppendAuditEntry(ctx, &domain.AuditEntry{Actor: "alice", Action: "pipeline.trigger", TargetID: "p2"}))
+
+	// changing an appended entry must not change the log
+	first.Actor = "mallory"
+
+	entries, err := log.ListAuditEntries(ctx, domain.AuditFilter{}, domain.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, "alice", entries[0].Actor)
+	assert.Equal(t, "pipeline.trigger", entries[2].Action)
+
+	entries, err = log.ListAuditEntries(ctx, domain.AuditFilter{Actor: "alice"}, domain.ListOptions{Offset: 1})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "p2", entries[0].TargetID)
+
+	// changing a listed entry must not change the log
+	entries[0].Actor = "mallory"
+	entries, err = log.ListAuditEntries(ctx, domain.AuditFilter{Actor: "mallory"}, domain.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
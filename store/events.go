@@ -0,0 +1,111 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hphilipps/stagerunner/domain"
+)
+
+const (
+	// eventHistorySize is the number of events which are kept to resume watches
+	eventHistorySize = 1000
+	// watchBufferSize is the number of events a watcher can fall behind before it is dropped
+	watchBufferSize = 100
+)
+
+// eventBroker is numbering events, keeping a history of them and fanning them out to watchers.
+type eventBroker struct {
+	seq      uint64
+	history  []domain.Event
+	watchers map[*watcher]struct{}
+	mu       sync.Mutex
+}
+
+type watcher struct {
+	filter domain.WatchFilter
+	ch     chan domain.Event
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{
+		watchers: make(map[*watcher]struct{}),
+	}
+}
+
+// publish is assigning the next sequence number to the event and sends it to all matching watchers.
+// Watchers which can't keep up are dropped by closing their channel.
+func (b *eventBroker) publish(event domain.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	event.Sequence = b.seq
+	event.Time = time.Now()
+
+	b.history = append(b.history, event)
+	if len(b.history) > eventHistorySize {
+		b.history = b.history[len(b.history)-eventHistorySize:]
+	}
+
+	for w := range b.watchers {
+		if !w.filter.Match(event) {
+			continue
+		}
+		select {
+		case w.ch <- event:
+		default:
+			delete(b.watchers, w)
+			close(w.ch)
+		}
+	}
+}
+
+// watch is registering a new watcher which is removed when the context is cancelled.
+// If the filter is resuming from a previous event, the missed events are replayed first. ErrEventsExpired
+// is returned if the event is not in the retained history, including events the broker doesn't know.
+func (b *eventBroker) watch(ctx context.Context, filter domain.WatchFilter) (<-chan domain.Event, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var backlog []domain.Event
+	if filter.After > 0 {
+		if !b.retains(filter.After) {
+			return nil, fmt.Errorf("%w: can not resume after event %d", domain.ErrEventsExpired, filter.After)
+		}
+		for _, event := range b.history {
+			if filter.Match(event) {
+				backlog = append(backlog, event)
+			}
+		}
+	}
+
+	w := &watcher{filter: filter, ch: make(chan domain.Event, len(backlog)+watchBufferSize)}
+	for _, event := range backlog {
+		w.ch <- event
+	}
+	b.watchers[w] = struct{}{}
+
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.watchers[w]; ok {
+			delete(b.watchers, w)
+			close(w.ch)
+		}
+	}()
+
+	return w.ch, nil
+}
+
+// retains returns true if the events after the sequence number are in the history. Sequence numbers
+// after the last event are not known, they were published before the store was restarted.
+func (b *eventBroker) retains(seq uint64) bool {
+	if seq > b.seq {
+		return false
+	}
+	return seq == b.seq || (len(b.history) > 0 && b.history[0].Sequence <= seq+1)
+}
@@ -0,0 +1,51 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hphilipps/stagerunner/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventBroker(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("expired resume", func(t *testing.T) {
+		b := newEventBroker()
+		for i := 0; i < eventHistorySize+10; i++ {
+			b.publish(domain.Event{Type: domain.EventPipelineCreated})
+		}
+
+		_, err := b.watch(ctx, domain.WatchFilter{After: 5})
+		assert.ErrorIs(t, err, domain.ErrEventsExpired)
+
+		ch, err := b.watch(ctx, domain.WatchFilter{After: 10})
+		require.NoError(t, err)
+		assert.Len(t, ch, eventHistorySize)
+
+		// the events of a previous store are unknown
+		_, err = b.watch(ctx, domain.WatchFilter{After: eventHistorySize + 11})
+		assert.ErrorIs(t, err, domain.ErrEventsExpired)
+		_, err = b.watch(ctx, domain.WatchFilter{After: eventHistorySize + 10})
+		assert.NoError(t, err)
+	})
+
+	t.Run("slow watcher is dropped", func(t *testing.T) {
+		b := newEventBroker()
+		ch, err := b.watch(ctx, domain.WatchFilter{})
+		require.NoError(t, err)
+
+		for i := 0; i < watchBufferSize+1; i++ {
+			b.publish(domain.Event{Type: domain.EventPipelineCreated})
+		}
+
+		received := 0
+		for range ch {
+			received++
+		}
+		assert.Equal(t, watchBufferSize, received)
+		assert.Empty(t, b.watchers)
+	})
+}
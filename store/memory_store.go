@@ -3,16 +3,22 @@ package store
 import (
 	"context"
 	"fmt"
+	"maps"
 	"sync"
 
 	"github.com/hphilipps/stagerunner/domain"
 )
 
-// MemoryStore implements Store interface using in-memory maps
+// MemoryStore implements Store interface using in-memory maps. It stores and returns copies
+// of the pipelines and runs, so they can be read while the executor is updating them.
 type MemoryStore struct {
 	pipelines    map[string]*domain.Pipeline
 	pipelineRuns map[string]*domain.PipelineRun
-	mu           sync.RWMutex
+	// pipelineIDs and pipelineRunIDs keep track of the creation order
+	pipelineIDs    []string
+	pipelineRunIDs []string
+	events         *eventBroker
+	mu             sync.RWMutex
 }
 
 // NewMemoryStore creates a new instance of MemoryStore
@@ -20,6 +26,7 @@ func NewMemoryStore() *MemoryStore {
 	return &MemoryStore{
 		pipelines:    make(map[string]*domain.Pipeline),
 		pipelineRuns: make(map[string]*domain.PipelineRun),
+		events:       newEventBroker(),
 	}
 }
 
@@ -32,7 +39,9 @@ func (s *MemoryStore) CreatePipeline(ctx context.Context, pipeline *domain.Pipel
 		return fmt.Errorf("%w: pipeline with ID %s already exists", domain.ErrAlreadyExists, pipeline.ID)
 	}
 
-	s.pipelines[pipeline.ID] = pipeline
+	s.pipelines[pipeline.ID] = pipeline.Clone()
+	s.pipelineIDs = append(s.pipelineIDs, pipeline.ID)
+	s.events.publish(domain.Event{Type: domain.EventPipelineCreated, PipelineID: pipeline.ID})
 	return nil
 }
 
@@ -45,7 +54,7 @@ func (s *MemoryStore) GetPipeline(ctx context.Context, id string) (*domain.Pipel
 	if !exists {
 		return nil, fmt.Errorf("%w: pipeline with ID %s not found", domain.ErrNotFound, id)
 	}
-	return pipeline, nil
+	return pipeline.Clone(), nil
 }
 
 // UpdatePipeline implements PipelineStore interface
@@ -57,7 +66,8 @@ func (s *MemoryStore) UpdatePipeline(ctx context.Context, pipeline *domain.Pipel
 		return fmt.Errorf("%w: pipeline with ID %s not found", domain.ErrNotFound, pipeline.ID)
 	}
 
-	s.pipelines[pipeline.ID] = pipeline
+	s.pipelines[pipeline.ID] = pipeline.Clone()
+	s.events.publish(domain.Event{Type: domain.EventPipelineUpdated, PipelineID: pipeline.ID})
 	return nil
 }
 
@@ -71,17 +81,52 @@ func (s *MemoryStore) DeletePipeline(ctx context.Context, id string) error {
 	}
 
 	delete(s.pipelines, id)
+	s.pipelineIDs = removeID(s.pipelineIDs, id)
+	s.events.publish(domain.Event{Type: domain.EventPipelineDeleted, PipelineID: id})
+	return nil
+}
+
+// DeletePipelineWithRuns implements PipelineStore interface
+func (s *MemoryStore) DeletePipelineWithRuns(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.pipelines[id]; !exists {
+		return fmt.Errorf("%w: pipeline with ID %s not found", domain.ErrNotFound, id)
+	}
+	var runIDs []string
+	for _, runID := range s.pipelineRunIDs {
+		run := s.pipelineRuns[runID]
+		if run.PipelineID != id {
+			continue
+		}
+		if !run.Finished() {
+			return &domain.RunNotFinishedError{RunID: runID}
+		}
+		runIDs = append(runIDs, runID)
+	}
+
+	for _, runID := range runIDs {
+		run := s.pipelineRuns[runID]
+		delete(s.pipelineRuns, runID)
+		s.pipelineRunIDs = removeID(s.pipelineRunIDs, runID)
+		s.events.publish(domain.Event{Type: domain.EventRunDeleted, PipelineID: id, RunID: runID, Status: run.Status})
+	}
+	delete(s.pipelines, id)
+	s.pipelineIDs = removeID(s.pipelineIDs, id)
+	s.events.publish(domain.Event{Type: domain.EventPipelineDeleted, PipelineID: id})
 	return nil
 }
 
 // ListPipelines implements PipelineStore interface
-func (s *MemoryStore) ListPipelines(ctx context.Context) ([]*domain.Pipeline, error) {
+func (s *MemoryStore) ListPipelines(ctx context.Context, opts domain.ListOptions) ([]*domain.Pipeline, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	pipelines := make([]*domain.Pipeline, 0, len(s.pipelines))
-	for _, p := range s.pipelines {
-		pipelines = append(pipelines, p)
+	start, end := opts.Bounds(len(s.pipelineIDs))
+	pipelines := make([]*domain.Pipeline, 0, end-start)
+	for _, id := range s.pipelineIDs[start:end] {
+		pipelines = append(pipelines, s.pipelines[id].Clone())
 	}
 	return pipelines, nil
 }
@@ -95,7 +140,9 @@ func (s *MemoryStore) CreatePipelineRun(ctx context.Context, pipelineRun *domain
 		return fmt.Errorf("%w: pipeline run with ID %s already exists", domain.ErrAlreadyExists, pipelineRun.ID)
 	}
 
-	s.pipelineRuns[pipelineRun.ID] = pipelineRun
+	s.pipelineRuns[pipelineRun.ID] = pipelineRun.Clone()
+	s.pipelineRunIDs = append(s.pipelineRunIDs, pipelineRun.ID)
+	s.events.publish(domain.Event{Type: domain.EventRunCreated, PipelineID: pipelineRun.PipelineID, RunID: pipelineRun.ID, Status: pipelineRun.Status})
 	return nil
 }
 
@@ -108,7 +155,7 @@ func (s *MemoryStore) GetPipelineRun(ctx context.Context, id string) (*domain.Pi
 	if !exists {
 		return nil, fmt.Errorf("%w: pipeline run with ID %s not found", domain.ErrNotFound, id)
 	}
-	return pipelineRun, nil
+	return pipelineRun.Clone(), nil
 }
 
 // UpdatePipelineRun implements PipelineRunStore interface
@@ -116,23 +163,58 @@ func (s *MemoryStore) UpdatePipelineRun(ctx context.Context, run *domain.Pipelin
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	_, exists := s.pipelineRuns[run.ID]
+	previous, exists := s.pipelineRuns[run.ID]
 	if !exists {
 		return fmt.Errorf("%w: pipeline run with ID %s not found", domain.ErrNotFound, run.ID)
 	}
 
-	s.pipelineRuns[run.ID] = run
+	s.pipelineRuns[run.ID] = run.Clone()
+	if previous.Status != run.Status || !maps.Equal(previous.StageStatuses, run.StageStatuses) {
+		s.events.publish(domain.Event{Type: domain.EventRunStatusChanged, PipelineID: run.PipelineID, RunID: run.ID, Status: run.Status})
+	}
+	return nil
+}
+
+// DeletePipelineRun implements PipelineRunStore interface
+func (s *MemoryStore) DeletePipelineRun(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	run, exists := s.pipelineRuns[id]
+	if !exists {
+		return fmt.Errorf("%w: pipeline run with ID %s not found", domain.ErrNotFound, id)
+	}
+
+	delete(s.pipelineRuns, id)
+	s.pipelineRunIDs = removeID(s.pipelineRunIDs, id)
+	s.events.publish(domain.Event{Type: domain.EventRunDeleted, PipelineID: run.PipelineID, RunID: id, Status: run.Status})
 	return nil
 }
 
 // ListPipelineRuns implements PipelineRunStore interface
-func (s *MemoryStore) ListPipelineRuns(ctx context.Context) ([]*domain.PipelineRun, error) {
+func (s *MemoryStore) ListPipelineRuns(ctx context.Context, opts domain.ListOptions) ([]*domain.PipelineRun, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	var runs []*domain.PipelineRun
-	for _, run := range s.pipelineRuns {
-		runs = append(runs, run)
+	start, end := opts.Bounds(len(s.pipelineRunIDs))
+	runs := make([]*domain.PipelineRun, 0, end-start)
+	for _, id := range s.pipelineRunIDs[start:end] {
+		runs = append(runs, s.pipelineRuns[id].Clone())
 	}
 	return runs, nil
 }
+
+// Watch implements Watcher interface
+func (s *MemoryStore) Watch(ctx context.Context, filter domain.WatchFilter) (<-chan domain.Event, error) {
+	return s.events.watch(ctx, filter)
+}
+
+// removeID removes the given id from the slice of ids, keeping the order
+func removeID(ids []string, id string) []string {
+	for i, v := range ids {
+		if v == id {
+			return append(ids[:i], ids[i+1:]...)
+		}
+	}
+	return ids
+}
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/hphilipps/stagerunner/domain"
+	"github.com/hphilipps/stagerunner/store/storetest"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -62,7 +63,7 @@ func TestMemoryStore_Pipeline(t *testing.T) {
 	})
 
 	t.Run("ListPipelines", func(t *testing.T) {
-		pipelines, err := store.ListPipelines(ctx)
+		pipelines, err := store.ListPipelines(ctx, domain.ListOptions{})
 		assert.NoError(t, err)
 		assert.Len(t, pipelines, 1)
 	})
@@ -159,8 +160,12 @@ func TestMemoryStore_PipelineRun(t *testing.T) {
 		_ = store.CreatePipelineRun(ctx, run2)
 
 		// Test listing runs for a pipeline
-		runs, err := store.ListPipelineRuns(ctx)
+		runs, err := store.ListPipelineRuns(ctx, domain.ListOptions{})
 		assert.NoError(t, err)
 		assert.Len(t, runs, 2)
 	})
 }
+
+func TestMemoryStore_Conformance(t *testing.T) {
+	storetest.Run(t, func() domain.Store { return NewMemoryStore() })
+}
@@ -0,0 +1,478 @@
+// Package storetest provides a conformance test suite for implementations of domain.Store.
+//
+// A store implementation can run the suite from its own tests:
+//
+//	func TestMyStore_Conformance(t *testing.T) {
+//		storetest.Run(t, func() domain.Store { return NewMyStore() })
+//	}
+package storetest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hphilipps/stagerunner/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// NewStoreFunc returns a new and empty store for every test.
+type NewStoreFunc func() domain.Store
+
+// Run is running the whole conformance suite against the stores returned by newStore.
+func Run(t *testing.T, newStore NewStoreFunc) {
+	t.Run("Pipeline", func(t *testing.T) { testPipelineCRUD(t, newStore()) })
+	t.Run("PipelineRun", func(t *testing.T) { testPipelineRunCRUD(t, newStore()) })
+	t.Run("DeletePipelineWithRuns", func(t *testing.T) { testDeletePipelineWithRuns(t, newStore()) })
+	t.Run("Ordering", func(t *testing.T) { testOrdering(t, newStore()) })
+	t.Run("Pagination", func(t *testing.T) { testPagination(t, newStore()) })
+	t.Run("Concurrency", func(t *testing.T) { testConcurrency(t, newStore()) })
+	t.Run("Copies", func(t *testing.T) { testCopies(t, newStore()) })
+	t.Run("Watch", func(t *testing.T) {
+		store := newStore()
+		if _, ok := store.(domain.Watcher); !ok {
+			t.Skip("store does not implement domain.Watcher")
+		}
+		testWatch(t, store)
+	})
+}
+
+// testPipelineCRUD is testing the pipeline CRUD operations and the errors they return
+func testPipelineCRUD(t *testing.T, store domain.Store) {
+	ctx := context.Background()
+
+	pipeline := &domain.Pipeline{ID: "test-pipeline", Name: "Test Pipeline"}
+
+	t.Run("CreatePipeline", func(t *testing.T) {
+		require.NoError(t, store.CreatePipeline(ctx, pipeline))
+
+		err := store.CreatePipeline(ctx, pipeline)
+		assert.ErrorIs(t, err, domain.ErrAlreadyExists)
+	})
+
+	t.Run("GetPipeline", func(t *testing.T) {
+		got, err := store.GetPipeline(ctx, pipeline.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "Test Pipeline", got.Name)
+
+		_, err = store.GetPipeline(ctx, "non-existent")
+		assert.ErrorIs(t, err, domain.ErrNotFound)
+	})
+
+	t.Run("UpdatePipeline", func(t *testing.T) {
+		err := store.UpdatePipeline(ctx, &domain.Pipeline{ID: pipeline.ID, Name: "Updated Pipeline"})
+		require.NoError(t, err)
+
+		got, err := store.GetPipeline(ctx, pipeline.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "Updated Pipeline", got.Name)
+
+		err = store.UpdatePipeline(ctx, &domain.Pipeline{ID: "non-existent"})
+		assert.ErrorIs(t, err, domain.ErrNotFound)
+	})
+
+	t.Run("ListPipelines", func(t *testing.T) {
+		pipelines, err := store.ListPipelines(ctx, domain.ListOptions{})
+		require.NoError(t, err)
+		require.Len(t, pipelines, 1)
+		assert.Equal(t, pipeline.ID, pipelines[0].ID)
+	})
+
+	t.Run("DeletePipeline", func(t *testing.T) {
+		require.NoError(t, store.DeletePipeline(ctx, pipeline.ID))
+
+		_, err := store.GetPipeline(ctx, pipeline.ID)
+		assert.ErrorIs(t, err, domain.ErrNotFound)
+
+		err = store.DeletePipeline(ctx, pipeline.ID)
+		assert.ErrorIs(t, err, domain.ErrNotFound)
+
+		pipelines, err := store.ListPipelines(ctx, domain.ListOptions{})
+		require.NoError(t, err)
+		assert.Empty(t, pipelines)
+	})
+}
+
+// testPipelineRunCRUD is testing the pipeline run CRUD operations and the errors they return
+func testPipelineRunCRUD(t *testing.T, store domain.Store) {
+	ctx := context.Background()
+
+	run := &domain.PipelineRun{ID: "test-run", PipelineID: "test-pipeline", Status: domain.StatusPending}
+
+	t.Run("CreatePipelineRun", func(t *testing.T) {
+		require.NoError(t, store.CreatePipelineRun(ctx, run))
+
+		err := store.CreatePipelineRun(ctx, run)
+		assert.ErrorIs(t, err, domain.ErrAlreadyExists)
+	})
+
+	t.Run("GetPipelineRun", func(t *testing.T) {
+		got, err := store.GetPipelineRun(ctx, run.ID)
+		require.NoError(t, err)
+		assert.Equal(t, domain.StatusPending, got.Status)
+		assert.Equal(t, "test-pipeline", got.PipelineID)
+
+		_, err = store.GetPipelineRun(ctx, "non-existent")
+		assert.ErrorIs(t, err, domain.ErrNotFound)
+	})
+
+	t.Run("UpdatePipelineRun", func(t *testing.T) {
+		err := store.UpdatePipelineRun(ctx, &domain.PipelineRun{
+			ID:         run.ID,
+			PipelineID: run.PipelineID,
+			Status:     domain.StatusRunning,
+			Logs:       map[string]string{domain.StageBuild: "Building..."},
+		})
+		require.NoError(t, err)
+
+		got, err := store.GetPipelineRun(ctx, run.ID)
+		require.NoError(t, err)
+		assert.Equal(t, domain.StatusRunning, got.Status)
+		assert.Equal(t, "Building...", got.Logs[domain.StageBuild])
+
+		err = store.UpdatePipelineRun(ctx, &domain.PipelineRun{ID: "non-existent"})
+		assert.ErrorIs(t, err, domain.ErrNotFound)
+	})
+
+	t.Run("ListPipelineRuns", func(t *testing.T) {
+		runs, err := store.ListPipelineRuns(ctx, domain.ListOptions{})
+		require.NoError(t, err)
+		require.Len(t, runs, 1)
+		assert.Equal(t, run.ID, runs[0].ID)
+	})
+
+	t.Run("DeletePipelineRun", func(t *testing.T) {
+		require.NoError(t, store.DeletePipelineRun(ctx, run.ID))
+
+		_, err := store.GetPipelineRun(ctx, run.ID)
+		assert.ErrorIs(t, err, domain.ErrNotFound)
+
+		err = store.DeletePipelineRun(ctx, run.ID)
+		assert.ErrorIs(t, err, domain.ErrNotFound)
+
+		runs, err := store.ListPipelineRuns(ctx, domain.ListOptions{})
+		require.NoError(t, err)
+		assert.Empty(t, runs)
+	})
+}
+
+// testDeletePipelineWithRuns is testing that pipelines are only deleted with their runs if all of them are finished
+func testDeletePipelineWithRuns(t *testing.T, store domain.Store) {
+	ctx := context.Background()
+	require.NoError(t, store.CreatePipeline(ctx, &domain.Pipeline{ID: "pipeline"}))
+	require.NoError(t, store.CreatePipeline(ctx, &domain.Pipeline{ID: "other"}))
+	require.NoError(t, store.CreatePipelineRun(ctx, &domain.PipelineRun{ID: "finished", PipelineID: "pipeline", Status: domain.StatusSuccess}))
+	require.NoError(t, store.CreatePipelineRun(ctx, &domain.PipelineRun{ID: "running", PipelineID: "pipeline", Status: domain.StatusRunning}))
+	require.NoError(t, store.CreatePipelineRun(ctx, &domain.PipelineRun{ID: "other-run", PipelineID: "other", Status: domain.StatusRunning}))
+
+	var notFinished *domain.RunNotFinishedError
+	err := store.DeletePipelineWithRuns(ctx, "pipeline")
+	require.ErrorAs(t, err, &notFinished)
+	assert.Equal(t, "running", notFinished.RunID)
+	// nothing has been deleted
+	_, err = store.GetPipeline(ctx, "pipeline")
+	require.NoError(t, err)
+	_, err = store.GetPipelineRun(ctx, "finished")
+	require.NoError(t, err)
+
+	require.NoError(t, store.UpdatePipelineRun(ctx, &domain.PipelineRun{ID: "running", PipelineID: "pipeline", Status: domain.StatusFailed}))
+	require.NoError(t, store.DeletePipelineWithRuns(ctx, "pipeline"))
+	_, err = store.GetPipeline(ctx, "pipeline")
+	assert.ErrorIs(t, err, domain.ErrNotFound)
+	runs, err := store.ListPipelineRuns(ctx, domain.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, runs, 1)
+	assert.Equal(t, "other-run", runs[0].ID)
+
+	assert.ErrorIs(t, store.DeletePipelineWithRuns(ctx, "pipeline"), domain.ErrNotFound)
+}
+
+// testOrdering is testing that list operations return items in creation order
+func testOrdering(t *testing.T, store domain.Store) {
+	ctx := context.Background()
+	n := 20
+
+	for i := 0; i < n; i++ {
+		require.NoError(t, store.CreatePipeline(ctx, &domain.Pipeline{ID: fmt.Sprintf("pipeline-%02d", n-i)}))
+		require.NoError(t, store.CreatePipelineRun(ctx, &domain.PipelineRun{ID: fmt.Sprintf("run-%02d", n-i)}))
+	}
+
+	// deleting must not change the order of the remaining items
+	require.NoError(t, store.DeletePipeline(ctx, fmt.Sprintf("pipeline-%02d", n/2)))
+	require.NoError(t, store.DeletePipelineRun(ctx, fmt.Sprintf("run-%02d", n/2)))
+
+	pipelines, err := store.ListPipelines(ctx, domain.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, pipelines, n-1)
+	prev := n + 1
+	for _, p := range pipelines {
+		var num int
+		_, err := fmt.Sscanf(p.ID, "pipeline-%d", &num)
+		require.NoError(t, err)
+		assert.Less(t, num, prev, "pipelines not in creation order")
+		prev = num
+	}
+
+	runs, err := store.ListPipelineRuns(ctx, domain.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, runs, n-1)
+	prev = n + 1
+	for _, r := range runs {
+		var num int
+		_, err := fmt.Sscanf(r.ID, "run-%d", &num)
+		require.NoError(t, err)
+		assert.Less(t, num, prev, "pipeline runs not in creation order")
+		prev = num
+	}
+}
+
+// testPagination is testing the offset and limit list options
+func testPagination(t *testing.T, store domain.Store) {
+	ctx := context.Background()
+	n := 10
+
+	for i := 0; i < n; i++ {
+		require.NoError(t, store.CreatePipeline(ctx, &domain.Pipeline{ID: fmt.Sprintf("pipeline-%d", i)}))
+		require.NoError(t, store.CreatePipelineRun(ctx, &domain.PipelineRun{ID: fmt.Sprintf("run-%d", i)}))
+	}
+
+	tests := []struct {
+		name    string
+		opts    domain.ListOptions
+		wantIDs []int
+	}{
+		{name: "no options", opts: domain.ListOptions{}, wantIDs: []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{name: "limit", opts: domain.ListOptions{Limit: 3}, wantIDs: []int{0, 1, 2}},
+		{name: "offset", opts: domain.ListOptions{Offset: 7}, wantIDs: []int{7, 8, 9}},
+		{name: "offset and limit", opts: domain.ListOptions{Offset: 4, Limit: 2}, wantIDs: []int{4, 5}},
+		{name: "limit beyond end", opts: domain.ListOptions{Offset: 8, Limit: 5}, wantIDs: []int{8, 9}},
+		{name: "offset beyond end", opts: domain.ListOptions{Offset: 20}, wantIDs: []int{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipelines, err := store.ListPipelines(ctx, tt.opts)
+			require.NoError(t, err)
+			runs, err := store.ListPipelineRuns(ctx, tt.opts)
+			require.NoError(t, err)
+
+			require.Len(t, pipelines, len(tt.wantIDs))
+			require.Len(t, runs, len(tt.wantIDs))
+			for i, id := range tt.wantIDs {
+				assert.Equal(t, fmt.Sprintf("pipeline-%d", id), pipelines[i].ID)
+				assert.Equal(t, fmt.Sprintf("run-%d", id), runs[i].ID)
+			}
+		})
+	}
+}
+
+// testConcurrency is testing that the store can be used from concurrent go routines.
+// It is most useful when running the tests with the race detector.
+func testConcurrency(t *testing.T, store domain.Store) {
+	ctx := context.Background()
+	n := 50
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			pipelineID := fmt.Sprintf("pipeline-%d", i)
+			runID := fmt.Sprintf("run-%d", i)
+
+			assert.NoError(t, store.CreatePipeline(ctx, &domain.Pipeline{ID: pipelineID}))
+			assert.NoError(t, store.CreatePipelineRun(ctx, &domain.PipelineRun{ID: runID, PipelineID: pipelineID}))
+
+			// all go routines are competing for the same ID, only one can win
+			err := store.CreatePipeline(ctx, &domain.Pipeline{ID: "contended"})
+			if err != nil {
+				assert.ErrorIs(t, err, domain.ErrAlreadyExists)
+			}
+
+			_, err = store.GetPipeline(ctx, pipelineID)
+			assert.NoError(t, err)
+			assert.NoError(t, store.UpdatePipeline(ctx, &domain.Pipeline{ID: pipelineID, Name: "updated"}))
+			assert.NoError(t, store.UpdatePipelineRun(ctx, &domain.PipelineRun{ID: runID, PipelineID: pipelineID, Status: domain.StatusSuccess}))
+
+			_, err = store.ListPipelines(ctx, domain.ListOptions{})
+			assert.NoError(t, err)
+			_, err = store.ListPipelineRuns(ctx, domain.ListOptions{})
+			assert.NoError(t, err)
+
+			if i%2 == 0 {
+				assert.NoError(t, store.DeletePipeline(ctx, pipelineID))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	pipelines, err := store.ListPipelines(ctx, domain.ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, pipelines, n/2+1)
+
+	runs, err := store.ListPipelineRuns(ctx, domain.ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, runs, n)
+	for _, run := range runs {
+		assert.Equal(t, domain.StatusSuccess, run.Status)
+	}
+}
+
+// testCopies checks that the store keeps and returns copies, so changes are only stored by the update methods
+func testCopies(t *testing.T, store domain.Store) {
+	ctx := context.Background()
+
+	pipeline := &domain.Pipeline{ID: "pipeline", Name: "original", Stages: map[string]domain.Stage{}}
+	require.NoError(t, store.CreatePipeline(ctx, pipeline))
+	pipeline.Name = "changed"
+	pipeline.Stages[domain.StageRun] = domain.NewRunStage(domain.StageRun, "make", false)
+	got, err := store.GetPipeline(ctx, "pipeline")
+	require.NoError(t, err)
+	assert.Equal(t, "original", got.Name)
+	assert.Empty(t, got.Stages)
+	got.Name = "changed"
+	got, err = store.GetPipeline(ctx, "pipeline")
+	require.NoError(t, err)
+	assert.Equal(t, "original", got.Name)
+
+	run := &domain.PipelineRun{
+		ID: "run", PipelineID: "pipeline", Status: domain.StatusPending,
+		StageStatuses: map[string]string{domain.StageRun: domain.StatusPending},
+		Logs:          map[string]string{},
+	}
+	require.NoError(t, store.CreatePipelineRun(ctx, run))
+	run.Status = domain.StatusRunning
+	run.StageStatuses[domain.StageRun] = domain.StatusRunning
+	run.Logs[domain.StageRun] = "running"
+	gotRun, err := store.GetPipelineRun(ctx, "run")
+	require.NoError(t, err)
+	assert.Equal(t, domain.StatusPending, gotRun.Status)
+	assert.Equal(t, domain.StatusPending, gotRun.StageStatuses[domain.StageRun])
+	assert.Empty(t, gotRun.Logs[domain.StageRun])
+
+	require.NoError(t, store.UpdatePipelineRun(ctx, run))
+	run.StageStatuses[domain.StageRun] = domain.StatusSuccess
+	runs, err := store.ListPipelineRuns(ctx, domain.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, runs, 1)
+	assert.Equal(t, domain.StatusRunning, runs[0].StageStatuses[domain.StageRun])
+	runs[0].StageStatuses[domain.StageRun] = domain.StatusFailed
+	gotRun, err = store.GetPipelineRun(ctx, "run")
+	require.NoError(t, err)
+	assert.Equal(t, domain.StatusRunning, gotRun.StageStatuses[domain.StageRun])
+	assert.Equal(t, "running", gotRun.Logs[domain.StageRun])
+}
+
+// testWatch is testing the change feed of stores implementing domain.Watcher
+func testWatch(t *testing.T, store domain.Store) {
+	watcher := store.(domain.Watcher)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	all, err := watcher.Watch(ctx, domain.WatchFilter{})
+	require.NoError(t, err)
+	runEvents, err := watcher.Watch(ctx, domain.WatchFilter{Types: []domain.EventType{domain.EventRunStatusChanged}})
+	require.NoError(t, err)
+	otherPipeline, err := watcher.Watch(ctx, domain.WatchFilter{PipelineID: "other-pipeline"})
+	require.NoError(t, err)
+
+	newRun := func(status string, stageStatuses map[string]string) *domain.PipelineRun {
+		return &domain.PipelineRun{ID: "run", PipelineID: "pipeline", Status: status, StageStatuses: stageStatuses}
+	}
+	require.NoError(t, store.CreatePipeline(ctx, &domain.Pipeline{ID: "pipeline"}))
+	require.NoError(t, store.UpdatePipeline(ctx, &domain.Pipeline{ID: "pipeline", Name: "updated"}))
+	require.NoError(t, store.CreatePipelineRun(ctx, newRun(domain.StatusPending, nil)))
+	require.NoError(t, store.UpdatePipelineRun(ctx, newRun(domain.StatusRunning, nil)))
+	// no status change
+	require.NoError(t, store.UpdatePipelineRun(ctx, newRun(domain.StatusRunning, nil)))
+	require.NoError(t, store.UpdatePipelineRun(ctx, newRun(domain.StatusRunning, map[string]string{domain.StageRun: domain.StatusSuccess})))
+	require.NoError(t, store.UpdatePipelineRun(ctx, newRun(domain.StatusSuccess, map[string]string{domain.StageRun: domain.StatusSuccess})))
+	require.NoError(t, store.DeletePipelineRun(ctx, "run"))
+	require.NoError(t, store.DeletePipeline(ctx, "pipeline"))
+
+	events := receiveEvents(t, all, 8)
+	wantTypes := []domain.EventType{
+		domain.EventPipelineCreated,
+		domain.EventPipelineUpdated,
+		domain.EventRunCreated,
+		domain.EventRunStatusChanged,
+		domain.EventRunStatusChanged,
+		domain.EventRunStatusChanged,
+		domain.EventRunDeleted,
+		domain.EventPipelineDeleted,
+	}
+	for i, event := range events {
+		assert.Equal(t, wantTypes[i], event.Type)
+		assert.Equal(t, "pipeline", event.PipelineID)
+		if i > 0 {
+			assert.Greater(t, event.Sequence, events[i-1].Sequence, "events not in order")
+		}
+	}
+	assert.Equal(t, "run", events[3].RunID)
+	assert.Equal(t, domain.StatusRunning, events[3].Status)
+	assert.Equal(t, domain.StatusSuccess, events[5].Status)
+
+	filtered := receiveEvents(t, runEvents, 3)
+	for _, event := range filtered {
+		assert.Equal(t, domain.EventRunStatusChanged, event.Type)
+	}
+	assertNoEvent(t, otherPipeline)
+
+	t.Run("resume", func(t *testing.T) {
+		resumed, err := watcher.Watch(ctx, domain.WatchFilter{After: events[5].Sequence})
+		require.NoError(t, err)
+		replayed := receiveEvents(t, resumed, 2)
+		assert.Equal(t, events[6:], replayed)
+		assertNoEvent(t, resumed)
+	})
+
+	t.Run("resume after unknown event", func(t *testing.T) {
+		// the event was published by another instance of the store
+		_, err := watcher.Watch(ctx, domain.WatchFilter{After: events[7].Sequence + 1})
+		assert.ErrorIs(t, err, domain.ErrEventsExpired)
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		ch, err := watcher.Watch(ctx, domain.WatchFilter{})
+		require.NoError(t, err)
+		cancel()
+
+		select {
+		case _, ok := <-ch:
+			assert.False(t, ok, "expected channel to be closed")
+		case <-time.After(time.Second):
+			t.Fatal("channel not closed after cancelling the context")
+		}
+	})
+}
+
+// receiveEvents is receiving n events from the channel or fails the test after a timeout
+func receiveEvents(t *testing.T, ch <-chan domain.Event, n int) []domain.Event {
+	t.Helper()
+	events := make([]domain.Event, 0, n)
+	for len(events) < n {
+		select {
+		case event, ok := <-ch:
+			require.True(t, ok, "channel closed after %d events", len(events))
+			events = append(events, event)
+		case <-time.After(time.Second):
+			t.Fatalf("received only %d of %d events", len(events), n)
+		}
+	}
+	return events
+}
+
+// assertNoEvent is asserting that no further event is received
+func assertNoEvent(t *testing.T, ch <-chan domain.Event) {
+	t.Helper()
+	select {
+	case event := <-ch:
+		t.Errorf("unexpected event: %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
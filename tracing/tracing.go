@@ -0,0 +1,87 @@
+// Package tracing is setting up the export of the OpenTelemetry traces of the server.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// Exporters supported by NewTracerProvider
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// Config is the configuration of the trace export.
+type Config struct {
+	// Exporter is one of ExporterNone, ExporterStdout or ExporterOTLP
+	Exporter string
+	// ServiceName is the name of the service reporting the spans
+	ServiceName string
+	// OTLPEndpoint is the host:port of the OTLP/HTTP collector. The standard OTEL_EXPORTER_OTLP_*
+	// environment variables are used if it is empty.
+	OTLPEndpoint string
+	// OTLPInsecure is disabling TLS for the connection to the collector
+	OTLPInsecure bool
+	// OTLPHeaders are sent with every export request to the collector, e.g. for authentication
+	OTLPHeaders map[string]string
+	// Writer is where the stdout exporter is writing the spans to, os.Stdout by default
+	Writer io.Writer
+}
+
+// NewTracerProvider returns a tracer provider exporting the spans with the configured exporter,
+// and a function flushing and stopping the export on shutdown.
+func NewTracerProvider(ctx context.Context, cfg Config) (trace.TracerProvider, func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+
+	switch cfg.Exporter {
+	case ExporterNone, "":
+		return noop.NewTracerProvider(), func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		w := cfg.Writer
+		if w == nil {
+			w = os.Stdout
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(w))
+	case ExporterOTLP:
+		var opts []otlptracehttp.Option
+		if cfg.OTLPEndpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.OTLPEndpoint))
+		}
+		if cfg.OTLPInsecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		if len(cfg.OTLPHeaders) > 0 {
+			opts = append(opts, otlptracehttp.WithHeaders(cfg.OTLPHeaders))
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, nil, fmt.Errorf("unknown trace exporter %q, must be %s, %s or %s", cfg.Exporter, ExporterNone, ExporterStdout, ExporterOTLP)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create %s trace exporter: %w", cfg.Exporter, err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(cfg.ServiceName)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create trace resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	return tp, tp.Shutdown, nil
+}
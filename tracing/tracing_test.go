@@ -0,0 +1,49 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestNewTracerProvider(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("none", func(t *testing.T) {
+		tp, shutdown, err := NewTracerProvider(ctx, Config{Exporter: ExporterNone})
+		require.NoError(t, err)
+		assert.IsType(t, noop.TracerProvider{}, tp)
+		assert.NoError(t, shutdown(ctx))
+	})
+
+	t.Run("stdout", func(t *testing.T) {
+		var buf bytes.Buffer
+		tp, shutdown, err := NewTracerProvider(ctx, Config{Exporter: ExporterStdout, ServiceName: "stagerunner-test", Writer: &buf})
+		require.NoError(t, err)
+
+		_, span := tp.Tracer("test").Start(ctx, "test-span")
+		span.End()
+		// shutdown is flushing the spans
+		require.NoError(t, shutdown(ctx))
+
+		assert.Contains(t, buf.String(), `"Name":"test-span"`)
+		assert.Contains(t, buf.String(), "stagerunner-test")
+	})
+
+	t.Run("otlp", func(t *testing.T) {
+		tp, shutdown, err := NewTracerProvider(ctx, Config{Exporter: ExporterOTLP, OTLPEndpoint: "localhost:4318", OTLPInsecure: true})
+		require.NoError(t, err)
+		assert.NotNil(t, tp)
+		// nothing has been recorded, so nothing has to be sent on shutdown
+		assert.NoError(t, shutdown(ctx))
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		_, _, err := NewTracerProvider(ctx, Config{Exporter: "jaeger"})
+		assert.ErrorContains(t, err, "unknown trace exporter")
+	})
+}
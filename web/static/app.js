@@ -0,0 +1,330 @@
+// stagerunner web UI: a single page application using the JSON API of the server.
+// All values of the API are inserted with textContent, never as HTML.
+"use strict";
+
+const apiPrefix = "/v1";
+const tokenKey = "stagerunner-token";
+const refreshInterval = 3000;
+const stages = ["run", "build", "deploy"];
+
+// el creates an element with attributes and children, strings are added as text nodes
+function el(tag, attrs, ...children) {
+  const node = document.createElement(tag);
+  for (const [name, value] of Object.entries(attrs || {})) {
+    if (name.startsWith("on")) {
+      node.addEventListener(name.slice(2), value);
+    } else if (value !== undefined && value !== null && value !== false) {
+      node.setAttribute(name, value === true ? "" : value);
+    }
+  }
+  for (const child of children.flat()) {
+    if (child !== undefined && child !== null) {
+      node.append(child instanceof Node ? child : String(child));
+    }
+  }
+  return node;
+}
+
+function showMessage(text, isError) {
+  const message = document.getElementById("message");
+  message.textContent = text;
+  message.className = isError ? "error" : "";
+  message.hidden = !text;
+}
+
+// api sends a request with the stored token and returns the decoded JSON response
+async function api(method, path, body) {
+  const headers = { "Authorization": localStorage.getItem(tokenKey) || "" };
+  if (body !== undefined) {
+    headers["Content-Type"] = "application/json";
+  }
+  if (method === "POST" && path.endsWith("/trigger") && window.crypto && crypto.randomUUID) {
+    // retries of the same trigger don't start another run
+    headers["Idempotency-Key"] = crypto.randomUUID();
+  }
+  const resp = await fetch(apiPrefix + path, {
+    method: method,
+    headers: headers,
+    body: body === undefined ? undefined : JSON.stringify(body),
+  });
+  if (resp.status === 401) {
+    throw new Error("Unauthorized: enter a valid API token");
+  }
+  if (!resp.ok) {
+    let message = resp.status + " " + resp.statusText;
+    try {
+      const error = await resp.json();
+      message = error.message || message;
+    } catch (e) {
+      // keep the status as message
+    }
+    throw new Error(message);
+  }
+  if (resp.status === 202 || resp.status === 204 || resp.headers.get("Content-Length") === "0") {
+    return null;
+  }
+  return resp.json();
+}
+
+function finished(run) {
+  return run.status === "success" || run.status === "failed";
+}
+
+function statusBadge(status) {
+  return el("span", { class: "status status-" + (status || "unknown") }, status || "unknown");
+}
+
+function formatTime(value) {
+  return value ? new Date(value).toLocaleString() : "";
+}
+
+function formatDuration(ms) {
+  const seconds = Math.max(0, Math.round(ms / 1000));
+  const h = Math.floor(seconds / 3600);
+  const m = Math.floor((seconds % 3600) / 60);
+  const s = seconds % 60;
+  return (h ? h + "h" : "") + (h || m ? m + "m" : "") + s + "s";
+}
+
+// runDuration is the time a run took, or has been running so far
+function runDuration(run) {
+  const end = finished(run) ? new Date(run.updated_at) : new Date();
+  return formatDuration(end - new Date(run.created_at));
+}
+
+function shortID(id) {
+  return id.slice(0, 8);
+}
+
+function sortRuns(runs) {
+  return runs.slice().sort((a, b) => new Date(b.created_at) - new Date(a.created_at));
+}
+
+async function trigger(pipelineID, ref) {
+  const resp = await api("POST", "/pipelines/" + encodeURIComponent(pipelineID) + "/trigger", { git_ref: ref });
+  showMessage("Triggered run " + resp.id + " on " + ref);
+  return resp;
+}
+
+async function cancel(runID) {
+  await api("POST", "/runs/" + encodeURIComponent(runID) + "/cancel");
+  showMessage("Cancelling run " + runID);
+}
+
+// action runs an API call of a button and renders the current page again
+function action(fn) {
+  return async (event) => {
+    event.preventDefault();
+    try {
+      await fn();
+    } catch (e) {
+      showMessage(e.message, true);
+    }
+    render();
+  };
+}
+
+function triggerForm(pipelineID, ref) {
+  const input = el("input", { value: ref || "main", size: 12, "aria-label": "Git ref", required: true });
+  return el("form", {
+    class: "inline",
+    onsubmit: action(() => trigger(pipelineID, input.value.trim())),
+  }, input, el("button", { type: "submit" }, "Trigger"));
+}
+
+function cancelButton(run) {
+  if (finished(run)) {
+    return null;
+  }
+  return el("button", { onclick: action(() => cancel(run.id)) }, "Cancel");
+}
+
+function runsTable(runs, pipelines) {
+  if (runs.length === 0) {
+    return el("p", { class: "muted" }, "No runs yet.");
+  }
+  const names = Object.fromEntries(pipelines.map((p) => [p.id, p.name]));
+  return el("table", {},
+    el("thead", {}, el("tr", {}, ["Run", "Pipeline", "Git ref", "Status", "Run", "Build", "Deploy", "Created", "Duration", ""].map((h) => el("th", {}, h)))),
+    el("tbody", {}, runs.map((run) => el("tr", {},
+      el("td", {}, el("a", { href: "#/runs/" + encodeURIComponent(run.id) }, el("code", {}, shortID(run.id)))),
+      el("td", {}, el("a", { href: "#/pipelines/" + encodeURIComponent(run.pipeline_id) }, names[run.pipeline_id] || shortID(run.pipeline_id))),
+      el("td", {}, run.git_ref),
+      el("td", {}, statusBadge(run.status)),
+      el("td", {}, statusBadge(run.run_status)),
+      el("td", {}, statusBadge(run.build_status)),
+      el("td", {}, statusBadge(run.deploy_status)),
+      el("td", {}, formatTime(run.created_at)),
+      el("td", {}, runDuration(run)),
+      el("td", {}, cancelButton(run)),
+    ))),
+  );
+}
+
+async function pipelinesPage() {
+  const [pipelines, runs] = await Promise.all([api("GET", "/pipelines"), api("GET", "/runs")]);
+  const latest = {};
+  for (const run of sortRuns(runs)) {
+    latest[run.pipeline_id] = latest[run.pipeline_id] || run;
+  }
+  return [
+    el("h1", {}, "Pipelines"),
+    pipelines.length === 0 ? el("p", { class: "muted" }, "No pipelines yet.") : el("table", {},
+      el("thead", {}, el("tr", {}, ["Name", "Repository", "Last run", "", "Trigger"].map((h) => el("th", {}, h)))),
+      el("tbody", {}, pipelines.map((p) => {
+        const run = latest[p.id];
+        return el("tr", {},
+          el("td", {}, el("a", { href: "#/pipelines/" + encodeURIComponent(p.id) }, p.name || p.id)),
+          el("td", {}, p.repository),
+          el("td", {}, run ? statusBadge(run.status) : el("span", { class: "muted" }, "never run")),
+          el("td", {}, run ? el("a", { href: "#/runs/" + encodeURIComponent(run.id) }, run.git_ref + ", " + formatTime(run.created_at)) : null),
+          el("td", {}, triggerForm(p.id)),
+        );
+      })),
+    ),
+  ];
+}
+
+async function pipelinePage(id) {
+  const [pipeline, runs] = await Promise.all([
+    api("GET", "/pipelines/" + encodeURIComponent(id)),
+    api("GET", "/runs"),
+  ]);
+  const stagesDef = pipeline.stages || {};
+  return [
+    el("h1", {}, "Pipeline " + (pipeline.name || pipeline.id)),
+    el("dl", {},
+      el("dt", {}, "ID"), el("dd", {}, el("code", {}, pipeline.id)),
+      el("dt", {}, "Repository"), el("dd", {}, pipeline.repository),
+      el("dt", {}, "Run"), el("dd", {}, el("code", {}, (stagesDef.run_stage || {}).command || "")),
+      el("dt", {}, "Build"), el("dd", {}, el("code", {}, (stagesDef.build_stage || {}).dockerfile_path || "")),
+      el("dt", {}, "Deploy"), el("dd", {}, el("code", {}, (stagesDef.deploy_stage || {}).cluster_name || "")),
+      el("dt", {}, "Trigger"), el("dd", {}, triggerForm(pipeline.id)),
+    ),
+    el("h2", {}, "Runs"),
+    runsTable(sortRuns(runs.filter((run) => run.pipeline_id === pipeline.id)), [pipeline]),
+  ];
+}
+
+async function runsPage() {
+  const [pipelines, runs] = await Promise.all([api("GET", "/pipelines"), api("GET", "/runs")]);
+  return [el("h1", {}, "Runs"), runsTable(sortRuns(runs), pipelines)];
+}
+
+// runStageNames returns the stages of a run, the built-in stages first and other stage types sorted by name
+function runStageNames(statuses) {
+  const others = Object.keys(statuses).filter((stage) => !stages.includes(stage)).sort();
+  return stages.filter((stage) => stage in statuses).concat(others);
+}
+
+async function runPage(id) {
+  const run = await api("GET", "/runs/" + encodeURIComponent(id));
+  let pipelineName = shortID(run.pipeline_id);
+  try {
+    pipelineName = (await api("GET", "/pipelines/" + encodeURIComponent(run.pipeline_id))).name || pipelineName;
+  } catch (e) {
+    // the pipeline might have been deleted
+  }
+  const timings = run.stage_timings || {};
+  const statuses = run.stage_statuses || { run: run.run_status, build: run.build_status, deploy: run.deploy_status };
+  const runStages = runStageNames(statuses);
+
+  return [
+    el("h1", {}, "Run ", el("code", {}, run.id), " ", statusBadge(run.status)),
+    el("dl", {},
+      el("dt", {}, "Pipeline"), el("dd", {}, el("a", { href: "#/pipelines/" + encodeURIComponent(run.pipeline_id) }, pipelineName)),
+      el("dt", {}, "Git ref"), el("dd", {}, run.git_ref),
+      el("dt", {}, "Created"), el("dd", {}, formatTime(run.created_at)),
+      el("dt", {}, "Updated"), el("dd", {}, formatTime(run.updated_at)),
+      el("dt", {}, "Duration"), el("dd", {}, runDuration(run)),
+    ),
+    el("p", {},
+      cancelButton(run), " ",
+      el("button", { onclick: action(async () => {
+        const resp = await trigger(run.pipeline_id, run.git_ref);
+        location.hash = "#/runs/" + encodeURIComponent(resp.id);
+      }) }, "Retry"),
+    ),
+    el("h2", {}, "Stages"),
+    el("table", {},
+      el("thead", {}, el("tr", {}, ["Stage", "Status", "Started", "Finished", "Duration"].map((h) => el("th", {}, h)))),
+      el("tbody", {}, runStages.map((stage) => {
+        const timing = timings[stage];
+        return el("tr", {},
+          el("td", {}, stage),
+          el("td", {}, statusBadge(statuses[stage])),
+          el("td", {}, timing ? formatTime(timing.started_at) : ""),
+          el("td", {}, timing ? formatTime(timing.finished_at) : ""),
+          el("td", {}, timing ? formatDuration((timing.finished_at ? new Date(timing.finished_at) : new Date()) - new Date(timing.started_at)) : ""),
+        );
+      })),
+    ),
+    runStages.filter((stage) => (run.logs || {})[stage]).map((stage) => [
+      el("h2", {}, "Log of " + stage),
+      el("pre", {}, run.logs[stage]),
+    ]),
+  ];
+}
+
+const routes = [
+  [/^#\/pipelines\/([^/]+)$/, pipelinePage],
+  [/^#\/runs\/([^/]+)$/, runPage],
+  [/^#\/runs$/, runsPage],
+  [/^#\/pipelines$/, pipelinesPage],
+];
+
+let rendering = false;
+
+// render shows the page of the current location hash
+async function render() {
+  if (rendering) {
+    return;
+  }
+  rendering = true;
+  try {
+    const hash = location.hash || "#/pipelines";
+    for (const [pattern, page] of routes) {
+      const match = hash.match(pattern);
+      if (!match) {
+        continue;
+      }
+      const content = await page(...match.slice(1).map(decodeURIComponent));
+      document.getElementById("content").replaceChildren(...content.flat(2).filter((c) => c));
+      return;
+    }
+    location.hash = "#/pipelines";
+  } catch (e) {
+    showMessage(e.message, true);
+  } finally {
+    rendering = false;
+  }
+}
+
+// refresh renders the page again, unless the user is typing into a form of the page
+function refresh() {
+  const active = document.activeElement;
+  if (active && active.tagName === "INPUT" && document.getElementById("content").contains(active)) {
+    return;
+  }
+  render();
+}
+
+document.getElementById("token").value = localStorage.getItem(tokenKey) || "";
+document.getElementById("token-form").addEventListener("submit", (event) => {
+  event.preventDefault();
+  localStorage.setItem(tokenKey, document.getElementById("token").value);
+  showMessage("");
+  render();
+});
+document.getElementById("logout").addEventListener("click", () => {
+  localStorage.removeItem(tokenKey);
+  document.getElementById("token").value = "";
+  document.getElementById("content").replaceChildren();
+  showMessage("Token removed");
+});
+window.addEventListener("hashchange", () => {
+  showMessage("");
+  render();
+});
+setInterval(refresh, refreshInterval);
+render();
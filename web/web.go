@@ -0,0 +1,35 @@
+// Package web provides the browser UI of stagerunner. It is a static single page application using the
+// JSON API of the server with the token entered by the user.
+package web
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed static
+var static embed.FS
+
+// contentSecurityPolicy only allows the assets of the UI and requests to the API of the same origin
+const contentSecurityPolicy = "default-src 'self'; img-src 'self' data:; frame-ancestors 'none'"
+
+// Handler returns a handler serving the UI. It is expecting paths relative to the mount point of the UI,
+// so it has to be wrapped with http.StripPrefix when it is mounted below the root.
+func Handler() http.Handler {
+	files, err := fs.Sub(static, "static")
+	if err != nil {
+		// the directory is embedded, so this is a programming error
+		panic(err)
+	}
+	fileServer := http.FileServer(http.FS(files))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", contentSecurityPolicy)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		// the assets are not versioned, so browsers have to revalidate them
+		w.Header().Set("Cache-Control", "no-cache")
+		fileServer.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,39 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler(t *testing.T) {
+	handler := Handler()
+
+	tests := []struct {
+		path            string
+		wantStatus      int
+		wantContentType string
+		wantBody        string
+	}{
+		{path: "/", wantStatus: http.StatusOK, wantContentType: "text/html; charset=utf-8", wantBody: `<script src="app.js">`},
+		{path: "/app.js", wantStatus: http.StatusOK, wantContentType: "text/javascript; charset=utf-8", wantBody: `const apiPrefix = "/v1"`},
+		{path: "/style.css", wantStatus: http.StatusOK, wantContentType: "text/css; charset=utf-8", wantBody: ".status-failed"},
+		{path: "/missing.js", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			assert.Equal(t, contentSecurityPolicy, w.Header().Get("Content-Security-Policy"))
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			assert.Equal(t, tt.wantContentType, w.Header().Get("Content-Type"))
+			assert.Contains(t, w.Body.String(), tt.wantBody)
+		})
+	}
+}